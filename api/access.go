@@ -0,0 +1,101 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 管理接口的来源地址白名单和黑名单，白名单为空时只按黑名单过滤
+var (
+	adminAllowNets []*net.IPNet
+	adminDenyNets  []*net.IPNet
+)
+
+// parseCIDRList 解析CIDR列表，不带掩码的地址按单个IP处理
+func parseCIDRList(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, item := range list {
+		item = strings.TrimSpace(item)
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("无效的CIDR: %s", item)
+			}
+			if ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("无效的CIDR: %s", item)
+		}
+		nets = append(nets, ipNet)
+	}
+
+	return nets, nil
+}
+
+// SetAdminAccess 设置管理接口允许和禁止访问的来源网段
+func SetAdminAccess(allow []string, deny []string) error {
+	allowNets, err := parseCIDRList(allow)
+	if err != nil {
+		return err
+	}
+
+	denyNets, err := parseCIDRList(deny)
+	if err != nil {
+		return err
+	}
+
+	adminAllowNets, adminDenyNets = allowNets, denyNets
+	return nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// adminAllowed 黑名单优先于白名单，未配置白名单时允许黑名单以外的所有来源
+func adminAllowed(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	if containsIP(adminDenyNets, ip) {
+		return false
+	}
+
+	return len(adminAllowNets) == 0 || containsIP(adminAllowNets, ip)
+}
+
+// AdminAccess 管理接口的来源地址过滤中间件，不允许的来源返回403
+func AdminAccess() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientIP := c.ClientIP()
+		if !adminAllowed(net.ParseIP(clientIP)) {
+			c.AbortWithStatusJSON(http.StatusForbidden, Response{
+				Code: 403,
+				Msg:  fmt.Sprintf("来源地址 %s 无权访问管理接口", clientIP),
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 查询结果缓存键：数据库路径、查询模式、IP以及后备数据库列表
+type searchCacheKey struct {
+	dbPath    string
+	mode      string
+	ip        uint32
+	fallbacks string
+}
+
+type searchCacheEntry struct {
+	key    searchCacheKey
+	result SearchResult
+}
+
+// searchCache 固定容量的LRU查询结果缓存，容量为0时不缓存
+type searchCache struct {
+	lock     sync.Mutex
+	capacity int
+	items    map[searchCacheKey]*list.Element
+	order    *list.List // 最近使用的在前
+
+	// 每次清空时递增，避免清空前开始的查询把旧数据库的结果写回缓存
+	generation uint64
+
+	hits   int64
+	misses int64
+}
+
+var resultCache = &searchCache{
+	items: make(map[searchCacheKey]*list.Element),
+	order: list.New(),
+}
+
+// SetSearchCacheSize 设置查询结果缓存容量，0表示关闭缓存
+func SetSearchCacheSize(capacity int) {
+	if capacity < 0 {
+		capacity = 0
+	}
+
+	resultCache.lock.Lock()
+	defer resultCache.lock.Unlock()
+
+	resultCache.capacity = capacity
+	for resultCache.order.Len() > capacity {
+		resultCache.removeOldest()
+	}
+}
+
+func newSearchCacheKey(dbPath string, mode string, ip uint32, fallbacks []string) searchCacheKey {
+	return searchCacheKey{
+		dbPath:    dbPath,
+		mode:      mode,
+		ip:        ip,
+		fallbacks: strings.Join(fallbacks, "\x00"),
+	}
+}
+
+// get 查询缓存，未命中时返回当前的generation，写回时需要原样传给put
+func (c *searchCache) get(key searchCacheKey) (SearchResult, uint64, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.capacity == 0 {
+		return SearchResult{}, c.generation, false
+	}
+
+	ele, ok := c.items[key]
+	if !ok {
+		atomic.AddInt64(&c.misses, 1)
+		return SearchResult{}, c.generation, false
+	}
+
+	atomic.AddInt64(&c.hits, 1)
+	c.order.MoveToFront(ele)
+	return ele.Value.(*searchCacheEntry).result, c.generation, true
+}
+
+func (c *searchCache) put(key searchCacheKey, result SearchResult, generation uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.capacity == 0 || generation != c.generation {
+		return
+	}
+
+	if ele, ok := c.items[key]; ok {
+		ele.Value.(*searchCacheEntry).result = result
+		c.order.MoveToFront(ele)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&searchCacheEntry{key: key, result: result})
+	for c.order.Len() > c.capacity {
+		c.removeOldest()
+	}
+}
+
+func (c *searchCache) removeOldest() {
+	ele := c.order.Back()
+	if ele == nil {
+		return
+	}
+
+	c.order.Remove(ele)
+	delete(c.items, ele.Value.(*searchCacheEntry).key)
+}
+
+// 数据库重新加载或卸载后缓存的结果可能已经过期，整体清空，返回清除的条数
+func invalidateSearchCache() int {
+	resultCache.lock.Lock()
+	defer resultCache.lock.Unlock()
+
+	n := resultCache.order.Len()
+	resultCache.items = make(map[searchCacheKey]*list.Element)
+	resultCache.order.Init()
+	resultCache.generation++
+	return n
+}
+
+// GetSearchCacheStats 获取查询结果缓存的统计信息
+func GetSearchCacheStats() map[string]interface{} {
+	resultCache.lock.Lock()
+	capacity, size := resultCache.capacity, resultCache.order.Len()
+	resultCache.lock.Unlock()
+
+	hits := atomic.LoadInt64(&resultCache.hits)
+	misses := atomic.LoadInt64(&resultCache.misses)
+	hitRate := 0.0
+	if hits+misses > 0 {
+		hitRate = float64(hits) / float64(hits+misses)
+	}
+
+	return map[string]interface{}{
+		"enabled":  capacity > 0,
+		"capacity": capacity,
+		"size":     size,
+		"hits":     hits,
+		"misses":   misses,
+		"hitRate":  hitRate,
+	}
+}
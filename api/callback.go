@@ -0,0 +1,116 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// 任务完成回调的签名头，值为 "sha256=" 加请求体的HMAC-SHA256十六进制值
+const callbackSignatureHeader = "X-IP2Region-Signature"
+
+// 回调的任务类型头：export 或 generate
+const callbackTaskTypeHeader = "X-IP2Region-Task-Type"
+
+const (
+	callbackMaxAttempts = 4
+	callbackBaseDelay   = time.Second
+	callbackTimeout     = 10 * time.Second
+)
+
+// 回调签名密钥，为空时回调请求不带签名头
+var callbackSecret string
+
+// 回调与远程源文件使用同一份允许访问的主机列表，重定向时同样重新检查
+var callbackClient = &http.Client{Timeout: callbackTimeout, CheckRedirect: remoteClient.CheckRedirect}
+
+// SetCallbackSecret 设置任务完成回调的HMAC签名密钥
+func SetCallbackSecret(secret string) {
+	callbackSecret = secret
+}
+
+// checkCallbackURL 校验请求中的回调地址，只允许http和https，主机需要在 -remote-allow-host 允许的范围内
+func checkCallbackURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("无效的回调地址: %s，只支持http和https", raw)
+	}
+	if err = checkRemoteHost(u); err != nil {
+		return fmt.Errorf("不允许的回调地址: %w", err)
+	}
+
+	return nil
+}
+
+func signCallbackPayload(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(callbackSecret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// notifyTaskCallback 将任务的最终状态POST到回调地址，失败时按指数退避重试，
+// 重试耗尽后只记录日志，不影响任务本身的状态
+func notifyTaskCallback(callbackURL string, taskType string, taskID string, status interface{}) {
+	body, err := json.Marshal(status)
+	if err != nil {
+		log.Printf("任务 %s: 序列化回调内容失败: %v", taskID, err)
+		return
+	}
+
+	delay := callbackBaseDelay
+	for attempt := 1; attempt <= callbackMaxAttempts; attempt++ {
+		err = postTaskCallback(callbackURL, taskType, body)
+		if err == nil {
+			log.Printf("任务 %s: 已回调 %s", taskID, callbackURL)
+			return
+		}
+
+		if attempt < callbackMaxAttempts {
+			log.Printf("警告: 任务 %s: 回调 %s 失败，%v 后进行第 %d/%d 次重试: %v", taskID, callbackURL, delay, attempt, callbackMaxAttempts-1, err)
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+
+	log.Printf("错误: 任务 %s: 回调 %s 重试 %d 次后仍然失败: %v", taskID, callbackURL, callbackMaxAttempts-1, err)
+}
+
+func postTaskCallback(callbackURL string, taskType string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(callbackTaskTypeHeader, taskType)
+	if callbackSecret != "" {
+		req.Header.Set(callbackSignatureHeader, signCallbackPayload(body))
+	}
+
+	resp, err := callbackClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("回调地址返回状态码 %d", resp.StatusCode)
+	}
+
+	return nil
+}
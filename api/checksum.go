@@ -0,0 +1,127 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 文件校验值查询参数
+type ChecksumRequest struct {
+	Path string `form:"path" binding:"required"`
+}
+
+// 文件校验值
+type ChecksumResult struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	SHA256  string    `json:"sha256"` // 十六进制
+	Cached  bool      `json:"cached"` // 文件大小和修改时间未变，使用了之前计算的结果
+}
+
+// fileChecksum 按路径缓存的校验值，大小或修改时间变化后失效
+type fileChecksum struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+// 缓存的文件数量上限，超过时清空重新缓存
+const fileChecksumCacheSize = 1024
+
+var (
+	fileChecksums     = make(map[string]fileChecksum)
+	fileChecksumsLock sync.Mutex
+)
+
+// fileSHA256 返回文件的SHA-256，文件的大小和修改时间与缓存一致时不再重新计算。
+// 计算期间文件被修改时结果不缓存
+func fileSHA256(path string) (ChecksumResult, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ChecksumResult{}, err
+	}
+
+	result := ChecksumResult{Path: path, Size: info.Size(), ModTime: info.ModTime()}
+
+	fileChecksumsLock.Lock()
+	cached, ok := fileChecksums[path]
+	fileChecksumsLock.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		result.SHA256, result.Cached = cached.sum, true
+		return result, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return ChecksumResult{}, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, f); err != nil {
+		return ChecksumResult{}, err
+	}
+	result.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	if after, err := os.Stat(path); err == nil && after.Size() == info.Size() && after.ModTime().Equal(info.ModTime()) {
+		fileChecksumsLock.Lock()
+		if len(fileChecksums) >= fileChecksumCacheSize {
+			fileChecksums = make(map[string]fileChecksum)
+		}
+		fileChecksums[path] = fileChecksum{size: info.Size(), modTime: info.ModTime(), sum: result.SHA256}
+		fileChecksumsLock.Unlock()
+	}
+
+	return result, nil
+}
+
+// Checksum 返回文件的SHA-256，供分发生成的XDB后在加载前校验文件完整
+func Checksum(c *gin.Context) {
+	var req ChecksumRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	if !resolveRequestPaths(c, &req.Path) {
+		return
+	}
+
+	if info, err := os.Stat(req.Path); err != nil || info.IsDir() {
+		c.JSON(http.StatusNotFound, Response{
+			Code: 404,
+			Msg:  "文件不存在: " + req.Path,
+		})
+		return
+	}
+
+	result, err := fileSHA256(req.Path)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "计算校验值失败: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "计算校验值成功",
+		Data: result,
+	})
+}
@@ -0,0 +1,101 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+)
+
+// samePath 判断两个路径是否指向同一个文件，能同时访问到两个文件时按文件本身比较，
+// 因此硬链接和符号链接也能识别；否则按绝对路径比较
+func samePath(a string, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+
+	if ia, err := os.Stat(a); err == nil {
+		if ib, err := os.Stat(b); err == nil {
+			return os.SameFile(ia, ib)
+		}
+	}
+
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// inUseFiles 返回服务正在读取的文件及其用途：已加载的数据库、别名、快照、后备数据库和打开的编辑器源文件
+func inUseFiles() map[string]string {
+	files := make(map[string]string)
+
+	searcherLock.RLock()
+	if searcher != nil {
+		files[searcherPath] = "已加载的数据库"
+	}
+	for name, alias := range searcherAliases {
+		if _, ok := files[alias.DbPath]; !ok {
+			files[alias.DbPath] = fmt.Sprintf("别名 %s 的数据库", name)
+		}
+	}
+	searcherLock.RUnlock()
+
+	for _, snapshot := range listSnapshots() {
+		if _, ok := files[snapshot.DbPath]; !ok {
+			files[snapshot.DbPath] = fmt.Sprintf("快照 %s 的数据库", snapshot.Date)
+		}
+	}
+
+	for _, p := range getFallbackDbPaths() {
+		if _, ok := files[p]; !ok {
+			files[p] = "后备数据库"
+		}
+	}
+
+	editorsLock.RLock()
+	for p := range editors {
+		if _, ok := files[p]; !ok {
+			files[p] = "正在编辑的源文件"
+		}
+	}
+	editorsLock.RUnlock()
+
+	return files
+}
+
+// checkOutputPath 输出文件与本次请求的输入文件相同时返回400；checkInUse为true时
+// 还要求输出文件不是服务正在读取的文件，直接截断写入的输出会破坏这些文件上正在进行的查询。
+// 生成XDB先写临时文件再重命名，可以替换已加载的数据库，只需要与输入文件比较
+func checkOutputPath(c *gin.Context, dst string, checkInUse bool, inputs ...string) bool {
+	for _, input := range inputs {
+		if samePath(dst, input) {
+			c.JSON(http.StatusBadRequest, Response{
+				Code: 400,
+				Msg:  fmt.Sprintf("输出文件不能与输入文件相同: %s", dst),
+			})
+			return false
+		}
+	}
+
+	if !checkInUse {
+		return true
+	}
+
+	for p, usage := range inUseFiles() {
+		if samePath(dst, p) {
+			c.JSON(http.StatusBadRequest, Response{
+				Code: 400,
+				Msg:  fmt.Sprintf("输出文件 %s 是%s，写入会破坏正在进行的查询，请使用其他路径", dst, usage),
+			})
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,133 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IP覆盖检查请求，数据库的选择与 /api/search 相同
+type ContainsRequest struct {
+	IP         string `json:"ip" binding:"required"`
+	DbPath     string `json:"dbPath,omitempty"`
+	Alias      string `json:"alias,omitempty"`
+	SearchMode string `json:"searchMode,omitempty"`
+	IPFormat   string `json:"ipFormat,omitempty"` // dotted (默认) 或 int
+}
+
+// IP覆盖检查结果
+type ContainsResult struct {
+	IP              string `json:"ip"`
+	Covered         bool   `json:"covered"` // 数据库中有覆盖该IP的段，地区为默认地区时同样为true
+	IoCount         int    `json:"ioCount"`
+	TookNanoseconds int64  `json:"tookNanoseconds"`
+	SearchMode      string `json:"searchMode"`
+	Reserved        bool   `json:"reserved,omitempty"`
+	ReservedKind    string `json:"reservedKind,omitempty"`
+}
+
+// ContainsIP 只判断数据库是否覆盖IP，查找段索引但不读取地区数据，不使用查询缓存和后备数据库
+func ContainsIP(c *gin.Context) {
+	var req ContainsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		atomic.AddInt64(&globalStats.totalErrors, 1)
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "请求参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	if !resolveRequestPaths(c, &req.DbPath) {
+		atomic.AddInt64(&globalStats.totalErrors, 1)
+		return
+	}
+
+	dbPath, searchMode, err := resolveSearchTarget(req.DbPath, req.Alias, req.SearchMode)
+	if err == nil {
+		err = validateSearchTarget(dbPath, searchMode)
+	}
+	if err != nil {
+		atomic.AddInt64(&globalStats.totalErrors, 1)
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  err.Error(),
+		})
+		return
+	}
+
+	if req.IPFormat != "" && req.IPFormat != ipFormatDotted && req.IPFormat != ipFormatInt {
+		atomic.AddInt64(&globalStats.totalErrors, 1)
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "无效的ipFormat，可选值: dotted, int",
+		})
+		return
+	}
+
+	atomic.AddInt64(&globalStats.totalSearches, 1)
+
+	ip, err := parseSearchIP(req.IP, req.IPFormat)
+	if err != nil {
+		atomic.AddInt64(&globalStats.totalErrors, 1)
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "无效的IP: " + err.Error(),
+		})
+		return
+	}
+
+	reservedKind, err := checkReservedIP(ip)
+	if err != nil {
+		atomic.AddInt64(&globalStats.totalErrors, 1)
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  err.Error(),
+		})
+		return
+	}
+
+	s, usedMode, release, err := acquireSearcher(dbPath, searchMode)
+	if err != nil {
+		atomic.AddInt64(&globalStats.totalErrors, 1)
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "查询失败: " + err.Error(),
+		})
+		return
+	}
+	defer release()
+
+	tStart := time.Now()
+	covered, ioCount, err := s.Contains(ip)
+	took := time.Since(tStart).Nanoseconds()
+	if err != nil {
+		atomic.AddInt64(&globalStats.totalErrors, 1)
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "查询失败: " + err.Error(),
+		})
+		return
+	}
+	atomic.AddInt64(&globalStats.totalIoOperations, int64(ioCount))
+
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "查询成功",
+		Data: ContainsResult{
+			IP:              req.IP,
+			Covered:         covered,
+			IoCount:         ioCount,
+			TookNanoseconds: took,
+			SearchMode:      usedMode,
+			Reserved:        reservedKind != "",
+			ReservedKind:    reservedKind,
+		},
+	})
+}
@@ -0,0 +1,117 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 数据目录根路径，为空时不限制请求中的文件路径
+var dataRoot string
+
+var errPathOutsideDataRoot = errors.New("路径超出数据目录范围")
+
+// SetDataRoot 设置数据目录，之后请求中的相对路径都相对于该目录解析，
+// 绝对路径或包含..的路径解析后不在该目录下时拒绝访问
+func SetDataRoot(root string) error {
+	if root == "" {
+		dataRoot = ""
+		return nil
+	}
+
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+
+	// 根目录本身可能是符号链接，统一使用真实路径比较
+	real, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return fmt.Errorf("数据目录不可用: %w", err)
+	}
+
+	info, err := os.Stat(real)
+	if err != nil {
+		return fmt.Errorf("数据目录不可用: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("数据目录不是目录: %s", root)
+	}
+
+	dataRoot = real
+	return nil
+}
+
+// GetDataRoot 获取当前的数据目录，未设置时为空
+func GetDataRoot() string {
+	return dataRoot
+}
+
+// resolveDataPath 将路径解析到数据目录下，未设置数据目录时原样返回
+func resolveDataPath(path string) (string, error) {
+	if dataRoot == "" || path == "" {
+		return path, nil
+	}
+
+	resolved := path
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(dataRoot, resolved)
+	}
+	resolved = filepath.Clean(resolved)
+
+	if !isWithinDataRoot(resolved) {
+		return "", fmt.Errorf("%w: %s", errPathOutsideDataRoot, path)
+	}
+
+	// 目录内的符号链接可能指向目录外，按真实路径再检查一次，
+	// 待创建的输出文件不存在，检查其所在目录
+	real, err := filepath.EvalSymlinks(resolved)
+	if err != nil {
+		if dir, dirErr := filepath.EvalSymlinks(filepath.Dir(resolved)); dirErr == nil {
+			real = filepath.Join(dir, filepath.Base(resolved))
+		} else {
+			real = resolved
+		}
+	}
+
+	if !isWithinDataRoot(real) {
+		return "", fmt.Errorf("%w: %s", errPathOutsideDataRoot, path)
+	}
+
+	return resolved, nil
+}
+
+func isWithinDataRoot(path string) bool {
+	rel, err := filepath.Rel(dataRoot, path)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// resolveRequestPaths 原地解析请求中的文件路径，任一路径越界时返回403并返回false
+func resolveRequestPaths(c *gin.Context, paths ...*string) bool {
+	for _, p := range paths {
+		resolved, err := resolveDataPath(*p)
+		if err != nil {
+			c.JSON(http.StatusForbidden, Response{
+				Code: 403,
+				Msg:  err.Error(),
+			})
+			return false
+		}
+		*p = resolved
+	}
+
+	return true
+}
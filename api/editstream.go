@@ -0,0 +1,327 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"ip2region-web/xdb"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 流式编辑请求：参数放在查询字符串中，请求体为NDJSON，每行一个段
+type EditStreamRequest struct {
+	SrcFile  string `form:"srcFile" binding:"required"`
+	FillOnly bool   `form:"fillOnly"` // 只填充空白或默认地区的范围，不覆盖已有地区
+	Encoding string `form:"encoding"` // 源文件编码：utf-8, gbk，默认utf-8
+	TaskID   string `form:"taskId"`   // 任务ID，便于传输过程中查询进度，为空时自动生成
+}
+
+// 流式编辑请求体中的一行，start和end为IPv4地址
+type EditStreamLine struct {
+	Start  string `json:"start"`
+	End    string `json:"end"`
+	Region string `json:"region"`
+}
+
+// 流式编辑的结果
+type EditStreamResult struct {
+	TaskID    string `json:"taskId"`
+	Lines     int    `json:"lines"` // 已读取的行数，包括空行
+	OldCount  int    `json:"oldCount"`
+	NewCount  int    `json:"newCount"`
+	Applied   int    `json:"applied"`
+	Skipped   int    `json:"skipped"`
+	BytesRead int64  `json:"bytesRead"`
+	Partial   bool   `json:"partial,omitempty"` // 出错时已应用的段会保留
+}
+
+var (
+	// 两次读到请求体数据之间的最长等待时间，超时后视为流已卡住并结束任务，0表示不限制
+	editStreamIdleTimeout = time.Minute
+
+	// 单行的最大长度
+	editStreamMaxLineBytes = 1 << 20
+
+	editStreamTaskIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+)
+
+// 每应用多少行更新一次任务进度
+const editStreamProgressEvery = 1024
+
+// SetEditStreamIdleTimeout 设置流式编辑时等待请求体数据的最长时间，0表示不限制
+func SetEditStreamIdleTimeout(timeout time.Duration) {
+	editStreamIdleTimeout = max(timeout, 0)
+}
+
+// errEditStreamLine 请求体中的某一行无效
+type errEditStreamLine struct {
+	line int
+	err  error
+}
+
+func (e *errEditStreamLine) Error() string {
+	return fmt.Sprintf("第%d行: %s", e.line, e.err)
+}
+
+// idleReader 每次读取前重置连接的读超时，客户端长时间不发送数据时读取失败，
+// 而不是让请求一直挂起
+type idleReader struct {
+	r       io.Reader
+	rc      *http.ResponseController
+	timeout time.Duration
+	read    int64
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	if r.rc != nil {
+		if err := r.rc.SetReadDeadline(time.Now().Add(r.timeout)); err != nil {
+			// 底层连接不支持读超时，之后不再设置
+			r.rc = nil
+		}
+	}
+
+	n, err := r.r.Read(p)
+	r.read += int64(n)
+	return n, err
+}
+
+// parseEditStreamLine 解析一行NDJSON为段
+func parseEditStreamLine(line []byte) (*xdb.Segment, error) {
+	var l EditStreamLine
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&l); err != nil {
+		return nil, fmt.Errorf("无效的JSON: %w", err)
+	}
+
+	// 地区信息按行写入源文件，不能包含换行
+	if strings.ContainsAny(l.Region, "\r\n") {
+		return nil, fmt.Errorf("地区信息不能包含换行")
+	}
+
+	return xdb.SegmentFrom(l.Start + "|" + l.End + "|" + l.Region)
+}
+
+// applyEditStream 逐行读取并应用段；每行应用完成后才读取下一行，
+// 处理速度跟不上时不会继续读取，发送方会被TCP流控阻塞
+func applyEditStream(ctx *gin.Context, editor *xdb.Editor, body io.Reader, mode xdb.PutMode, progress func(lines int, r xdb.PutResult)) (int, xdb.PutResult, error) {
+	var result xdb.PutResult
+	var lines = 0
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), editStreamMaxLineBytes)
+	for scanner.Scan() {
+		lines++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		seg, err := parseEditStreamLine(line)
+		if err != nil {
+			return lines, result, &errEditStreamLine{line: lines, err: err}
+		}
+
+		r, err := editor.PutSegmentMode(seg, mode)
+		result.Applied += r.Applied
+		result.Skipped += r.Skipped
+		result.OldRows += r.OldRows
+		result.NewRows += r.NewRows
+		if err != nil {
+			return lines, result, &errEditStreamLine{line: lines, err: err}
+		}
+
+		if lines%editStreamProgressEvery == 0 {
+			if err := ctx.Request.Context().Err(); err != nil {
+				return lines, result, err
+			}
+			progress(lines, result)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return lines, result, &errEditStreamLine{line: lines + 1, err: fmt.Errorf("行长度超过 %d 字节", editStreamMaxLineBytes)}
+		}
+		return lines, result, err
+	}
+
+	return lines, result, nil
+}
+
+// 流式批量编辑IP段：请求体为NDJSON，边读取边写入编辑器，不需要先把补丁写到磁盘。
+// 任务状态通过 /api/edit/file-task/:taskId 查询，请求结束后返回最终结果
+func EditStream(c *gin.Context) {
+	var req EditStreamRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	if !resolveRequestPaths(c, &req.SrcFile) {
+		return
+	}
+
+	if !checkEncoding(c, &req.Encoding) {
+		return
+	}
+
+	if req.TaskID != "" && !editStreamTaskIDPattern.MatchString(req.TaskID) {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "无效的taskId，只能包含字母、数字、下划线、点和连字符，最长64个字符",
+		})
+		return
+	}
+
+	// 读取请求体期间持有编辑锁，与异步的批量编辑相同
+	if !lockEditor(c, req.SrcFile) {
+		return
+	}
+	defer unlockEditor(req.SrcFile)
+
+	editor, err := getEditor(req.SrcFile, req.Encoding)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "创建编辑器失败: " + err.Error(),
+		})
+		return
+	}
+
+	taskID := req.TaskID
+	if taskID == "" {
+		taskID = newTaskID("editstream")
+	}
+
+	editFileTasksLock.Lock()
+	if task, exists := editFileTasks[taskID]; exists && task.Status == "processing" {
+		editFileTasksLock.Unlock()
+		c.JSON(http.StatusConflict, Response{
+			Code: 409,
+			Msg:  "任务正在执行: " + taskID,
+		})
+		return
+	}
+	editFileTasks[taskID] = &EditFileTaskStatus{
+		TaskID:    taskID,
+		File:      "(stream)",
+		SrcFile:   req.SrcFile,
+		Status:    "processing",
+		StartTime: time.Now(),
+	}
+	editFileTasksLock.Unlock()
+
+	body := &idleReader{r: c.Request.Body, timeout: editStreamIdleTimeout}
+	if editStreamIdleTimeout > 0 {
+		body.rc = http.NewResponseController(c.Writer)
+	}
+
+	contentLength := c.Request.ContentLength
+	lines, r, err := applyEditStream(c, editor, body, editPutMode(req.FillOnly), func(lines int, r xdb.PutResult) {
+		updateEditFileTask(taskID, func(task *EditFileTaskStatus) {
+			task.AppliedSegments = lines
+			task.Applied, task.Skipped = r.Applied, r.Skipped
+			task.BytesRead = body.read
+			if contentLength > 0 {
+				task.Progress = math.Round(float64(body.read)/float64(contentLength)*10000) / 100
+			}
+		})
+	})
+
+	// 读取结束后取消读超时，避免影响写响应和连接复用
+	if body.rc != nil {
+		_ = body.rc.SetReadDeadline(time.Time{})
+	}
+
+	result := EditStreamResult{
+		TaskID:    taskID,
+		Lines:     lines,
+		OldCount:  r.OldRows,
+		NewCount:  r.NewRows,
+		Applied:   r.Applied,
+		Skipped:   r.Skipped,
+		BytesRead: body.read,
+		Partial:   err != nil && r.NewRows > 0,
+	}
+
+	updateEditFileTask(taskID, func(task *EditFileTaskStatus) {
+		task.TotalSegments, task.AppliedSegments = lines, lines
+		task.OldCount, task.NewCount = r.OldRows, r.NewRows
+		task.Applied, task.Skipped = r.Applied, r.Skipped
+		task.BytesRead = body.read
+		task.EndTime = time.Now()
+		if err != nil {
+			task.Status = "failed"
+			task.ErrorMessage = err.Error()
+			task.Partial = result.Partial
+			return
+		}
+
+		task.Status = "completed"
+		task.Progress = 100
+	})
+
+	if err == nil {
+		log.Printf("任务 %s: 流式编辑 %s 完成，读取 %d 行，应用 %d 个段，跳过 %d 个段", taskID, req.SrcFile, lines, r.Applied, r.Skipped)
+		c.JSON(http.StatusOK, Response{
+			Code: 0,
+			Msg:  "编辑成功",
+			Data: result,
+		})
+		return
+	}
+
+	log.Printf("任务 %s: 流式编辑 %s 在第 %d 行失败，已应用的 %d 个段会保留: %v", taskID, req.SrcFile, lines, r.Applied, err)
+
+	// 读超时后请求的context也会被取消，需要先于客户端断开判断
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		c.Header("Connection", "close")
+		c.JSON(http.StatusRequestTimeout, Response{
+			Code: 408,
+			Msg:  fmt.Sprintf("流式编辑失败: 超过 %s 没有收到数据", editStreamIdleTimeout),
+			Data: result,
+		})
+		return
+	}
+
+	// 客户端已断开，不再输出响应
+	if c.Request.Context().Err() != nil {
+		return
+	}
+
+	var lineErr *errEditStreamLine
+	switch {
+	case errors.As(err, &lineErr):
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "流式编辑失败: " + err.Error(),
+			Data: result,
+		})
+	default:
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "读取请求体失败: " + err.Error(),
+			Data: result,
+		})
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bufio"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"ip2region-web/xdb"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 生成耗时预估请求
+type GenerateEstimateRequest struct {
+	SrcFile  string `json:"srcFile" binding:"required"`
+	Encoding string `json:"encoding,omitempty"` // 源文件编码：utf-8, gbk，默认沿用编辑器的编码或utf-8
+}
+
+// 生成耗时预估结果
+type GenerateEstimateResult struct {
+	SrcFile          string  `json:"srcFile"`
+	FileSize         int64   `json:"fileSize"`
+	Lines            int     `json:"lines"`            // 数据行数，不含空行和注释
+	InvalidLines     int     `json:"invalidLines"`     // 格式错误的行数，生成时会失败
+	Segments         int     `json:"segments"`         // 合并相邻同区域段之后的段数量，与生成时一致
+	UniqueRegions    int     `json:"uniqueRegions"`    // 不重复的地区数量
+	Throughput       float64 `json:"throughput"`       // 预估使用的生成速度 (段/秒)
+	Calibrated       bool    `json:"calibrated"`       // 生成速度是否来自本机已完成的生成
+	EstimatedSeconds float64 `json:"estimatedSeconds"` // 预计生成耗时
+	ScanNanoseconds  int64   `json:"scanNanoseconds"`  // 本次扫描源文件的耗时
+}
+
+// 未校准时使用的生成速度 (段/秒)
+const defaultGenerateThroughput = 100000
+
+// 段数量或耗时太小的生成主要是固定开销，不用于校准
+const (
+	minCalibrateSegments = 1000
+	minCalibrateDuration = 100 * time.Millisecond
+)
+
+var (
+	generateThroughput     float64 = defaultGenerateThroughput
+	generateCalibrated     bool
+	generateThroughputLock sync.RWMutex
+)
+
+// SetGenerateThroughput 设置预估生成耗时的初始速度 (段/秒)，通常为在本机测得的值；
+// 之后每次完成的生成都会继续校准，0表示使用内置的默认值
+func SetGenerateThroughput(segmentsPerSecond float64) {
+	if segmentsPerSecond <= 0 {
+		return
+	}
+
+	generateThroughputLock.Lock()
+	defer generateThroughputLock.Unlock()
+	generateThroughput = segmentsPerSecond
+	generateCalibrated = true
+}
+
+// recordGenerateThroughput 按一次完成的生成校准生成速度，取指数移动平均以平滑单次波动
+func recordGenerateThroughput(segments int, elapsed time.Duration) {
+	if segments < minCalibrateSegments || elapsed < minCalibrateDuration {
+		return
+	}
+
+	measured := float64(segments) / elapsed.Seconds()
+
+	generateThroughputLock.Lock()
+	defer generateThroughputLock.Unlock()
+	if !generateCalibrated {
+		generateThroughput = measured
+		generateCalibrated = true
+		return
+	}
+	generateThroughput = 0.7*generateThroughput + 0.3*measured
+}
+
+func getGenerateThroughput() (float64, bool) {
+	generateThroughputLock.RLock()
+	defer generateThroughputLock.RUnlock()
+	return generateThroughput, generateCalibrated
+}
+
+// scanSourceForEstimate 顺序读取一遍源文件，统计数据行、合并后的段和不重复的地区，不做完整校验
+func scanSourceForEstimate(srcFile string, encoding string, result *GenerateEstimateResult) error {
+	handle, err := os.Open(srcFile)
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	if info, err := handle.Stat(); err == nil {
+		result.FileSize = info.Size()
+	}
+
+	reader, err := xdb.NewSourceReader(handle, encoding)
+	if err != nil {
+		return err
+	}
+
+	regions := make(map[string]struct{})
+	var lastRegion string
+	var lastEnd uint64 = math.MaxUint64
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), xdb.MaxRegionLength+64)
+	for first := true; scanner.Scan(); first = false {
+		line := strings.TrimSpace(scanner.Text())
+		if first {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		result.Lines++
+
+		ps := strings.SplitN(line, "|", 3)
+		if len(ps) != 3 {
+			result.InvalidLines++
+			continue
+		}
+		sip, err1 := xdb.IP2Long(ps[0])
+		eip, err2 := xdb.IP2Long(ps[1])
+		if err1 != nil || err2 != nil || sip > eip {
+			result.InvalidLines++
+			continue
+		}
+
+		// 与加载源文件时一样，连续且地区相同的相邻行合并为一个段
+		if ps[2] != lastRegion || uint64(sip) != lastEnd+1 {
+			result.Segments++
+		}
+		lastRegion, lastEnd = ps[2], uint64(eip)
+
+		if _, ok := regions[ps[2]]; !ok {
+			regions[ps[2]] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	result.UniqueRegions = len(regions)
+	return nil
+}
+
+// EstimateGenerate 扫描一遍源文件，按本机的生成速度预估生成XDB的耗时，不写任何文件
+func EstimateGenerate(c *gin.Context) {
+	var req GenerateEstimateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "请求参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	if !resolveSourcePath(c, &req.SrcFile) {
+		return
+	}
+
+	if !checkEncoding(c, &req.Encoding) {
+		return
+	}
+
+	if _, err := os.Stat(req.SrcFile); !isRemoteSource(req.SrcFile) && os.IsNotExist(err) {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "源文件不存在: " + req.SrcFile,
+		})
+		return
+	}
+
+	// 远程源文件先下载到临时文件，下载时间不计入扫描耗时
+	srcFile, cleanup, ok := fetchRequestSource(c, req.SrcFile)
+	if !ok {
+		return
+	}
+	defer cleanup()
+
+	tStart := time.Now()
+	result := GenerateEstimateResult{SrcFile: req.SrcFile}
+	if err := scanSourceForEstimate(srcFile, sourceEncodingFor(req.SrcFile, req.Encoding), &result); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "读取源文件失败: " + err.Error(),
+		})
+		return
+	}
+	result.ScanNanoseconds = time.Since(tStart).Nanoseconds()
+
+	throughput, calibrated := getGenerateThroughput()
+	result.Throughput, result.Calibrated = math.Round(throughput), calibrated
+	result.EstimatedSeconds = math.Round(float64(result.Segments)/throughput*100) / 100
+
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "预估成功",
+		Data: result,
+	})
+}
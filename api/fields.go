@@ -0,0 +1,109 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 地区信息按 | 分隔的各字段名称，按位置排列，例如 国家,区域,省份,城市,ISP；
+// 为空时查询只能按位置选择字段
+var regionFieldNames []string
+
+// SetRegionFields 设置地区信息的字段布局，名称不能为空、不能重复，也不能是纯数字
+func SetRegionFields(names []string) error {
+	seen := make(map[string]bool, len(names))
+	for i, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return fmt.Errorf("第%d个字段名为空", i+1)
+		}
+		if _, err := strconv.Atoi(name); err == nil {
+			return fmt.Errorf("字段名不能是数字: %s", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("字段名重复: %s", name)
+		}
+		seen[name] = true
+		names[i] = name
+	}
+
+	regionFieldNames = names
+	return nil
+}
+
+// resolveRegionField 将请求中的字段选择解析为从0开始的位置，可以是位置数字或字段名，
+// 返回的名称在配置了字段布局时为字段名，否则为位置数字
+func resolveRegionField(field string) (int, string, error) {
+	field = strings.TrimSpace(field)
+	if index, err := strconv.Atoi(field); err == nil {
+		if index < 0 {
+			return 0, "", fmt.Errorf("无效的字段位置: %d，位置从0开始", index)
+		}
+		if len(regionFieldNames) > 0 {
+			if index >= len(regionFieldNames) {
+				return 0, "", fmt.Errorf("字段位置 %d 超出字段布局，共 %d 个字段: %s", index, len(regionFieldNames), strings.Join(regionFieldNames, ","))
+			}
+			return index, regionFieldNames[index], nil
+		}
+		return index, field, nil
+	}
+
+	for i, name := range regionFieldNames {
+		if name == field {
+			return i, name, nil
+		}
+	}
+
+	if len(regionFieldNames) == 0 {
+		return 0, "", fmt.Errorf("未配置字段布局，只能按位置选择字段: %s", field)
+	}
+	return 0, "", fmt.Errorf("未知的字段: %s，可选字段: %s", field, strings.Join(regionFieldNames, ","))
+}
+
+// regionField 返回地区信息中指定位置的字段，字段数量不足时返回空字符串
+func regionField(region string, index int) string {
+	for i := 0; i < index; i++ {
+		p := strings.IndexByte(region, '|')
+		if p < 0 {
+			return ""
+		}
+		region = region[p+1:]
+	}
+
+	if p := strings.IndexByte(region, '|'); p >= 0 {
+		return region[:p]
+	}
+	return region
+}
+
+// checkFieldOrder 校验请求中的字段顺序，位置从0开始，可以重复或只列出部分字段；
+// 配置了字段布局时位置不能超出布局
+func checkFieldOrder(order []int) error {
+	for _, index := range order {
+		if index < 0 {
+			return fmt.Errorf("fieldOrder中的字段位置无效: %d，位置从0开始", index)
+		}
+		if len(regionFieldNames) > 0 && index >= len(regionFieldNames) {
+			return fmt.Errorf("fieldOrder中的字段位置 %d 超出字段布局，共 %d 个字段: %s", index, len(regionFieldNames), strings.Join(regionFieldNames, ","))
+		}
+	}
+	return nil
+}
+
+// reorderRegionFields 按order中的位置依次取出地区信息的字段，地区信息的字段数量不足时返回错误
+func reorderRegionFields(region string, order []int) ([]string, error) {
+	fields := strings.Split(region, "|")
+	parts := make([]string, 0, len(order))
+	for _, index := range order {
+		if index >= len(fields) {
+			return nil, fmt.Errorf("fieldOrder中的字段位置 %d 超出地区信息的字段数量，地区信息 `%s` 共 %d 个字段", index, region, len(fields))
+		}
+		parts = append(parts, fields[index])
+	}
+	return parts, nil
+}
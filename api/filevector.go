@@ -0,0 +1,86 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"sync"
+	"time"
+
+	"ip2region-web/xdb"
+)
+
+// file-vector 模式与file模式一样每次查询打开临时搜索器、用完即关，不替换已加载的数据库；
+// 区别是向量索引按文件缓存，同一文件的查询共享一份，每次查询少读一次文件
+const fileVectorMode = "file-vector"
+
+// isPerRequestMode 判断是否为每次查询创建临时搜索器的模式
+func isPerRequestMode(mode string) bool {
+	return mode == "file" || mode == fileVectorMode
+}
+
+// cachedVectorIndex 按路径缓存的向量索引，文件大小或修改时间变化后失效
+type cachedVectorIndex struct {
+	size    int64
+	modTime time.Time
+	index   []byte
+}
+
+// 缓存的文件数量上限，每个文件占用512KiB，超过时清空重新缓存
+const vectorIndexCacheSize = 16
+
+var (
+	vectorIndexes     = make(map[string]cachedVectorIndex)
+	vectorIndexesLock sync.Mutex
+)
+
+// openPerRequestSearcher 创建file或file-vector模式的临时搜索器，由调用方关闭。
+// 缓存按已打开的文件的信息比较，打开前后文件被替换时不会把旧文件的向量索引用在新文件上
+func openPerRequestSearcher(dbPath string, mode string) (*xdb.Searcher, error) {
+	s, err := xdb.NewWithFileOnly(dbPath)
+	if err != nil || mode != fileVectorMode {
+		return s, err
+	}
+
+	info, err := s.Stat()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	vectorIndexesLock.Lock()
+	cached, ok := vectorIndexes[dbPath]
+	vectorIndexesLock.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		if err = s.SetVectorIndex(cached.index); err != nil {
+			s.Close()
+			return nil, err
+		}
+		return s, nil
+	}
+
+	if err = s.LoadVectorIndex(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	vectorIndexesLock.Lock()
+	if len(vectorIndexes) >= vectorIndexCacheSize {
+		vectorIndexes = make(map[string]cachedVectorIndex)
+	}
+	vectorIndexes[dbPath] = cachedVectorIndex{size: info.Size(), modTime: info.ModTime(), index: s.VectorIndex()}
+	vectorIndexesLock.Unlock()
+
+	return s, nil
+}
+
+// clearVectorIndexCache 清空按文件缓存的向量索引，返回清除的文件数量
+func clearVectorIndexCache() int {
+	vectorIndexesLock.Lock()
+	defer vectorIndexesLock.Unlock()
+
+	n := len(vectorIndexes)
+	vectorIndexes = make(map[string]cachedVectorIndex)
+	return n
+}
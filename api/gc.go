@@ -0,0 +1,80 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"ip2region-web/xdb"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 进程内存使用情况，单位为字节
+type MemoryStats struct {
+	HeapAlloc    uint64 `json:"heapAlloc"`    // 堆上仍在使用的对象
+	HeapInuse    uint64 `json:"heapInuse"`    // 堆上正在使用的内存块
+	HeapIdle     uint64 `json:"heapIdle"`     // 堆上空闲的内存块
+	HeapReleased uint64 `json:"heapReleased"` // 已归还操作系统的内存
+	Sys          uint64 `json:"sys"`          // 从操作系统获取的内存总量
+	NumGC        uint32 `json:"numGC"`
+}
+
+func readMemoryStats() MemoryStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return MemoryStats{
+		HeapAlloc:    m.HeapAlloc,
+		HeapInuse:    m.HeapInuse,
+		HeapIdle:     m.HeapIdle,
+		HeapReleased: m.HeapReleased,
+		Sys:          m.Sys,
+		NumGC:        m.NumGC,
+	}
+}
+
+// freeMemory 执行垃圾回收并把空闲内存归还操作系统，
+// FreeOSMemory 会再执行一次回收，sync.Pool中的查询缓冲区在两次回收后释放
+func freeMemory() {
+	runtime.GC()
+	debug.FreeOSMemory()
+}
+
+// 内存回收结果
+type GCResult struct {
+	Before        MemoryStats `json:"before"`
+	After         MemoryStats `json:"after"`
+	IPBuffers     int         `json:"ipBuffers"`     // 丢弃的IP字符串缓冲区数量
+	CacheEntries  int         `json:"cacheEntries"`  // 清除的查询缓存条数
+	VectorIndexes int         `json:"vectorIndexes"` // 清除的file-vector模式向量索引缓存数量
+	TimeTaken     string      `json:"timeTaken"`
+	TimeTakenMs   int64       `json:"timeTakenMs"`
+}
+
+// RunGC 清空缓冲区池、查询缓存和向量索引缓存，然后执行垃圾回收并把空闲内存归还操作系统。
+// 已加载的数据库不受影响，适合在大批量导出等操作之后由运维手动调用
+func RunGC(c *gin.Context) {
+	tStart := time.Now()
+	result := GCResult{Before: readMemoryStats()}
+
+	result.IPBuffers = xdb.DrainIPBufPool()
+	result.CacheEntries = invalidateSearchCache()
+	result.VectorIndexes = clearVectorIndexCache()
+
+	freeMemory()
+
+	result.After = readMemoryStats()
+	timeTaken := time.Since(tStart)
+	result.TimeTaken, result.TimeTakenMs = timeTaken.String(), timeTaken.Milliseconds()
+
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "内存回收完成",
+		Data: result,
+	})
+}
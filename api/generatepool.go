@@ -0,0 +1,118 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"sync"
+)
+
+// 默认同时执行的生成任务数量
+const defaultGenerateWorkers = 2
+
+// generateWaiter 排队等待执行的生成任务，taskID为空表示同步的 /api/generate 请求
+type generateWaiter struct {
+	taskID string
+	ready  chan struct{}
+}
+
+// generatePool 限制同时执行的生成任务数量，超出的任务按提交顺序排队。
+// 每个生成都要完整读取源文件并写入目标文件，同时执行太多只会互相争抢磁盘
+type generatePool struct {
+	lock    sync.Mutex
+	workers int
+	running int
+	waiting []*generateWaiter
+}
+
+var generateTasksPool = &generatePool{workers: defaultGenerateWorkers}
+
+// SetGenerateWorkers 设置同时执行的生成任务数量，小于1时按1处理
+func SetGenerateWorkers(workers int) {
+	generateTasksPool.lock.Lock()
+	defer generateTasksPool.lock.Unlock()
+	generateTasksPool.workers = max(workers, 1)
+	generateTasksPool.dispatchLocked()
+}
+
+// acquire 等待空闲的执行位置，cancel被关闭或done结束时放弃排队并返回false；
+// 返回true后必须调用release
+func (p *generatePool) acquire(taskID string, cancel <-chan bool, done <-chan struct{}) bool {
+	p.lock.Lock()
+	if p.running < p.workers && len(p.waiting) == 0 {
+		p.running++
+		p.lock.Unlock()
+		return true
+	}
+
+	w := &generateWaiter{taskID: taskID, ready: make(chan struct{})}
+	p.waiting = append(p.waiting, w)
+	p.updatePositionsLocked()
+	p.lock.Unlock()
+
+	select {
+	case <-w.ready:
+		return true
+	case <-cancel:
+	case <-done:
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for i, other := range p.waiting {
+		if other == w {
+			p.waiting = append(p.waiting[:i], p.waiting[i+1:]...)
+			p.updatePositionsLocked()
+			if taskID != "" {
+				updateGenerateTaskStatus(taskID, func(task *GenerateTaskStatus) {
+					task.QueuePosition = 0
+				})
+			}
+			return false
+		}
+	}
+
+	// 放弃排队的同时已经分配到了执行位置，交还给下一个任务
+	p.running--
+	p.dispatchLocked()
+	return false
+}
+
+// release 交还执行位置
+func (p *generatePool) release() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.running--
+	p.dispatchLocked()
+}
+
+// dispatchLocked 按排队顺序把空闲的执行位置分配给等待的任务，调用时需持有p.lock
+func (p *generatePool) dispatchLocked() {
+	dispatched := false
+	for p.running < p.workers && len(p.waiting) > 0 {
+		w := p.waiting[0]
+		p.waiting = p.waiting[1:]
+		p.running++
+		close(w.ready)
+		dispatched = true
+	}
+	if dispatched {
+		p.updatePositionsLocked()
+	}
+}
+
+// updatePositionsLocked 把每个排队任务的位置写入任务状态，调用时需持有p.lock
+func (p *generatePool) updatePositionsLocked() {
+	for i, w := range p.waiting {
+		if w.taskID == "" {
+			continue
+		}
+		position := i + 1
+		updateGenerateTaskStatus(w.taskID, func(task *GenerateTaskStatus) {
+			if task.Status == "queued" {
+				task.QueuePosition = position
+			}
+		})
+	}
+}
@@ -6,19 +6,31 @@ package api
 
 import (
 	"bufio"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math"
+	"math/bits"
+	"math/rand"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
 	_ "unsafe" // 用于go:linkname
 
 	"ip2region-web/xdb"
@@ -37,58 +49,430 @@ type Response struct {
 type SearchRequest struct {
 	IP         string `json:"ip" binding:"required"`
 	DbPath     string `json:"dbPath,omitempty"`     // 可选的数据库文件路径
-	SearchMode string `json:"searchMode,omitempty"` // 查询模式：file, vector, memory
+	Alias      string `json:"alias,omitempty"`      // 可选的数据库别名，与dbPath二选一
+	SearchMode string `json:"searchMode,omitempty"` // 查询模式：file, file-vector, vector, hybrid, memory
+
+	// 可选的后备数据库列表，前一个数据库未命中时依次查询；未指定时使用启动参数配置的列表
+	FallbackDbPaths []string `json:"fallbackDbPaths,omitempty"`
+
+	Explain bool `json:"explain,omitempty"` // 为true时在结果中附带索引查找路径
+
+	// IP格式：dotted (默认，点分十进制) 或 int (uint32十进制整数，如16777217)
+	IPFormat string `json:"ipFormat,omitempty"`
+
+	// 只取地区信息中的一个字段：从0开始的位置，或-region-fields配置的字段名
+	Field string `json:"field,omitempty"`
+
+	// 按从0开始的位置重新排列地区信息的字段，结果在regionParts中返回；
+	// reorderRegion为true时region同样按该顺序以 | 连接
+	FieldOrder    []int `json:"fieldOrder,omitempty"`
+	ReorderRegion bool  `json:"reorderRegion,omitempty"`
+
+	// 查询历史快照：使用日期不晚于date (2006-01-02) 的最近一个已注册快照，不能与dbPath和alias同时指定
+	Date string `json:"date,omitempty"`
+
+	// 未命中任何段时返回的地区信息，覆盖-default-region；指定为空字符串时返回空地区
+	DefaultRegion *string `json:"defaultRegion,omitempty"`
 }
 
 // 加载XDB文件到内存请求
 type LoadXdbRequest struct {
 	DbPath     string `json:"dbPath" binding:"required"`
-	SearchMode string `json:"searchMode" binding:"required"` // 查询模式：vector, memory
+	SearchMode string `json:"searchMode" binding:"required"` // 查询模式：vector, hybrid, memory
+	Alias      string `json:"alias,omitempty"`               // 可选的数据库别名，加载后可在查询时代替dbPath
+	Warmup     bool   `json:"warmup,omitempty"`              // 是否在投入使用前预热搜索器
 }
 
 // 加载XDB文件结果
 type LoadXdbResult struct {
 	DbPath        string `json:"dbPath"`
+	Alias         string `json:"alias,omitempty"`
 	SearchMode    string `json:"searchMode"` // 当前加载的模式
 	InMemoryMode  bool   `json:"inMemoryMode"`
 	BufferSizeKB  int64  `json:"bufferSizeKB"`
 	VectorLoaded  bool   `json:"vectorLoaded"`
 	VectorSizeKB  int    `json:"vectorSizeKB"`
+	SegIndexKB    int    `json:"segmentIndexSizeKB"` // 混合模式下常驻内存的段索引块大小
 	LoadTimeTaken string `json:"loadTimeTaken"`
+	LoadTimeMs    int64  `json:"loadTimeMs"` // 加载耗时毫秒数，与loadTimeTaken相同
+	WarmedUp      bool   `json:"warmedUp"`
+	WarmupTaken   string `json:"warmupTimeTaken,omitempty"`
+	WarmupTimeMs  int64  `json:"warmupTimeMs"` // 未预热时为0
 }
 
 // IP查询结果
 type SearchResult struct {
 	Region          string `json:"region"`
 	IoCount         int    `json:"ioCount"`
-	TookNanoseconds int64  `json:"tookNanoseconds"` // 纳秒级精度的查询耗时
-	SearchMode      string `json:"searchMode"`      // 使用的查询模式
-	QueryTime       string `json:"queryTime"`       // 新增：查询完成时的服务器时间
+	TookNanoseconds int64  `json:"tookNanoseconds"`         // 纳秒级精度的查询耗时
+	SearchMode      string `json:"searchMode"`              // 使用的查询模式
+	QueryTime       string `json:"queryTime"`               // 新增：查询完成时的服务器时间
+	DbUsed          string `json:"dbUsed,omitempty"`        // 命中结果的数据库路径
+	Cached          bool   `json:"cached,omitempty"`        // 结果来自查询缓存，此时ioCount为0
+	IsDefault       bool   `json:"isDefault"`               // 未命中任何段，或命中的地区信息为全零的默认值
+	SnapshotDate    string `json:"snapshotDate,omitempty"`  // 请求了date时为实际使用的快照日期
+	Found           bool   `json:"found"`                   // 是否命中了段，未命中时region为配置的默认地区
+	RequestedMode   string `json:"requestedMode,omitempty"` // 实际使用的模式与请求的searchMode不同时为请求的模式
+	ModeNote        string `json:"modeNote,omitempty"`      // 实际使用的模式与请求不同的原因
+	Reserved        bool   `json:"reserved,omitempty"`      // 查询的是私有、回环等保留地址，需开启-reserved-ip
+	ReservedKind    string `json:"reservedKind,omitempty"`  // 保留地址的类别
+
+	Field       string   `json:"field,omitempty"`       // 请求了field时为选择的字段名或位置
+	FieldValue  *string  `json:"fieldValue,omitempty"`  // 请求了field时为该字段的值，字段数量不足时为空字符串
+	RegionParts []string `json:"regionParts,omitempty"` // 请求了fieldOrder时为按该顺序排列的地区字段
+
+	Explain *SearchExplain `json:"explain,omitempty"` // 仅在请求explain时返回
+}
+
+// SearchExplain 查询的索引查找路径，用于诊断某些/16网段IO次数偏多的原因
+type SearchExplain struct {
+	VectorIndex int    `json:"vectorIndex"`          // 向量索引单元格序号 (第一字节*256+第二字节)
+	SPtr        uint32 `json:"sPtr"`                 // 单元格内第一条段索引的位置
+	EPtr        uint32 `json:"ePtr"`                 // 单元格内最后一条段索引之后的位置
+	CellEntries int    `json:"cellEntries"`          // 单元格内的段索引条数
+	Iterations  int    `json:"iterations"`           // 二分查找迭代次数
+	DataPtr     uint32 `json:"dataPtr"`              // 命中的地区数据位置，未命中为0
+	DataLen     int    `json:"dataLen"`              // 命中的地区数据长度
+	VectorPtr   uint32 `json:"vectorPtr"`            // 向量索引单元格在文件中的绝对偏移
+	IndexPtr    uint32 `json:"indexPtr"`             // 命中的段索引条目在文件中的绝对偏移，未命中为0
+	SegStartIP  string `json:"segStartIP,omitempty"` // 命中段索引条目记录的起始IP
+	SegEndIP    string `json:"segEndIP,omitempty"`   // 命中段索引条目记录的结束IP
+
+	// 地区数据的诊断信息：索引记录的字节数与实际返回的地区信息对比，
+	// 两者不一致或不是合法的UTF-8时说明地区数据被截断或损坏
+	RegionBytes  int  `json:"regionBytes"`  // 索引记录的地区数据字节数，同dataLen
+	DecodedBytes int  `json:"decodedBytes"` // 返回的地区信息字节数
+	DecodedRunes int  `json:"decodedRunes"` // 返回的地区信息字符数
+	ValidUTF8    bool `json:"validUTF8"`    // 返回的地区信息是否为合法的UTF-8
+
+	// 单元格内所有覆盖该IP的段索引项，多于一项说明段索引互相重叠，
+	// 二分查找的结果取决于落在哪一项上
+	Matches     []SearchExplainMatch `json:"matches"`
+	Overlapping bool                 `json:"overlapping"`
+}
+
+// SearchExplainMatch 覆盖查询IP的一条段索引项
+type SearchExplainMatch struct {
+	IndexPtr uint32 `json:"indexPtr"`
+	StartIP  string `json:"startIP"`
+	EndIP    string `json:"endIP"`
+	Region   string `json:"region"`
+}
+
+// 批量IP查询请求
+type BatchSearchRequest struct {
+	IPs        []string `json:"ips" binding:"required"`
+	DbPath     string   `json:"dbPath,omitempty"`
+	Alias      string   `json:"alias,omitempty"`
+	SearchMode string   `json:"searchMode,omitempty"`
+}
+
+// 批量查询中单个IP的结果，成功时包含region和ioCount，失败时只包含error
+type BatchSearchItem struct {
+	IP           string  `json:"ip"`
+	Region       *string `json:"region,omitempty"`
+	IoCount      *int    `json:"ioCount,omitempty"`
+	Error        string  `json:"error,omitempty"`
+	Reserved     bool    `json:"reserved,omitempty"`     // 保留地址，需开启-reserved-ip
+	ReservedKind string  `json:"reservedKind,omitempty"` // 保留地址的类别
+}
+
+// 批量IP查询结果
+type BatchSearchResult struct {
+	Results         []BatchSearchItem `json:"results"`
+	Total           int               `json:"total"`
+	ErrorCount      int               `json:"errorCount"`
+	SearchMode      string            `json:"searchMode"`
+	TookNanoseconds int64             `json:"tookNanoseconds"`
+	RequestedMode   string            `json:"requestedMode,omitempty"` // 实际使用的模式与请求的searchMode不同时为请求的模式
+	ModeNote        string            `json:"modeNote,omitempty"`      // 实际使用的模式与请求不同的原因
+}
+
+// CIDR网段查询请求
+type CidrSearchRequest struct {
+	CIDRs      []string `json:"cidrs" binding:"required"`
+	DbPath     string   `json:"dbPath,omitempty"`
+	Alias      string   `json:"alias,omitempty"`
+	SearchMode string   `json:"searchMode,omitempty"`
+}
+
+// 网段内某个地区覆盖的IP数量
+type CidrRegionCount struct {
+	Region  string `json:"region"`
+	IPCount uint64 `json:"ipCount"`
+}
+
+// 单个网段的查询结果，地区按IP数量从多到少排列
+type CidrSearchItem struct {
+	CIDR     string            `json:"cidr"`
+	StartIP  string            `json:"startIP,omitempty"`
+	EndIP    string            `json:"endIP,omitempty"`
+	TotalIPs uint64            `json:"totalIPs,omitempty"`
+	Regions  []CidrRegionCount `json:"regions,omitempty"`
+	Lookups  int               `json:"lookups,omitempty"` // 实际执行的查询次数
+	Sampled  bool              `json:"sampled,omitempty"` // 网段内IP段过多时改为均匀抽样，此时IP数量为估算值
+	Error    string            `json:"error,omitempty"`
+}
+
+// CIDR网段查询结果
+type CidrSearchResult struct {
+	Results         []CidrSearchItem `json:"results"`
+	Total           int              `json:"total"`
+	ErrorCount      int              `json:"errorCount"`
+	SearchMode      string           `json:"searchMode"`
+	TookNanoseconds int64            `json:"tookNanoseconds"`
+}
+
+// 地区分布统计请求，范围用cidr或startIP/endIP指定
+type RegionHistogramRequest struct {
+	CIDR       string `json:"cidr,omitempty"`
+	StartIP    string `json:"startIP,omitempty"`
+	EndIP      string `json:"endIP,omitempty"`
+	Top        int    `json:"top,omitempty"` // 只返回IP数量最多的前N个地区，0表示全部返回
+	DbPath     string `json:"dbPath,omitempty"`
+	Alias      string `json:"alias,omitempty"`
+	SearchMode string `json:"searchMode,omitempty"`
+}
+
+// 地区分布统计结果，地区按IP数量从多到少排列
+type RegionHistogramResult struct {
+	StartIP         string            `json:"startIP"`
+	EndIP           string            `json:"endIP"`
+	TotalIPs        uint64            `json:"totalIPs"`
+	CoveredIPs      uint64            `json:"coveredIPs"`  // 被IP段覆盖的IP数量，与totalIPs的差为未覆盖的IP
+	Segments        int               `json:"segments"`    // 与范围相交的IP段数量
+	RegionCount     int               `json:"regionCount"` // 范围内不同地区的数量
+	Regions         []CidrRegionCount `json:"regions"`
+	OtherIPs        uint64            `json:"otherIPs,omitempty"` // 指定top时，未列出的地区覆盖的IP数量之和
+	SearchMode      string            `json:"searchMode"`
+	TookNanoseconds int64             `json:"tookNanoseconds"`
+}
+
+// 相邻IP段查询请求
+type NeighborsRequest struct {
+	IP         string `json:"ip" binding:"required"`
+	DbPath     string `json:"dbPath,omitempty"`
+	Alias      string `json:"alias,omitempty"`
+	SearchMode string `json:"searchMode,omitempty"`
+}
+
+// 相邻IP段查询中的单个IP段
+type NeighborSegment struct {
+	StartIP string `json:"startIP"`
+	EndIP   string `json:"endIP"`
+	Region  string `json:"region"`
+}
+
+// 相邻IP段查询结果，位于第一个或最后一个段时prev或next为空
+type NeighborsResult struct {
+	IP         string           `json:"ip"`
+	Prev       *NeighborSegment `json:"prev"`
+	Current    *NeighborSegment `json:"current"`
+	Next       *NeighborSegment `json:"next"`
+	SearchMode string           `json:"searchMode"`
+}
+
+func toNeighborSegment(seg *xdb.Segment) *NeighborSegment {
+	if seg == nil {
+		return nil
+	}
+
+	return &NeighborSegment{
+		StartIP: xdb.Long2IP(seg.StartIP),
+		EndIP:   xdb.Long2IP(seg.EndIP),
+		Region:  seg.Region,
+	}
+}
+
+// 向量索引单元格占用统计请求
+type VectorOccupancyRequest struct {
+	DbPath       string `json:"dbPath,omitempty"`
+	Alias        string `json:"alias,omitempty"`
+	SearchMode   string `json:"searchMode,omitempty"`
+	Top          int    `json:"top,omitempty"`          // 返回段索引条数最多的单元格数量，默认10
+	IncludeCells bool   `json:"includeCells,omitempty"` // 同时返回256x256的完整矩阵，用于绘制热力图
+}
+
+// 单个向量索引单元格，对应一个/16网段
+type VectorCell struct {
+	Cell    string `json:"cell"` // 例如 1.0.0.0/16
+	Entries int    `json:"entries"`
+}
+
+// 向量索引单元格占用统计结果
+type VectorOccupancyResult struct {
+	Cells          int          `json:"cells"`
+	EmptyCells     int          `json:"emptyCells"`
+	TotalEntries   int          `json:"totalEntries"`
+	MinEntries     int          `json:"minEntries"` // 非空单元格中的最小条数
+	MaxEntries     int          `json:"maxEntries"`
+	AvgEntries     float64      `json:"avgEntries"`          // 所有单元格的平均条数
+	AvgNonEmpty    float64      `json:"avgNonEmpty"`         // 非空单元格的平均条数
+	MaxSearchDepth int          `json:"maxSearchDepth"`      // 最大单元格的二分查找迭代次数上限
+	Hotspots       []VectorCell `json:"hotspots"`            // 条数最多的单元格，从多到少
+	Occupancy      [][]int      `json:"occupancy,omitempty"` // [第一字节][第二字节]
+	SearchMode     string       `json:"searchMode"`
 }
 
 // 数据库生成请求
 type GenDbRequest struct {
-	SrcFile string `json:"srcFile" binding:"required"`
-	DstFile string `json:"dstFile" binding:"required"`
+	SrcFile     string `json:"srcFile" binding:"required"`
+	DstFile     string `json:"dstFile" binding:"required"`
+	IndexPolicy string `json:"indexPolicy,omitempty"` // 索引策略，目前只支持vector，默认vector
+	Encoding    string `json:"encoding,omitempty"`    // 源文件编码：utf-8, gbk，默认utf-8
+	FileMode    string `json:"fileMode,omitempty"`    // 生成文件的八进制权限，例如 0640，默认使用-file-mode
+	Strict      bool   `json:"strict,omitempty"`      // 严格模式：源数据有空缺或重叠时拒绝生成并列出问题
+
+	// 地区信息的结构要求，有不符合的行时拒绝生成并列出这些行
+	RegionSchema *xdb.RegionSchema `json:"regionSchema,omitempty"`
+}
+
+// 导出XDB请求
+type ExportXdbRequest struct {
+	XdbPath      string `json:"xdbPath" binding:"required"`
+	ExportPath   string `json:"exportPath" binding:"required"`
+	BufferSizeKB int    `json:"bufferSizeKB,omitempty"` // 写文件缓冲区大小(KB)，默认使用-export-buffer-kb
+	StepSize     int    `json:"stepSize,omitempty"`     // 扫描步长(IP数)，默认使用-export-step
+	CallbackURL  string `json:"callbackUrl,omitempty"`  // 任务结束后POST最终状态的地址
+
+	// 在文件开头写入以#开头的来源信息注释，解析源文件时会跳过这些行
+	IncludeHeader bool `json:"includeHeader,omitempty"`
+
+	// 以gzip格式写出，exportPath以.gz结尾时自动启用
+	Compress bool `json:"compress,omitempty"`
+
+	// 导出文件的八进制权限，例如 0640，默认使用-file-mode
+	FileMode string `json:"fileMode,omitempty"`
+}
+
+// XDB同步转换请求
+type ConvertXdbRequest struct {
+	XdbPath string `json:"xdbPath" binding:"required"`
 }
 
 // 编辑IP段请求
 type EditSegmentRequest struct {
-	Segment string `json:"segment" binding:"required"`
-	SrcFile string `json:"srcFile" binding:"required"`
+	Segment  string `json:"segment" binding:"required"`
+	SrcFile  string `json:"srcFile" binding:"required"`
+	FillOnly bool   `json:"fillOnly,omitempty"` // 只填充空白或默认地区的范围，不覆盖已有地区
+	Encoding string `json:"encoding,omitempty"` // 源文件编码：utf-8, gbk，默认utf-8
+}
+
+// 按IP修改所在段地区的请求
+type EditSegmentAtRequest struct {
+	SrcFile   string `json:"srcFile" binding:"required"`
+	IP        string `json:"ip" binding:"required"`
+	NewRegion string `json:"newRegion" binding:"required"`
+	Encoding  string `json:"encoding,omitempty"` // 源文件编码：utf-8, gbk，默认utf-8
+}
+
+// 按IP修改所在段地区的结果，起止IP为段原有的边界
+type EditSegmentAtResult struct {
+	StartIP   string `json:"startIP"`
+	EndIP     string `json:"endIP"`
+	OldRegion string `json:"oldRegion"`
+	NewRegion string `json:"newRegion"`
+	Segment   string `json:"segment"` // 修改后的段
+}
+
+// 批量替换地区请求
+type ReplaceRegionRequest struct {
+	SrcFile   string `json:"srcFile" binding:"required"`
+	OldRegion string `json:"oldRegion" binding:"required"`
+	NewRegion string `json:"newRegion"`
+	Match     string `json:"match,omitempty"`    // exact (默认): 整个地区等于oldRegion；substring: 替换地区中出现的所有oldRegion
+	Encoding  string `json:"encoding,omitempty"` // 源文件编码：utf-8, gbk，默认utf-8
 }
 
 // 编辑文件请求
 type EditFileRequest struct {
-	File    string `json:"file" binding:"required"`
-	SrcFile string `json:"srcFile" binding:"required"`
+	File     string `json:"file" binding:"required"`
+	SrcFile  string `json:"srcFile" binding:"required"`
+	FillOnly bool   `json:"fillOnly,omitempty"` // 只填充空白或默认地区的范围，不覆盖已有地区
+	Encoding string `json:"encoding,omitempty"` // 源文件编码：utf-8, gbk，默认utf-8
+	Async    bool   `json:"async,omitempty"`    // 异步执行，返回taskId，通过 /api/edit/file-task/:taskId 查询进度
+}
+
+// 内联编辑请求：源文本随请求提交，编辑结果随响应返回，服务端不读写任何文件
+type InlineEditRequest struct {
+	Source   string   `json:"source"`             // 源文本，为空时从整个IP空间的默认段开始
+	Segments []string `json:"segments,omitempty"` // 依次写入的IP段，格式同 /api/edit/segment
+	Patch    string   `json:"patch,omitempty"`    // 补丁文本，格式与源文件相同，在segments之后写入
+	FillOnly bool     `json:"fillOnly,omitempty"` // 只填充空白或默认地区的范围，不覆盖已有地区
+}
+
+// 内联编辑结果
+type InlineEditResult struct {
+	Source   string `json:"source"`
+	SegLen   int    `json:"segLen"`
+	OldCount int    `json:"oldCount"`
+	NewCount int    `json:"newCount"`
+	Applied  int    `json:"applied"`
+	Skipped  int    `json:"skipped"`
+}
+
+// 校验IP段请求
+type ValidateSegmentRequest struct {
+	Segment      string            `json:"segment" binding:"required"`
+	RegionSchema *xdb.RegionSchema `json:"regionSchema,omitempty"` // 同时检查地区信息的字段数量和必填字段
+}
+
+// 校验IP段结果，valid为false时只有error和hint
+type ValidateSegmentResult struct {
+	Valid       bool     `json:"valid"`
+	Error       string   `json:"error,omitempty"`
+	Hint        string   `json:"hint,omitempty"`
+	StartIP     string   `json:"startIP,omitempty"`
+	EndIP       string   `json:"endIP,omitempty"`
+	IPCount     uint64   `json:"ipCount,omitempty"`
+	Region      string   `json:"region,omitempty"`
+	RegionParts []string `json:"regionParts,omitempty"`
+	IsDefault   bool     `json:"isDefault,omitempty"` // 地区为空或全为0
+	Canonical   string   `json:"canonical,omitempty"` // 规范化后的写法，与保存到源文件中的一致
+}
+
+// 编辑请求的合并模式
+func editPutMode(fillOnly bool) xdb.PutMode {
+	if fillOnly {
+		return xdb.PutFillOnly
+	}
+	return xdb.PutOverwrite
+}
+
+// 编辑差异查询参数
+type EditDiffRequest struct {
+	SrcFile string `form:"srcFile" binding:"required"`
+	Limit   int    `form:"limit"` // 每类差异最多返回的条数，默认1000
+}
+
+// 单条差异，新增和删除的段只有region，修改的段有oldRegion和newRegion
+type SegmentDiffItem struct {
+	StartIP   string `json:"startIP"`
+	EndIP     string `json:"endIP"`
+	Region    string `json:"region,omitempty"`
+	OldRegion string `json:"oldRegion,omitempty"`
+	NewRegion string `json:"newRegion,omitempty"`
+}
+
+// 编辑差异结果，各类差异超过limit时只返回前limit条，count为实际总数
+type EditDiffResult struct {
+	SrcFile       string            `json:"srcFile"`
+	Dirty         bool              `json:"dirty"` // 编辑器中是否有未保存的修改
+	Added         []SegmentDiffItem `json:"added"`
+	Removed       []SegmentDiffItem `json:"removed"`
+	Modified      []SegmentDiffItem `json:"modified"`
+	AddedCount    int               `json:"addedCount"`
+	RemovedCount  int               `json:"removedCount"`
+	ModifiedCount int               `json:"modifiedCount"`
+	Truncated     bool              `json:"truncated"`
 }
 
 // 查看IP段请求
 type ListSegmentsRequest struct {
-	Offset  int    `json:"offset"`
-	Size    int    `json:"size"`
-	SrcFile string `json:"srcFile" binding:"required"`
+	Offset   int    `json:"offset"`
+	Size     int    `json:"size"`
+	SrcFile  string `json:"srcFile" binding:"required"`
+	Encoding string `json:"encoding,omitempty"` // 源文件编码：utf-8, gbk，默认utf-8
 }
 
 // 保存编辑请求
@@ -96,10 +480,130 @@ type SaveEditRequest struct {
 	SrcFile string `json:"srcFile" binding:"required"`
 }
 
+// 整理源文件请求
+type CompactSourceRequest struct {
+	SrcFile  string `json:"srcFile" binding:"required"`
+	Encoding string `json:"encoding,omitempty"` // 源文件编码：utf-8(默认) 或 gbk
+}
+
+// 整理源文件结果
+type CompactSourceResult struct {
+	SrcFile     string `json:"srcFile"`
+	LinesBefore int    `json:"linesBefore"` // 整理前的行数，包括注释和空行
+	LinesAfter  int    `json:"linesAfter"`  // 整理后的行数，即段的数量
+}
+
 // 保存编辑并生成数据库请求
 type SaveAndGenerateRequest struct {
-	SrcFile string `json:"srcFile" binding:"required"`
-	DstFile string `json:"dstFile" binding:"required"`
+	SrcFile     string `json:"srcFile" binding:"required"`
+	DstFile     string `json:"dstFile" binding:"required"`
+	IndexPolicy string `json:"indexPolicy,omitempty"` // 索引策略，目前只支持vector，默认vector
+	Encoding    string `json:"encoding,omitempty"`    // 源文件编码：utf-8, gbk，默认utf-8
+	CallbackURL string `json:"callbackUrl,omitempty"` // 异步生成任务结束后POST最终状态的地址
+	FileMode    string `json:"fileMode,omitempty"`    // 生成文件的八进制权限，例如 0640，默认使用-file-mode
+	Strict      bool   `json:"strict,omitempty"`      // 严格模式：源数据有空缺或重叠时拒绝生成并列出问题
+
+	// 地区信息的结构要求，有不符合的行时拒绝生成并列出这些行
+	RegionSchema *xdb.RegionSchema `json:"regionSchema,omitempty"`
+}
+
+// continuityProblems 严格模式下的连续性错误中记录的问题，其它错误返回nil
+func continuityProblems(err error) *xdb.ContinuityError {
+	var cErr *xdb.ContinuityError
+	if errors.As(err, &cErr) {
+		return cErr
+	}
+	return nil
+}
+
+// regionSchemaViolations 地区信息结构检查失败时的错误，其它错误返回nil
+func regionSchemaViolations(err error) *xdb.RegionSchemaError {
+	var sErr *xdb.RegionSchemaError
+	if errors.As(err, &sErr) {
+		return sErr
+	}
+	return nil
+}
+
+// checkRegionSchema 检查请求中的地区信息结构要求，无效时返回400并返回false
+func checkRegionSchema(c *gin.Context, schema *xdb.RegionSchema) bool {
+	if schema == nil {
+		return true
+	}
+
+	if err := schema.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "无效的regionSchema: " + err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+// checkFileMode 解析请求中的八进制文件权限，未指定时返回0，无效时返回400并返回false
+func checkFileMode(c *gin.Context, fileMode string) (os.FileMode, bool) {
+	mode, err := xdb.ParseFileMode(fileMode)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  err.Error(),
+		})
+		return 0, false
+	}
+	return mode, true
+}
+
+// checkEncoding 校验并规范化请求中的源文件编码，未指定时保持为空，不支持的编码返回400并返回false
+func checkEncoding(c *gin.Context, encoding *string) bool {
+	if *encoding == "" {
+		return true
+	}
+
+	normalized, err := xdb.NormalizeEncoding(*encoding)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  fmt.Sprintf("不支持的源文件编码: %s，支持的编码: utf-8, gbk", *encoding),
+		})
+		return false
+	}
+
+	*encoding = normalized
+	return true
+}
+
+// sourceEncodingFor 生成时使用的源文件编码：请求未指定时沿用该文件编辑器的编码
+func sourceEncodingFor(srcFile string, encoding string) string {
+	if encoding != "" {
+		return encoding
+	}
+
+	editorsLock.RLock()
+	defer editorsLock.RUnlock()
+	if editor, ok := editors[srcFile]; ok {
+		return editor.Encoding()
+	}
+
+	return xdb.EncodingUTF8
+}
+
+// parseIndexPolicy 解析请求中的索引策略名称，为空时使用向量索引
+func parseIndexPolicy(name string) (xdb.IndexPolicy, error) {
+	if name == "" {
+		return xdb.VectorIndexPolicy, nil
+	}
+
+	policy, err := xdb.IndexPolicyFromString(name)
+	if err != nil {
+		return 0, fmt.Errorf("不支持的索引策略: %s，支持的策略: vector", name)
+	}
+	// Maker 只实现了向量索引的布局，btree 只会写入头部而不会改变文件结构
+	if policy != xdb.VectorIndexPolicy {
+		return 0, fmt.Errorf("索引策略 %s 尚未实现，支持的策略: vector", policy)
+	}
+
+	return policy, nil
 }
 
 // 单个IP查询修改请求
@@ -130,11 +634,54 @@ type ModifyIPRequest struct {
 var (
 	searcher     *xdb.Searcher
 	searcherPath string
-	searcherMode string       // 当前搜索器模式：file, vector, memory
+	searcherMode string       // 当前搜索器模式：file, vector, hybrid, memory
 	inMemoryMode int32        // 使用atomic操作，0表示false，1表示true
 	searcherLock sync.RWMutex // 保护searcher和searcherPath的读写锁
+
+	// 加载时数据库文件的大小和修改时间，用于判断内存中的数据是否已经过期
+	searcherFileInfo os.FileInfo
+
+	// 数据库别名表：别名 -> 加载时的路径和模式，同样由searcherLock保护
+	searcherAliases = make(map[string]searcherAlias)
 )
 
+// 数据库别名信息
+type searcherAlias struct {
+	DbPath     string `json:"dbPath"`
+	SearchMode string `json:"searchMode"`
+}
+
+// 记录数据库别名
+func setSearcherAlias(alias string, dbPath string, mode string) {
+	searcherLock.Lock()
+	defer searcherLock.Unlock()
+	searcherAliases[alias] = searcherAlias{DbPath: dbPath, SearchMode: mode}
+}
+
+// 根据别名解析数据库路径和加载模式
+func resolveSearcherAlias(alias string) (searcherAlias, bool) {
+	searcherLock.RLock()
+	defer searcherLock.RUnlock()
+	a, ok := searcherAliases[alias]
+	return a, ok
+}
+
+// 启动时配置的后备数据库列表（使用atomic.Value保护，存储[]string）
+var fallbackDbPaths atomic.Value
+
+// SetFallbackDbPaths 设置默认的后备数据库列表，主数据库未命中时依次查询
+func SetFallbackDbPaths(paths []string) {
+	fallbackDbPaths.Store(append([]string(nil), paths...))
+}
+
+// 获取默认的后备数据库列表
+func getFallbackDbPaths() []string {
+	if val := fallbackDbPaths.Load(); val != nil {
+		return val.([]string)
+	}
+	return nil
+}
+
 // 全局编辑文件路径（使用atomic.Value保护）
 var (
 	currentEditFilePath atomic.Value // 存储string类型
@@ -152,6 +699,9 @@ type SearchStats struct {
 	totalSearches     int64 // 总搜索次数
 	totalErrors       int64 // 总错误次数
 	totalIoOperations int64 // 总IO操作次数
+	indexSearches     int64 // 查找过段索引的次数，不包括缓存命中和未映射的/16网段
+	totalIterations   int64 // 段索引二分查找的总迭代次数
+	clientCancelled   int64 // 客户端在查询完成前断开的次数，不计入错误
 }
 
 var globalStats SearchStats
@@ -163,14 +713,55 @@ func GetSearchStats() (searches, errors, ioOps int64) {
 		atomic.LoadInt64(&globalStats.totalIoOperations)
 }
 
+// GetIterationStats 获取查找过段索引的次数和二分查找的总迭代次数
+func GetIterationStats() (indexSearches, iterations int64) {
+	return atomic.LoadInt64(&globalStats.indexSearches),
+		atomic.LoadInt64(&globalStats.totalIterations)
+}
+
+// GetClientCancelledCount 获取客户端中途断开的查询次数
+func GetClientCancelledCount() int64 {
+	return atomic.LoadInt64(&globalStats.clientCancelled)
+}
+
+// statusClientClosedRequest 客户端在服务端响应前关闭连接，沿用nginx的499状态码
+const statusClientClosedRequest = 499
+
+// abortIfClientGone 客户端已断开连接时记录499并跳过响应写入，返回true表示已中止
+func abortIfClientGone(c *gin.Context) bool {
+	if c.Request.Context().Err() == nil {
+		return false
+	}
+
+	atomic.AddInt64(&globalStats.clientCancelled, 1)
+	c.AbortWithStatus(statusClientClosedRequest)
+	return true
+}
+
+// 搜索器加载选项
+type searcherOptions struct {
+	// 新搜索器替换全局搜索器之前执行，例如预热
+	beforeSwap func(s *xdb.Searcher) error
+}
+
 // 获取或创建指定模式的搜索器
 func getSearcherByMode(dbPath string, mode string) (*xdb.Searcher, error) {
+	return getSearcherWithOptions(dbPath, mode, searcherOptions{})
+}
+
+// isCachedMode 判断是否为常驻的全局搜索器模式，文件模式的搜索器用完即关
+func isCachedMode(mode string) bool {
+	return mode == "vector" || mode == "hybrid" || mode == "memory"
+}
+
+// 按加载选项获取或创建指定模式的搜索器
+func getSearcherWithOptions(dbPath string, mode string, opts searcherOptions) (*xdb.Searcher, error) {
 	// 文件模式不使用全局缓存，应该由调用方自己管理生命周期
 	if mode == "file" {
 		return xdb.NewWithFileOnly(dbPath)
 	}
 
-	// 先使用读锁检查（仅限向量和内存模式）
+	// 先使用读锁检查（仅限常驻模式）
 	searcherLock.RLock()
 	if searcherPath == dbPath && searcher != nil && searcherMode == mode {
 		searcherLock.RUnlock()
@@ -195,12 +786,20 @@ func getSearcherByMode(dbPath string, mode string) (*xdb.Searcher, error) {
 		searcherMode = ""
 		atomic.StoreInt32(&inMemoryMode, 0)
 	}
+	invalidateSearchCache()
+
+	// 在打开文件之前记录文件信息，加载期间文件被替换时会被判断为过期而不是漏判
+	fileInfo, err := os.Stat(dbPath)
+	if err != nil {
+		return nil, err
+	}
 
 	// 根据模式创建新的搜索器（排除文件模式）
-	var err error
 	switch mode {
 	case "vector":
 		searcher, err = xdb.NewSearcherWithVectorIndex(dbPath)
+	case "hybrid":
+		searcher, err = xdb.NewSearcherWithHybridMode(dbPath)
 	case "memory":
 		searcher, err = xdb.NewSearcherWithMemoryMode(dbPath)
 	default:
@@ -211,14 +810,24 @@ func getSearcherByMode(dbPath string, mode string) (*xdb.Searcher, error) {
 		return nil, err
 	}
 
+	if opts.beforeSwap != nil {
+		if err = opts.beforeSwap(searcher); err != nil {
+			searcher.Close()
+			searcher = nil
+			return nil, err
+		}
+	}
+
 	// 设置全局变量
 	searcherPath = dbPath
 	searcherMode = mode
+	searcherFileInfo = fileInfo
 	if searcher.IsMemoryMode() {
 		atomic.StoreInt32(&inMemoryMode, 1)
 	} else {
 		atomic.StoreInt32(&inMemoryMode, 0)
 	}
+	retargetWatch(dbPath)
 
 	return searcher, nil
 }
@@ -234,20 +843,41 @@ func LoadXdbToMemory(c *gin.Context) {
 		return
 	}
 
+	if !resolveRequestPaths(c, &req.DbPath) {
+		return
+	}
+
 	// 验证搜索模式
-	if req.SearchMode != "vector" && req.SearchMode != "memory" {
+	if !isCachedMode(req.SearchMode) {
 		c.JSON(http.StatusBadRequest, Response{
 			Code: 400,
-			Msg:  "不支持的搜索模式，只支持: vector, memory",
+			Msg:  "不支持的搜索模式，只支持: vector, hybrid, memory",
 		})
 		return
 	}
 
+	req.Alias = strings.TrimSpace(req.Alias)
+
 	// 开始计时
 	tStart := time.Now()
 
-	// 根据模式加载搜索器
-	s, err := getSearcherByMode(req.DbPath, req.SearchMode)
+	// 根据模式加载搜索器，需要预热时在替换全局搜索器之前完成
+	var warmedUp bool
+	var warmupTaken time.Duration
+	var opts searcherOptions
+	if req.Warmup {
+		opts.beforeSwap = func(s *xdb.Searcher) error {
+			wStart := time.Now()
+			if err := s.Warmup(); err != nil {
+				return fmt.Errorf("预热失败: %w", err)
+			}
+			warmupTaken = time.Since(wStart)
+			warmedUp = true
+			return nil
+		}
+	}
+
+	s, err := getSearcherWithOptions(req.DbPath, req.SearchMode, opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{
 			Code: 500,
@@ -256,21 +886,48 @@ func LoadXdbToMemory(c *gin.Context) {
 		return
 	}
 
+	// 已加载的搜索器被直接复用时也按请求预热
+	if req.Warmup && !warmedUp {
+		if err := opts.beforeSwap(s); err != nil {
+			c.JSON(http.StatusInternalServerError, Response{
+				Code: 500,
+				Msg:  err.Error(),
+			})
+			return
+		}
+	}
+
+	// 记录别名，后续查询可以直接使用别名
+	if req.Alias != "" {
+		setSearcherAlias(req.Alias, req.DbPath, req.SearchMode)
+	}
+
 	// 获取加载结果信息
+	loadTaken := time.Since(tStart)
 	result := LoadXdbResult{
 		DbPath:        req.DbPath,
+		Alias:         req.Alias,
 		SearchMode:    req.SearchMode,
 		InMemoryMode:  s.IsMemoryMode(),
 		BufferSizeKB:  s.GetContentBufferSize() / 1024,
 		VectorLoaded:  s.IsVectorIndexLoaded(),
 		VectorSizeKB:  s.GetVectorIndexSize() / 1024,
-		LoadTimeTaken: time.Since(tStart).String(),
+		SegIndexKB:    s.GetSegmentIndexSize() / 1024,
+		LoadTimeTaken: loadTaken.String(),
+		LoadTimeMs:    loadTaken.Milliseconds(),
+		WarmedUp:      warmedUp,
+	}
+	if warmedUp {
+		result.WarmupTaken = warmupTaken.String()
+		result.WarmupTimeMs = warmupTaken.Milliseconds()
 	}
 
 	var modeDesc string
 	switch req.SearchMode {
 	case "vector":
 		modeDesc = "向量索引模式"
+	case "hybrid":
+		modeDesc = "混合模式"
 	case "memory":
 		modeDesc = "完全内存模式"
 	}
@@ -285,8 +942,6 @@ func LoadXdbToMemory(c *gin.Context) {
 // 卸载内存中的XDB文件
 func UnloadXdb(c *gin.Context) {
 	searcherLock.Lock()
-	defer searcherLock.Unlock()
-
 	if searcher != nil {
 		searcher.Close()
 	}
@@ -294,9 +949,12 @@ func UnloadXdb(c *gin.Context) {
 	searcher = nil
 	searcherPath = ""
 	atomic.StoreInt32(&inMemoryMode, 0)
+	invalidateSearchCache()
+	searcherLock.Unlock()
 
-	// 强制垃圾回收，确保释放文件句柄
-	runtime.GC()
+	// 强制垃圾回收，确保释放文件句柄和内存模式的缓冲区；
+	// 回收可能耗时较长，在释放锁之后执行，不阻塞其他查询
+	freeMemory()
 
 	c.JSON(http.StatusOK, Response{
 		Code: 0,
@@ -317,11 +975,13 @@ func GetXdbStatus(c *gin.Context) {
 		"vectorIndex": false,
 		"bufferSize":  int64(0),
 		"vectorSize":  0,
+		"aliases":     searcherAliases,
+		"cache":       GetSearchCacheStats(),
 	}
 
-	// 只有向量模式和内存模式才显示为已加载状态
+	// 只有常驻模式 (向量、混合、内存) 才显示为已加载状态
 	// 文件模式不保持加载状态，因为它是用完即关的
-	if searcher != nil && (searcherMode == "vector" || searcherMode == "memory") {
+	if searcher != nil && isCachedMode(searcherMode) {
 		status["loaded"] = true
 		status["dbPath"] = searcherPath
 		status["searchMode"] = searcherMode
@@ -329,6 +989,12 @@ func GetXdbStatus(c *gin.Context) {
 		status["vectorIndex"] = searcher.IsVectorIndexLoaded()
 		status["bufferSize"] = searcher.GetContentBufferSize()
 		status["vectorSize"] = searcher.GetVectorIndexSize()
+		status["segmentIndex"] = searcher.IsSegmentIndexLoaded()
+		status["segmentIndexSize"] = searcher.GetSegmentIndexSize()
+		if count, err := searcher.RegionCount(); err == nil {
+			status["regionCount"] = count
+		}
+		addStaleStatus(status, searcherPath, searcherFileInfo)
 	}
 
 	c.JSON(http.StatusOK, Response{
@@ -338,6 +1004,76 @@ func GetXdbStatus(c *gin.Context) {
 	})
 }
 
+// addStaleStatus 对比加载时和当前磁盘上的文件大小与修改时间，不一致或文件已不存在时stale为true
+func addStaleStatus(status map[string]interface{}, dbPath string, loaded os.FileInfo) {
+	if loaded == nil {
+		return
+	}
+
+	status["fileSize"] = loaded.Size()
+	status["fileModTime"] = loaded.ModTime()
+
+	current, err := os.Stat(dbPath)
+	if err != nil {
+		status["stale"] = true
+		status["diskError"] = err.Error()
+		return
+	}
+
+	status["diskFileSize"] = current.Size()
+	status["diskFileModTime"] = current.ModTime()
+	status["stale"] = current.Size() != loaded.Size() || !current.ModTime().Equal(loaded.ModTime())
+}
+
+// 解析查询目标：alias 与 dbPath 二选一，未指定模式时使用别名加载时的模式
+func resolveSearchTarget(dbPath string, alias string, searchMode string) (string, string, error) {
+	if alias == "" {
+		return dbPath, searchMode, nil
+	}
+
+	if dbPath != "" {
+		return "", "", fmt.Errorf("dbPath和alias不能同时指定")
+	}
+
+	a, ok := resolveSearcherAlias(alias)
+	if !ok {
+		return "", "", fmt.Errorf("未知的数据库别名: %s", alias)
+	}
+
+	if searchMode == "" {
+		searchMode = a.SearchMode
+	}
+	return a.DbPath, searchMode, nil
+}
+
+// validateSearchTarget 在查询之前检查数据库路径与查询模式的组合，
+// 让明显无效的请求直接返回400，而不是在查询过程中才失败
+func validateSearchTarget(dbPath string, searchMode string) error {
+	if searchMode != "" && !isPerRequestMode(searchMode) && !isCachedMode(searchMode) {
+		return fmt.Errorf("不支持的搜索模式: %s，支持的模式: file, file-vector, vector, hybrid, memory", searchMode)
+	}
+
+	if dbPath != "" {
+		return nil
+	}
+
+	if isPerRequestMode(searchMode) {
+		return fmt.Errorf("%s模式需要通过dbPath或alias指定数据库", searchMode)
+	}
+
+	searcherLock.RLock()
+	loaded := searcher != nil && isCachedMode(searcherMode)
+	searcherLock.RUnlock()
+	if !loaded {
+		if searchMode == "" {
+			return fmt.Errorf("未指定数据库，且没有已加载的数据库，请指定dbPath或alias，或先加载数据库")
+		}
+		return fmt.Errorf("%s模式未指定数据库时使用已加载的数据库，但当前没有已加载的数据库，请指定dbPath或alias，或先加载数据库", searchMode)
+	}
+
+	return nil
+}
+
 // SearchIP 搜索IP地址信息
 func SearchIP(c *gin.Context) {
 	var req SearchRequest
@@ -350,456 +1086,447 @@ func SearchIP(c *gin.Context) {
 		return
 	}
 
-	// 增加搜索计数
-	atomic.AddInt64(&globalStats.totalSearches, 1)
-
-	result, err := SearchIPFunc(req.IP, req.DbPath, req.SearchMode)
-	if err != nil {
+	paths := []*string{&req.DbPath}
+	for i := range req.FallbackDbPaths {
+		paths = append(paths, &req.FallbackDbPaths[i])
+	}
+	if !resolveRequestPaths(c, paths...) {
 		atomic.AddInt64(&globalStats.totalErrors, 1)
-		c.JSON(http.StatusInternalServerError, Response{
-			Code: 500,
-			Msg:  "搜索失败: " + err.Error(),
-		})
 		return
 	}
 
-	// 增加IO操作计数
-	atomic.AddInt64(&globalStats.totalIoOperations, int64(result.IoCount))
+	// 按日期选择历史快照
+	var snapshot DbSnapshot
+	if req.Date != "" {
+		var err error
+		if req.DbPath != "" || req.Alias != "" {
+			err = fmt.Errorf("date不能与dbPath或alias同时指定")
+		} else {
+			snapshot, err = resolveSnapshot(req.Date)
+		}
+		if err != nil {
+			atomic.AddInt64(&globalStats.totalErrors, 1)
+			c.JSON(http.StatusBadRequest, Response{
+				Code: 400,
+				Msg:  err.Error(),
+			})
+			return
+		}
 
-	c.JSON(http.StatusOK, Response{
-		Code: 0,
-		Msg:  "搜索成功",
-		Data: result,
-	})
-}
-
-// SearchIPFunc 内部IP搜索函数
-func SearchIPFunc(ip string, dbPath string, searchMode string) (*SearchResult, error) {
-	var s *xdb.Searcher
-	var err error
-	var usedMode string
-	var shouldCloseSearcher bool = false // 标记是否需要在函数结束时关闭searcher
-
-	// 如果是文件模式，每次都创建新的searcher，用完即关
-	if searchMode == "file" {
-		if dbPath == "" {
-			return nil, fmt.Errorf("文件模式需要指定数据库文件路径")
-		}
-
-		s, err = xdb.NewWithFileOnly(dbPath)
-		if err != nil {
-			return nil, fmt.Errorf("加载数据库失败: %s", err.Error())
+		req.DbPath = snapshot.DbPath
+		if req.SearchMode == "" {
+			req.SearchMode = snapshot.SearchMode
 		}
-		usedMode = "file"
-		shouldCloseSearcher = true // 文件模式需要关闭
-	} else {
-		// 对于向量模式和内存模式，先检查是否有已加载的数据库可以使用
-		searcherLock.RLock()
-		hasLoadedSearcher := searcher != nil
-		loadedPath := searcherPath
-		loadedMode := searcherMode
-		searcherLock.RUnlock()
-
-		// 优先使用已加载的数据库（仅限于向量和内存模式）
-		if hasLoadedSearcher && (dbPath == "" || dbPath == loadedPath) && (loadedMode == "vector" || loadedMode == "memory") {
-			// 如果未指定数据库路径，或指定的路径与已加载的相同，且已加载的是向量或内存模式
-			searcherLock.RLock()
-			if searcher != nil {
-				s = searcher
-				usedMode = loadedMode
-				searcherLock.RUnlock()
-			} else {
-				searcherLock.RUnlock()
-				return nil, fmt.Errorf("数据库连接已断开，请重新加载")
-			}
-		} else if dbPath == "" {
-			// 如果未指定数据库路径且没有已加载的数据库
-			return nil, fmt.Errorf("未指定数据库文件，且没有加载数据库")
-		} else {
-			// 需要加载指定路径的数据库
-			if searchMode == "" {
-				searchMode = "file" // 默认使用文件模式
-			}
-
-			// 验证搜索模式
-			if searchMode != "file" && searchMode != "vector" && searchMode != "memory" {
-				return nil, fmt.Errorf("不支持的搜索模式: %s，支持的模式: file, vector, memory", searchMode)
-			}
-
-			// 如果是文件模式，创建临时searcher
-			if searchMode == "file" {
-				s, err = xdb.NewWithFileOnly(dbPath)
-				if err != nil {
-					return nil, fmt.Errorf("加载数据库失败: %s", err.Error())
-				}
-				usedMode = "file"
-				shouldCloseSearcher = true
-			} else {
-				// 向量模式和内存模式使用全局缓存
-				s, err = getSearcherByMode(dbPath, searchMode)
-				if err != nil {
-					return nil, fmt.Errorf("加载数据库失败: %s", err.Error())
-				}
-				usedMode = searchMode
-			}
+		// 历史查询不使用启动参数配置的后备数据库，避免混入当前的数据
+		if req.FallbackDbPaths == nil {
+			req.FallbackDbPaths = []string{}
 		}
 	}
 
-	// 确保文件模式的searcher在函数结束时被关闭
-	if shouldCloseSearcher {
-		defer func() {
-			if s != nil {
-				s.Close()
-			}
-		}()
-	}
-
-	// 检查和转换IP
-	ipUint32, err := xdb.IP2Long(ip)
-	if err != nil {
-		return nil, fmt.Errorf("无效的IP地址: %s", err.Error())
-	}
-
-	startTime := time.Now().UnixNano()
-	region, ioCount, err := s.Search(ipUint32)
-	endTime := time.Now().UnixNano()
-	elapsed := endTime - startTime
-
+	// 解析数据库别名
+	dbPath, searchMode, err := resolveSearchTarget(req.DbPath, req.Alias, req.SearchMode)
 	if err != nil {
-		return nil, fmt.Errorf("搜索失败: %s", err.Error())
+		atomic.AddInt64(&globalStats.totalErrors, 1)
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  err.Error(),
+		})
+		return
 	}
+	req.DbPath, req.SearchMode = dbPath, searchMode
 
-	return &SearchResult{
-		Region:          region,
-		IoCount:         ioCount,
-		TookNanoseconds: elapsed,
-		SearchMode:      usedMode,
-		QueryTime:       time.Now().Format("2006/01/02 15:04:05"),
-	}, nil
-}
-
-// 生成数据库
-func GenerateDb(c *gin.Context) {
-	var req GenDbRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := validateSearchTarget(req.DbPath, req.SearchMode); err != nil {
+		atomic.AddInt64(&globalStats.totalErrors, 1)
 		c.JSON(http.StatusBadRequest, Response{
 			Code: 400,
-			Msg:  "请求参数错误: " + err.Error(),
+			Msg:  err.Error(),
 		})
 		return
 	}
 
-	// 检查源文件是否存在
-	if _, err := os.Stat(req.SrcFile); os.IsNotExist(err) {
+	if req.IPFormat != "" && req.IPFormat != ipFormatDotted && req.IPFormat != ipFormatInt {
+		atomic.AddInt64(&globalStats.totalErrors, 1)
 		c.JSON(http.StatusBadRequest, Response{
 			Code: 400,
-			Msg:  "源文件不存在: " + req.SrcFile,
+			Msg:  "无效的ipFormat，可选值: dotted, int",
 		})
 		return
 	}
 
-	// 确保目标目录存在
-	dstDir := filepath.Dir(req.DstFile)
-	if err := os.MkdirAll(dstDir, 0755); err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Code: 500,
-			Msg:  "创建目标目录失败: " + err.Error(),
+	if req.DefaultRegion != nil {
+		if err := xdb.CheckRegionLength(*req.DefaultRegion); err != nil {
+			atomic.AddInt64(&globalStats.totalErrors, 1)
+			c.JSON(http.StatusBadRequest, Response{
+				Code: 400,
+				Msg:  "无效的defaultRegion: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	var fieldIndex int
+	var fieldName string
+	if req.Field != "" {
+		fieldIndex, fieldName, err = resolveRegionField(req.Field)
+		if err != nil {
+			atomic.AddInt64(&globalStats.totalErrors, 1)
+			c.JSON(http.StatusBadRequest, Response{
+				Code: 400,
+				Msg:  err.Error(),
+			})
+			return
+		}
+	}
+
+	if err := checkFieldOrder(req.FieldOrder); err != nil {
+		atomic.AddInt64(&globalStats.totalErrors, 1)
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  err.Error(),
 		})
 		return
 	}
 
-	// 创建数据库生成器
-	tStart := time.Now()
-	maker, err := xdb.NewMaker(xdb.VectorIndexPolicy, req.SrcFile, req.DstFile)
+	// 增加搜索计数
+	atomic.AddInt64(&globalStats.totalSearches, 1)
+
+	ip, err := parseSearchIP(req.IP, req.IPFormat)
 	if err != nil {
+		atomic.AddInt64(&globalStats.totalErrors, 1)
 		c.JSON(http.StatusInternalServerError, Response{
 			Code: 500,
-			Msg:  "创建生成器失败: " + err.Error(),
+			Msg:  "搜索失败: " + err.Error(),
 		})
 		return
 	}
-	defer maker.Close()
 
-	// 初始化
-	if err := maker.Init(); err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Code: 500,
-			Msg:  "初始化失败: " + err.Error(),
-			Data: nil,
+	reservedKind, err := checkReservedIP(ip)
+	if err != nil {
+		atomic.AddInt64(&globalStats.totalErrors, 1)
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  err.Error(),
 		})
 		return
 	}
 
-	// 开始处理
-	if err := maker.Start(); err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Code: 500,
-			Msg:  "处理失败: " + err.Error(),
-			Data: nil,
-		})
+	fallbacks := req.FallbackDbPaths
+	if fallbacks == nil {
+		fallbacks = getFallbackDbPaths()
+	}
+
+	result, err := searchIPWithFallback(ip, req.DbPath, req.SearchMode, fallbacks, req.Explain)
+
+	// 客户端已断开时不再写响应，也不计入错误次数
+	if abortIfClientGone(c) {
 		return
 	}
 
-	// 结束处理
-	if err := maker.End(); err != nil {
+	if err != nil {
+		atomic.AddInt64(&globalStats.totalErrors, 1)
 		c.JSON(http.StatusInternalServerError, Response{
 			Code: 500,
-			Msg:  "结束处理失败: " + err.Error(),
-			Data: nil,
+			Msg:  "搜索失败: " + err.Error(),
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, Response{
-		Code: 0,
-		Msg:  "生成成功",
-		Data: gin.H{
-			"elapsed": time.Since(tStart).String(),
-			"srcFile": req.SrcFile,
-			"dstFile": req.DstFile,
-		},
-	})
-}
+	// 增加IO操作计数
+	atomic.AddInt64(&globalStats.totalIoOperations, int64(result.IoCount))
 
-// 编辑器实例缓存
-var (
-	editors     = make(map[string]*xdb.Editor)
-	editorsLock sync.RWMutex
-)
+	applyNotFoundRegion(result, req.DefaultRegion)
+	result.Reserved, result.ReservedKind = reservedKind != "", reservedKind
 
-// 获取编辑器实例
-func getEditor(srcFile string) (*xdb.Editor, error) {
-	if _, err := os.Stat(srcFile); os.IsNotExist(err) {
-		return nil, fmt.Errorf("文件不存在: %s", srcFile)
+	if req.Field != "" {
+		value := regionField(result.Region, fieldIndex)
+		result.Field = fieldName
+		result.FieldValue = &value
 	}
 
-	// 先使用读锁检查
-	editorsLock.RLock()
-	if editor, ok := editors[srcFile]; ok {
-		if editor.IsHandleValid() {
-			editorsLock.RUnlock()
-			setCurrentEditFilePath(srcFile)
-			return editor, nil
+	// field按原始位置选择字段，之后再按fieldOrder排列；未命中且默认地区为空时没有字段可排列
+	if len(req.FieldOrder) > 0 && result.Region != "" {
+		parts, err := reorderRegionFields(result.Region, req.FieldOrder)
+		if err != nil {
+			atomic.AddInt64(&globalStats.totalErrors, 1)
+			c.JSON(http.StatusBadRequest, Response{
+				Code: 400,
+				Msg:  err.Error(),
+			})
+			return
 		}
-	}
-	editorsLock.RUnlock()
-
-	// 需要创建新的编辑器，使用写锁
-	editorsLock.Lock()
-	defer editorsLock.Unlock()
-
-	// 双重检查锁定模式
-	if editor, ok := editors[srcFile]; ok {
-		if editor.IsHandleValid() {
-			setCurrentEditFilePath(srcFile)
-			return editor, nil
-		} else {
-			// 先关闭旧的编辑器
-			editor.Close()
-			delete(editors, srcFile)
+		result.RegionParts = parts
+		if req.ReorderRegion {
+			result.Region = strings.Join(parts, "|")
 		}
 	}
+	result.SnapshotDate = snapshot.Date
 
-	// 创建新的编辑器
-	editor, err := xdb.NewEditor(srcFile)
-	if err != nil {
-		return nil, err
-	}
-
-	// 保存到全局编辑器集合
-	editors[srcFile] = editor
-	setCurrentEditFilePath(srcFile)
-	return editor, nil
+	renderSearchResponse(c, Response{
+		Code: 0,
+		Msg:  "搜索成功",
+		Data: result,
+	}, encodeSearchResponse)
 }
 
-// 编辑单个IP段
-func EditSegment(c *gin.Context) {
-	var req EditSegmentRequest
+// 单次批量查询允许的最大IP数量
+const batchSearchMaxIPs = 10000
+
+// SearchIPBatch 批量查询IP，单个IP无效或查询失败不会影响其它IP，只要请求本身合法就返回200
+func SearchIPBatch(c *gin.Context) {
+	var req BatchSearchRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
+		atomic.AddInt64(&globalStats.totalErrors, 1)
 		c.JSON(http.StatusBadRequest, Response{
 			Code: 400,
-			Msg:  "参数错误: " + err.Error(),
-			Data: nil,
-		})
-		return
-	}
-
-	// 获取编辑器
-	editor, err := getEditor(req.SrcFile)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Code: 500,
-			Msg:  "创建编辑器失败: " + err.Error(),
-			Data: nil,
+			Msg:  "请求参数错误: " + err.Error(),
 		})
 		return
 	}
 
-	// 编辑IP段
-	oldCount, newCount, err := editor.Put(req.Segment)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Code: 500,
-			Msg:  "编辑IP段失败: " + err.Error(),
-			Data: nil,
-		})
+	if !resolveRequestPaths(c, &req.DbPath) {
 		return
 	}
 
-	c.JSON(http.StatusOK, Response{
-		Code: 0,
-		Msg:  "编辑成功",
-		Data: gin.H{
-			"oldCount": oldCount,
-			"newCount": newCount,
-			"segment":  req.Segment,
-		},
-	})
-}
-
-// 从文件批量编辑IP段
-func EditFromFile(c *gin.Context) {
-	var req EditFileRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if len(req.IPs) > batchSearchMaxIPs {
+		atomic.AddInt64(&globalStats.totalErrors, 1)
 		c.JSON(http.StatusBadRequest, Response{
 			Code: 400,
-			Msg:  "参数错误: " + err.Error(),
-			Data: nil,
+			Msg:  fmt.Sprintf("单次最多查询 %d 个IP，当前 %d 个", batchSearchMaxIPs, len(req.IPs)),
 		})
 		return
 	}
 
-	// 验证文件存在
-	if _, err := os.Stat(req.File); os.IsNotExist(err) {
+	dbPath, searchMode, err := resolveSearchTarget(req.DbPath, req.Alias, req.SearchMode)
+	if err != nil {
+		// 与单个查询一样，别名或查询模式无法解析时计入错误次数
+		atomic.AddInt64(&globalStats.totalErrors, 1)
 		c.JSON(http.StatusBadRequest, Response{
 			Code: 400,
-			Msg:  "文件不存在: " + req.File,
-			Data: nil,
+			Msg:  err.Error(),
 		})
 		return
 	}
 
-	// 获取编辑器
-	editor, err := getEditor(req.SrcFile)
+	// 整批复用同一个搜索器
+	s, usedMode, release, err := acquireSearcher(dbPath, searchMode)
 	if err != nil {
+		atomic.AddInt64(&globalStats.totalErrors, 1)
 		c.JSON(http.StatusInternalServerError, Response{
 			Code: 500,
-			Msg:  "创建编辑器失败: " + err.Error(),
-			Data: nil,
+			Msg:  "搜索失败: " + err.Error(),
 		})
 		return
 	}
+	defer release()
 
-	// 从文件编辑
-	oldCount, newCount, err := editor.PutFile(req.File)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Code: 500,
-			Msg:  "从文件编辑失败: " + err.Error(),
-			Data: nil,
-		})
-		return
+	tStart := time.Now()
+	result := BatchSearchResult{
+		Results:    make([]BatchSearchItem, 0, len(req.IPs)),
+		Total:      len(req.IPs),
+		SearchMode: usedMode,
 	}
+	result.RequestedMode, result.ModeNote = searchModeNote(searchMode, usedMode)
+	for _, ip := range req.IPs {
+		// 客户端已断开时停止剩余的查询
+		if abortIfClientGone(c) {
+			return
+		}
 
-	c.JSON(http.StatusOK, Response{
+		atomic.AddInt64(&globalStats.totalSearches, 1)
+
+		item := BatchSearchItem{IP: ip}
+		var r *SearchResult
+		ipUint32, err := parseSearchIP(strings.TrimSpace(ip), ipFormatDotted)
+		if err == nil {
+			item.ReservedKind, err = checkReservedIP(ipUint32)
+			item.Reserved = item.ReservedKind != ""
+		}
+		if err == nil {
+			r, err = searchWithSearcher(s, usedMode, ipUint32, false)
+		}
+		if err != nil {
+			atomic.AddInt64(&globalStats.totalErrors, 1)
+			item.Error = err.Error()
+			result.ErrorCount++
+		} else {
+			atomic.AddInt64(&globalStats.totalIoOperations, int64(r.IoCount))
+			item.Region = &r.Region
+			item.IoCount = &r.IoCount
+		}
+		result.Results = append(result.Results, item)
+	}
+	result.TookNanoseconds = time.Since(tStart).Nanoseconds()
+
+	renderSearchResponse(c, Response{
 		Code: 0,
-		Msg:  "编辑成功",
-		Data: gin.H{
-			"oldCount": oldCount,
-			"newCount": newCount,
-			"file":     req.File,
-		},
-	})
+		Msg:  fmt.Sprintf("批量查询完成，失败 %d 个", result.ErrorCount),
+		Data: result,
+	}, encodeBatchSearchResponse)
 }
 
-// 列出IP段
-func ListSegments(c *gin.Context) {
-	var req ListSegmentsRequest
+const (
+	// 单次最多查询的网段数量
+	cidrSearchMaxCIDRs = 100
+
+	// 单个网段逐段扫描的最大查询次数，超过后改为均匀抽样
+	cidrScanMaxLookups = 4096
+
+	// 抽样时的采样点数量
+	cidrSampleSize = 1024
+)
+
+// SearchCIDRs 查询网段内包含的地区以及各地区覆盖的IP数量
+func SearchCIDRs(c *gin.Context) {
+	var req CidrSearchRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
+		atomic.AddInt64(&globalStats.totalErrors, 1)
 		c.JSON(http.StatusBadRequest, Response{
 			Code: 400,
-			Msg:  "参数错误: " + err.Error(),
-			Data: nil,
+			Msg:  "请求参数错误: " + err.Error(),
 		})
 		return
 	}
 
-	// 设置默认值
-	if req.Size <= 0 {
-		req.Size = 10
+	if !resolveRequestPaths(c, &req.DbPath) {
+		return
 	}
 
-	// 获取编辑器
-	editor, err := getEditor(req.SrcFile)
+	if len(req.CIDRs) > cidrSearchMaxCIDRs {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  fmt.Sprintf("单次最多查询 %d 个网段，当前 %d 个", cidrSearchMaxCIDRs, len(req.CIDRs)),
+		})
+		return
+	}
+
+	dbPath, searchMode, err := resolveSearchTarget(req.DbPath, req.Alias, req.SearchMode)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  err.Error(),
+		})
+		return
+	}
+
+	// 所有网段复用同一个搜索器
+	s, usedMode, release, err := acquireSearcher(dbPath, searchMode)
+	if err != nil {
+		atomic.AddInt64(&globalStats.totalErrors, 1)
 		c.JSON(http.StatusInternalServerError, Response{
 			Code: 500,
-			Msg:  "创建编辑器失败: " + err.Error(),
-			Data: nil,
+			Msg:  "搜索失败: " + err.Error(),
 		})
 		return
 	}
+	defer release()
 
-	// 获取IP段列表
-	segments := editor.Slice(req.Offset, req.Size)
+	tStart := time.Now()
+	result := CidrSearchResult{
+		Results:    make([]CidrSearchItem, 0, len(req.CIDRs)),
+		Total:      len(req.CIDRs),
+		SearchMode: usedMode,
+	}
+	for _, cidr := range req.CIDRs {
+		// 客户端已断开时停止剩余的查询
+		if abortIfClientGone(c) {
+			return
+		}
+
+		item := searchCIDR(s, strings.TrimSpace(cidr))
+		if item.Error != "" {
+			result.ErrorCount++
+		}
+		result.Results = append(result.Results, item)
+	}
+	result.TookNanoseconds = time.Since(tStart).Nanoseconds()
 
 	c.JSON(http.StatusOK, Response{
 		Code: 0,
-		Msg:  "获取成功",
-		Data: gin.H{
-			"offset":   req.Offset,
-			"size":     req.Size,
-			"total":    editor.SegLen(),
-			"segments": segments,
-		},
+		Msg:  fmt.Sprintf("网段查询完成，失败 %d 个", result.ErrorCount),
+		Data: result,
 	})
 }
 
-// 保存编辑
-func SaveEdit(c *gin.Context) {
-	var req SaveEditRequest
+// 查询IP所在的IP段及其前后相邻的IP段
+func SearchNeighbors(c *gin.Context) {
+	var req NeighborsRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
+		atomic.AddInt64(&globalStats.totalErrors, 1)
 		c.JSON(http.StatusBadRequest, Response{
 			Code: 400,
-			Msg:  "参数错误: " + err.Error(),
-			Data: nil,
+			Msg:  "请求参数错误: " + err.Error(),
 		})
 		return
 	}
 
-	// 获取编辑器
-	editor, ok := editors[req.SrcFile]
-	if !ok {
+	if !resolveRequestPaths(c, &req.DbPath) {
+		return
+	}
+
+	ip, err := xdb.IP2Long(strings.TrimSpace(req.IP))
+	if err != nil {
+		atomic.AddInt64(&globalStats.totalErrors, 1)
 		c.JSON(http.StatusBadRequest, Response{
 			Code: 400,
-			Msg:  "编辑器不存在，请先进行编辑操作",
-			Data: nil,
+			Msg:  "无效的IP地址: " + err.Error(),
 		})
 		return
 	}
 
-	// 保存编辑
-	if err := editor.Save(); err != nil {
+	dbPath, searchMode, err := resolveSearchTarget(req.DbPath, req.Alias, req.SearchMode)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  err.Error(),
+		})
+		return
+	}
+
+	s, usedMode, release, err := acquireSearcher(dbPath, searchMode)
+	if err != nil {
+		atomic.AddInt64(&globalStats.totalErrors, 1)
 		c.JSON(http.StatusInternalServerError, Response{
 			Code: 500,
-			Msg:  "保存失败: " + err.Error(),
-			Data: nil,
+			Msg:  "搜索失败: " + err.Error(),
+		})
+		return
+	}
+	defer release()
+
+	prev, cur, next, err := s.Neighbors(ip)
+	if err != nil {
+		atomic.AddInt64(&globalStats.totalErrors, 1)
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "搜索失败: " + err.Error(),
+		})
+		return
+	}
+
+	if cur == nil {
+		c.JSON(http.StatusNotFound, Response{
+			Code: 404,
+			Msg:  fmt.Sprintf("IP %s 不在任何IP段中", xdb.Long2IP(ip)),
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, Response{
 		Code: 0,
-		Msg:  "保存成功",
-		Data: gin.H{
-			"srcFile": req.SrcFile,
+		Msg:  "查询成功",
+		Data: NeighborsResult{
+			IP:         xdb.Long2IP(ip),
+			Prev:       toNeighborSegment(prev),
+			Current:    toNeighborSegment(cur),
+			Next:       toNeighborSegment(next),
+			SearchMode: usedMode,
 		},
 	})
 }
 
-// 保存编辑并生成数据库文件
-func SaveAndGenerateDb(c *gin.Context) {
-	var req SaveAndGenerateRequest
+// 统计向量索引每个单元格下的段索引条数，用于发现二分查找较深的热点网段
+func VectorOccupancy(c *gin.Context) {
+	var req VectorOccupancyRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, Response{
 			Code: 400,
@@ -808,725 +1535,3597 @@ func SaveAndGenerateDb(c *gin.Context) {
 		return
 	}
 
-	// 获取编辑器
-	editor, err := getEditor(req.SrcFile)
+	if !resolveRequestPaths(c, &req.DbPath) {
+		return
+	}
+
+	dbPath, searchMode, err := resolveSearchTarget(req.DbPath, req.Alias, req.SearchMode)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
-			Code: 500,
-			Msg:  "获取编辑器失败: " + err.Error(),
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  err.Error(),
 		})
 		return
 	}
 
-	// 如果编辑器需要保存，先保存更改
-	if editor.NeedSave() {
-		if err := editor.Save(); err != nil {
-			c.JSON(http.StatusInternalServerError, Response{
-				Code: 500,
-				Msg:  "保存文件失败: " + err.Error(),
-			})
-			return
-		}
+	s, usedMode, release, err := acquireSearcher(dbPath, searchMode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "加载数据库失败: " + err.Error(),
+		})
+		return
 	}
+	defer release()
 
-	// 使用编辑器中的内存数据直接生成XDB文件
-	tStart := time.Now()
-	if err := editor.SaveToXdbFile(req.DstFile); err != nil {
+	occupancy, err := s.VectorOccupancy()
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{
 			Code: 500,
-			Msg:  "生成XDB文件失败: " + err.Error(),
+			Msg:  "读取向量索引失败: " + err.Error(),
 		})
 		return
 	}
 
+	top := req.Top
+	if top <= 0 {
+		top = 10
+	}
+
+	result := VectorOccupancyResult{SearchMode: usedMode}
+	var cells []VectorCell
+	for i, row := range occupancy {
+		for j, entries := range row {
+			result.Cells++
+			if entries == 0 {
+				result.EmptyCells++
+				continue
+			}
+
+			result.TotalEntries += entries
+			if result.MinEntries == 0 || entries < result.MinEntries {
+				result.MinEntries = entries
+			}
+			result.MaxEntries = max(result.MaxEntries, entries)
+			cells = append(cells, VectorCell{Cell: fmt.Sprintf("%d.%d.0.0/16", i, j), Entries: entries})
+		}
+	}
+
+	result.AvgEntries = math.Round(float64(result.TotalEntries)/float64(result.Cells)*100) / 100
+	if nonEmpty := result.Cells - result.EmptyCells; nonEmpty > 0 {
+		result.AvgNonEmpty = math.Round(float64(result.TotalEntries)/float64(nonEmpty)*100) / 100
+	}
+	if result.MaxEntries > 0 {
+		// 查询时二分的范围包含ePtr处的一条，共MaxEntries+1条
+		result.MaxSearchDepth = bits.Len(uint(result.MaxEntries + 1))
+	}
+
+	sort.SliceStable(cells, func(a, b int) bool {
+		return cells[a].Entries > cells[b].Entries
+	})
+	result.Hotspots = cells[:min(top, len(cells))]
+
+	if req.IncludeCells {
+		result.Occupancy = occupancy
+	}
+
 	c.JSON(http.StatusOK, Response{
 		Code: 0,
-		Msg:  "源文件已保存并生成XDB文件",
-		Data: map[string]interface{}{
-			"srcFile":   req.SrcFile,
-			"dstFile":   req.DstFile,
-			"segLen":    editor.SegLen(),
-			"timeTaken": time.Since(tStart).String(),
-		},
+		Msg:  "统计完成",
+		Data: result,
 	})
 }
 
-// 清理资源函数
-func Cleanup() {
-	// 关闭搜索器
-	if searcher != nil {
-		searcher.Close()
-		searcher = nil
+// searchCIDR 按IP段逐段扫描网段，每次查询跳到命中段的结束IP之后；
+// IP段数量超过cidrScanMaxLookups时改为均匀抽样估算
+func searchCIDR(s *xdb.Searcher, cidr string) CidrSearchItem {
+	item := CidrSearchItem{CIDR: cidr}
+
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		item.Error = "无效的CIDR: " + cidr
+		return item
+	}
+	ip4 := ipNet.IP.To4()
+	if ip4 == nil || len(ipNet.Mask) != net.IPv4len {
+		item.Error = "不支持IPv6网段: " + cidr
+		return item
 	}
-	// 编辑器不需要关闭
-	editors = make(map[string]*xdb.Editor)
-}
 
-// 导出任务状态结构（优化版本，使用atomic计数器）
-type ExportTaskStatus struct {
-	TaskID            string  `json:"taskId"`
-	XdbPath           string  `json:"xdbPath"`
-	ExportPath        string  `json:"exportPath"`
-	Status            string  `json:"status"`            // "pending", "processing", "completed", "failed"
-	Progress          float64 `json:"progress"`          // 进度百分比 0-100
-	CurrentAClass     uint32  `json:"currentAClass"`     // 当前处理的A类网段
-	ProcessedAClasses int     `json:"processedAClasses"` // 已处理的A类网段数量
-	TotalAClasses     int     `json:"totalAClasses"`     // 总A类网段数量
+	sip := binary.BigEndian.Uint32(ip4)
+	eip := sip | ^binary.BigEndian.Uint32(ipNet.Mask)
+	total := uint64(eip) - uint64(sip) + 1
+	item.StartIP, item.EndIP, item.TotalIPs = xdb.Long2IP(sip), xdb.Long2IP(eip), total
 
-	// 新增：明确的导出字段用于JSON序列化
-	RecordCount  int64 `json:"recordCount"`  // 直接使用这个名字，确保前端也用它
-	SegmentCount int64 `json:"segmentCount"` // 直接使用这个名字
+	counts := make(map[string]uint64)
+	lookup := func(ip uint32) (string, *xdb.SearchInfo, error) {
+		item.Lookups++
+		atomic.AddInt64(&globalStats.totalSearches, 1)
+		region, ioCount, info, err := s.SearchWithInfo(ip)
+		if err != nil {
+			atomic.AddInt64(&globalStats.totalErrors, 1)
+			return "", nil, err
+		}
+		atomic.AddInt64(&globalStats.totalIoOperations, int64(ioCount))
+		return region, info, nil
+	}
 
-	recordCount  int64 // 内部原子计数器，保持小写非导出
-	segmentCount int64 // 内部原子计数器，保持小写非导出
+	for ip := uint64(sip); ip <= uint64(eip); {
+		if item.Lookups >= cidrScanMaxLookups {
+			item.Sampled = true
+			break
+		}
 
-	ErrorMessage    string    `json:"errorMessage"`
-	StartTime       time.Time `json:"startTime"`
-	EndTime         time.Time `json:"endTime"`
-	DurationSeconds float64   `json:"durationSeconds,omitempty"` // 可选字段，改为秒数
-	lastUpdateTime  int64     `json:"-"`                         // 使用atomic存储unix时间戳
-	DetailedStatus  string    `json:"detailedStatus"`            // 详细状态描述
-}
+		region, info, err := lookup(uint32(ip))
+		if err != nil {
+			item.Error = "搜索失败: " + err.Error()
+			return item
+		}
 
-// GetRecordCountInternal 原子获取记录数 (内部使用)
-func (e *ExportTaskStatus) GetRecordCountInternal() int64 {
-	return atomic.LoadInt64(&e.recordCount)
-}
+		// 未命中任何段时只计当前IP
+		end := ip
+		if info.DataLen > 0 {
+			end = min(uint64(info.EndIP), uint64(eip))
+		}
+		counts[region] += end - ip + 1
+		ip = end + 1
+	}
 
-// AddRecordCountInternal 原子增加记录数 (内部使用) - 注意：原AddRecordCount功能不变，仅改名以匹配内部使用约定
-func (e *ExportTaskStatus) AddRecordCountInternal(delta int64) int64 {
-	return atomic.AddInt64(&e.recordCount, delta)
-}
+	if item.Sampled {
+		// 第i个采样点代表 [sip+i*total/n, sip+(i+1)*total/n) 范围内的IP
+		clear(counts)
+		n := min(uint64(cidrSampleSize), total)
+		for i := uint64(0); i < n; i++ {
+			from := uint64(sip) + i*total/n
+			to := uint64(sip) + (i+1)*total/n
+			region, _, err := lookup(uint32(from))
+			if err != nil {
+				item.Error = "搜索失败: " + err.Error()
+				return item
+			}
+			counts[region] += to - from
+		}
+	}
 
-// SetRecordCountInternal 原子设置记录数 (内部使用)
-func (e *ExportTaskStatus) SetRecordCountInternal(count int64) {
-	atomic.StoreInt64(&e.recordCount, count)
-}
+	item.Regions = make([]CidrRegionCount, 0, len(counts))
+	for region, count := range counts {
+		item.Regions = append(item.Regions, CidrRegionCount{Region: region, IPCount: count})
+	}
+	sort.Slice(item.Regions, func(i, j int) bool {
+		if item.Regions[i].IPCount != item.Regions[j].IPCount {
+			return item.Regions[i].IPCount > item.Regions[j].IPCount
+		}
+		return item.Regions[i].Region < item.Regions[j].Region
+	})
 
-// GetSegmentCountInternal 原子获取段数 (内部使用)
-func (e *ExportTaskStatus) GetSegmentCountInternal() int64 {
-	return atomic.LoadInt64(&e.segmentCount)
+	return item
 }
 
-// AddSegmentCountInternal 原子增加段数 (内部使用) - 注意：原AddSegmentCount功能不变，仅改名以匹配内部使用约定
-func (e *ExportTaskStatus) AddSegmentCountInternal(delta int64) int64 {
-	return atomic.AddInt64(&e.segmentCount, delta)
-}
+// parseHistogramRange 解析统计范围，cidr与startIP/endIP二选一
+func parseHistogramRange(req *RegionHistogramRequest) (uint32, uint32, error) {
+	if req.CIDR != "" {
+		if req.StartIP != "" || req.EndIP != "" {
+			return 0, 0, fmt.Errorf("cidr和startIP/endIP不能同时指定")
+		}
 
-// SetSegmentCountInternal 原子设置段数 (内部使用)
-func (e *ExportTaskStatus) SetSegmentCountInternal(count int64) {
-	atomic.StoreInt64(&e.segmentCount, count)
-}
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(req.CIDR))
+		if err != nil {
+			return 0, 0, fmt.Errorf("无效的CIDR: %s", req.CIDR)
+		}
+		ip4 := ipNet.IP.To4()
+		if ip4 == nil || len(ipNet.Mask) != net.IPv4len {
+			return 0, 0, fmt.Errorf("不支持IPv6网段: %s", req.CIDR)
+		}
 
-// GetLastUpdateTime 获取最后更新时间
-func (e *ExportTaskStatus) GetLastUpdateTime() time.Time {
-	timestamp := atomic.LoadInt64(&e.lastUpdateTime)
-	if timestamp == 0 {
-		return time.Time{}
+		sip := binary.BigEndian.Uint32(ip4)
+		return sip, sip | ^binary.BigEndian.Uint32(ipNet.Mask), nil
 	}
-	return time.Unix(timestamp, 0)
-}
 
-// UpdateLastUpdateTime 更新最后更新时间为当前时间
+	if req.StartIP == "" || req.EndIP == "" {
+		return 0, 0, fmt.Errorf("需要指定cidr，或者同时指定startIP和endIP")
+	}
+
+	sip, err := xdb.IP2Long(strings.TrimSpace(req.StartIP))
+	if err != nil {
+		return 0, 0, fmt.Errorf("无效的startIP: %w", err)
+	}
+	eip, err := xdb.IP2Long(strings.TrimSpace(req.EndIP))
+	if err != nil {
+		return 0, 0, fmt.Errorf("无效的endIP: %w", err)
+	}
+	if sip > eip {
+		return 0, 0, fmt.Errorf("startIP不能大于endIP")
+	}
+
+	return sip, eip, nil
+}
+
+// SearchRegionHistogram 统计IP范围内各地区覆盖的IP数量。按段索引顺序遍历范围内的IP段，
+// 用段的长度计数，不需要逐IP查询，结果是精确值
+func SearchRegionHistogram(c *gin.Context) {
+	var req RegionHistogramRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "请求参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	if !resolveRequestPaths(c, &req.DbPath) {
+		return
+	}
+
+	sip, eip, err := parseHistogramRange(&req)
+	if err == nil && req.Top < 0 {
+		err = fmt.Errorf("top不能为负数")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  err.Error(),
+		})
+		return
+	}
+
+	dbPath, searchMode, err := resolveSearchTarget(req.DbPath, req.Alias, req.SearchMode)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  err.Error(),
+		})
+		return
+	}
+
+	s, usedMode, release, err := acquireSearcher(dbPath, searchMode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "搜索失败: " + err.Error(),
+		})
+		return
+	}
+	defer release()
+
+	tStart := time.Now()
+	result := RegionHistogramResult{
+		StartIP:    xdb.Long2IP(sip),
+		EndIP:      xdb.Long2IP(eip),
+		TotalIPs:   uint64(eip) - uint64(sip) + 1,
+		SearchMode: usedMode,
+	}
+
+	counts := make(map[string]uint64)
+	err = s.IterateRange(sip, eip, func(seg *xdb.Segment) error {
+		n := uint64(seg.EndIP) - uint64(seg.StartIP) + 1
+		counts[seg.Region] += n
+		result.CoveredIPs += n
+		result.Segments++
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "遍历段索引失败: " + err.Error(),
+		})
+		return
+	}
+
+	result.RegionCount = len(counts)
+	result.Regions = make([]CidrRegionCount, 0, len(counts))
+	for region, count := range counts {
+		result.Regions = append(result.Regions, CidrRegionCount{Region: region, IPCount: count})
+	}
+	sort.Slice(result.Regions, func(i, j int) bool {
+		if result.Regions[i].IPCount != result.Regions[j].IPCount {
+			return result.Regions[i].IPCount > result.Regions[j].IPCount
+		}
+		return result.Regions[i].Region < result.Regions[j].Region
+	})
+
+	if req.Top > 0 && len(result.Regions) > req.Top {
+		for _, r := range result.Regions[req.Top:] {
+			result.OtherIPs += r.IPCount
+		}
+		result.Regions = result.Regions[:req.Top]
+	}
+	result.TookNanoseconds = time.Since(tStart).Nanoseconds()
+
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  fmt.Sprintf("统计完成，共 %d 个地区", result.RegionCount),
+		Data: result,
+	})
+}
+
+// SearchIPFunc 内部IP搜索函数，主数据库未命中时使用启动参数配置的后备数据库
+func SearchIPFunc(ip string, dbPath string, searchMode string) (*SearchResult, error) {
+	return SearchIPWithFallback(ip, dbPath, searchMode, getFallbackDbPaths())
+}
+
+// SearchIPWithFallback 先查询主数据库，未命中（地区为空或全为默认值）时依次查询后备数据库，
+// 后备数据库优先复用已加载的搜索器，否则以文件模式临时打开
+func SearchIPWithFallback(ip string, dbPath string, searchMode string, fallbacks []string) (*SearchResult, error) {
+	ipUint32, err := parseSearchIP(ip, ipFormatDotted)
+	if err != nil {
+		return nil, err
+	}
+
+	reservedKind, err := checkReservedIP(ipUint32)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := searchIPWithFallback(ipUint32, dbPath, searchMode, fallbacks, false)
+	if err != nil {
+		return nil, err
+	}
+
+	applyNotFoundRegion(result, nil)
+	result.Reserved, result.ReservedKind = reservedKind != "", reservedKind
+	return result, nil
+}
+
+// 未命中任何段时返回的地区信息，为空时返回空地区
+var notFoundRegion atomic.Value
+
+// SetNotFoundRegion 设置未命中任何段时返回的地区信息，例如 UNKNOWN|||
+func SetNotFoundRegion(region string) error {
+	if err := xdb.CheckRegionLength(region); err != nil {
+		return err
+	}
+
+	notFoundRegion.Store(region)
+	return nil
+}
+
+// applyNotFoundRegion 设置found，未命中时把地区替换为默认地区；override非nil时优先于-default-region
+func applyNotFoundRegion(result *SearchResult, override *string) {
+	result.Found = result.Region != ""
+	if result.Found {
+		return
+	}
+
+	if override != nil {
+		result.Region = *override
+	} else if region, ok := notFoundRegion.Load().(string); ok {
+		result.Region = region
+	}
+}
+
+// 支持的IP输入格式
+const (
+	ipFormatDotted = "dotted" // 点分十进制，如 1.0.0.1
+	ipFormatInt    = "int"    // uint32十进制整数，如 16777217
+)
+
+// parseSearchIP 按指定格式解析IP，int格式直接解析为uint32，与XDB内部使用的整数形式一致
+func parseSearchIP(ip string, format string) (uint32, error) {
+	if format == ipFormatInt {
+		v, err := strconv.ParseUint(ip, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("无效的IP整数: %s，取值范围为0-4294967295", ip)
+		}
+		return uint32(v), nil
+	}
+
+	v, err := xdb.IP2Long(ip)
+	if err != nil {
+		return 0, fmt.Errorf("无效的IP地址: %s", err.Error())
+	}
+	return v, nil
+}
+
+// explain为true时结果附带最终命中数据库的索引查找路径
+func searchIPWithFallback(ip uint32, dbPath string, searchMode string, fallbacks []string, explain bool) (*SearchResult, error) {
+	// explain需要真实的查找路径，不使用缓存
+	var cacheKey = newSearchCacheKey(dbPath, searchMode, ip, fallbacks)
+	var cacheGen uint64
+	if !explain {
+		tStart := time.Now()
+		cached, gen, ok := resultCache.get(cacheKey)
+		if ok {
+			cached.IoCount = 0
+			cached.Cached = true
+			cached.TookNanoseconds = time.Since(tStart).Nanoseconds()
+			cached.QueryTime = time.Now().Format("2006/01/02 15:04:05")
+			return &cached, nil
+		}
+		cacheGen = gen
+	}
+
+	result, err := searchIPOnce(ip, dbPath, searchMode, explain)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.DbUsed == "" {
+		result.DbUsed = dbPath
+		if dbPath == "" {
+			searcherLock.RLock()
+			result.DbUsed = searcherPath
+			searcherLock.RUnlock()
+		}
+	}
+
+	for _, fallback := range fallbacks {
+		if !xdb.IsDefaultRegion(result.Region) || fallback == result.DbUsed {
+			continue
+		}
+
+		// 后备数据库按请求打开，不能作为常驻数据库加载，否则之后未指定dbPath的查询会落到后备数据库上
+		next, err := searchIPOnce(ip, fallback, "file-vector", explain)
+		if err != nil {
+			return nil, fmt.Errorf("后备数据库 %s: %w", fallback, err)
+		}
+
+		// 累计整条查询链的IO次数和耗时
+		next.IoCount += result.IoCount
+		next.TookNanoseconds += result.TookNanoseconds
+		next.DbUsed = fallback
+		result = next
+	}
+
+	if !explain {
+		resultCache.put(cacheKey, *result, cacheGen)
+	}
+
+	return result, nil
+}
+
+// 在单个数据库中查询IP
+func searchIPOnce(ip uint32, dbPath string, searchMode string, explain bool) (*SearchResult, error) {
+	s, usedMode, release, err := acquireSearcher(dbPath, searchMode)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	result, err := searchWithSearcher(s, usedMode, ip, explain)
+	if err != nil {
+		return nil, err
+	}
+	result.RequestedMode, result.ModeNote = searchModeNote(searchMode, usedMode)
+	return result, nil
+}
+
+// 请求未指定searchMode且没有可复用的已加载数据库时使用的模式
+var defaultSearchMode = "file"
+
+// SetDefaultSearchMode 设置未指定searchMode时的默认模式：file, file-vector, vector, hybrid, memory
+func SetDefaultSearchMode(mode string) error {
+	if !isPerRequestMode(mode) && !isCachedMode(mode) {
+		return fmt.Errorf("不支持的搜索模式: %s，支持的模式: file, file-vector, vector, hybrid, memory", mode)
+	}
+
+	defaultSearchMode = mode
+	return nil
+}
+
+// acquireSearcher 获取用于查询的搜索器，返回实际使用的模式；
+// 文件模式的临时搜索器由release关闭，复用的全局搜索器release为空操作
+func acquireSearcher(dbPath string, searchMode string) (*xdb.Searcher, string, func(), error) {
+	var s *xdb.Searcher
+	var err error
+	var usedMode string
+	var shouldCloseSearcher bool = false // 标记是否需要在使用结束时关闭searcher
+	// 如果是文件模式，每次都创建新的searcher，用完即关
+	if isPerRequestMode(searchMode) {
+		if dbPath == "" {
+			return nil, "", nil, fmt.Errorf("文件模式需要指定数据库文件路径")
+		}
+
+		s, err = openPerRequestSearcher(dbPath, searchMode)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("加载数据库失败: %s", err.Error())
+		}
+		usedMode = searchMode
+		shouldCloseSearcher = true // 文件模式需要关闭
+	} else {
+		// 对于常驻模式，先检查是否有已加载的数据库可以使用
+		searcherLock.RLock()
+		hasLoadedSearcher := searcher != nil
+		loadedPath := searcherPath
+		loadedMode := searcherMode
+		searcherLock.RUnlock()
+
+		// 优先使用已加载的数据库（仅限于常驻模式）
+		if hasLoadedSearcher && (dbPath == "" || dbPath == loadedPath) && isCachedMode(loadedMode) {
+			// 如果未指定数据库路径，或指定的路径与已加载的相同，且已加载的是向量或内存模式
+			searcherLock.RLock()
+			if searcher != nil {
+				s = searcher
+				usedMode = loadedMode
+				searcherLock.RUnlock()
+			} else {
+				searcherLock.RUnlock()
+				return nil, "", nil, fmt.Errorf("数据库连接已断开，请重新加载")
+			}
+		} else if dbPath == "" {
+			// 如果未指定数据库路径且没有已加载的数据库
+			return nil, "", nil, fmt.Errorf("未指定数据库文件，且没有加载数据库")
+		} else {
+			// 需要加载指定路径的数据库，未指定模式时使用默认模式；
+			// 已加载了其他数据库时使用文件模式，避免替换掉已加载的数据库
+			if searchMode == "" {
+				searchMode = defaultSearchMode
+				if hasLoadedSearcher {
+					searchMode = "file"
+				}
+			}
+
+			// 验证搜索模式
+			if !isPerRequestMode(searchMode) && !isCachedMode(searchMode) {
+				return nil, "", nil, fmt.Errorf("不支持的搜索模式: %s，支持的模式: file, file-vector, vector, hybrid, memory", searchMode)
+			}
+
+			// 如果是文件模式，创建临时searcher
+			if isPerRequestMode(searchMode) {
+				s, err = openPerRequestSearcher(dbPath, searchMode)
+				if err != nil {
+					return nil, "", nil, fmt.Errorf("加载数据库失败: %s", err.Error())
+				}
+				usedMode = searchMode
+				shouldCloseSearcher = true
+			} else {
+				// 常驻模式使用全局缓存
+				s, err = getSearcherByMode(dbPath, searchMode)
+				if err != nil {
+					return nil, "", nil, fmt.Errorf("加载数据库失败: %s", err.Error())
+				}
+				usedMode = searchMode
+			}
+		}
+	}
+
+	// 确保文件模式的searcher在使用结束时被关闭
+	release := func() {}
+	if shouldCloseSearcher {
+		release = func() {
+			if s != nil {
+				s.Close()
+			}
+		}
+	}
+
+	return s, usedMode, release, nil
+}
+
+// searchModeNote 请求指定的模式与实际使用的模式不同时，返回请求的模式和原因。
+// 同一数据库已按其他常驻模式加载时会直接复用，不为单次请求替换全局搜索器
+func searchModeNote(requested string, used string) (string, string) {
+	if requested == "" || requested == used {
+		return "", ""
+	}
+	return requested, fmt.Sprintf("该数据库已按%s模式加载，复用了已加载的搜索器；需要按%s模式查询时请先通过 /api/load-xdb 以该模式重新加载", used, requested)
+}
+
+// searchWithSearcher 使用指定搜索器查询单个IP，explain为true时附带索引查找路径
+func searchWithSearcher(s *xdb.Searcher, usedMode string, ipUint32 uint32, explain bool) (*SearchResult, error) {
+	var err error
+	var region string
+	var ioCount int
+	var iterations int
+	var info *xdb.SearchInfo
+	startTime := time.Now().UnixNano()
+	if explain {
+		region, ioCount, info, err = s.SearchWithInfo(ipUint32)
+	} else {
+		region, ioCount, iterations, err = s.SearchWithIterations(ipUint32)
+	}
+	endTime := time.Now().UnixNano()
+	elapsed := endTime - startTime
+
+	if err != nil {
+		return nil, fmt.Errorf("搜索失败: %s", err.Error())
+	}
+
+	// 二分查找的深度反映单元格内段索引的密度，未映射的/16网段不计入
+	if info != nil {
+		iterations = info.Iterations
+	}
+	if iterations > 0 {
+		atomic.AddInt64(&globalStats.indexSearches, 1)
+		atomic.AddInt64(&globalStats.totalIterations, int64(iterations))
+	}
+
+	result := &SearchResult{
+		Region:          region,
+		IoCount:         ioCount,
+		TookNanoseconds: elapsed,
+		SearchMode:      usedMode,
+		QueryTime:       time.Now().Format("2006/01/02 15:04:05"),
+		IsDefault:       xdb.IsDefaultRegion(region),
+	}
+
+	if info != nil {
+		result.Explain = &SearchExplain{
+			VectorIndex: info.VectorIndex,
+			SPtr:        info.SPtr,
+			EPtr:        info.EPtr,
+			CellEntries: info.CellEntries,
+			Iterations:  info.Iterations,
+			DataPtr:     info.DataPtr,
+			DataLen:     info.DataLen,
+			VectorPtr:   info.VectorPtr,
+			IndexPtr:    info.IndexPtr,
+
+			RegionBytes:  info.DataLen,
+			DecodedBytes: len(region),
+			DecodedRunes: utf8.RuneCountInString(region),
+			ValidUTF8:    utf8.ValidString(region),
+		}
+		if info.DataLen > 0 {
+			result.Explain.SegStartIP = xdb.Long2IP(info.StartIP)
+			result.Explain.SegEndIP = xdb.Long2IP(info.EndIP)
+		}
+
+		// 在计时之外扫描整个单元格，找出所有覆盖该IP的段索引项
+		matches, err := s.SearchAll(ipUint32)
+		if err != nil {
+			return nil, fmt.Errorf("扫描段索引失败: %s", err.Error())
+		}
+		result.Explain.Matches = make([]SearchExplainMatch, 0, len(matches))
+		for _, m := range matches {
+			result.Explain.Matches = append(result.Explain.Matches, SearchExplainMatch{
+				IndexPtr: m.IndexPtr,
+				StartIP:  xdb.Long2IP(m.StartIP),
+				EndIP:    xdb.Long2IP(m.EndIP),
+				Region:   m.Region,
+			})
+		}
+		result.Explain.Overlapping = len(matches) > 1
+		if result.Explain.Overlapping {
+			log.Printf("警告: IP %s 被 %d 条段索引项覆盖，段索引存在重叠", xdb.Long2IP(ipUint32), len(matches))
+		}
+	}
+
+	return result, nil
+}
+
+// 生成数据库
+func GenerateDb(c *gin.Context) {
+	var req GenDbRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "请求参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	if !resolveSourcePath(c, &req.SrcFile) || !resolveRequestPaths(c, &req.DstFile) {
+		return
+	}
+
+	if !checkOutputPath(c, req.DstFile, false, req.SrcFile) {
+		return
+	}
+
+	if !checkEncoding(c, &req.Encoding) {
+		return
+	}
+
+	fileMode, ok := checkFileMode(c, req.FileMode)
+	if !ok || !checkRegionSchema(c, req.RegionSchema) {
+		return
+	}
+
+	policy, err := parseIndexPolicy(req.IndexPolicy)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  err.Error(),
+		})
+		return
+	}
+
+	// 检查源文件是否存在
+	if _, err := os.Stat(req.SrcFile); !isRemoteSource(req.SrcFile) && os.IsNotExist(err) {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "源文件不存在: " + req.SrcFile,
+		})
+		return
+	}
+
+	// 确保目标目录存在
+	dstDir := filepath.Dir(req.DstFile)
+	if err := os.MkdirAll(dstDir, 0755); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "创建目标目录失败: " + err.Error(),
+		})
+		return
+	}
+
+	// 远程源文件先下载到临时文件，生成结束后删除
+	srcFile, cleanup, ok := fetchRequestSource(c, req.SrcFile)
+	if !ok {
+		return
+	}
+	defer cleanup()
+
+	// 与异步生成任务共用执行位置，没有空闲位置时等待，客户端断开时放弃
+	if !generateTasksPool.acquire("", nil, c.Request.Context().Done()) {
+		return
+	}
+	defer generateTasksPool.release()
+
+	// 创建数据库生成器
+	tStart := time.Now()
+	maker, err := xdb.NewMaker(policy, srcFile, req.DstFile)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "创建生成器失败: " + err.Error(),
+		})
+		return
+	}
+	defer maker.Close()
+
+	if fileMode != 0 {
+		if err := maker.SetFileMode(fileMode); err != nil {
+			c.JSON(http.StatusInternalServerError, Response{
+				Code: 500,
+				Msg:  "设置文件权限失败: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	encoding := sourceEncodingFor(req.SrcFile, req.Encoding)
+	if err := maker.SetSourceEncoding(encoding); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  err.Error(),
+		})
+		return
+	}
+	maker.SetStrict(req.Strict)
+	_ = maker.SetRegionSchema(req.RegionSchema) // 已由checkRegionSchema检查
+
+	// 初始化，严格模式下源数据不连续或地区信息不符合结构要求时在写入目标文件之前失败
+	if err := maker.Init(); err != nil {
+		if cErr := continuityProblems(err); cErr != nil {
+			c.JSON(http.StatusBadRequest, Response{
+				Code: 400,
+				Msg:  "严格模式检查失败: " + cErr.Error(),
+				Data: cErr.Problems,
+			})
+			return
+		}
+		if sErr := regionSchemaViolations(err); sErr != nil {
+			c.JSON(http.StatusBadRequest, Response{
+				Code: 400,
+				Msg:  "地区信息结构检查失败: " + sErr.Error(),
+				Data: sErr.Violations,
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "初始化失败: " + err.Error(),
+			Data: nil,
+		})
+		return
+	}
+
+	// 开始处理
+	if err := maker.Start(); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "处理失败: " + err.Error(),
+			Data: nil,
+		})
+		return
+	}
+
+	// 结束处理
+	if err := maker.End(); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "结束处理失败: " + err.Error(),
+			Data: nil,
+		})
+		return
+	}
+	// 目标文件已被替换，文件模式下缓存的查询结果可能已经过期
+	invalidateSearchCache()
+
+	elapsed := time.Since(tStart)
+	recordGenerateThroughput(maker.GetSegmentsCount(), elapsed)
+
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "生成成功",
+		Data: gin.H{
+			"elapsed":     elapsed.String(),
+			"elapsedMs":   elapsed.Milliseconds(),
+			"srcFile":     req.SrcFile,
+			"dstFile":     req.DstFile,
+			"indexPolicy": policy.String(),
+			"encoding":    encoding,
+		},
+	})
+}
+
+// 编辑器实例缓存
+var (
+	editors     = make(map[string]*xdb.Editor)
+	editorsLock sync.RWMutex
+)
+
+// 每个源文件的编辑锁，编辑器不是并发安全的，异步的批量编辑在整个导入期间持有
+var (
+	editorLocks     = make(map[string]*sync.Mutex)
+	editorLocksLock sync.Mutex
+)
+
+func editorLock(srcFile string) *sync.Mutex {
+	editorLocksLock.Lock()
+	defer editorLocksLock.Unlock()
+
+	lock, ok := editorLocks[srcFile]
+	if !ok {
+		lock = &sync.Mutex{}
+		editorLocks[srcFile] = lock
+	}
+	return lock
+}
+
+// lockEditor 获取源文件的编辑锁，批量编辑正在执行时返回409，成功时调用方需要调用unlockEditor
+func lockEditor(c *gin.Context, srcFile string) bool {
+	if editorLock(srcFile).TryLock() {
+		return true
+	}
+
+	c.JSON(http.StatusConflict, Response{
+		Code: 409,
+		Msg:  "源文件正在执行批量编辑: " + srcFile,
+	})
+	return false
+}
+
+func unlockEditor(srcFile string) {
+	editorLock(srcFile).Unlock()
+}
+
+// saveEditorIfNeeded 保存源文件的编辑器中未保存的编辑，批量编辑正在执行时返回错误
+func saveEditorIfNeeded(srcFile string) error {
+	lock := editorLock(srcFile)
+	if !lock.TryLock() {
+		return fmt.Errorf("源文件正在执行批量编辑: %s", srcFile)
+	}
+	defer lock.Unlock()
+
+	editorsLock.RLock()
+	editor, ok := editors[srcFile]
+	editorsLock.RUnlock()
+	if !ok || !editor.NeedSave() {
+		return nil
+	}
+
+	if err := editor.Save(); err != nil {
+		return fmt.Errorf("保存编辑内容失败: %w", err)
+	}
+	return nil
+}
+
+// 获取编辑器实例，encoding为空时沿用已打开的编辑器的编码，新建时默认为UTF-8
+func getEditor(srcFile string, encoding string) (*xdb.Editor, error) {
+	_, statErr := os.Stat(srcFile)
+
+	// 先使用读锁检查
+	editorsLock.RLock()
+	if editor, ok := editors[srcFile]; ok {
+		if editor.IsHandleValid() {
+			editorsLock.RUnlock()
+			if err := checkEditorEncoding(editor, encoding); err != nil {
+				return nil, err
+			}
+			setCurrentEditFilePath(srcFile)
+			return editor, nil
+		}
+	}
+	editorsLock.RUnlock()
+
+	// 需要创建新的编辑器，使用写锁
+	editorsLock.Lock()
+	defer editorsLock.Unlock()
+
+	// 双重检查锁定模式
+	if editor, ok := editors[srcFile]; ok {
+		if editor.IsHandleValid() {
+			if err := checkEditorEncoding(editor, encoding); err != nil {
+				return nil, err
+			}
+			setCurrentEditFilePath(srcFile)
+			return editor, nil
+		} else {
+			// 先关闭旧的编辑器
+			editor.Close()
+			delete(editors, srcFile)
+		}
+	}
+
+	// 创建新的编辑器，源文件不存在时从整个IP空间的默认段开始，保存时创建文件
+	var editor *xdb.Editor
+	var err error
+	if os.IsNotExist(statErr) {
+		editor, err = xdb.NewEmptyEditor(srcFile, encoding)
+	} else {
+		editor, err = xdb.NewEditorWithEncoding(srcFile, encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 保存到全局编辑器集合
+	editors[srcFile] = editor
+	setCurrentEditFilePath(srcFile)
+	return editor, nil
+}
+
+// 同一文件的编辑器只能使用一种编码，避免保存时按另一种编码写回
+func checkEditorEncoding(editor *xdb.Editor, encoding string) error {
+	if encoding != "" && encoding != editor.Encoding() {
+		return fmt.Errorf("文件已按 %s 编码打开，如需使用 %s 编码请先卸载编辑文件", editor.Encoding(), encoding)
+	}
+	return nil
+}
+
+// 编辑单个IP段
+func EditSegment(c *gin.Context) {
+	var req EditSegmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "参数错误: " + err.Error(),
+			Data: nil,
+		})
+		return
+	}
+
+	if !resolveRequestPaths(c, &req.SrcFile) {
+		return
+	}
+
+	if !checkEncoding(c, &req.Encoding) {
+		return
+	}
+
+	if !lockEditor(c, req.SrcFile) {
+		return
+	}
+	defer unlockEditor(req.SrcFile)
+
+	// 获取编辑器
+	editor, err := getEditor(req.SrcFile, req.Encoding)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "创建编辑器失败: " + err.Error(),
+			Data: nil,
+		})
+		return
+	}
+
+	// 编辑IP段
+	var r xdb.PutResult
+	seg, err := xdb.SegmentFrom(req.Segment)
+	if err == nil {
+		r, err = editor.PutSegmentMode(seg, editPutMode(req.FillOnly))
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "编辑IP段失败: " + err.Error(),
+			Data: nil,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "编辑成功",
+		Data: gin.H{
+			"oldCount": r.OldRows,
+			"newCount": r.NewRows,
+			"applied":  r.Applied,
+			"skipped":  r.Skipped,
+			"segment":  req.Segment,
+		},
+	})
+}
+
+// 按IP修改所在段的地区，不需要知道段的起止IP，段的边界保持不变
+func EditSegmentAt(c *gin.Context) {
+	var req EditSegmentAtRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	if !resolveRequestPaths(c, &req.SrcFile) {
+		return
+	}
+
+	if !checkEncoding(c, &req.Encoding) {
+		return
+	}
+
+	ip, err := xdb.IP2Long(strings.TrimSpace(req.IP))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "无效的IP: " + err.Error(),
+		})
+		return
+	}
+
+	// 地区写入源文件的一行，不能包含换行
+	if strings.ContainsAny(req.NewRegion, "\r\n") {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "地区信息不能包含换行符",
+		})
+		return
+	}
+
+	if !lockEditor(c, req.SrcFile) {
+		return
+	}
+	defer unlockEditor(req.SrcFile)
+
+	editor, err := getEditor(req.SrcFile, req.Encoding)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "创建编辑器失败: " + err.Error(),
+		})
+		return
+	}
+
+	old, err := editor.SetRegionAt(ip, req.NewRegion)
+	if err != nil {
+		// 没有段包含该IP时返回404，地区信息过长等请求错误返回400
+		status, code := http.StatusBadRequest, 400
+		if errors.Is(err, xdb.ErrSegmentNotFound) {
+			status, code = http.StatusNotFound, 404
+		}
+		c.JSON(status, Response{
+			Code: code,
+			Msg:  "编辑IP段失败: " + err.Error(),
+		})
+		return
+	}
+
+	seg := &xdb.Segment{StartIP: old.StartIP, EndIP: old.EndIP, Region: req.NewRegion}
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "编辑成功",
+		Data: EditSegmentAtResult{
+			StartIP:   xdb.Long2IP(old.StartIP),
+			EndIP:     xdb.Long2IP(old.EndIP),
+			OldRegion: old.Region,
+			NewRegion: req.NewRegion,
+			Segment:   seg.String(),
+		},
+	})
+}
+
+// 地区的匹配方式
+const (
+	regionMatchExact     = "exact"
+	regionMatchSubstring = "substring"
+)
+
+// 批量替换地区：修改编辑器中所有匹配的段的地区，替换后与相邻段地区相同的段会合并
+func ReplaceRegion(c *gin.Context) {
+	var req ReplaceRegionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	if req.Match == "" {
+		req.Match = regionMatchExact
+	}
+	if req.Match != regionMatchExact && req.Match != regionMatchSubstring {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "无效的match，可选值: exact, substring",
+		})
+		return
+	}
+
+	// 地区写入源文件的一行，不能包含换行
+	if strings.ContainsAny(req.NewRegion, "\r\n") {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "地区信息不能包含换行符",
+		})
+		return
+	}
+
+	if !resolveRequestPaths(c, &req.SrcFile) {
+		return
+	}
+
+	if !checkEncoding(c, &req.Encoding) {
+		return
+	}
+
+	if !lockEditor(c, req.SrcFile) {
+		return
+	}
+	defer unlockEditor(req.SrcFile)
+
+	editor, err := getEditor(req.SrcFile, req.Encoding)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "创建编辑器失败: " + err.Error(),
+		})
+		return
+	}
+
+	changed, merged, err := editor.ReplaceRegion(req.OldRegion, req.NewRegion, req.Match == regionMatchSubstring)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "替换地区失败: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "替换成功",
+		Data: gin.H{
+			"changed": changed,
+			"merged":  merged,
+			"segLen":  editor.SegLen(),
+		},
+	})
+}
+
+// 校验IP段：使用与编辑接口相同的解析器检查输入，不修改任何编辑器
+func ValidateSegment(c *gin.Context) {
+	var req ValidateSegmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	seg, err := xdb.SegmentFrom(req.Segment)
+	if err != nil {
+		c.JSON(http.StatusOK, Response{
+			Code: 0,
+			Msg:  "IP段格式错误",
+			Data: ValidateSegmentResult{
+				Error: err.Error(),
+				Hint:  "格式应为 起始IP|结束IP|地区，例如 1.0.0.0|1.0.0.255|中国|0|广东省|广州市|电信",
+			},
+		})
+		return
+	}
+
+	if !checkRegionSchema(c, req.RegionSchema) {
+		return
+	}
+	if req.RegionSchema != nil {
+		if reason := req.RegionSchema.Check(seg.Region); reason != "" {
+			c.JSON(http.StatusOK, Response{
+				Code: 0,
+				Msg:  "地区信息不符合结构要求",
+				Data: ValidateSegmentResult{
+					Error: reason,
+					Hint:  regionSchemaHint(req.RegionSchema),
+				},
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "IP段格式正确",
+		Data: ValidateSegmentResult{
+			Valid:       true,
+			StartIP:     xdb.Long2IP(seg.StartIP),
+			EndIP:       xdb.Long2IP(seg.EndIP),
+			IPCount:     uint64(seg.EndIP) - uint64(seg.StartIP) + 1,
+			Region:      seg.Region,
+			RegionParts: strings.Split(seg.Region, "|"),
+			IsDefault:   xdb.IsDefaultRegion(seg.Region),
+			Canonical:   seg.String(),
+		},
+	})
+}
+
+// regionSchemaHint 描述地区信息的结构要求，作为校验失败时的提示
+func regionSchemaHint(schema *xdb.RegionSchema) string {
+	var parts []string
+	if schema.Fields > 0 {
+		parts = append(parts, fmt.Sprintf("地区信息应为%d个以 | 分隔的字段", schema.Fields))
+	}
+	if len(schema.Required) > 0 {
+		var fields []string
+		for _, i := range schema.Required {
+			fields = append(fields, fmt.Sprintf("第%d个", i+1))
+		}
+		parts = append(parts, strings.Join(fields, "、")+"字段不能为空或0")
+	}
+	return strings.Join(parts, "，")
+}
+
+// 内联请求体的大小上限，源文本和补丁都在内存中处理
+const inlineEditMaxBytes = 32 * 1024 * 1024
+
+// 内联编辑：在内存中对请求提交的源文本应用编辑并返回结果，适合由客户端保管数据的无状态部署
+func EditInline(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, inlineEditMaxBytes)
+
+	var req InlineEditRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, Response{
+				Code: 413,
+				Msg:  fmt.Sprintf("请求体超过内联编辑上限 %d 字节，请使用基于文件的编辑接口", inlineEditMaxBytes),
+			})
+			return
+		}
+
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	// JSON中的文本已是UTF-8，内联编辑不需要转换编码
+	editor, err := xdb.NewEditorFromBytes([]byte(req.Source), xdb.EncodingUTF8)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "解析源文本失败: " + err.Error(),
+		})
+		return
+	}
+	defer editor.Close()
+
+	mode := editPutMode(req.FillOnly)
+	var result xdb.PutResult
+	for i, line := range req.Segments {
+		seg, err := xdb.SegmentFrom(strings.TrimSpace(line))
+		var r xdb.PutResult
+		if err == nil {
+			r, err = editor.PutSegmentMode(seg, mode)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, Response{
+				Code: 400,
+				Msg:  fmt.Sprintf("编辑第%d个IP段失败: %v", i+1, err),
+			})
+			return
+		}
+
+		result.OldRows += r.OldRows
+		result.NewRows += r.NewRows
+		result.Applied += r.Applied
+		result.Skipped += r.Skipped
+	}
+
+	if req.Patch != "" {
+		r, err := editor.PutReaderMode(strings.NewReader(req.Patch), mode)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, Response{
+				Code: 400,
+				Msg:  "应用补丁失败: " + err.Error(),
+			})
+			return
+		}
+
+		result.OldRows += r.OldRows
+		result.NewRows += r.NewRows
+		result.Applied += r.Applied
+		result.Skipped += r.Skipped
+	}
+
+	editor.Coalesce()
+
+	var out strings.Builder
+	if _, err := editor.WriteTo(&out); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "输出源文本失败: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "编辑成功",
+		Data: InlineEditResult{
+			Source:   out.String(),
+			SegLen:   editor.SegLen(),
+			OldCount: result.OldRows,
+			NewCount: result.NewRows,
+			Applied:  result.Applied,
+			Skipped:  result.Skipped,
+		},
+	})
+}
+
+// 从文件批量编辑的限制：补丁文件的最大行数和应用补丁的最长时间，0表示不限制
+var (
+	editFileMaxLines = 5000000
+	editFileTimeout  = 10 * time.Minute
+)
+
+// SetEditFileLimits 设置从文件批量编辑时补丁文件的最大行数和最长执行时间，0表示不限制
+func SetEditFileLimits(maxLines int, timeout time.Duration) {
+	editFileMaxLines = max(maxLines, 0)
+	editFileTimeout = max(timeout, 0)
+}
+
+// 从文件批量编辑的异步任务状态
+type EditFileTaskStatus struct {
+	TaskID          string    `json:"taskId"`
+	File            string    `json:"file"`
+	SrcFile         string    `json:"srcFile"`
+	Status          string    `json:"status"`   // "processing", "completed", "failed"
+	Progress        float64   `json:"progress"` // 已应用的段百分比 0-100
+	TotalSegments   int       `json:"totalSegments"`
+	AppliedSegments int       `json:"appliedSegments"`
+	OldCount        int       `json:"oldCount"`
+	NewCount        int       `json:"newCount"`
+	Applied         int       `json:"applied"`
+	Skipped         int       `json:"skipped"`
+	Partial         bool      `json:"partial,omitempty"`   // 超出限制时已应用的段会保留
+	BytesRead       int64     `json:"bytesRead,omitempty"` // 流式编辑已读取的请求体字节数
+	ErrorMessage    string    `json:"errorMessage,omitempty"`
+	StartTime       time.Time `json:"startTime"`
+	EndTime         time.Time `json:"endTime"`
+}
+
+var (
+	editFileTasks     = make(map[string]*EditFileTaskStatus)
+	editFileTasksLock sync.RWMutex
+)
+
+// GetEditFileTaskStatus 返回任务状态的副本，任务不存在时返回nil
+func GetEditFileTaskStatus(taskID string) *EditFileTaskStatus {
+	editFileTasksLock.RLock()
+	defer editFileTasksLock.RUnlock()
+
+	if task, exists := editFileTasks[taskID]; exists {
+		taskCopy := *task
+		return &taskCopy
+	}
+
+	return nil
+}
+
+func updateEditFileTask(taskID string, update func(task *EditFileTaskStatus)) {
+	editFileTasksLock.Lock()
+	defer editFileTasksLock.Unlock()
+
+	if task, exists := editFileTasks[taskID]; exists {
+		update(task)
+	}
+}
+
+// applyEditFile 按配置的限制从文件批量编辑，progress可以为nil
+func applyEditFile(editor *xdb.Editor, file string, fillOnly bool, progress func(done, total int)) (xdb.PutResult, error) {
+	return editor.PutFileWithLimits(file, editPutMode(fillOnly), xdb.PutLimits{
+		MaxLines: editFileMaxLines,
+		Timeout:  editFileTimeout,
+		Progress: progress,
+	})
+}
+
+// 执行从文件批量编辑的异步任务
+func executeEditFileTask(taskID string, editor *xdb.Editor, file string, fillOnly bool) {
+	r, err := applyEditFile(editor, file, fillOnly, func(done, total int) {
+		updateEditFileTask(taskID, func(task *EditFileTaskStatus) {
+			task.TotalSegments = total
+			task.AppliedSegments = done
+			if total > 0 {
+				task.Progress = math.Round(float64(done)/float64(total)*10000) / 100
+			}
+		})
+	})
+
+	updateEditFileTask(taskID, func(task *EditFileTaskStatus) {
+		task.OldCount, task.NewCount = r.OldRows, r.NewRows
+		task.Applied, task.Skipped = r.Applied, r.Skipped
+		task.EndTime = time.Now()
+		if err != nil {
+			task.Status = "failed"
+			task.ErrorMessage = err.Error()
+			task.Partial = errors.Is(err, xdb.ErrPutLimitExceeded) && r.NewRows > 0
+			return
+		}
+
+		task.Status = "completed"
+		task.Progress = 100
+	})
+
+	if err != nil {
+		log.Printf("任务 %s: 从文件 %s 编辑失败: %v", taskID, file, err)
+	} else {
+		log.Printf("任务 %s: 从文件 %s 编辑完成，应用 %d 个段，跳过 %d 个段", taskID, file, r.Applied, r.Skipped)
+	}
+}
+
+// 从文件批量编辑IP段
+func EditFromFile(c *gin.Context) {
+	var req EditFileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "参数错误: " + err.Error(),
+			Data: nil,
+		})
+		return
+	}
+
+	if !resolveSourcePath(c, &req.File) || !resolveRequestPaths(c, &req.SrcFile) {
+		return
+	}
+
+	if !checkEncoding(c, &req.Encoding) {
+		return
+	}
+
+	// 验证文件存在
+	if _, err := os.Stat(req.File); !isRemoteSource(req.File) && os.IsNotExist(err) {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "文件不存在: " + req.File,
+			Data: nil,
+		})
+		return
+	}
+
+	// 获取编辑器
+	editor, err := getEditor(req.SrcFile, req.Encoding)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "创建编辑器失败: " + err.Error(),
+			Data: nil,
+		})
+		return
+	}
+
+	// 异步任务结束时才释放编辑锁
+	if !lockEditor(c, req.SrcFile) {
+		return
+	}
+
+	// 远程文件先下载到临时文件，编辑结束后删除
+	file, cleanup, ok := fetchRequestSource(c, req.File)
+	if !ok {
+		unlockEditor(req.SrcFile)
+		return
+	}
+
+	// 大批量的导入可以异步执行并查询进度
+	if req.Async {
+		taskID := newTaskID("editfile")
+		editFileTasksLock.Lock()
+		editFileTasks[taskID] = &EditFileTaskStatus{
+			TaskID:    taskID,
+			File:      req.File,
+			SrcFile:   req.SrcFile,
+			Status:    "processing",
+			StartTime: time.Now(),
+		}
+		editFileTasksLock.Unlock()
+
+		go func() {
+			defer cleanup()
+			defer unlockEditor(req.SrcFile)
+			executeEditFileTask(taskID, editor, file, req.FillOnly)
+		}()
+
+		c.JSON(http.StatusOK, Response{
+			Code: 0,
+			Msg:  "编辑任务已创建",
+			Data: gin.H{"taskId": taskID},
+		})
+		return
+	}
+
+	// 从文件编辑
+	defer cleanup()
+	defer unlockEditor(req.SrcFile)
+	r, err := applyEditFile(editor, file, req.FillOnly, nil)
+	if errors.Is(err, xdb.ErrPutLimitExceeded) {
+		// 超时前已应用的段会保留在编辑器中，保存前可以通过 /api/edit/diff 检查
+		c.JSON(http.StatusRequestEntityTooLarge, Response{
+			Code: 413,
+			Msg:  "从文件编辑超出限制: " + err.Error(),
+			Data: gin.H{
+				"oldCount": r.OldRows,
+				"newCount": r.NewRows,
+				"applied":  r.Applied,
+				"skipped":  r.Skipped,
+				"partial":  r.NewRows > 0,
+				"file":     req.File,
+			},
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "从文件编辑失败: " + err.Error(),
+			Data: nil,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "编辑成功",
+		Data: gin.H{
+			"oldCount": r.OldRows,
+			"newCount": r.NewRows,
+			"applied":  r.Applied,
+			"skipped":  r.Skipped,
+			"file":     req.File,
+		},
+	})
+}
+
+// 获取从文件批量编辑的任务状态
+func GetEditFileTaskStatusHandler(c *gin.Context) {
+	task := GetEditFileTaskStatus(c.Param("taskId"))
+	if task == nil {
+		c.JSON(http.StatusNotFound, Response{
+			Code: 404,
+			Msg:  "找不到指定的编辑任务",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "获取任务状态成功",
+		Data: task,
+	})
+}
+
+// 列出IP段
+func ListSegments(c *gin.Context) {
+	var req ListSegmentsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "参数错误: " + err.Error(),
+			Data: nil,
+		})
+		return
+	}
+
+	if !resolveRequestPaths(c, &req.SrcFile) {
+		return
+	}
+
+	if !checkEncoding(c, &req.Encoding) {
+		return
+	}
+
+	// 设置默认值
+	if req.Size <= 0 {
+		req.Size = 10
+	}
+
+	if !lockEditor(c, req.SrcFile) {
+		return
+	}
+	defer unlockEditor(req.SrcFile)
+
+	// 获取编辑器
+	editor, err := getEditor(req.SrcFile, req.Encoding)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "创建编辑器失败: " + err.Error(),
+			Data: nil,
+		})
+		return
+	}
+
+	// offset限制在[0, total)范围内，超出范围时返回空页
+	total := editor.SegLen()
+	if req.Offset < 0 {
+		req.Offset = 0
+	} else if req.Offset > total {
+		req.Offset = total
+	}
+
+	// 获取IP段列表
+	segments := editor.Slice(req.Offset, req.Size)
+	nextOffset := req.Offset + len(segments)
+
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "获取成功",
+		Data: gin.H{
+			"offset":     req.Offset,
+			"size":       req.Size,
+			"total":      total,
+			"segments":   segments,
+			"hasMore":    nextOffset < total,
+			"nextOffset": nextOffset,
+		},
+	})
+}
+
+const editDiffDefaultLimit = 1000
+
+// EditDiff 对比编辑器中的段与磁盘上的源文件，相当于编辑会话的diff
+func EditDiff(c *gin.Context) {
+	var req EditDiffRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "参数错误: " + err.Error(),
+			Data: nil,
+		})
+		return
+	}
+
+	if !resolveRequestPaths(c, &req.SrcFile) {
+		return
+	}
+
+	if req.Limit <= 0 {
+		req.Limit = editDiffDefaultLimit
+	}
+
+	if !lockEditor(c, req.SrcFile) {
+		return
+	}
+	defer unlockEditor(req.SrcFile)
+
+	editorsLock.RLock()
+	editor, ok := editors[req.SrcFile]
+	editorsLock.RUnlock()
+	if !ok {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "编辑器不存在，请先进行编辑操作",
+			Data: nil,
+		})
+		return
+	}
+
+	diff, err := editor.Diff()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "读取源文件失败: " + err.Error(),
+			Data: nil,
+		})
+		return
+	}
+
+	result := EditDiffResult{
+		SrcFile:       req.SrcFile,
+		Dirty:         editor.NeedSave(),
+		Added:         make([]SegmentDiffItem, 0, min(len(diff.Added), req.Limit)),
+		Removed:       make([]SegmentDiffItem, 0, min(len(diff.Removed), req.Limit)),
+		Modified:      make([]SegmentDiffItem, 0, min(len(diff.Modified), req.Limit)),
+		AddedCount:    len(diff.Added),
+		RemovedCount:  len(diff.Removed),
+		ModifiedCount: len(diff.Modified),
+	}
+	result.Truncated = result.AddedCount > req.Limit || result.RemovedCount > req.Limit || result.ModifiedCount > req.Limit
+
+	for _, seg := range diff.Added[:min(len(diff.Added), req.Limit)] {
+		result.Added = append(result.Added, SegmentDiffItem{
+			StartIP: xdb.Long2IP(seg.StartIP),
+			EndIP:   xdb.Long2IP(seg.EndIP),
+			Region:  seg.Region,
+		})
+	}
+	for _, seg := range diff.Removed[:min(len(diff.Removed), req.Limit)] {
+		result.Removed = append(result.Removed, SegmentDiffItem{
+			StartIP: xdb.Long2IP(seg.StartIP),
+			EndIP:   xdb.Long2IP(seg.EndIP),
+			Region:  seg.Region,
+		})
+	}
+	for _, change := range diff.Modified[:min(len(diff.Modified), req.Limit)] {
+		result.Modified = append(result.Modified, SegmentDiffItem{
+			StartIP:   xdb.Long2IP(change.New.StartIP),
+			EndIP:     xdb.Long2IP(change.New.EndIP),
+			OldRegion: change.Old.Region,
+			NewRegion: change.New.Region,
+		})
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "获取编辑差异成功",
+		Data: result,
+	})
+}
+
+// 保存编辑
+func SaveEdit(c *gin.Context) {
+	var req SaveEditRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "参数错误: " + err.Error(),
+			Data: nil,
+		})
+		return
+	}
+
+	if !resolveRequestPaths(c, &req.SrcFile) {
+		return
+	}
+
+	if !lockEditor(c, req.SrcFile) {
+		return
+	}
+	defer unlockEditor(req.SrcFile)
+
+	// 获取编辑器
+	editor, ok := editors[req.SrcFile]
+	if !ok {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "编辑器不存在，请先进行编辑操作",
+			Data: nil,
+		})
+		return
+	}
+
+	// 保存前合并相邻的同区域段
+	merged := editor.Coalesce()
+
+	// 保存编辑
+	if err := editor.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "保存失败: " + err.Error(),
+			Data: nil,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "保存成功",
+		Data: gin.H{
+			"srcFile": req.SrcFile,
+			"merged":  merged,
+			"segLen":  editor.SegLen(),
+		},
+	})
+}
+
+// countFileLines 统计文件的行数，最后一行没有换行符时也计入
+func countFileLines(path string) (int, error) {
+	handle, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer handle.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(handle)
+	for scanner.Scan() {
+		lines++
+	}
+
+	return lines, scanner.Err()
+}
+
+// 整理源文件：合并相邻的同区域段，去掉注释和空行，规范化空白后按IP顺序重写
+func CompactSource(c *gin.Context) {
+	var req CompactSourceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	if !resolveRequestPaths(c, &req.SrcFile) {
+		return
+	}
+
+	if !checkEncoding(c, &req.Encoding) {
+		return
+	}
+
+	linesBefore, err := countFileLines(req.SrcFile)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "读取源文件失败: " + err.Error(),
+		})
+		return
+	}
+
+	if !lockEditor(c, req.SrcFile) {
+		return
+	}
+	defer unlockEditor(req.SrcFile)
+
+	editor, err := getEditor(req.SrcFile, req.Encoding)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "获取编辑器失败: " + err.Error(),
+		})
+		return
+	}
+
+	// 整理会重写源文件，不能把尚未确认的编辑一并写入
+	if editor.NeedSave() {
+		c.JSON(http.StatusConflict, Response{
+			Code: 409,
+			Msg:  "源文件有未保存的编辑，请先保存或放弃后再整理",
+		})
+		return
+	}
+
+	if err := editor.Compact(); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "整理源文件失败: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "整理完成",
+		Data: CompactSourceResult{
+			SrcFile:     req.SrcFile,
+			LinesBefore: linesBefore,
+			LinesAfter:  editor.SegLen(),
+		},
+	})
+}
+
+// 保存编辑并生成数据库文件
+func SaveAndGenerateDb(c *gin.Context) {
+	var req SaveAndGenerateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "请求参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	if !resolveRequestPaths(c, &req.SrcFile, &req.DstFile) {
+		return
+	}
+
+	if !checkOutputPath(c, req.DstFile, false, req.SrcFile) {
+		return
+	}
+
+	if !checkEncoding(c, &req.Encoding) {
+		return
+	}
+
+	fileMode, ok := checkFileMode(c, req.FileMode)
+	if !ok || !checkRegionSchema(c, req.RegionSchema) {
+		return
+	}
+
+	policy, err := parseIndexPolicy(req.IndexPolicy)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  err.Error(),
+		})
+		return
+	}
+
+	if !lockEditor(c, req.SrcFile) {
+		return
+	}
+	defer unlockEditor(req.SrcFile)
+
+	// 获取编辑器
+	editor, err := getEditor(req.SrcFile, req.Encoding)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "获取编辑器失败: " + err.Error(),
+		})
+		return
+	}
+
+	// 合并相邻的同区域段，如果编辑器需要保存，先保存更改
+	merged := editor.Coalesce()
+	if editor.NeedSave() {
+		if err := editor.Save(); err != nil {
+			c.JSON(http.StatusInternalServerError, Response{
+				Code: 500,
+				Msg:  "保存文件失败: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	// 编辑器加载时已拒绝空缺和重叠，严格模式还要求覆盖整个地址空间
+	if req.Strict {
+		if err := xdb.CheckContinuity(editor.Slice(0, editor.SegLen())); err != nil {
+			cErr := continuityProblems(err)
+			c.JSON(http.StatusBadRequest, Response{
+				Code: 400,
+				Msg:  "严格模式检查失败: " + cErr.Error(),
+				Data: cErr.Problems,
+			})
+			return
+		}
+	}
+
+	if req.RegionSchema != nil {
+		if err := xdb.CheckRegionSchema(editor.Slice(0, editor.SegLen()), req.RegionSchema); err != nil {
+			sErr := regionSchemaViolations(err)
+			c.JSON(http.StatusBadRequest, Response{
+				Code: 400,
+				Msg:  "地区信息结构检查失败: " + sErr.Error(),
+				Data: sErr.Violations,
+			})
+			return
+		}
+	}
+
+	// 与其它生成共用执行位置，没有空闲位置时等待，客户端断开时放弃
+	if !generateTasksPool.acquire("", nil, c.Request.Context().Done()) {
+		return
+	}
+	defer generateTasksPool.release()
+
+	// 使用编辑器中的内存数据直接生成XDB文件
+	tStart := time.Now()
+	if err := editor.SaveToXdbFileWithMode(req.DstFile, policy, fileMode); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "生成XDB文件失败: " + err.Error(),
+		})
+		return
+	}
+	invalidateSearchCache()
+	timeTaken := time.Since(tStart)
+
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "源文件已保存并生成XDB文件",
+		Data: map[string]interface{}{
+			"srcFile":     req.SrcFile,
+			"dstFile":     req.DstFile,
+			"segLen":      editor.SegLen(),
+			"merged":      merged,
+			"indexPolicy": policy.String(),
+			"timeTaken":   timeTaken.String(),
+			"timeTakenMs": timeTaken.Milliseconds(),
+		},
+	})
+}
+
+// 清理资源函数
+func Cleanup() {
+	// 关闭搜索器
+	if searcher != nil {
+		searcher.Close()
+		searcher = nil
+	}
+	// 编辑器不需要关闭
+	editors = make(map[string]*xdb.Editor)
+}
+
+// 导出任务状态结构（优化版本，使用atomic计数器）
+type ExportTaskStatus struct {
+	TaskID            string  `json:"taskId"`
+	XdbPath           string  `json:"xdbPath"`
+	ExportPath        string  `json:"exportPath"`
+	Status            string  `json:"status"`            // "pending", "processing", "completed", "failed"
+	Progress          float64 `json:"progress"`          // 进度百分比 0-100
+	CurrentAClass     uint32  `json:"currentAClass"`     // 当前处理的A类网段
+	ProcessedAClasses int     `json:"processedAClasses"` // 已处理的A类网段数量
+	TotalAClasses     int     `json:"totalAClasses"`     // 总A类网段数量
+
+	// 新增：明确的导出字段用于JSON序列化
+	RecordCount  int64 `json:"recordCount"`  // 直接使用这个名字，确保前端也用它
+	SegmentCount int64 `json:"segmentCount"` // 直接使用这个名字
+
+	IndexSegments int64 `json:"indexSegments,omitempty"` // XDB中的段索引条数，用于估计导出规模
+
+	// 导出完成后的文件大小，未压缩时两者相同
+	Compressed        bool  `json:"compressed,omitempty"`
+	FileBytes         int64 `json:"fileBytes,omitempty"`         // 导出文件的字节数
+	UncompressedBytes int64 `json:"uncompressedBytes,omitempty"` // 解压后的字节数
+
+	recordCount  int64 // 内部原子计数器，保持小写非导出
+	segmentCount int64 // 内部原子计数器，保持小写非导出
+
+	ErrorMessage    string    `json:"errorMessage"`
+	StartTime       time.Time `json:"startTime"`
+	EndTime         time.Time `json:"endTime"`
+	DurationSeconds float64   `json:"durationSeconds,omitempty"` // 可选字段，改为秒数
+	EtaSeconds      float64   `json:"etaSeconds"`                // 预计剩余秒数，-1表示暂时无法估算
+	lastUpdateTime  int64     `json:"-"`                         // 使用atomic存储unix时间戳
+	DetailedStatus  string    `json:"detailedStatus"`            // 详细状态描述
+}
+
+// GetRecordCountInternal 原子获取记录数 (内部使用)
+func (e *ExportTaskStatus) GetRecordCountInternal() int64 {
+	return atomic.LoadInt64(&e.recordCount)
+}
+
+// AddRecordCountInternal 原子增加记录数 (内部使用) - 注意：原AddRecordCount功能不变，仅改名以匹配内部使用约定
+func (e *ExportTaskStatus) AddRecordCountInternal(delta int64) int64 {
+	return atomic.AddInt64(&e.recordCount, delta)
+}
+
+// SetRecordCountInternal 原子设置记录数 (内部使用)
+func (e *ExportTaskStatus) SetRecordCountInternal(count int64) {
+	atomic.StoreInt64(&e.recordCount, count)
+}
+
+// GetSegmentCountInternal 原子获取段数 (内部使用)
+func (e *ExportTaskStatus) GetSegmentCountInternal() int64 {
+	return atomic.LoadInt64(&e.segmentCount)
+}
+
+// AddSegmentCountInternal 原子增加段数 (内部使用) - 注意：原AddSegmentCount功能不变，仅改名以匹配内部使用约定
+func (e *ExportTaskStatus) AddSegmentCountInternal(delta int64) int64 {
+	return atomic.AddInt64(&e.segmentCount, delta)
+}
+
+// SetSegmentCountInternal 原子设置段数 (内部使用)
+func (e *ExportTaskStatus) SetSegmentCountInternal(count int64) {
+	atomic.StoreInt64(&e.segmentCount, count)
+}
+
+// GetLastUpdateTime 获取最后更新时间
+func (e *ExportTaskStatus) GetLastUpdateTime() time.Time {
+	timestamp := atomic.LoadInt64(&e.lastUpdateTime)
+	if timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(timestamp, 0)
+}
+
+// UpdateLastUpdateTime 更新最后更新时间为当前时间
 func (e *ExportTaskStatus) UpdateLastUpdateTime() {
 	atomic.StoreInt64(&e.lastUpdateTime, time.Now().Unix())
 }
 
-// LastUpdateTime 为了兼容JSON序列化，提供getter方法 - 这个可以保留，因为它处理的是非原子字段的逻辑转换
-func (e *ExportTaskStatus) LastUpdateTime() time.Time {
-	return e.GetLastUpdateTime()
+// LastUpdateTime 为了兼容JSON序列化，提供getter方法 - 这个可以保留，因为它处理的是非原子字段的逻辑转换
+func (e *ExportTaskStatus) LastUpdateTime() time.Time {
+	return e.GetLastUpdateTime()
+}
+
+// 导出任务管理器
+var (
+	exportTasks     = make(map[string]*ExportTaskStatus)
+	exportTasksLock = sync.RWMutex{}
+	// 添加取消信号通道的映射
+	cancelChans = make(map[string]chan bool)
+)
+
+// 获取任务状态
+func GetExportTaskStatus(taskID string) *ExportTaskStatus {
+	exportTasksLock.RLock()
+	defer exportTasksLock.RUnlock()
+
+	if task, exists := exportTasks[taskID]; exists {
+		// 创建一个任务状态的副本用于返回，以确保我们填充的导出字段是最新的
+		taskCopy := *task // Dereference to get a copy
+
+		// 计算已运行时间 (这部分逻辑保持不变)
+		var duration time.Duration
+		if taskCopy.Status == "completed" || taskCopy.Status == "failed" {
+			if !taskCopy.EndTime.IsZero() && !taskCopy.StartTime.IsZero() {
+				duration = taskCopy.EndTime.Sub(taskCopy.StartTime)
+			} else {
+				duration = time.Since(taskCopy.StartTime)
+			}
+		} else {
+			duration = time.Since(taskCopy.StartTime)
+		}
+
+		durationSeconds := math.Round(duration.Seconds())
+		if durationSeconds < 0 || math.IsNaN(durationSeconds) {
+			durationSeconds = 0
+		}
+		taskCopy.DurationSeconds = durationSeconds
+
+		taskCopy.RecordCount = task.GetRecordCountInternal()
+		taskCopy.SegmentCount = task.GetSegmentCountInternal()
+
+		return &taskCopy
+	}
+	return nil
+}
+
+// 更新任务状态
+func updateExportTaskStatus(taskID string, updater func(*ExportTaskStatus)) {
+	exportTasksLock.Lock()
+	defer exportTasksLock.Unlock()
+
+	if task, exists := exportTasks[taskID]; exists {
+		updater(task)
+	} else {
+		log.Printf("任务 %s: updateExportTaskStatus - 任务不存在，无法更新", taskID)
+	}
+}
+
+// ExportXdb 导出XDB文件中的数据到文本文件
+func ExportXdb(c *gin.Context) {
+	var req ExportXdbRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "请求参数错误: " + err.Error(),
+			Data: nil,
+		})
+		return
+	}
+
+	if !resolveRequestPaths(c, &req.XdbPath, &req.ExportPath) {
+		return
+	}
+
+	if !checkOutputPath(c, req.ExportPath, true, req.XdbPath) {
+		return
+	}
+
+	// 未指定的调优参数使用默认值
+	if req.BufferSizeKB == 0 {
+		req.BufferSizeKB = exportBufferSizeKB
+	}
+	if req.StepSize == 0 {
+		req.StepSize = exportStepSize
+	}
+	if err := checkExportTuning(req.BufferSizeKB, req.StepSize); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  err.Error(),
+			Data: nil,
+		})
+		return
+	}
+	opts := exportOptions{
+		bufferSize:    req.BufferSizeKB * 1024,
+		stepSize:      uint32(req.StepSize),
+		includeHeader: req.IncludeHeader,
+		compress:      req.Compress || strings.EqualFold(filepath.Ext(req.ExportPath), ".gz"),
+	}
+
+	if err := checkCallbackURL(req.CallbackURL); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  err.Error(),
+			Data: nil,
+		})
+		return
+	}
+
+	fileMode, ok := checkFileMode(c, req.FileMode)
+	if !ok {
+		return
+	}
+	opts.fileMode = fileMode
+
+	// 创建导出任务ID
+	taskID := newTaskID("export")
+
+	// 创建任务取消通道
+	exportTasksLock.Lock()
+	cancelChan := make(chan bool, 1)
+	cancelChans[taskID] = cancelChan
+
+	// 初始化任务状态
+	exportTasks[taskID] = &ExportTaskStatus{
+		TaskID:         taskID,
+		XdbPath:        req.XdbPath,
+		ExportPath:     req.ExportPath,
+		Status:         "pending",
+		StartTime:      time.Now(),
+		EtaSeconds:     -1,
+		lastUpdateTime: time.Now().Unix(),
+	}
+	exportTasksLock.Unlock()
+
+	// 异步执行导出
+	go executeExportTask(taskID, req.XdbPath, req.ExportPath, opts, req.CallbackURL)
+
+	// 返回任务ID
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "导出任务已创建",
+		Data: map[string]interface{}{
+			"taskId": taskID,
+		},
+	})
+}
+
+func executeExportTask(taskID string, xdbPath string, exportPath string, opts exportOptions, callbackURL string) {
+	// 任务结束后通知回调地址
+	if callbackURL != "" {
+		defer func() {
+			go notifyTaskCallback(callbackURL, "export", taskID, GetExportTaskStatus(taskID))
+		}()
+	}
+
+	log.Printf("开始执行导出任务: %s, XDB: %s, 导出至: %s, 缓冲区: %dKB, 扫描步长: %d", taskID, xdbPath, exportPath, opts.bufferSize/1024, opts.stepSize)
+
+	// 获取取消通道
+	var cancelChan chan bool
+	exportTasksLock.RLock()
+	if ch, exists := cancelChans[taskID]; exists {
+		cancelChan = ch
+	} else {
+		// 取消通道在创建任务时注册，开始执行前已被取消时会被移除
+		cancelChan = make(chan bool, 1)
+		close(cancelChan)
+		log.Printf("任务 %s 的取消通道未找到，任务在开始前已被取消", taskID)
+	}
+	exportTasksLock.RUnlock()
+
+	// 清理函数
+	defer func() {
+		exportTasksLock.Lock()
+		delete(cancelChans, taskID)
+		exportTasksLock.Unlock()
+		log.Printf("导出任务清理完成: %s", taskID)
+	}()
+
+	// 更新任务状态为处理中，开始前已被取消的任务直接结束
+	var cancelledBeforeStart bool
+	updateExportTaskStatus(taskID, func(task *ExportTaskStatus) {
+		if task.Status == "failed" {
+			cancelledBeforeStart = true
+			return
+		}
+		task.Status = "processing"
+		task.DetailedStatus = "正在加载XDB文件..."
+		task.StartTime = time.Now()
+		task.Progress = 0
+		task.SetRecordCountInternal(0)
+		task.SetSegmentCountInternal(0)
+		task.UpdateLastUpdateTime()
+	})
+	if cancelledBeforeStart {
+		return
+	}
+
+	var searcherInstance *xdb.Searcher
+	var err error
+	var localSearcherCreated bool = false
+
+	// 尝试使用全局已加载的常驻模式 searcher
+	searcherLock.RLock()
+	if searcher != nil && searcherPath == xdbPath && isCachedMode(searcherMode) {
+		searcherInstance = searcher
+		log.Printf("任务 %s: 使用已加载的 %s 模式搜索器: %s", taskID, searcherMode, searcherPath)
+	}
+	searcherLock.RUnlock()
+
+	if searcherInstance == nil {
+		// 如果没有匹配的全局搜索器，或者全局搜索器是文件模式（不应在此处使用），则为本次任务创建临时的文件模式搜索器
+		log.Printf("任务 %s: 未匹配到已加载的向量/内存模式搜索器，将创建临时文件模式搜索器用于导出: %s", taskID, xdbPath)
+		searcherInstance, err = xdb.NewWithFileOnly(xdbPath) // 直接使用 NewWithFileOnly
+		if err != nil {
+			errMsg := fmt.Sprintf("创建临时文件模式搜索器失败: %v", err)
+			log.Printf("任务 %s: %s", taskID, errMsg)
+			updateExportTaskStatus(taskID, func(task *ExportTaskStatus) {
+				task.Status = "failed"
+				task.ErrorMessage = errMsg
+				task.EndTime = time.Now()
+			})
+			return
+		}
+		localSearcherCreated = true // 标记此搜索器是本地创建的，需要关闭
+		log.Printf("任务 %s: 临时文件模式XDB文件加载成功: %s", taskID, xdbPath)
+	}
+
+	// 如果是本地创建的临时搜索器，确保在使用完毕后关闭
+	if localSearcherCreated && searcherInstance != nil {
+		defer searcherInstance.Close()
+	}
+
+	updateExportTaskStatus(taskID, func(task *ExportTaskStatus) {
+		task.DetailedStatus = "正在导出IP段..."
+		task.UpdateLastUpdateTime()
+	})
+
+	// 段索引条数只用于报告数据库规模，导出的段数量取决于合并和未覆盖的范围
+	if sPtr, ePtr, err := searcherInstance.IndexBlockRange(); err == nil && ePtr >= sPtr && sPtr > 0 {
+		indexSegments := int64(ePtr-sPtr)/xdb.SegmentIndexSize + 1
+		log.Printf("任务 %s: XDB共有 %d 条段索引", taskID, indexSegments)
+		updateExportTaskStatus(taskID, func(task *ExportTaskStatus) {
+			task.IndexSegments = indexSegments
+		})
+	}
+
+	expectedFields, err := sampleRegionFields(searcherInstance)
+	if err != nil {
+		errMsg := fmt.Sprintf("读取段索引失败: %v", err)
+		log.Printf("任务 %s: %s", taskID, errMsg)
+		updateExportTaskStatus(taskID, func(task *ExportTaskStatus) {
+			task.Status = "failed"
+			task.ErrorMessage = errMsg
+			task.EndTime = time.Now()
+		})
+		return
+	}
+	log.Printf("任务 %s: 根据段索引推断的区域字段数量: %d", taskID, expectedFields)
+
+	var header []string
+	if opts.includeHeader {
+		header = exportHeader(xdbPath)
+	}
+
+	writer, err := newExportSegmentWriter(exportPath, header, expectedFields, opts.bufferSize, opts.compress, opts.fileMode)
+	if err != nil {
+		log.Printf("任务 %s: %v", taskID, err)
+		updateExportTaskStatus(taskID, func(task *ExportTaskStatus) {
+			task.Status = "failed"
+			task.ErrorMessage = err.Error()
+			task.EndTime = time.Now()
+		})
+		return
+	}
+
+	// 用于跟踪已处理的段数量
+	var processedSegments int64 = 0
+
+	segmentTotal, err := dumpAllIPsFromXDB(searcherInstance, taskID, opts.stepSize, cancelChan, writer.Write, func(processedIP uint32, totalIPs uint32, segmentCount int) {
+		// 扫描时段已经逐个写入文件，扫描占0-99%，最后的1%留给刷新缓冲区和重命名，完成时才显示100%
+		var progress float64
+		if totalIPs > 0 {
+			progress = float64(processedIP) / float64(totalIPs) * exportScanProgress
+		}
+
+		// 更新已处理的段数量
+		processedSegments = int64(segmentCount)
+
+		// 准备详细状态字符串，不包括百分比
+		detailedStatus := fmt.Sprintf("正在扫描 IP: %s - 已写入 %d 个IP段",
+			xdb.Long2IP(processedIP), segmentCount)
+
+		updateExportTaskStatus(taskID, func(task *ExportTaskStatus) {
+			// RecordCount 表示当前处理到的IP地址
+			// SegmentCount 表示已写入的IP段数量
+			task.SetRecordCountInternal(int64(processedIP)) // 当前处理的IP地址
+			task.SetSegmentCountInternal(processedSegments) // 已写入的段数量
+			task.Progress = progress
+			task.EtaSeconds = estimateEtaSeconds(time.Since(task.StartTime), int64(processedIP), int64(totalIPs))
+			task.CurrentAClass = 0
+			task.ProcessedAClasses = 0
+			task.TotalAClasses = 0
+			task.DetailedStatus = detailedStatus
+			task.UpdateLastUpdateTime()
+		})
+		log.Printf("任务 %s: 扫描进度 - %s", taskID, detailedStatus)
+	})
+
+	if err == nil {
+		updateExportTaskStatus(taskID, func(task *ExportTaskStatus) {
+			task.Progress = exportScanProgress
+			task.EtaSeconds = 0
+			task.DetailedStatus = fmt.Sprintf("正在完成导出文件 - 已写入 %d 个IP段", segmentTotal)
+			task.UpdateLastUpdateTime()
+		})
+		err = writer.Commit()
+	} else {
+		writer.Abort()
+	}
+
+	if err != nil {
+		errMsg := fmt.Sprintf("导出IP段失败: %v", err)
+		log.Printf("任务 %s: %s", taskID, errMsg)
+		// 检查错误是否由于取消操作导致
+		if errors.Is(err, context.Canceled) || errors.Is(err, errTaskCancelled) || strings.Contains(err.Error(), "任务已取消") {
+			updateExportTaskStatus(taskID, func(task *ExportTaskStatus) {
+				task.Status = "failed"
+				task.ErrorMessage = "导出任务已取消"
+				task.EndTime = time.Now()
+			})
+		} else {
+			updateExportTaskStatus(taskID, func(task *ExportTaskStatus) {
+				task.Status = "failed"
+				task.ErrorMessage = errMsg
+				task.EndTime = time.Now()
+			})
+		}
+		return
+	}
+
+	if writer.defaultFilled > 0 {
+		log.Printf("任务 %s: %d 个未覆盖的IP段使用了 %d 字段的默认值", taskID, writer.defaultFilled, expectedFields)
+	}
+	updateExportTaskStatus(taskID, func(task *ExportTaskStatus) {
+		task.SetSegmentCountInternal(int64(segmentTotal))
+		task.Compressed = opts.compress
+		task.FileBytes, task.UncompressedBytes = writer.fileBytes, writer.uncompressedBytes
+	})
+	if opts.compress {
+		log.Printf("任务 %s: 压缩后 %d 字节，解压后 %d 字节", taskID, writer.fileBytes, writer.uncompressedBytes)
+	}
+
+	log.Printf("任务 %s: 导出成功完成", taskID)
+	updateExportTaskStatus(taskID, func(task *ExportTaskStatus) {
+		task.Status = "completed"
+		task.Progress = 100
+		task.EtaSeconds = 0
+		task.EndTime = time.Now()
+		task.DetailedStatus = "导出完成"
+		task.UpdateLastUpdateTime()
+	})
+}
+
+var errTaskCancelled = errors.New("任务已取消")
+
+// 扫描结束时的导出进度，任务完成后才为100
+const exportScanProgress = 99
+
+// 导出写文件缓冲区大小和扫描步长的取值范围
+const (
+	exportMinBufferSizeKB = 64
+	exportMaxBufferSizeKB = 64 * 1024
+	exportMaxStepSize     = 65536
+)
+
+// 导出调优参数的默认值，可以通过命令行参数修改，单次请求也可以覆盖
+var (
+	exportBufferSizeKB = 4 * 1024
+	exportStepSize     = 256
+)
+
+// exportOptions 单次导出任务使用的调优参数
+type exportOptions struct {
+	bufferSize    int         // 写文件缓冲区字节数
+	stepSize      uint32      // 扫描步长，段边界按该粒度识别
+	includeHeader bool        // 在文件开头写入来源信息注释
+	compress      bool        // 以gzip格式写出
+	fileMode      os.FileMode // 导出文件的权限，0表示保留已有文件的权限或使用默认权限
+}
+
+// checkExportTuning 校验缓冲区大小和扫描步长，步长需为2的幂以便与网段边界对齐
+func checkExportTuning(bufferSizeKB int, stepSize int) error {
+	if bufferSizeKB < exportMinBufferSizeKB || bufferSizeKB > exportMaxBufferSizeKB {
+		return fmt.Errorf("写文件缓冲区大小应在 %dKB 到 %dKB 之间，当前为 %dKB", exportMinBufferSizeKB, exportMaxBufferSizeKB, bufferSizeKB)
+	}
+
+	if stepSize < 1 || stepSize > exportMaxStepSize || stepSize&(stepSize-1) != 0 {
+		return fmt.Errorf("扫描步长应为 1 到 %d 之间的2的幂，当前为 %d", exportMaxStepSize, stepSize)
+	}
+
+	return nil
+}
+
+// SetExportTuning 设置导出写文件缓冲区大小(KB)和扫描步长的默认值
+func SetExportTuning(bufferSizeKB int, stepSize int) error {
+	if err := checkExportTuning(bufferSizeKB, stepSize); err != nil {
+		return err
+	}
+
+	exportBufferSizeKB, exportStepSize = bufferSizeKB, stepSize
+	return nil
+}
+
+// 导出扫描时单个IP查询失败的重试次数，首次重试等待exportRetryBaseDelay，之后每次翻倍
+var exportSearchRetries int32 = 3
+
+const exportRetryBaseDelay = 50 * time.Millisecond
+
+// SetExportSearchRetries 设置导出扫描时单个IP查询失败的重试次数，0表示不重试
+func SetExportSearchRetries(retries int) {
+	if retries < 0 {
+		retries = 0
+	}
+	atomic.StoreInt32(&exportSearchRetries, int32(retries))
+}
+
+// searchWithRetry 查询失败时按指数退避重试，导出被取消时立即返回errTaskCancelled，
+// 同时返回命中段的结束IP，未命中任何段时结束IP为ip本身
+func searchWithRetry(ctx context.Context, s *xdb.Searcher, ip uint32) (string, uint32, bool, error) {
+	retries := int(atomic.LoadInt32(&exportSearchRetries))
+	delay := exportRetryBaseDelay
+
+	region, _, endIP, found, err := s.SearchWithRange(ip)
+	for attempt := 1; err != nil && attempt <= retries; attempt++ {
+		log.Printf("警告: 查询 IP %s 失败，%v 后进行第 %d/%d 次重试: %v", xdb.Long2IP(ip), delay, attempt, retries, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", ip, false, errTaskCancelled
+		case <-timer.C:
+		}
+
+		delay *= 2
+		region, _, endIP, found, err = s.SearchWithRange(ip)
+	}
+
+	return region, endIP, found, err
+}
+
+// dumpAllIPsFromXDB 逐段扫描XDB，按IP顺序把每个IP段交给emit，返回IP段数量；
+// 扫描的同时写出，内存占用与数据库大小无关
+func dumpAllIPsFromXDB(s *xdb.Searcher, taskID string, stepSize uint32, cancelChan chan bool, emit func(seg *IPSegment) error, progressCallback func(processedIP, totalIPs uint32, segmentCount int)) (int, error) {
+	log.Printf("任务 %s: 开始从XDB逐IP转储所有数据", taskID)
+
+	var currentIP uint32 = 0x01000000 // 1.0.0.0
+	const lastIP uint32 = 0xFFFFFFFF
+
+	if currentIP > lastIP {
+		log.Printf("任务 %s: 起始扫描IP (1.0.0.0) 大于 IPv4 最大IP，不执行扫描。", taskID)
+		return 0, nil
+	}
+
+	log.Printf("任务 %s: 逐段扫描将从 IP %s 开始，未命中任何段时步长为 %d", taskID, xdb.Long2IP(currentIP), stepSize)
+
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	go func() {
+		select {
+		case <-cancelChan:
+			cancelCtx()
+		case <-ctx.Done():
+		}
+	}()
+
+	var segmentCount int = 0
+	var lastRegion string = ""
+	var started bool = false
+	var segmentStartIP uint32 = currentIP
+	var lookups int = 0
+
+	for currentIP <= lastIP {
+		if ctx.Err() != nil {
+			log.Printf("任务 %s: XDB转储导出被取消 (当前IP: %s)", taskID, xdb.Long2IP(currentIP))
+			return segmentCount, errTaskCancelled
+		}
+
+		// 查询当前IP的区域信息以及所在段的结束IP，临时性错误会重试
+		currentRegion, segmentEndIP, found, err := searchWithRetry(ctx, s, currentIP)
+		lookups++
+		if errors.Is(err, errTaskCancelled) {
+			log.Printf("任务 %s: XDB转储导出被取消 (当前IP: %s)", taskID, xdb.Long2IP(currentIP))
+			return segmentCount, errTaskCancelled
+		}
+		if err != nil {
+			log.Printf("错误: 任务 %s: 查询 IP %s 重试 %d 次后仍然失败，跳过 %d 个IP，导出结果在此处可能缺失段边界: %v",
+				taskID, xdb.Long2IP(currentIP), atomic.LoadInt32(&exportSearchRetries), stepSize, err)
+			// 检查是否会发生溢出
+			if currentIP > lastIP-stepSize {
+				// 如果加上stepSize会溢出，直接跳出循环
+				log.Printf("任务 %s: IP %s 接近最大值，停止扫描以避免溢出", taskID, xdb.Long2IP(currentIP))
+				break
+			}
+			currentIP += stepSize
+			continue
+		}
+
+		// 未命中任何段的IP区域为空，写文件时按数据的字段数量填充默认值
+		// 如果区域发生变化，保存上一个段
+		if started && currentRegion != lastRegion {
+			if err := emit(&IPSegment{
+				StartIP: segmentStartIP,
+				EndIP:   currentIP - 1,
+				Region:  lastRegion,
+			}); err != nil {
+				return segmentCount, err
+			}
+			segmentCount++
+			segmentStartIP = currentIP
+		}
+
+		lastRegion = currentRegion
+		started = true
+
+		// 每查询一定次数后更新进度
+		if lookups%256 == 0 {
+			progressCallback(currentIP, lastIP, segmentCount)
+		}
+
+		// 命中段时直接跳到段的结束IP之后，每个段只需查询一次，单个IP的段或命中段的最后一个IP时同样如此；
+		// 未命中时按步长前进
+		var nextIP uint64
+		if found {
+			nextIP = uint64(segmentEndIP) + 1
+		} else {
+			nextIP = uint64(currentIP) + uint64(stepSize)
+		}
+
+		// 检查是否会发生溢出
+		if nextIP > uint64(lastIP) {
+			log.Printf("任务 %s: IP %s 接近最大值，完成扫描", taskID, xdb.Long2IP(currentIP))
+			break
+		}
+		currentIP = uint32(nextIP)
+	}
+
+	// 添加最后一个段
+	if started {
+		if err := emit(&IPSegment{
+			StartIP: segmentStartIP,
+			EndIP:   lastIP,
+			Region:  lastRegion,
+		}); err != nil {
+			return segmentCount, err
+		}
+		segmentCount++
+	}
+
+	progressCallback(lastIP, lastIP, segmentCount)
+	log.Printf("任务 %s: XDB转储完成，共发现 %d 个段，查询 %d 次 (从 %s 开始扫描)", taskID, segmentCount, lookups, xdb.Long2IP(0x01000000))
+	return segmentCount, nil
 }
 
-// 导出任务管理器
-var (
-	exportTasks     = make(map[string]*ExportTaskStatus)
-	exportTasksLock = sync.RWMutex{}
-	// 添加取消信号通道的映射
-	cancelChans = make(map[string]chan bool)
-)
+// 推断区域字段数量时最多读取的段索引条数
+const regionFieldsSampleSize = 10000
 
-// 获取任务状态
-func GetExportTaskStatus(taskID string) *ExportTaskStatus {
-	exportTasksLock.RLock()
-	defer exportTasksLock.RUnlock()
+var errSampleDone = errors.New("sample done")
 
-	if task, exists := exportTasks[taskID]; exists {
-		// 创建一个任务状态的副本用于返回，以确保我们填充的导出字段是最新的
-		taskCopy := *task // Dereference to get a copy
+// sampleRegionFields 在扫描之前从段索引中读取一部分地区信息，推断导出数据的区域字段数量
+func sampleRegionFields(s *xdb.Searcher) (int, error) {
+	var regions []string
+	err := s.IterateIndex(func(seg *xdb.Segment) error {
+		regions = append(regions, seg.Region)
+		if len(regions) >= regionFieldsSampleSize {
+			return errSampleDone
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errSampleDone) {
+		return 0, err
+	}
 
-		// 计算已运行时间 (这部分逻辑保持不变)
-		var duration time.Duration
-		if taskCopy.Status == "completed" || taskCopy.Status == "failed" {
-			if !taskCopy.EndTime.IsZero() && !taskCopy.StartTime.IsZero() {
-				duration = taskCopy.EndTime.Sub(taskCopy.StartTime)
-			} else {
-				duration = time.Since(taskCopy.StartTime)
-			}
+	return inferRegionFields(regions), nil
+}
+
+// inferRegionFields 推断导出数据的区域字段数量：取非默认区域中最常见的字段数量，
+// 只有默认区域时取其中最常见的字段数量，都没有时取DefaultRegion的字段数量
+func inferRegionFields(regions []string) int {
+	var counts, defaultCounts = map[int]int{}, map[int]int{}
+	for _, region := range regions {
+		if region == "" {
+			continue
+		}
+		n := strings.Count(region, "|") + 1
+		if xdb.IsDefaultRegion(region) {
+			defaultCounts[n]++
 		} else {
-			duration = time.Since(taskCopy.StartTime)
+			counts[n]++
 		}
+	}
 
-		durationSeconds := math.Round(duration.Seconds())
-		if durationSeconds < 0 || math.IsNaN(durationSeconds) {
-			durationSeconds = 0
+	if len(counts) == 0 {
+		counts = defaultCounts
+	}
+
+	fields, best := strings.Count(xdb.DefaultRegion, "|")+1, 0
+	for n, c := range counts {
+		if c > best || (c == best && n < fields) {
+			fields, best = n, c
 		}
-		taskCopy.DurationSeconds = durationSeconds
+	}
+	return fields
+}
 
-		taskCopy.RecordCount = task.GetRecordCountInternal()
-		taskCopy.SegmentCount = task.GetSegmentCountInternal()
+// toolVersion 返回构建信息中的模块版本，没有版本号时为 (devel) 加提交号
+func toolVersion() string {
+	version := "(devel)"
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return version
+	}
 
-		return &taskCopy
+	// 伪版本号中已经包含提交号
+	if info.Main.Version != "" && info.Main.Version != version {
+		return info.Main.Version
 	}
-	return nil
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && len(setting.Value) >= 12 {
+			version += " " + setting.Value[:12]
+		}
+	}
+	return version
 }
 
-// 更新任务状态
-func updateExportTaskStatus(taskID string, updater func(*ExportTaskStatus)) {
-	exportTasksLock.Lock()
-	defer exportTasksLock.Unlock()
+// 文件头中段数量一行的前缀，扫描开始时数量未知，先写入占位的空格，写完所有段后在原位置填入。
+// gzip压缩的导出无法回填，这一行改为写在文件末尾
+const (
+	exportHeaderSegmentsPrefix = "# segments: "
+	exportHeaderCountWidth     = 20
+)
 
-	if task, exists := exportTasks[taskID]; exists {
-		updater(task)
+// exportHeader 导出文件开头的来源信息注释
+func exportHeader(xdbPath string) []string {
+	return []string{
+		"# ip2region-web export",
+		"# source: " + xdbPath,
+		"# exported_at: " + time.Now().Format(time.RFC3339),
+		exportHeaderSegmentsPrefix + strings.Repeat(" ", exportHeaderCountWidth),
+		"# tool_version: " + toolVersion(),
+	}
+}
+
+// exportSegmentWriter 扫描的同时把IP段逐个写入导出文件，不在内存中保留全部IP段。
+// 先写入同目录下的临时文件，完成后再重命名为导出文件，失败或取消时不会留下不完整的文件
+type exportSegmentWriter struct {
+	path           string
+	file           *os.File
+	gz             *gzip.Writer // 压缩导出时位于缓冲区和文件之间，否则为nil
+	w              *bufio.Writer
+	expectedFields int
+	written        int
+	defaultFilled  int
+	countOffset    int64 // 文件头中段数量占位的位置，没有文件头时为-1
+	countTrailer   bool  // 段数量写在文件末尾
+
+	uncompressedBytes int64 // 写入缓冲区的字节数
+	fileBytes         int64 // Commit后导出文件的字节数
+}
+
+// newExportSegmentWriter 创建导出文件的临时文件，header非空时先逐行写入header；
+// compress为true时写出的内容经过gzip压缩，mode为导出文件的权限，0表示保留已有文件的权限或使用默认权限
+func newExportSegmentWriter(filePath string, header []string, expectedFields int, bufferSize int, compress bool, mode os.FileMode) (*exportSegmentWriter, error) {
+	file, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("创建导出文件 %s 失败: %w", filePath, err)
+	}
+
+	// 临时文件创建时只有所有者可读写，重命名前改为导出文件的权限
+	if err := file.Chmod(xdb.TargetFileMode(filePath, mode)); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("设置导出文件权限失败: %w", err)
+	}
+
+	w := &exportSegmentWriter{
+		path:           filePath,
+		file:           file,
+		expectedFields: expectedFields,
+		countOffset:    -1,
+	}
+	if compress {
+		w.gz = gzip.NewWriter(file)
+		w.w = bufio.NewWriterSize(w.gz, bufferSize)
 	} else {
-		log.Printf("任务 %s: updateExportTaskStatus - 任务不存在，无法更新", taskID)
+		w.w = bufio.NewWriterSize(file, bufferSize)
+	}
+
+	var offset int64
+	for _, line := range header {
+		if strings.HasPrefix(line, exportHeaderSegmentsPrefix) {
+			if compress {
+				w.countTrailer = true
+				continue
+			}
+			w.countOffset = offset + int64(len(exportHeaderSegmentsPrefix))
+		}
+		n, err := w.w.WriteString(line + "\n")
+		if err != nil {
+			w.Abort()
+			return nil, fmt.Errorf("写入文件头失败: %w", err)
+		}
+		offset += int64(n)
 	}
+	w.uncompressedBytes = offset
+
+	return w, nil
 }
 
-// ExportXdb 导出XDB文件中的数据到文本文件
-func ExportXdb(c *gin.Context) {
-	var req struct {
-		XdbPath    string `json:"xdbPath" binding:"required"`
-		ExportPath string `json:"exportPath" binding:"required"`
+// Write 写入一个IP段，未命中任何段的范围填充与数据字段数量一致的默认值
+func (w *exportSegmentWriter) Write(segment *IPSegment) error {
+	region := segment.Region
+	if region == "" {
+		region = xdb.DefaultRegionWithFields(w.expectedFields)
+		w.defaultFilled++
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
+	// 每行都写入换行符，包括最后一行
+	n, err := fmt.Fprintf(w.w, "%s|%s|%s\n", xdb.Long2IP(segment.StartIP), xdb.Long2IP(segment.EndIP), region)
+	if err != nil {
+		return fmt.Errorf("写入文件失败 (段 %d, IP: %s): %w", w.written, xdb.Long2IP(segment.StartIP), err)
+	}
+
+	w.uncompressedBytes += int64(n)
+	w.written++
+	return nil
+}
+
+// Commit 刷新缓冲区和压缩流，在文件头中填入段数量，然后把临时文件重命名为导出文件
+func (w *exportSegmentWriter) Commit() error {
+	if w.countTrailer {
+		n, err := fmt.Fprintf(w.w, "%s%d\n", exportHeaderSegmentsPrefix, w.written)
+		if err != nil {
+			w.Abort()
+			return fmt.Errorf("写入段数量失败: %w", err)
+		}
+		w.uncompressedBytes += int64(n)
+	}
+
+	if err := w.w.Flush(); err != nil {
+		w.Abort()
+		return fmt.Errorf("刷新缓冲区失败: %w", err)
+	}
+
+	if w.gz != nil {
+		if err := w.gz.Close(); err != nil {
+			w.Abort()
+			return fmt.Errorf("结束压缩失败: %w", err)
+		}
+	}
+
+	if w.countOffset >= 0 {
+		count := fmt.Sprintf("%-*d", exportHeaderCountWidth, w.written)
+		if _, err := w.file.WriteAt([]byte(count), w.countOffset); err != nil {
+			w.Abort()
+			return fmt.Errorf("写入段数量失败: %w", err)
+		}
+	}
+
+	if info, err := w.file.Stat(); err == nil {
+		w.fileBytes = info.Size()
+	}
+
+	// 与生成XDB一样先落盘再重命名，掉电后不会留下被截断的导出文件
+	if err := w.file.Sync(); err != nil {
+		w.Abort()
+		return fmt.Errorf("同步导出文件失败: %w", err)
+	}
+
+	if err := w.file.Close(); err != nil {
+		os.Remove(w.file.Name())
+		return fmt.Errorf("关闭导出文件失败: %w", err)
+	}
+
+	if err := os.Rename(w.file.Name(), w.path); err != nil {
+		os.Remove(w.file.Name())
+		return fmt.Errorf("重命名导出文件失败: %w", err)
+	}
+
+	return nil
+}
+
+// Abort 放弃导出，删除临时文件；缓冲区和压缩流中未写出的内容直接丢弃
+func (w *exportSegmentWriter) Abort() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
+
+// GetExportTaskStatusHandler 获取导出任务状态
+func GetExportTaskStatusHandler(c *gin.Context) {
+	taskID := c.Param("taskId")
+	if taskID == "" {
 		c.JSON(http.StatusBadRequest, Response{
 			Code: 400,
-			Msg:  "请求参数错误: " + err.Error(),
+			Msg:  "任务ID不能为空",
 			Data: nil,
 		})
 		return
 	}
 
-	// 创建导出任务ID
-	taskID := fmt.Sprintf("export_%s", time.Now().Format("20060102150405"))
+	task := GetExportTaskStatus(taskID)
+	if task == nil {
+		c.JSON(http.StatusNotFound, Response{
+			Code: 404,
+			Msg:  "找不到指定的导出任务",
+			Data: nil,
+		})
+		return
+	}
 
-	// 创建任务取消通道
-	exportTasksLock.Lock()
-	cancelChan := make(chan bool, 1)
-	cancelChans[taskID] = cancelChan
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "获取任务状态成功",
+		Data: task,
+	})
+}
 
-	// 初始化任务状态
-	exportTasks[taskID] = &ExportTaskStatus{
-		TaskID:         taskID,
-		XdbPath:        req.XdbPath,
-		ExportPath:     req.ExportPath,
-		Status:         "pending",
-		StartTime:      time.Now(),
-		lastUpdateTime: time.Now().Unix(),
+// CancelExportTask 取消导出任务
+func CancelExportTask(c *gin.Context) {
+	taskID := c.Param("taskId")
+	if taskID == "" {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "任务ID不能为空",
+			Data: nil,
+		})
+		return
 	}
-	exportTasksLock.Unlock()
 
-	// 异步执行导出
-	go executeExportTask(taskID, req.XdbPath, req.ExportPath)
+	task := GetExportTaskStatus(taskID)
+	if task == nil {
+		c.JSON(http.StatusNotFound, Response{
+			Code: 404,
+			Msg:  "找不到指定的导出任务",
+			Data: nil,
+		})
+		return
+	}
+
+	// 只能取消 pending 或 processing 状态的任务
+	if task.Status != "pending" && task.Status != "processing" {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "任务已完成或已失败，无法取消",
+			Data: nil,
+		})
+		return
+	}
+
+	// 关闭通道通知导出协程终止，与取消所有任务同时发生时也只关闭一次
+	signalExportCancel(taskID)
+
+	// 更新任务状态
+	updateExportTaskStatus(taskID, func(task *ExportTaskStatus) {
+		task.Status = "failed"
+		task.ErrorMessage = "用户取消任务"
+		task.EndTime = time.Now()
+	})
 
-	// 返回任务ID
 	c.JSON(http.StatusOK, Response{
 		Code: 0,
-		Msg:  "导出任务已创建",
-		Data: map[string]interface{}{
-			"taskId": taskID,
-		},
+		Msg:  "任务已取消",
+		Data: nil,
 	})
 }
 
-func executeExportTask(taskID string, xdbPath string, exportPath string) {
-	log.Printf("开始执行导出任务: %s, XDB: %s, 导出至: %s", taskID, xdbPath, exportPath)
+// 基准测试请求
+type BenchmarkRequest struct {
+	DbPath     string `json:"dbPath" binding:"required"`
+	Iterations int    `json:"iterations,omitempty"` // 查询次数，默认10000
+	SearchMode string `json:"searchMode,omitempty"` // file, file-vector, vector, hybrid, memory，默认vector
+}
 
-	// 获取取消通道
-	var cancelChan chan bool
-	exportTasksLock.RLock()
-	if ch, exists := cancelChans[taskID]; exists {
-		cancelChan = ch
-	} else {
-		cancelChan = make(chan bool, 1)
-		log.Printf("警告: 任务 %s 的取消通道未找到，已重新创建。", taskID)
+// 基准测试结果，耗时单位均为纳秒
+type BenchmarkResult struct {
+	DbPath     string  `json:"dbPath"`
+	SearchMode string  `json:"searchMode"`
+	Iterations int     `json:"iterations"`
+	MinNanos   int64   `json:"minNanoseconds"`
+	AvgNanos   int64   `json:"avgNanoseconds"`
+	P50Nanos   int64   `json:"p50Nanoseconds"`
+	P95Nanos   int64   `json:"p95Nanoseconds"`
+	P99Nanos   int64   `json:"p99Nanoseconds"`
+	MaxNanos   int64   `json:"maxNanoseconds"`
+	AvgIoCount float64 `json:"avgIoCount"`
+	LoadTaken  string  `json:"loadTimeTaken"`
+	TotalTaken string  `json:"totalTimeTaken"`
+	ErrorCount int     `json:"errorCount"`
+	FirstError string  `json:"firstError,omitempty"`
+}
+
+const (
+	benchmarkDefaultIterations = 10000
+	benchmarkMaxIterations     = 1000000
+)
+
+// Benchmark 使用随机IP测量指定数据库和模式的查询耗时。
+// 使用独立创建的搜索器，不替换全局搜索器，也不计入globalStats
+func Benchmark(c *gin.Context) {
+	var req BenchmarkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "请求参数错误: " + err.Error(),
+		})
+		return
 	}
-	exportTasksLock.RUnlock()
 
-	// 清理函数
-	defer func() {
-		exportTasksLock.Lock()
-		delete(cancelChans, taskID)
-		exportTasksLock.Unlock()
-		log.Printf("导出任务清理完成: %s", taskID)
-	}()
+	if !resolveRequestPaths(c, &req.DbPath) {
+		return
+	}
 
-	// 更新任务状态为处理中
-	updateExportTaskStatus(taskID, func(task *ExportTaskStatus) {
-		task.Status = "processing"
-		task.DetailedStatus = "正在加载XDB文件..."
-		task.StartTime = time.Now()
-		task.Progress = 0
-		task.SetRecordCountInternal(0)
-		task.SetSegmentCountInternal(0)
-		task.UpdateLastUpdateTime()
-	})
+	if req.Iterations <= 0 {
+		req.Iterations = benchmarkDefaultIterations
+	}
+	if req.Iterations > benchmarkMaxIterations {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  fmt.Sprintf("iterations不能超过%d", benchmarkMaxIterations),
+		})
+		return
+	}
+	if req.SearchMode == "" {
+		req.SearchMode = "vector"
+	}
 
-	var searcherInstance *xdb.Searcher
+	tLoad := time.Now()
+	var s *xdb.Searcher
 	var err error
-	var localSearcherCreated bool = false
+	switch req.SearchMode {
+	case "file":
+		s, err = xdb.NewWithFileOnly(req.DbPath)
+	case fileVectorMode:
+		s, err = xdb.NewWithFileAndVector(req.DbPath)
+	case "vector":
+		s, err = xdb.NewSearcherWithVectorIndex(req.DbPath)
+	case "hybrid":
+		s, err = xdb.NewSearcherWithHybridMode(req.DbPath)
+	case "memory":
+		s, err = xdb.NewSearcherWithMemoryMode(req.DbPath)
+	default:
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "不支持的搜索模式: " + req.SearchMode,
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "加载数据库失败: " + err.Error(),
+		})
+		return
+	}
+	defer s.Close()
+	loadTaken := time.Since(tLoad)
 
-	// 尝试使用全局已加载的 vector 或 memory 模式的 searcher
-	searcherLock.RLock()
-	if searcher != nil && searcherPath == xdbPath && (searcherMode == "vector" || searcherMode == "memory") {
-		searcherInstance = searcher
-		log.Printf("任务 %s: 使用已加载的 %s 模式搜索器: %s", taskID, searcherMode, searcherPath)
+	result := BenchmarkResult{
+		DbPath:     req.DbPath,
+		SearchMode: req.SearchMode,
+		Iterations: req.Iterations,
+		LoadTaken:  loadTaken.String(),
 	}
-	searcherLock.RUnlock()
 
-	if searcherInstance == nil {
-		// 如果没有匹配的全局搜索器，或者全局搜索器是文件模式（不应在此处使用），则为本次任务创建临时的文件模式搜索器
-		log.Printf("任务 %s: 未匹配到已加载的向量/内存模式搜索器，将创建临时文件模式搜索器用于导出: %s", taskID, xdbPath)
-		searcherInstance, err = xdb.NewWithFileOnly(xdbPath) // 直接使用 NewWithFileOnly
-		if err != nil {
-			errMsg := fmt.Sprintf("创建临时文件模式搜索器失败: %v", err)
-			log.Printf("任务 %s: %s", taskID, errMsg)
-			updateExportTaskStatus(taskID, func(task *ExportTaskStatus) {
-				task.Status = "failed"
-				task.ErrorMessage = errMsg
-				task.EndTime = time.Now()
-			})
+	tStart := time.Now()
+	var totalIo, totalNanos int64
+	durations := make([]int64, 0, req.Iterations)
+	for i := 0; i < req.Iterations; i++ {
+		if i%1000 == 0 && c.Request.Context().Err() != nil {
 			return
 		}
-		localSearcherCreated = true // 标记此搜索器是本地创建的，需要关闭
-		log.Printf("任务 %s: 临时文件模式XDB文件加载成功: %s", taskID, xdbPath)
+
+		ip := rand.Uint32()
+		t := time.Now()
+		_, ioCount, err := s.Search(ip)
+		elapsed := time.Since(t).Nanoseconds()
+		if err != nil {
+			if result.ErrorCount == 0 {
+				result.FirstError = err.Error()
+			}
+			result.ErrorCount++
+			continue
+		}
+
+		durations = append(durations, elapsed)
+		totalNanos += elapsed
+		totalIo += int64(ioCount)
 	}
+	result.TotalTaken = time.Since(tStart).String()
 
-	// 如果是本地创建的临时搜索器，确保在使用完毕后关闭
-	if localSearcherCreated && searcherInstance != nil {
-		defer searcherInstance.Close()
+	if n := len(durations); n > 0 {
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+		percentile := func(p float64) int64 {
+			return durations[int(math.Ceil(p*float64(n)))-1]
+		}
+
+		result.MinNanos = durations[0]
+		result.MaxNanos = durations[n-1]
+		result.AvgNanos = totalNanos / int64(n)
+		result.P50Nanos = percentile(0.50)
+		result.P95Nanos = percentile(0.95)
+		result.P99Nanos = percentile(0.99)
+		result.AvgIoCount = float64(totalIo) / float64(n)
 	}
 
-	updateExportTaskStatus(taskID, func(task *ExportTaskStatus) {
-		task.DetailedStatus = "正在导出IP段..."
-		task.UpdateLastUpdateTime()
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "基准测试完成",
+		Data: result,
 	})
+}
 
-	// 用于跟踪已处理的段数量
-	var processedSegments int64 = 0
+// 同步转换允许的最大XDB文件大小，更大的文件请使用异步导出
+const convertMaxFileSize = 32 * 1024 * 1024
 
-	allSegments, err := dumpAllIPsFromXDB(searcherInstance, taskID, cancelChan, func(processedIP uint32, totalIPs uint32, segmentCount int) {
-		var progress float64
-		if totalIPs > 0 {
-			progress = float64(processedIP) / float64(totalIPs) * 100
-		}
+// ConvertXdb 将较小的XDB文件直接转换为 起始IP|结束IP|地区 格式的源文本，分块写入HTTP响应，不生成中间文件
+func ConvertXdb(c *gin.Context) {
+	var req ConvertXdbRequest
 
-		// 更新已处理的段数量
-		processedSegments = int64(segmentCount)
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "请求参数错误: " + err.Error(),
+		})
+		return
+	}
 
-		// 准备详细状态字符串，不包括百分比
-		detailedStatus := fmt.Sprintf("正在扫描 IP: %s - 已发现 %d 个IP段",
-			xdb.Long2IP(processedIP), segmentCount)
+	if !resolveRequestPaths(c, &req.XdbPath) {
+		return
+	}
 
-		updateExportTaskStatus(taskID, func(task *ExportTaskStatus) {
-			// RecordCount 表示当前处理到的IP地址
-			// SegmentCount 表示已发现的IP段数量
-			task.SetRecordCountInternal(int64(processedIP)) // 当前处理的IP地址
-			task.SetSegmentCountInternal(processedSegments) // 已发现的段数量
-			task.Progress = progress
-			task.CurrentAClass = 0
-			task.ProcessedAClasses = 0
-			task.TotalAClasses = 0
-			task.DetailedStatus = detailedStatus
-			task.UpdateLastUpdateTime()
+	fileInfo, err := os.Stat(req.XdbPath)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "XDB文件不存在: " + req.XdbPath,
 		})
-		log.Printf("任务 %s: 扫描进度 - %s", taskID, detailedStatus)
-	})
+		return
+	}
+
+	if fileInfo.Size() > convertMaxFileSize {
+		c.JSON(http.StatusRequestEntityTooLarge, Response{
+			Code: 413,
+			Msg:  fmt.Sprintf("XDB文件大小 %d 字节超过同步转换上限 %d 字节，请使用 /api/export-xdb 异步导出", fileInfo.Size(), convertMaxFileSize),
+			Data: gin.H{
+				"fileSize":  fileInfo.Size(),
+				"maxSize":   convertMaxFileSize,
+				"exportApi": "/api/export-xdb",
+			},
+		})
+		return
+	}
 
+	s, err := xdb.NewWithFileOnly(req.XdbPath)
 	if err != nil {
-		errMsg := fmt.Sprintf("导出IP段失败: %v", err)
-		log.Printf("任务 %s: %s", taskID, errMsg)
-		// 检查错误是否由于取消操作导致
-		if errors.Is(err, context.Canceled) || errors.Is(err, errTaskCancelled) || strings.Contains(err.Error(), "任务已取消") {
-			updateExportTaskStatus(taskID, func(task *ExportTaskStatus) {
-				task.Status = "failed"
-				task.ErrorMessage = "导出任务已取消"
-				task.EndTime = time.Now()
-			})
-		} else {
-			updateExportTaskStatus(taskID, func(task *ExportTaskStatus) {
-				task.Status = "failed"
-				task.ErrorMessage = errMsg
-				task.EndTime = time.Now()
-			})
-		}
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "加载XDB文件失败: " + err.Error(),
+		})
 		return
 	}
+	defer s.Close()
 
-	select {
-	case <-cancelChan:
-		log.Printf("任务 %s 在数据收集后、写入文件前被取消", taskID)
-		updateExportTaskStatus(taskID, func(task *ExportTaskStatus) {
-			task.Status = "failed"
-			task.ErrorMessage = "导出任务已取消"
-			task.EndTime = time.Now()
+	// 开始输出前先校验索引块，避免响应头发出后才发现文件无效
+	if _, _, err := s.IndexBlockRange(); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "读取XDB索引信息失败: " + err.Error(),
 		})
 		return
-	default:
 	}
 
-	updateExportTaskStatus(taskID, func(task *ExportTaskStatus) {
-		task.DetailedStatus = fmt.Sprintf("准备写入 %d 个IP段到文件...", len(allSegments))
-		task.SetSegmentCountInternal(int64(len(allSegments)))
-		task.Progress = 99 // 扫描完成，准备写入
-		task.UpdateLastUpdateTime()
-	})
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", strings.TrimSuffix(filepath.Base(req.XdbPath), filepath.Ext(req.XdbPath))+".txt"))
+	c.Status(http.StatusOK)
 
-	expectedFields := 5 // 默认值
-	if len(allSegments) > 0 {
-		firstRegionStr := allSegments[0].Region
-		if firstRegionStr != "" {
-			parts := strings.Split(firstRegionStr, "|")
-			allZeros := true
-			nonZeroPartsCount := 0
-			for _, part := range parts {
-				trimmedPart := strings.TrimSpace(part)
-				if trimmedPart != "0" && trimmedPart != "" {
-					allZeros = false
-				}
-				if trimmedPart != "" {
-					nonZeroPartsCount++
-				}
-			}
-			if !allZeros && nonZeroPartsCount > 0 {
-				expectedFields = nonZeroPartsCount
-			} else if allZeros && len(parts) > 0 {
-				expectedFields = len(parts)
-			}
+	bufWriter := bufio.NewWriterSize(c.Writer, 64*1024)
+	segCount := 0
+	err = s.IterateIndex(func(seg *xdb.Segment) error {
+		if err := c.Request.Context().Err(); err != nil {
+			return err
 		}
-		// 确保字段数在合理范围内
-		if expectedFields < 1 {
-			expectedFields = 1
-		} else if expectedFields > 15 {
-			expectedFields = 15
+
+		if _, err := bufWriter.WriteString(seg.String() + "\n"); err != nil {
+			return err
 		}
-		log.Printf("任务 %s: 根据首个有效段推断的区域字段数量: %d (首段Region: '%s')", taskID, expectedFields, firstRegionStr)
-	} else {
-		log.Printf("任务 %s: 未发现任何IP段，使用默认区域字段数量: %d", taskID, expectedFields)
-	}
 
-	err = writeResultsToFile(allSegments, exportPath, expectedFields, taskID, cancelChan, func(writtenCount, totalCount int) {
-		if writtenCount == 1 {
-			// 开始写入
-			updateExportTaskStatus(taskID, func(task *ExportTaskStatus) {
-				task.Progress = 99
-				task.DetailedStatus = fmt.Sprintf("正在写入 %d 个IP段到文件...", totalCount)
-				task.UpdateLastUpdateTime()
-			})
-			log.Printf("任务 %s: 开始写入 %d 个IP段到文件", taskID, totalCount)
+		segCount++
+		if segCount%10000 == 0 {
+			if err := bufWriter.Flush(); err != nil {
+				return err
+			}
+			c.Writer.Flush()
 		}
+		return nil
 	})
 
+	if errFlush := bufWriter.Flush(); errFlush == nil {
+		c.Writer.Flush()
+	}
+
 	if err != nil {
-		errMsg := fmt.Sprintf("写入导出文件失败: %v", err)
-		log.Printf("任务 %s: %s", taskID, errMsg)
-		if errors.Is(err, context.Canceled) || errors.Is(err, errTaskCancelled) || strings.Contains(err.Error(), "任务已取消") {
-			updateExportTaskStatus(taskID, func(task *ExportTaskStatus) {
-				task.Status = "failed"
-				task.ErrorMessage = "导出任务已取消 (写入阶段)"
-				task.EndTime = time.Now()
-			})
-		} else {
-			updateExportTaskStatus(taskID, func(task *ExportTaskStatus) {
-				task.Status = "failed"
-				task.ErrorMessage = errMsg
-				task.EndTime = time.Now()
-			})
-		}
+		// 响应头已经发出，只能记录日志
+		log.Printf("转换XDB文件 %s 失败 (已输出 %d 个IP段): %v", req.XdbPath, segCount, err)
 		return
 	}
 
-	log.Printf("任务 %s: 导出成功完成", taskID)
-	updateExportTaskStatus(taskID, func(task *ExportTaskStatus) {
-		task.Status = "completed"
-		task.Progress = 100
-		task.EndTime = time.Now()
-		task.DetailedStatus = "导出完成"
-		task.UpdateLastUpdateTime()
-	})
+	log.Printf("转换XDB文件 %s 完成，共输出 %d 个IP段", req.XdbPath, segCount)
 }
 
-var errTaskCancelled = errors.New("任务已取消")
-
-// dumpAllIPsFromXDB 从 xdb.Searcher 实例中逐个IP地址导出数据。
-func dumpAllIPsFromXDB(s *xdb.Searcher, taskID string, cancelChan chan bool, progressCallback func(processedIP, totalIPs uint32, segmentCount int)) ([]*IPSegment, error) {
-	log.Printf("任务 %s: 开始从XDB逐IP转储所有数据", taskID)
-	segments := make([]*IPSegment, 0, 14000000) // 预分配1400万容量
+// 源文件校验请求，srcFile为空时只返回XDB中记录的校验值
+type VerifySourceRequest struct {
+	XdbPath string `json:"xdbPath" binding:"required"`
+	SrcFile string `json:"srcFile,omitempty"`
+}
 
-	var currentIP uint32 = 0x01000000 // 1.0.0.0
-	const lastIP uint32 = 0xFFFFFFFF
-	const stepSize uint32 = 256 // 每256个IP为一个步长，可以调整这个值
+// 源文件校验结果
+type VerifySourceResult struct {
+	XdbPath        string `json:"xdbPath"`
+	SrcFile        string `json:"srcFile,omitempty"`
+	SourceChecksum string `json:"sourceChecksum"`           // XDB头部记录的源文件SHA-256，旧版本生成的为空
+	ActualChecksum string `json:"actualChecksum,omitempty"` // 指定源文件当前的SHA-256
+	Match          bool   `json:"match"`
+}
 
-	if currentIP > lastIP {
-		log.Printf("任务 %s: 起始扫描IP (1.0.0.0) 大于 IPv4 最大IP，不执行扫描。", taskID)
-		return segments, nil
-	}
+// 增量导出请求：对比两个XDB，只导出新数据库中与基准数据库不同的段
+type ExportDeltaRequest struct {
+	XdbPath     string `json:"xdbPath" binding:"required"`     // 新数据库
+	BaseXdbPath string `json:"baseXdbPath" binding:"required"` // 上一次分发的数据库快照
+	ExportPath  string `json:"exportPath" binding:"required"`  // 补丁文件，可直接用于 /api/edit/file
+}
 
-	log.Printf("任务 %s: 逐IP扫描将从 IP %s 开始，步长为 %d", taskID, xdb.Long2IP(currentIP), stepSize)
+// 增量导出结果
+type ExportDeltaResult struct {
+	XdbPath      string `json:"xdbPath"`
+	BaseXdbPath  string `json:"baseXdbPath"`
+	ExportPath   string `json:"exportPath"`
+	SegmentCount int    `json:"segmentCount"` // 补丁中的段数量
+	IPCount      uint64 `json:"ipCount"`      // 补丁覆盖的IP数量
+	TimeTaken    string `json:"timeTaken"`
+}
 
-	ctx, cancelCtx := context.WithCancel(context.Background())
-	defer cancelCtx()
+// 二进制补丁导出请求：生成由基准XDB变为新XDB的二进制补丁
+type ExportPatchRequest struct {
+	XdbPath     string `json:"xdbPath" binding:"required"`     // 新数据库
+	BaseXdbPath string `json:"baseXdbPath" binding:"required"` // 设备上已有的数据库
+	ExportPath  string `json:"exportPath" binding:"required"`  // 二进制补丁文件
+}
 
-	go func() {
-		select {
-		case <-cancelChan:
-			cancelCtx()
-		case <-ctx.Done():
-		}
-	}()
+// 二进制补丁导出结果
+type ExportPatchResult struct {
+	XdbPath     string          `json:"xdbPath"`
+	BaseXdbPath string          `json:"baseXdbPath"`
+	ExportPath  string          `json:"exportPath"`
+	Stats       *xdb.PatchStats `json:"stats"`
+	TimeTaken   string          `json:"timeTaken"`
+}
 
-	var segmentCount int = 0
-	var lastRegion string = ""
-	var segmentStartIP uint32 = currentIP
+// 二进制补丁应用请求
+type ApplyPatchRequest struct {
+	BaseXdbPath string `json:"baseXdbPath" binding:"required"` // 生成补丁时的基准数据库
+	PatchPath   string `json:"patchPath" binding:"required"`
+	DstFile     string `json:"dstFile" binding:"required"` // 输出的新数据库，可以与baseXdbPath相同
+}
 
-	for currentIP <= lastIP {
-		if ctx.Err() != nil {
-			log.Printf("任务 %s: XDB转储导出被取消 (当前IP: %s)", taskID, xdb.Long2IP(currentIP))
-			return nil, errTaskCancelled
-		}
+// 二进制补丁应用结果
+type ApplyPatchResult struct {
+	BaseXdbPath string `json:"baseXdbPath"`
+	PatchPath   string `json:"patchPath"`
+	DstFile     string `json:"dstFile"`
+	TimeTaken   string `json:"timeTaken"`
+}
 
-		// 查询当前IP的区域信息
-		currentRegion, _, err := s.Search(currentIP)
-		if err != nil {
-			log.Printf("警告: 任务 %s: 查询 IP %s 失败: %v", taskID, xdb.Long2IP(currentIP), err)
-			// 检查是否会发生溢出
-			if currentIP > lastIP-stepSize {
-				// 如果加上stepSize会溢出，直接跳出循环
-				log.Printf("任务 %s: IP %s 接近最大值，停止扫描以避免溢出", taskID, xdb.Long2IP(currentIP))
-				break
-			}
-			currentIP += stepSize
-			continue
-		}
+// loadIndexSegments 按索引顺序读取XDB中的所有段
+func loadIndexSegments(dbPath string) ([]*xdb.Segment, error) {
+	s, err := xdb.NewWithFileOnly(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
 
-		// 如果区域为空，使用默认值
-		if currentRegion == "" {
-			currentRegion = "0|0|0|0|0|0|0|0"
-		}
+	var segments []*xdb.Segment
+	err = s.IterateIndex(func(seg *xdb.Segment) error {
+		segments = append(segments, seg)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		// 如果区域发生变化，保存上一个段
-		if lastRegion != "" && currentRegion != lastRegion {
-			segments = append(segments, &IPSegment{
-				StartIP: segmentStartIP,
-				EndIP:   currentIP - 1,
-				Region:  lastRegion,
-			})
-			segmentCount++
-			segmentStartIP = currentIP
-		}
+	return segments, nil
+}
 
-		lastRegion = currentRegion
+// ExportDelta 导出两个XDB之间发生变化的段，用于小更新的增量分发。
+// 目前没有编辑日志，只能与上一次的数据库快照对比
+func ExportDelta(c *gin.Context) {
+	var req ExportDeltaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "请求参数错误: " + err.Error(),
+		})
+		return
+	}
 
-		// 每处理一定数量的IP后更新进度
-		if currentIP%256 == 0 || currentIP == lastIP {
-			progressCallback(currentIP, lastIP, segmentCount)
-		}
+	if !resolveRequestPaths(c, &req.XdbPath, &req.BaseXdbPath, &req.ExportPath) {
+		return
+	}
 
-		// 检查是否会发生溢出
-		if currentIP > lastIP-stepSize {
-			// 如果加上stepSize会溢出，直接跳出循环
-			log.Printf("任务 %s: IP %s 接近最大值，完成扫描", taskID, xdb.Long2IP(currentIP))
-			break
-		}
-		currentIP += stepSize
+	if !checkOutputPath(c, req.ExportPath, true, req.XdbPath, req.BaseXdbPath) {
+		return
 	}
 
-	// 添加最后一个段
-	if lastRegion != "" {
-		segments = append(segments, &IPSegment{
-			StartIP: segmentStartIP,
-			EndIP:   lastIP,
-			Region:  lastRegion,
+	tStart := time.Now()
+	cur, err := loadIndexSegments(req.XdbPath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "读取XDB文件失败: " + err.Error(),
 		})
-		segmentCount++
+		return
 	}
 
-	progressCallback(lastIP, lastIP, segmentCount)
-	log.Printf("任务 %s: XDB转储完成，共发现 %d 个段 (从 %s 开始扫描)", taskID, segmentCount, xdb.Long2IP(0x01000000))
-	return segments, nil
-}
+	base, err := loadIndexSegments(req.BaseXdbPath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "读取基准XDB文件失败: " + err.Error(),
+		})
+		return
+	}
 
-// writeResultsToFile 将IP段写入文件。
-// 添加了 taskID 和 cancelChan 用于检查取消信号，以及一个简单的进度回调。
-func writeResultsToFile(results []*IPSegment, filePath string, expectedFields int, taskID string, cancelChan chan bool, progressCallback func(writtenCount, totalCount int)) error {
-	log.Printf("任务 %s: 开始将 %d 个IP段写入文件 %s", taskID, len(results), filePath)
+	delta := xdb.DiffSegments(base, cur)
 
-	outFile, err := os.Create(filePath)
+	outFile, err := os.OpenFile(req.ExportPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, xdb.DefaultFileMode())
 	if err != nil {
-		return fmt.Errorf("创建导出文件 %s 失败: %w", filePath, err)
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "创建补丁文件失败: " + err.Error(),
+		})
+		return
 	}
 	defer outFile.Close()
 
-	bufWriter := bufio.NewWriterSize(outFile, 4*1024*1024) // 4MB缓冲区
-	var finalErr error                                     // 用于捕获 flush时的错误
+	result := ExportDeltaResult{
+		XdbPath:      req.XdbPath,
+		BaseXdbPath:  req.BaseXdbPath,
+		ExportPath:   req.ExportPath,
+		SegmentCount: len(delta),
+	}
 
-	defer func() {
-		if errFlush := bufWriter.Flush(); errFlush != nil {
-			log.Printf("任务 %s: 刷新缓冲区到文件 %s 失败: %v", taskID, filePath, errFlush)
-			if finalErr == nil { // 只在没有其他错误时设置
-				finalErr = fmt.Errorf("刷新缓冲区失败: %w", errFlush)
-			}
+	bufWriter := bufio.NewWriter(outFile)
+	for _, seg := range delta {
+		result.IPCount += uint64(seg.EndIP) - uint64(seg.StartIP) + 1
+		if _, err = bufWriter.WriteString(seg.String() + "\n"); err != nil {
+			break
 		}
-	}()
-
-	if len(results) == 0 {
-		log.Printf("任务 %s: 没有结果可写入文件 %s", taskID, filePath)
-		return nil // finalErr 仍然可能由 Flush 产生
 	}
+	if err == nil {
+		err = bufWriter.Flush()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "写入补丁文件失败: " + err.Error(),
+		})
+		return
+	}
+	result.TimeTaken = time.Since(tStart).String()
 
-	totalSegments := len(results)
-	for i, segment := range results {
-		select {
-		case <-cancelChan:
-			log.Printf("任务 %s: 写入文件时检测到取消信号 (段 %d/%d)", taskID, i+1, totalSegments)
-			finalErr = errTaskCancelled // 使用预定义的取消错误
-			return finalErr
-		default:
-		}
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  fmt.Sprintf("增量导出完成，共 %d 个段", result.SegmentCount),
+		Data: result,
+	})
+}
 
-		region := segment.Region
-		if region == "" {
-			log.Printf("任务 %s: 警告 - 段 (%s - %s) Region为空，将使用 %d 字段的默认全零值", taskID, xdb.Long2IP(segment.StartIP), xdb.Long2IP(segment.EndIP), expectedFields)
-			if expectedFields <= 1 {
-				region = "0"
-			} else {
-				region = strings.Repeat("0|", expectedFields-1) + "0"
-			}
-		}
+// ExportPatch 生成二进制补丁，设备上已有基准数据库时只需下发补丁，
+// 通过 /api/apply-patch 或 xdb.ApplyPatch 可以逐字节还原出新数据库
+func ExportPatch(c *gin.Context) {
+	var req ExportPatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "请求参数错误: " + err.Error(),
+		})
+		return
+	}
 
-		line := fmt.Sprintf("%s|%s|%s",
-			xdb.Long2IP(segment.StartIP),
-			xdb.Long2IP(segment.EndIP),
-			region)
+	if !resolveRequestPaths(c, &req.XdbPath, &req.BaseXdbPath, &req.ExportPath) {
+		return
+	}
 
-		if _, errw := bufWriter.WriteString(line); errw != nil {
-			finalErr = fmt.Errorf("写入文件失败 (段 %d, IP: %s): %w", i, xdb.Long2IP(segment.StartIP), errw)
-			return finalErr
-		}
-		// 每行都写入换行符，包括最后一行
-		if _, errw := bufWriter.WriteString("\n"); errw != nil {
-			finalErr = fmt.Errorf("写入换行符失败 (段 %d): %w", i, errw)
-			return finalErr
-		}
+	if !checkOutputPath(c, req.ExportPath, true, req.XdbPath, req.BaseXdbPath) {
+		return
+	}
 
-		if (i+1)%1000 == 0 || i == totalSegments-1 { // 每1000条或最后一条时回调进度
-			progressCallback(i+1, totalSegments)
-		}
+	tStart := time.Now()
+	stats, err := xdb.MakePatchFile(req.BaseXdbPath, req.XdbPath, req.ExportPath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "生成补丁失败: " + err.Error(),
+		})
+		return
 	}
 
-	log.Printf("任务 %s: 所有 %d 段已写入缓冲区，准备刷新到文件 %s", taskID, totalSegments, filePath)
-	return finalErr // 可能被 defer中的Flush错误覆盖
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  fmt.Sprintf("补丁生成完成，%d 字节", stats.PatchSize),
+		Data: ExportPatchResult{
+			XdbPath:     req.XdbPath,
+			BaseXdbPath: req.BaseXdbPath,
+			ExportPath:  req.ExportPath,
+			Stats:       stats,
+			TimeTaken:   time.Since(tStart).String(),
+		},
+	})
 }
 
-// GetExportTaskStatusHandler 获取导出任务状态
-func GetExportTaskStatusHandler(c *gin.Context) {
-	taskID := c.Param("taskId")
-	if taskID == "" {
+// ApplyPatch 将二进制补丁应用到基准数据库，结果与生成补丁时的新数据库逐字节相同
+func ApplyPatch(c *gin.Context) {
+	var req ApplyPatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, Response{
 			Code: 400,
-			Msg:  "任务ID不能为空",
-			Data: nil,
+			Msg:  "请求参数错误: " + err.Error(),
 		})
 		return
 	}
 
-	task := GetExportTaskStatus(taskID)
-	if task == nil {
-		c.JSON(http.StatusNotFound, Response{
-			Code: 404,
-			Msg:  "找不到指定的导出任务",
-			Data: nil,
+	if !resolveRequestPaths(c, &req.BaseXdbPath, &req.PatchPath, &req.DstFile) {
+		return
+	}
+
+	// 先写临时文件再重命名，可以直接替换基准数据库或已加载的数据库
+	if !checkOutputPath(c, req.DstFile, false, req.PatchPath) {
+		return
+	}
+
+	tStart := time.Now()
+	if err := xdb.ApplyPatchFile(req.BaseXdbPath, req.PatchPath, req.DstFile); err != nil {
+		status, code := http.StatusInternalServerError, 500
+		if errors.Is(err, xdb.ErrPatchBaseMismatch) {
+			status, code = http.StatusConflict, 409
+		}
+		c.JSON(status, Response{
+			Code: code,
+			Msg:  "应用补丁失败: " + err.Error(),
 		})
 		return
 	}
+	invalidateSearchCache()
 
 	c.JSON(http.StatusOK, Response{
 		Code: 0,
-		Msg:  "获取任务状态成功",
-		Data: task,
+		Msg:  "补丁应用完成",
+		Data: ApplyPatchResult{
+			BaseXdbPath: req.BaseXdbPath,
+			PatchPath:   req.PatchPath,
+			DstFile:     req.DstFile,
+			TimeTaken:   time.Since(tStart).String(),
+		},
 	})
 }
 
-// CancelExportTask 取消导出任务
-func CancelExportTask(c *gin.Context) {
-	taskID := c.Param("taskId")
-	if taskID == "" {
+// VerifySource 校验XDB文件是否由指定的源文件生成
+func VerifySource(c *gin.Context) {
+	var req VerifySourceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, Response{
 			Code: 400,
-			Msg:  "任务ID不能为空",
-			Data: nil,
+			Msg:  "请求参数错误: " + err.Error(),
 		})
 		return
 	}
 
-	task := GetExportTaskStatus(taskID)
-	if task == nil {
-		c.JSON(http.StatusNotFound, Response{
-			Code: 404,
-			Msg:  "找不到指定的导出任务",
-			Data: nil,
+	if !resolveRequestPaths(c, &req.XdbPath, &req.SrcFile) {
+		return
+	}
+
+	s, err := xdb.NewWithFileOnly(req.XdbPath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "打开XDB文件失败: " + err.Error(),
 		})
 		return
 	}
+	defer s.Close()
 
-	// 只能取消 pending 或 processing 状态的任务
-	if task.Status != "pending" && task.Status != "processing" {
-		c.JSON(http.StatusBadRequest, Response{
-			Code: 400,
-			Msg:  "任务已完成或已失败，无法取消",
-			Data: nil,
+	embedded, err := s.SourceChecksum()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "读取源文件校验值失败: " + err.Error(),
 		})
 		return
 	}
 
-	// 发送取消信号
-	exportTasksLock.RLock()
-	cancelChan, exists := cancelChans[taskID]
-	exportTasksLock.RUnlock()
+	result := VerifySourceResult{
+		XdbPath:        req.XdbPath,
+		SrcFile:        req.SrcFile,
+		SourceChecksum: embedded,
+	}
 
-	if exists {
-		// 关闭通道通知导出协程终止
-		close(cancelChan)
+	if req.SrcFile != "" {
+		f, err := os.Open(req.SrcFile)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, Response{
+				Code: 400,
+				Msg:  "打开源文件失败: " + err.Error(),
+			})
+			return
+		}
+		defer f.Close()
+
+		hash := sha256.New()
+		if _, err = io.Copy(hash, f); err != nil {
+			c.JSON(http.StatusInternalServerError, Response{
+				Code: 500,
+				Msg:  "计算源文件校验值失败: " + err.Error(),
+			})
+			return
+		}
+
+		result.ActualChecksum = hex.EncodeToString(hash.Sum(nil))
+		result.Match = embedded != "" && embedded == result.ActualChecksum
 	}
 
-	// 更新任务状态
-	updateExportTaskStatus(taskID, func(task *ExportTaskStatus) {
-		task.Status = "failed"
-		task.ErrorMessage = "用户取消任务"
-		task.EndTime = time.Now()
-	})
+	msg := "获取源文件校验值成功"
+	switch {
+	case embedded == "":
+		msg = "该XDB文件没有记录源文件校验值"
+	case req.SrcFile != "" && result.Match:
+		msg = "校验通过，XDB文件由该源文件生成"
+	case req.SrcFile != "":
+		msg = "校验失败，XDB文件不是由该源文件生成"
+	}
 
 	c.JSON(http.StatusOK, Response{
 		Code: 0,
-		Msg:  "任务已取消",
-		Data: nil,
+		Msg:  msg,
+		Data: result,
 	})
 }
 
 // GenerateTaskStatus任务状态结构体
 type GenerateTaskStatus struct {
-	TaskID          string    `json:"taskId"`
-	SrcFile         string    `json:"srcFile"`
-	DstFile         string    `json:"dstFile"`
-	Status          string    `json:"status"`             // "pending", "processing", "completed", "failed"
-	Progress        float64   `json:"progress,omitempty"` // 不再使用，保留字段以兼容旧版本
-	SegmentCount    int64     `json:"segmentCount"`
-	ErrorMessage    string    `json:"errorMessage"`
-	StartTime       time.Time `json:"startTime"`
-	EndTime         time.Time `json:"endTime"`
-	DurationSeconds float64   `json:"durationSeconds,omitempty"` // 秒数
-	LastUpdateTime  time.Time `json:"lastUpdateTime,omitempty"`  // 最后更新时间
+	TaskID            string    `json:"taskId"`
+	SrcFile           string    `json:"srcFile"`
+	DstFile           string    `json:"dstFile"`
+	IndexPolicy       string    `json:"indexPolicy"`
+	Status            string    `json:"status"`                  // "pending", "queued", "processing", "completed", "failed"
+	QueuePosition     int       `json:"queuePosition,omitempty"` // 排队中时的位置，从1开始
+	Progress          float64   `json:"progress"`                // 索引构建进度百分比 0-100
+	SegmentCount      int64     `json:"segmentCount"`
+	ProcessedSegments int64     `json:"processedSegments"` // 已建立索引的段数量
+	EtaSeconds        float64   `json:"etaSeconds"`        // 预计剩余秒数，-1表示暂时无法估算
+	ErrorMessage      string    `json:"errorMessage"`
+	StartTime         time.Time `json:"startTime"`
+	EndTime           time.Time `json:"endTime"`
+	DurationSeconds   float64   `json:"durationSeconds,omitempty"` // 秒数
+	LastUpdateTime    time.Time `json:"lastUpdateTime,omitempty"`  // 最后更新时间
+
+	// 严格模式下源数据不连续时的问题列表
+	Problems []xdb.ContinuityProblem `json:"problems,omitempty"`
+
+	// 地区信息不符合结构要求的行
+	Violations []xdb.RegionViolation `json:"violations,omitempty"`
+}
+
+// 按已完成比例估算剩余秒数：elapsed × (total−done)/done。
+// 刚开始时样本太少，估算值会剧烈波动，此时返回-1表示暂时无法估算
+func estimateEtaSeconds(elapsed time.Duration, done, total int64) float64 {
+	if total <= 0 || done <= 0 {
+		return -1
+	}
+
+	if done >= total {
+		return 0
+	}
+
+	if float64(done)/float64(total) < 0.01 || elapsed < time.Second {
+		return -1
+	}
+
+	return math.Round(elapsed.Seconds() * float64(total-done) / float64(done))
 }
 
 // 生成任务管理器
@@ -1536,23 +5135,34 @@ var (
 	generateCancelChans = make(map[string]chan bool)
 )
 
+// 任务ID的序号，同一秒内创建的任务ID也不会重复
+var taskSeq atomic.Uint64
+
+// newTaskID 返回 前缀_时间_序号 格式的任务ID
+func newTaskID(prefix string) string {
+	return fmt.Sprintf("%s_%s_%d", prefix, time.Now().Format("20060102150405"), taskSeq.Add(1))
+}
+
 // 获取生成任务状态
 func GetGenerateTaskStatus(taskID string) *GenerateTaskStatus {
 	generateTasksLock.RLock()
 	defer generateTasksLock.RUnlock()
 
 	if task, exists := generateTasks[taskID]; exists {
+		// 只持有读锁，在副本上填充运行时间，不能修改共享的任务状态
+		taskCopy := *task
+
 		// 计算已运行时间
 		var duration time.Duration
-		if task.Status == "completed" || task.Status == "failed" {
-			if !task.EndTime.IsZero() && !task.StartTime.IsZero() {
-				duration = task.EndTime.Sub(task.StartTime)
+		if taskCopy.Status == "completed" || taskCopy.Status == "failed" {
+			if !taskCopy.EndTime.IsZero() && !taskCopy.StartTime.IsZero() {
+				duration = taskCopy.EndTime.Sub(taskCopy.StartTime)
 			} else {
 				// 如果开始或结束时间未设置，使用当前时间
-				duration = time.Since(task.StartTime)
+				duration = time.Since(taskCopy.StartTime)
 			}
 		} else {
-			duration = time.Since(task.StartTime)
+			duration = time.Since(taskCopy.StartTime)
 		}
 
 		// 更新运行时间信息 - 秒数
@@ -1562,9 +5172,9 @@ func GetGenerateTaskStatus(taskID string) *GenerateTaskStatus {
 		}
 		// 四舍五入到整数
 		durationSeconds = math.Round(durationSeconds)
-		task.DurationSeconds = durationSeconds
+		taskCopy.DurationSeconds = durationSeconds
 
-		return task
+		return &taskCopy
 	}
 	return nil
 }
@@ -1591,8 +5201,44 @@ func GenerateDbWithProgress(c *gin.Context) {
 		return
 	}
 
+	if !resolveSourcePath(c, &req.SrcFile) || !resolveRequestPaths(c, &req.DstFile) {
+		return
+	}
+
+	if !checkOutputPath(c, req.DstFile, false, req.SrcFile) {
+		return
+	}
+
+	if !checkEncoding(c, &req.Encoding) {
+		return
+	}
+
+	if err := checkCallbackURL(req.CallbackURL); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  err.Error(),
+			Data: nil,
+		})
+		return
+	}
+
+	fileMode, ok := checkFileMode(c, req.FileMode)
+	if !ok || !checkRegionSchema(c, req.RegionSchema) {
+		return
+	}
+
+	policy, err := parseIndexPolicy(req.IndexPolicy)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  err.Error(),
+			Data: nil,
+		})
+		return
+	}
+
 	// 创建生成任务ID
-	taskID := fmt.Sprintf("generate_%s", time.Now().Format("20060102150405"))
+	taskID := newTaskID("generate")
 
 	// 创建任务取消通道
 	generateTasksLock.Lock()
@@ -1604,14 +5250,16 @@ func GenerateDbWithProgress(c *gin.Context) {
 		TaskID:         taskID,
 		SrcFile:        req.SrcFile,
 		DstFile:        req.DstFile,
+		IndexPolicy:    policy.String(),
 		Status:         "pending",
 		StartTime:      time.Now(),
 		LastUpdateTime: time.Now(),
+		EtaSeconds:     -1,
 	}
 	generateTasksLock.Unlock()
 
 	// 异步执行生成
-	go executeGenerateDbTask(taskID, req.SrcFile, req.DstFile)
+	go executeGenerateDbTask(taskID, req.SrcFile, req.DstFile, policy, sourceEncodingFor(req.SrcFile, req.Encoding), fileMode, req.Strict, req.RegionSchema, req.CallbackURL)
 
 	// 返回任务ID
 	c.JSON(http.StatusOK, Response{
@@ -1624,7 +5272,14 @@ func GenerateDbWithProgress(c *gin.Context) {
 }
 
 // 执行生成任务
-func executeGenerateDbTask(taskID, srcFile, dstFile string) {
+func executeGenerateDbTask(taskID, srcFile, dstFile string, policy xdb.IndexPolicy, encoding string, fileMode os.FileMode, strict bool, schema *xdb.RegionSchema, callbackURL string) {
+	// 任务结束后通知回调地址
+	if callbackURL != "" {
+		defer func() {
+			go notifyTaskCallback(callbackURL, "generate", taskID, GetGenerateTaskStatus(taskID))
+		}()
+	}
+
 	// 获取取消通道
 	var cancelChan chan bool
 
@@ -1632,7 +5287,9 @@ func executeGenerateDbTask(taskID, srcFile, dstFile string) {
 	if ch, exists := generateCancelChans[taskID]; exists {
 		cancelChan = ch
 	} else {
-		cancelChan = make(chan bool, 1) // 防御性编程：如果没找到，创建一个新的
+		// 取消通道在创建任务时注册，开始执行前已被取消时会被移除
+		cancelChan = make(chan bool, 1)
+		close(cancelChan)
 	}
 	generateTasksLock.RUnlock()
 
@@ -1643,12 +5300,32 @@ func executeGenerateDbTask(taskID, srcFile, dstFile string) {
 		generateTasksLock.Unlock()
 	}()
 
-	// 更新任务状态为处理中
+	// 排队等待空闲的执行位置，排队期间被取消时直接结束，状态已由取消接口更新
+	updateGenerateTaskStatus(taskID, func(task *GenerateTaskStatus) {
+		if task.Status == "pending" {
+			task.Status = "queued"
+		}
+	})
+	if !generateTasksPool.acquire(taskID, cancelChan, nil) {
+		return
+	}
+
+	// 更新任务状态为处理中，分配到执行位置的同时被取消的任务交还位置后结束
+	var cancelledBeforeStart bool
 	updateGenerateTaskStatus(taskID, func(task *GenerateTaskStatus) {
+		if task.Status == "failed" {
+			cancelledBeforeStart = true
+			return
+		}
 		task.Status = "processing"
+		task.QueuePosition = 0
 		task.StartTime = time.Now()      // 确保开始时间被设置
 		task.LastUpdateTime = time.Now() // 初始化最后更新时间
 	})
+	if cancelledBeforeStart {
+		generateTasksPool.release()
+		return
+	}
 
 	// 设置超时控制
 	timeoutTimer := time.NewTimer(10 * time.Minute)
@@ -1657,20 +5334,53 @@ func executeGenerateDbTask(taskID, srcFile, dstFile string) {
 	// 创建通道用于传递结果
 	doneChan := make(chan bool, 1)
 
-	// 启动生成协程
+	// 启动生成协程，超时后协程可能仍在收尾，结束时才交还执行位置
 	go func() {
+		defer generateTasksPool.release()
+
 		// 检查是否有对该文件的编辑，如果有，先保存
-		if editor, ok := editors[srcFile]; ok && editor.NeedSave() {
-			// 有未保存的编辑内容，先保存
-			if err := editor.Save(); err != nil {
+		if err := saveEditorIfNeeded(srcFile); err != nil {
+			updateGenerateTaskStatus(taskID, func(task *GenerateTaskStatus) {
+				task.Status = "failed"
+				task.ErrorMessage = err.Error()
+				task.EndTime = time.Now()
+			})
+			doneChan <- true
+			return
+		}
+
+		// 远程源文件先下载到临时文件，下载时间计入任务的超时时间；
+		// 取消任务、取消所有任务和超时都会关闭取消通道，同时中止下载并交还执行位置
+		if isRemoteSource(srcFile) {
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				select {
+				case <-cancelChan:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+			localFile, cleanup, err := fetchRemoteSource(ctx, srcFile)
+			cancelled := ctx.Err() != nil
+			cancel()
+			if err != nil {
 				updateGenerateTaskStatus(taskID, func(task *GenerateTaskStatus) {
+					// 取消或超时的任务保留取消时记录的原因
+					if cancelled && task.Status == "failed" {
+						return
+					}
 					task.Status = "failed"
-					task.ErrorMessage = "保存编辑内容失败: " + err.Error()
+					task.ErrorMessage = "下载源文件失败: " + err.Error()
+					if cancelled {
+						task.ErrorMessage = "用户取消任务"
+					}
 					task.EndTime = time.Now()
 				})
 				doneChan <- true
 				return
 			}
+			defer cleanup()
+			srcFile = localFile
 		}
 
 		// 检查文件是否存在
@@ -1699,7 +5409,8 @@ func executeGenerateDbTask(taskID, srcFile, dstFile string) {
 		}
 
 		// 创建maker
-		maker, err := xdb.NewMaker(xdb.VectorIndexPolicy, srcFile, dstFile)
+		tStart := time.Now()
+		maker, err := xdb.NewMaker(policy, srcFile, dstFile)
 		if err != nil {
 			updateGenerateTaskStatus(taskID, func(task *GenerateTaskStatus) {
 				task.Status = "failed"
@@ -1711,6 +5422,30 @@ func executeGenerateDbTask(taskID, srcFile, dstFile string) {
 		}
 		defer maker.Close()
 
+		if err := maker.SetSourceEncoding(encoding); err != nil {
+			updateGenerateTaskStatus(taskID, func(task *GenerateTaskStatus) {
+				task.Status = "failed"
+				task.ErrorMessage = err.Error()
+				task.EndTime = time.Now()
+			})
+			doneChan <- true
+			return
+		}
+
+		if fileMode != 0 {
+			if err := maker.SetFileMode(fileMode); err != nil {
+				updateGenerateTaskStatus(taskID, func(task *GenerateTaskStatus) {
+					task.Status = "failed"
+					task.ErrorMessage = "设置文件权限失败: " + err.Error()
+					task.EndTime = time.Now()
+				})
+				doneChan <- true
+				return
+			}
+		}
+		maker.SetStrict(strict)
+		_ = maker.SetRegionSchema(schema) // 已由checkRegionSchema检查
+
 		// 更新任务状态
 		updateGenerateTaskStatus(taskID, func(task *GenerateTaskStatus) {
 			// 使用新添加的GetSegmentsCount方法获取段数量
@@ -1737,6 +5472,14 @@ func executeGenerateDbTask(taskID, srcFile, dstFile string) {
 			updateGenerateTaskStatus(taskID, func(task *GenerateTaskStatus) {
 				task.Status = "failed"
 				task.ErrorMessage = "初始化失败: " + err.Error()
+				if cErr := continuityProblems(err); cErr != nil {
+					task.ErrorMessage = "严格模式检查失败: " + cErr.Error()
+					task.Problems = cErr.Problems
+				}
+				if sErr := regionSchemaViolations(err); sErr != nil {
+					task.ErrorMessage = "地区信息结构检查失败: " + sErr.Error()
+					task.Violations = sErr.Violations
+				}
 				task.EndTime = time.Now()
 			})
 			doneChan <- true
@@ -1764,62 +5507,21 @@ func executeGenerateDbTask(taskID, srcFile, dstFile string) {
 			// 继续执行
 		}
 
-		// 创建一个停止进度更新的通道
-		progressStopChan := make(chan bool, 1)
-		defer close(progressStopChan)
-
-		// 启动一个goroutine来定期更新进度
-		go func() {
-			// 定期更新进度的计时器
-			ticker := time.NewTicker(500 * time.Millisecond) // 每500毫秒更新一次
-			defer ticker.Stop()
-
-			// 模拟处理进度的计数器
-			var processedCounter int64 = 0
-			// 获取总段数
-			totalSegments := int64(maker.GetSegmentsCount())
-			if totalSegments <= 0 {
-				totalSegments = 1 // 防止除零错误
-			}
-
-			// 估算每次需要增加的段数（基于总段数的百分比）
-			incrementPerTick := totalSegments / 100 // 每次增加1%的进度
-			if incrementPerTick < 1 {
-				incrementPerTick = 1 // 确保至少增加1
-			}
-
-			for {
-				select {
-				case <-progressStopChan:
-					// 收到停止信号，退出goroutine
-					return
-				case <-ticker.C:
-					// 模拟处理进度
-					if processedCounter < totalSegments {
-						// 增加进度计数器
-						processedCounter += incrementPerTick
-						// 确保不超过总数
-						if processedCounter > totalSegments {
-							processedCounter = totalSegments
-						}
-						// 更新进度
-						updateGenerateTaskStatus(taskID, func(task *GenerateTaskStatus) {
-							task.SegmentCount = processedCounter
-							task.LastUpdateTime = time.Now()
-						})
-					}
-				case <-cancelChan:
-					// 任务被取消，退出goroutine
-					return
+		// 使用Maker的真实进度回调更新任务进度和预计剩余时间
+		maker.SetProgressCallback(func(done, total int) {
+			updateGenerateTaskStatus(taskID, func(task *GenerateTaskStatus) {
+				task.ProcessedSegments = int64(done)
+				task.SegmentCount = int64(total)
+				if total > 0 {
+					task.Progress = float64(done) / float64(total) * 100
 				}
-			}
-		}()
+				task.EtaSeconds = estimateEtaSeconds(time.Since(task.StartTime), int64(done), int64(total))
+				task.LastUpdateTime = time.Now()
+			})
+		})
 
 		// 开始处理
 		if err := maker.Start(); err != nil {
-			// 发送信号停止进度更新
-			progressStopChan <- true
-
 			updateGenerateTaskStatus(taskID, func(task *GenerateTaskStatus) {
 				task.Status = "failed"
 				task.ErrorMessage = "处理失败: " + err.Error()
@@ -1829,9 +5531,6 @@ func executeGenerateDbTask(taskID, srcFile, dstFile string) {
 			return
 		}
 
-		// 发送信号停止进度更新
-		progressStopChan <- true
-
 		// 更新任务状态
 		updateGenerateTaskStatus(taskID, func(task *GenerateTaskStatus) {
 			// 确保最终段数是正确的
@@ -1863,10 +5562,14 @@ func executeGenerateDbTask(taskID, srcFile, dstFile string) {
 			doneChan <- true
 			return
 		}
+		invalidateSearchCache()
+		recordGenerateThroughput(maker.GetSegmentsCount(), time.Since(tStart))
 
 		// 更新任务完成状态
 		updateGenerateTaskStatus(taskID, func(task *GenerateTaskStatus) {
 			task.Status = "completed"
+			task.Progress = 100
+			task.EtaSeconds = 0
 			task.EndTime = time.Now()
 		})
 
@@ -1879,7 +5582,7 @@ func executeGenerateDbTask(taskID, srcFile, dstFile string) {
 		// 生成正常完成，状态已在任务中更新
 	case <-timeoutTimer.C:
 		// 超时
-		close(cancelChan) // 发送取消信号
+		signalGenerateCancel(taskID) // 发送取消信号
 		updateGenerateTaskStatus(taskID, func(task *GenerateTaskStatus) {
 			if task.Status != "completed" {
 				task.Status = "failed"
@@ -1941,8 +5644,8 @@ func CancelGenerateTask(c *gin.Context) {
 		return
 	}
 
-	// 只能取消 pending 或 processing 状态的任务
-	if task.Status != "pending" && task.Status != "processing" {
+	// 只能取消 pending、queued 或 processing 状态的任务
+	if task.Status != "pending" && task.Status != "queued" && task.Status != "processing" {
 		c.JSON(http.StatusBadRequest, Response{
 			Code: 400,
 			Msg:  "任务已完成或已失败，无法取消",
@@ -1951,15 +5654,8 @@ func CancelGenerateTask(c *gin.Context) {
 		return
 	}
 
-	// 发送取消信号
-	generateTasksLock.RLock()
-	cancelChan, exists := generateCancelChans[taskID]
-	generateTasksLock.RUnlock()
-
-	if exists {
-		// 关闭通道通知生成协程终止
-		close(cancelChan)
-	}
+	// 关闭通道通知生成协程终止，与取消所有任务同时发生时也只关闭一次
+	signalGenerateCancel(taskID)
 
 	// 更新任务状态
 	updateGenerateTaskStatus(taskID, func(task *GenerateTaskStatus) {
@@ -1987,6 +5683,7 @@ func LoadXdbToMemoryFunc(dbPath string) (*LoadXdbResult, error) {
 	}
 
 	// 获取加载结果信息
+	loadTaken := time.Since(tStart)
 	result := &LoadXdbResult{
 		DbPath:        dbPath,
 		SearchMode:    "memory", // 明确指定是内存模式
@@ -1994,7 +5691,8 @@ func LoadXdbToMemoryFunc(dbPath string) (*LoadXdbResult, error) {
 		BufferSizeKB:  s.GetContentBufferSize() / 1024,
 		VectorLoaded:  s.IsVectorIndexLoaded(),
 		VectorSizeKB:  s.GetVectorIndexSize() / 1024,
-		LoadTimeTaken: time.Since(tStart).String(),
+		LoadTimeTaken: loadTaken.String(),
+		LoadTimeMs:    loadTaken.Milliseconds(),
 	}
 
 	return result, nil
@@ -2047,6 +5745,11 @@ func UnloadEditFile(c *gin.Context) {
 	// 记录旧路径用于返回信息
 	oldPath := currentPath
 
+	if !lockEditor(c, currentPath) {
+		return
+	}
+	defer unlockEditor(currentPath)
+
 	// 清理编辑器实例
 	editorsLock.Lock()
 	if editor, ok := editors[currentPath]; ok {
@@ -2113,6 +5816,10 @@ func DumpVectorIndexInfo(c *gin.Context) {
 		return
 	}
 
+	if !resolveRequestPaths(c, &req.DbPath) {
+		return
+	}
+
 	result, err := CheckVectorIndexByIP(req.IP, req.DbPath)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{
@@ -2253,6 +5960,10 @@ func ForceLoadToMemory(c *gin.Context) {
 		return
 	}
 
+	if !resolveRequestPaths(c, &req.DbPath) {
+		return
+	}
+
 	// 强制卸载现有的searcher
 	searcherLock.Lock()
 	if searcher != nil {
@@ -2262,6 +5973,7 @@ func ForceLoadToMemory(c *gin.Context) {
 		searcherMode = "" // 清除模式
 		atomic.StoreInt32(&inMemoryMode, 0)
 	}
+	invalidateSearchCache()
 	searcherLock.Unlock()
 
 	// 强制垃圾回收
@@ -2279,6 +5991,7 @@ func ForceLoadToMemory(c *gin.Context) {
 	}
 
 	// 验证加载结果
+	loadTaken := time.Since(tStart)
 	result := LoadXdbResult{
 		DbPath:        req.DbPath,
 		SearchMode:    "memory", // 明确是内存模式
@@ -2286,7 +5999,8 @@ func ForceLoadToMemory(c *gin.Context) {
 		BufferSizeKB:  s.GetContentBufferSize() / 1024,
 		VectorLoaded:  s.IsVectorIndexLoaded(),
 		VectorSizeKB:  s.GetVectorIndexSize() / 1024,
-		LoadTimeTaken: time.Since(tStart).String(),
+		LoadTimeTaken: loadTaken.String(),
+		LoadTimeMs:    loadTaken.Milliseconds(),
 	}
 
 	c.JSON(http.StatusOK, Response{
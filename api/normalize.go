@@ -0,0 +1,121 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"ip2region-web/xdb"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 规范化源文件请求
+type NormalizeSourceRequest struct {
+	SrcFile    string `json:"srcFile" binding:"required"`
+	DstFile    string `json:"dstFile" binding:"required"`
+	Precedence string `json:"precedence,omitempty"` // 重叠范围的取舍：last-wins(默认) 或 first-wins
+	FillRegion string `json:"fillRegion,omitempty"` // 填充空缺的地区信息，默认为与数据字段数量一致的 0|0|0|0|0
+	Encoding   string `json:"encoding,omitempty"`   // 源文件编码：utf-8(默认) 或 gbk，输出使用相同编码
+}
+
+// 规范化源文件结果
+type NormalizeSourceResult struct {
+	SrcFile    string             `json:"srcFile"`
+	DstFile    string             `json:"dstFile"`
+	Precedence string             `json:"precedence"`
+	Stats      xdb.NormalizeStats `json:"stats"` // 整理时所做的修改
+	TimeTaken  string             `json:"timeTaken"`
+}
+
+// NormalizeSource 读取可以乱序、重叠的源文件，按起始IP排序、按precedence去除重叠、
+// 填充空缺并合并相邻的同地区段，写出覆盖整个地址空间的源文件，不生成XDB
+func NormalizeSource(c *gin.Context) {
+	var req NormalizeSourceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	if !resolveSourcePath(c, &req.SrcFile) || !resolveRequestPaths(c, &req.DstFile) {
+		return
+	}
+
+	// 输出先写临时文件再重命名，正在编辑的源文件被替换后编辑器中的数据会与文件不一致
+	if !checkOutputPath(c, req.DstFile, true, req.SrcFile) {
+		return
+	}
+
+	if !checkEncoding(c, &req.Encoding) {
+		return
+	}
+
+	precedence, err := xdb.ParsePrecedence(req.Precedence)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  fmt.Sprintf("不支持的precedence: %s，支持: last-wins, first-wins", req.Precedence),
+		})
+		return
+	}
+
+	if req.FillRegion != "" {
+		if err := xdb.CheckRegionLength(req.FillRegion); err != nil || strings.ContainsAny(req.FillRegion, "\r\n") {
+			c.JSON(http.StatusBadRequest, Response{
+				Code: 400,
+				Msg:  "无效的fillRegion，不能包含换行且不能超过最大长度",
+			})
+			return
+		}
+	}
+
+	if _, err := os.Stat(req.SrcFile); !isRemoteSource(req.SrcFile) && os.IsNotExist(err) {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "源文件不存在: " + req.SrcFile,
+		})
+		return
+	}
+
+	// 远程源文件先下载到临时文件，整理结束后删除
+	srcFile, cleanup, ok := fetchRequestSource(c, req.SrcFile)
+	if !ok {
+		return
+	}
+	defer cleanup()
+
+	tStart := time.Now()
+	encoding := sourceEncodingFor(req.SrcFile, req.Encoding)
+	stats, err := xdb.NormalizeFile(srcFile, req.DstFile, encoding, xdb.NormalizeOptions{
+		Precedence: precedence,
+		FillRegion: req.FillRegion,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "规范化源文件失败: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "规范化完成",
+		Data: NormalizeSourceResult{
+			SrcFile:    req.SrcFile,
+			DstFile:    req.DstFile,
+			Precedence: precedence.String(),
+			Stats:      stats,
+			TimeTaken:  time.Since(tStart).String(),
+		},
+	})
+}
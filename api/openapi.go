@@ -0,0 +1,358 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"ip2region-web/xdb"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAPI文档中的JSON Schema片段
+type openAPISchema = map[string]interface{}
+
+// 接口文档：请求体和响应data的结构通过反射从对应的结构体生成，
+// data为gin.H等动态结构时使用手写的Schema
+type apiDoc struct {
+	Handler     gin.HandlerFunc
+	Summary     string
+	Request     interface{}   // 请求体结构体零值，nil表示无请求体
+	Query       interface{}   // 查询参数结构体零值，字段名取自form标签
+	Response    interface{}   // 响应data的结构体零值
+	Schema      openAPISchema // 响应data的手写Schema，优先于Response
+	ContentType string        // 成功响应的内容类型，默认application/json
+}
+
+// 对象Schema的简写，fields依次为字段名和类型
+func objectSchema(fields ...string) openAPISchema {
+	props := openAPISchema{}
+	for i := 0; i+1 < len(fields); i += 2 {
+		props[fields[i]] = openAPISchema{"type": fields[i+1]}
+	}
+	return openAPISchema{"type": "object", "properties": props}
+}
+
+// 已登记的接口文档，未登记的路由仍会出现在文档中，但只有通用的响应结构
+var apiDocs = []apiDoc{
+	{Handler: SearchIP, Summary: "IP地址查询", Request: SearchRequest{}, Response: SearchResult{}},
+	{Handler: SearchIPBatch, Summary: "批量IP查询", Request: BatchSearchRequest{}, Response: BatchSearchResult{}},
+	{Handler: ContainsIP, Summary: "检查IP是否被数据库覆盖", Request: ContainsRequest{}, Response: ContainsResult{}},
+	{Handler: SearchCIDRs, Summary: "查询CIDR网段内的地区分布", Request: CidrSearchRequest{}, Response: CidrSearchResult{}},
+	{Handler: SearchRegionHistogram, Summary: "统计IP范围内各地区覆盖的IP数量", Request: RegionHistogramRequest{}, Response: RegionHistogramResult{}},
+	{Handler: SearchNeighbors, Summary: "查询IP所在的IP段及其前后相邻的IP段", Request: NeighborsRequest{}, Response: NeighborsResult{}},
+	{Handler: LoadXdbToMemory, Summary: "加载XDB文件到指定模式", Request: LoadXdbRequest{}, Response: LoadXdbResult{}},
+	{Handler: GetXdbStatus, Summary: "获取XDB加载状态", Schema: openAPISchema{
+		"type": "object",
+		"properties": openAPISchema{
+			"loaded":           openAPISchema{"type": "boolean"},
+			"dbPath":           openAPISchema{"type": "string"},
+			"searchMode":       openAPISchema{"type": "string"},
+			"inMemory":         openAPISchema{"type": "boolean"},
+			"vectorIndex":      openAPISchema{"type": "boolean"},
+			"bufferSize":       openAPISchema{"type": "integer"},
+			"vectorSize":       openAPISchema{"type": "integer"},
+			"segmentIndex":     openAPISchema{"type": "boolean"},
+			"segmentIndexSize": openAPISchema{"type": "integer"},
+			"regionCount":      openAPISchema{"type": "integer"},
+			"fileSize":         openAPISchema{"type": "integer"},
+			"fileModTime":      openAPISchema{"type": "string", "format": "date-time"},
+			"diskFileSize":     openAPISchema{"type": "integer"},
+			"diskFileModTime":  openAPISchema{"type": "string", "format": "date-time"},
+			"diskError":        openAPISchema{"type": "string"},
+			"stale":            openAPISchema{"type": "boolean"},
+			"cache":            objectSchema("enabled", "boolean", "capacity", "integer", "size", "integer", "hits", "integer", "misses", "integer", "hitRate", "number"),
+			"aliases": openAPISchema{
+				"type":                 "object",
+				"additionalProperties": objectSchema("dbPath", "string", "searchMode", "string"),
+			},
+		},
+	}},
+	{Handler: GetStats, Summary: "获取全局查询统计、运行时长和任务数量", Response: StatsResult{}},
+	{Handler: ResetStats, Summary: "重置全局查询统计计数器"},
+	{Handler: VectorOccupancy, Summary: "统计向量索引每个单元格下的段索引条数", Request: VectorOccupancyRequest{}, Response: VectorOccupancyResult{}},
+	{Handler: RegisterSnapshot, Summary: "注册历史数据库快照", Request: RegisterSnapshotRequest{}, Response: DbSnapshot{}},
+	{Handler: ListSnapshots, Summary: "列出已注册的历史数据库快照", Response: []DbSnapshot{}},
+	{Handler: UnloadXdb, Summary: "卸载已加载的XDB文件"},
+	{Handler: RunGC, Summary: "清空缓冲区池和缓存并回收内存", Response: GCResult{}},
+	{Handler: ExportXdb, Summary: "异步导出XDB为源文本文件", Request: ExportXdbRequest{}, Schema: objectSchema("taskId", "string")},
+	{Handler: ConvertXdb, Summary: "同步将小XDB文件转换为源文本", Request: ConvertXdbRequest{}, ContentType: "text/plain"},
+	{Handler: ExportDelta, Summary: "导出两个XDB之间变化的段作为补丁文件", Request: ExportDeltaRequest{}, Response: ExportDeltaResult{}},
+	{Handler: ExportPatch, Summary: "生成两个XDB之间的二进制补丁", Request: ExportPatchRequest{}, Response: ExportPatchResult{}},
+	{Handler: ApplyPatch, Summary: "将二进制补丁应用到基准XDB", Request: ApplyPatchRequest{}, Response: ApplyPatchResult{}},
+	{Handler: OptimizeXdb, Summary: "按段索引顺序重写XDB的地区数据", Request: OptimizeXdbRequest{}, Response: OptimizeXdbResult{}},
+	{Handler: Checksum, Summary: "计算文件的SHA-256", Query: ChecksumRequest{}, Response: ChecksumResult{}},
+	{Handler: VerifySource, Summary: "校验XDB文件是否由指定源文件生成", Request: VerifySourceRequest{}, Response: VerifySourceResult{}},
+	{Handler: Benchmark, Summary: "测量指定数据库和模式的查询耗时", Request: BenchmarkRequest{}, Response: BenchmarkResult{}},
+	{Handler: GetExportTaskStatusHandler, Summary: "获取导出任务状态", Response: ExportTaskStatus{}},
+	{Handler: CancelExportTask, Summary: "取消导出任务"},
+	{Handler: GenerateDbWithProgress, Summary: "异步生成XDB文件", Request: SaveAndGenerateRequest{}, Schema: objectSchema("taskId", "string")},
+	{Handler: ListTasks, Summary: "分页列出导出和生成任务", Query: ListTasksRequest{}},
+	{Handler: CancelAllTasks, Summary: "取消所有未结束的导出和生成任务", Response: CancelAllTasksResult{}},
+	{Handler: GetGenerateTaskStatusHandler, Summary: "获取生成任务状态", Response: GenerateTaskStatus{}},
+	{Handler: CancelGenerateTask, Summary: "取消生成任务"},
+	{Handler: EstimateGenerate, Summary: "预估生成XDB的耗时", Request: GenerateEstimateRequest{}, Response: GenerateEstimateResult{}},
+	{Handler: GenerateDb, Summary: "同步生成XDB文件", Request: GenDbRequest{}, Schema: objectSchema("elapsed", "string", "elapsedMs", "integer", "srcFile", "string", "dstFile", "string", "indexPolicy", "string", "encoding", "string")},
+	{Handler: GetTaskStatus, Summary: "查询任务状态", Schema: openAPISchema{"type": "object"}},
+	{Handler: EditSegment, Summary: "编辑IP段", Request: EditSegmentRequest{}, Schema: objectSchema("oldCount", "integer", "newCount", "integer", "applied", "integer", "skipped", "integer", "segment", "string")},
+	{Handler: EditSegmentAt, Summary: "按IP修改所在段的地区", Request: EditSegmentAtRequest{}, Response: EditSegmentAtResult{}},
+	{Handler: ReplaceRegion, Summary: "批量替换地区", Request: ReplaceRegionRequest{}, Schema: objectSchema("changed", "integer", "merged", "integer", "segLen", "integer")},
+	{Handler: EditFromFile, Summary: "从文件批量编辑IP段", Request: EditFileRequest{}, Schema: objectSchema("oldCount", "integer", "newCount", "integer", "applied", "integer", "skipped", "integer", "file", "string", "partial", "boolean", "taskId", "string")},
+	{Handler: EditStream, Summary: "从NDJSON请求体流式批量编辑IP段", Query: EditStreamRequest{}, Response: EditStreamResult{}},
+	{Handler: GetEditFileTaskStatusHandler, Summary: "获取从文件批量编辑的任务状态", Response: EditFileTaskStatus{}},
+	{Handler: ListSegments, Summary: "分页列出IP段", Request: ListSegmentsRequest{}, Schema: openAPISchema{
+		"type": "object",
+		"properties": openAPISchema{
+			"offset":     openAPISchema{"type": "integer"},
+			"size":       openAPISchema{"type": "integer"},
+			"total":      openAPISchema{"type": "integer"},
+			"segments":   openAPISchema{"type": "array", "items": openAPISchema{"$ref": "#/components/schemas/Segment"}},
+			"hasMore":    openAPISchema{"type": "boolean"},
+			"nextOffset": openAPISchema{"type": "integer"},
+		},
+	}},
+	{Handler: EditDiff, Summary: "对比编辑器中未保存的修改与源文件", Query: EditDiffRequest{}, Response: EditDiffResult{}},
+	{Handler: ValidateSegment, Summary: "校验IP段格式，不修改编辑器", Request: ValidateSegmentRequest{}, Response: ValidateSegmentResult{}},
+	{Handler: EditInline, Summary: "在内存中编辑请求提交的源文本并返回结果", Request: InlineEditRequest{}, Response: InlineEditResult{}},
+	{Handler: NormalizeSource, Summary: "规范化源文件：排序、去除重叠、填充空缺并合并相邻同区域段", Request: NormalizeSourceRequest{}, Response: NormalizeSourceResult{}},
+	{Handler: CompactSource, Summary: "整理源文件：合并相邻同区域段并去掉注释和空行", Request: CompactSourceRequest{}, Response: CompactSourceResult{}},
+	{Handler: SaveEdit, Summary: "保存编辑", Request: SaveEditRequest{}, Schema: objectSchema("srcFile", "string", "merged", "integer", "segLen", "integer")},
+	{Handler: SaveAndGenerateDb, Summary: "保存编辑并生成XDB文件", Request: SaveAndGenerateRequest{}, Schema: objectSchema("srcFile", "string", "dstFile", "string", "segLen", "integer", "merged", "integer", "indexPolicy", "string", "timeTaken", "string", "timeTakenMs", "integer")},
+	{Handler: GetCurrentEditFile, Summary: "获取当前编辑的源文件", Schema: objectSchema("currentEditFile", "string", "fileLoaded", "boolean")},
+	{Handler: UnloadEditFile, Summary: "卸载当前编辑的源文件", Schema: objectSchema("unloadedFile", "string")},
+	{Handler: GetDebugStatus, Summary: "获取调试状态", Schema: openAPISchema{"type": "object"}},
+	{Handler: PreviewSplit, Summary: "预览段按向量索引单元格拆分的结果", Request: SplitPreviewRequest{}, Response: SplitPreviewResult{}},
+	{Handler: ForceLoadToMemory, Summary: "强制重新加载XDB到内存模式", Request: LoadXdbRequest{}, Response: LoadXdbResult{}},
+}
+
+// 获取处理函数的完整名称，与gin.RouteInfo.Handler一致
+func handlerName(h gin.HandlerFunc) string {
+	return runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name()
+}
+
+// OpenAPISpec 返回输出OpenAPI 3文档的处理函数，路径来自路由注册信息，
+// 请求和响应结构来自apiDocs中登记的结构体，保证文档与服务端定义同步
+func OpenAPISpec(routes func() gin.RoutesInfo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, buildOpenAPISpec(routes()))
+	}
+}
+
+// gin的路径参数 :taskId 对应OpenAPI的 {taskId}
+var ginPathParam = regexp.MustCompile(`:([A-Za-z0-9_]+)`)
+
+func buildOpenAPISpec(routes gin.RoutesInfo) openAPISchema {
+	docs := make(map[string]apiDoc, len(apiDocs))
+	for _, d := range apiDocs {
+		docs[handlerName(d.Handler)] = d
+	}
+
+	g := &schemaGenerator{components: openAPISchema{}}
+	g.component(reflect.TypeOf(Response{}))
+	g.component(reflect.TypeOf(xdb.Segment{}))
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+
+	paths := openAPISchema{}
+	for _, r := range routes {
+		if !strings.HasPrefix(r.Path, "/api/") {
+			continue
+		}
+
+		path := ginPathParam.ReplaceAllString(r.Path, "{$1}")
+		item, ok := paths[path].(openAPISchema)
+		if !ok {
+			item = openAPISchema{}
+			paths[path] = item
+		}
+
+		item[strings.ToLower(r.Method)] = g.operation(r, docs[r.Handler])
+	}
+
+	return openAPISchema{
+		"openapi": "3.0.3",
+		"info": openAPISchema{
+			"title":   "IP2Region Web API",
+			"version": "2.0",
+		},
+		"paths":      paths,
+		"components": openAPISchema{"schemas": g.components},
+	}
+}
+
+func (g *schemaGenerator) operation(r gin.RouteInfo, d apiDoc) openAPISchema {
+	op := openAPISchema{
+		"operationId": r.Handler[strings.LastIndex(r.Handler, ".")+1:],
+	}
+	if d.Summary != "" {
+		op["summary"] = d.Summary
+	}
+
+	var params []openAPISchema
+	for _, m := range ginPathParam.FindAllStringSubmatch(r.Path, -1) {
+		params = append(params, openAPISchema{
+			"name":     m[1],
+			"in":       "path",
+			"required": true,
+			"schema":   openAPISchema{"type": "string"},
+		})
+	}
+	if d.Query != nil {
+		t := reflect.TypeOf(d.Query)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("form"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			params = append(params, openAPISchema{
+				"name":     name,
+				"in":       "query",
+				"required": strings.Contains(f.Tag.Get("binding"), "required"),
+				"schema":   g.schema(f.Type),
+			})
+		}
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	if d.Request != nil {
+		op["requestBody"] = openAPISchema{
+			"required": true,
+			"content": openAPISchema{
+				"application/json": openAPISchema{"schema": g.schema(reflect.TypeOf(d.Request))},
+			},
+		}
+	}
+
+	var content openAPISchema
+	if d.ContentType != "" && d.ContentType != "application/json" {
+		content = openAPISchema{d.ContentType: openAPISchema{"schema": openAPISchema{"type": "string"}}}
+	} else {
+		data := d.Schema
+		if data == nil && d.Response != nil {
+			data = g.schema(reflect.TypeOf(d.Response))
+		}
+
+		envelope := openAPISchema{"$ref": "#/components/schemas/Response"}
+		if data != nil {
+			envelope = openAPISchema{"allOf": []openAPISchema{
+				envelope,
+				{"type": "object", "properties": openAPISchema{"data": data}},
+			}}
+		}
+		content = openAPISchema{"application/json": openAPISchema{"schema": envelope}}
+	}
+
+	op["responses"] = openAPISchema{
+		"200": openAPISchema{"description": "成功", "content": content},
+		"default": openAPISchema{
+			"description": "失败，code为HTTP状态码，msg为错误信息",
+			"content": openAPISchema{
+				"application/json": openAPISchema{"schema": openAPISchema{"$ref": "#/components/schemas/Response"}},
+			},
+		},
+	}
+
+	return op
+}
+
+// 通过反射把Go结构体转换为JSON Schema，命名结构体放入components并以$ref引用
+type schemaGenerator struct {
+	components openAPISchema
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g *schemaGenerator) schema(t reflect.Type) openAPISchema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := g.schema(t.Elem())
+		if _, isRef := s["$ref"]; isRef {
+			return openAPISchema{"allOf": []openAPISchema{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.Bool:
+		return openAPISchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return openAPISchema{"type": "integer", "format": "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return openAPISchema{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return openAPISchema{"type": "number"}
+	case reflect.String:
+		return openAPISchema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return openAPISchema{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return openAPISchema{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t == timeType {
+			return openAPISchema{"type": "string", "format": "date-time"}
+		}
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		return g.component(t)
+	default:
+		return openAPISchema{}
+	}
+}
+
+// 登记命名结构体并返回其引用
+func (g *schemaGenerator) component(t reflect.Type) openAPISchema {
+	ref := openAPISchema{"$ref": "#/components/schemas/" + t.Name()}
+	if _, ok := g.components[t.Name()]; !ok {
+		g.components[t.Name()] = openAPISchema{} // 先占位，避免递归结构死循环
+		g.components[t.Name()] = g.object(t)
+	}
+	return ref
+}
+
+func (g *schemaGenerator) object(t reflect.Type) openAPISchema {
+	props := openAPISchema{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name := f.Name
+		if tag := f.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if n := strings.Split(tag, ",")[0]; n != "" {
+				name = n
+			}
+		}
+
+		props[name] = g.schema(f.Type)
+		if strings.Contains(f.Tag.Get("binding"), "required") {
+			required = append(required, name)
+		}
+	}
+
+	s := openAPISchema{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
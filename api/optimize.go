@@ -0,0 +1,94 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"ip2region-web/xdb"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 校验优化结果时比较的查询次数上限
+const optimizeMaxSamples = 1000000
+
+// XDB优化请求
+type OptimizeXdbRequest struct {
+	XdbPath  string `json:"xdbPath" binding:"required"`
+	DstFile  string `json:"dstFile,omitempty"`  // 输出的数据库，默认替换xdbPath
+	Samples  int    `json:"samples,omitempty"`  // 写入前校验的查询次数，默认10000
+	FileMode string `json:"fileMode,omitempty"` // 输出文件的八进制权限，例如 0640，默认保留已有文件的权限
+}
+
+// XDB优化结果
+type OptimizeXdbResult struct {
+	XdbPath     string            `json:"xdbPath"`
+	DstFile     string            `json:"dstFile"`
+	TimeTaken   string            `json:"timeTaken"`
+	TimeTakenMs int64             `json:"timeTakenMs"`
+	Stats       xdb.OptimizeStats `json:"stats"`
+}
+
+// OptimizeXdb 按段索引的顺序重写XDB的地区数据并去重，查询结果不变，文件模式下读取地区数据的局部性更好
+func OptimizeXdb(c *gin.Context) {
+	var req OptimizeXdbRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "请求参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	if req.DstFile == "" {
+		req.DstFile = req.XdbPath
+	}
+
+	if !resolveRequestPaths(c, &req.XdbPath, &req.DstFile) {
+		return
+	}
+
+	if req.Samples == 0 {
+		req.Samples = xdb.DefaultOptimizeSamples
+	}
+	if req.Samples < 0 || req.Samples > optimizeMaxSamples {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "samples 必须在 1 到 1000000 之间",
+		})
+		return
+	}
+
+	fileMode, ok := checkFileMode(c, req.FileMode)
+	if !ok {
+		return
+	}
+
+	tStart := time.Now()
+	stats, err := xdb.OptimizeFile(req.XdbPath, req.DstFile, req.Samples, fileMode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Code: 500,
+			Msg:  "优化XDB失败: " + err.Error(),
+		})
+		return
+	}
+	invalidateSearchCache()
+	timeTaken := time.Since(tStart)
+
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "优化完成",
+		Data: OptimizeXdbResult{
+			XdbPath:     req.XdbPath,
+			DstFile:     req.DstFile,
+			TimeTaken:   timeTaken.String(),
+			TimeTakenMs: timeTaken.Milliseconds(),
+			Stats:       *stats,
+		},
+	})
+}
@@ -0,0 +1,104 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package api
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative searchpb/search.proto
+
+import (
+	"net/http"
+
+	"ip2region-web/api/searchpb"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gin-gonic/gin/render"
+	"google.golang.org/protobuf/proto"
+)
+
+// 查询接口可选的响应格式，按请求头Accept协商，未明确要求时使用JSON
+var searchResponseFormats = []string{binding.MIMEJSON, binding.MIMEPROTOBUF, binding.MIMEMSGPACK}
+
+// renderSearchResponse 按Accept输出查询响应；protobuf格式见 searchpb/search.proto，
+// encode为nil时表示该响应没有protobuf格式，仍然输出JSON
+func renderSearchResponse(c *gin.Context, resp Response, encode func(Response) ([]byte, error)) {
+	switch c.NegotiateFormat(searchResponseFormats...) {
+	case binding.MIMEPROTOBUF:
+		if encode != nil {
+			// 地区信息不是合法的UTF-8时无法编码为proto3的string
+			b, err := encode(resp)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, Response{
+					Code: 500,
+					Msg:  "编码protobuf响应失败: " + err.Error(),
+				})
+				return
+			}
+			c.Data(http.StatusOK, binding.MIMEPROTOBUF, b)
+			return
+		}
+	case binding.MIMEMSGPACK:
+		c.Render(http.StatusOK, render.MsgPack{Data: resp})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// encodeSearchResponse 编码 SearchResponse，explain只在JSON和msgpack格式中返回
+func encodeSearchResponse(resp Response) ([]byte, error) {
+	var msg = &searchpb.SearchResponse{Code: int32(resp.Code), Msg: resp.Msg}
+	if r, ok := resp.Data.(*SearchResult); ok && r != nil {
+		msg.Data = &searchpb.SearchResult{
+			Region:          r.Region,
+			IoCount:         int32(r.IoCount),
+			TookNanoseconds: r.TookNanoseconds,
+			SearchMode:      r.SearchMode,
+			QueryTime:       r.QueryTime,
+			DbUsed:          r.DbUsed,
+			Cached:          r.Cached,
+			Field:           r.Field,
+			FieldValue:      r.FieldValue,
+			IsDefault:       r.IsDefault,
+			SnapshotDate:    r.SnapshotDate,
+			Found:           r.Found,
+			RequestedMode:   r.RequestedMode,
+			ModeNote:        r.ModeNote,
+			Reserved:        r.Reserved,
+			ReservedKind:    r.ReservedKind,
+			RegionParts:     r.RegionParts,
+		}
+	}
+	return proto.Marshal(msg)
+}
+
+// encodeBatchSearchResponse 编码 BatchSearchResponse
+func encodeBatchSearchResponse(resp Response) ([]byte, error) {
+	var msg = &searchpb.BatchSearchResponse{Code: int32(resp.Code), Msg: resp.Msg}
+	if r, ok := resp.Data.(BatchSearchResult); ok {
+		msg.Data = &searchpb.BatchSearchResult{
+			Results:         make([]*searchpb.BatchSearchItem, 0, len(r.Results)),
+			Total:           int32(r.Total),
+			ErrorCount:      int32(r.ErrorCount),
+			SearchMode:      r.SearchMode,
+			TookNanoseconds: r.TookNanoseconds,
+			RequestedMode:   r.RequestedMode,
+			ModeNote:        r.ModeNote,
+		}
+		for _, it := range r.Results {
+			var item = &searchpb.BatchSearchItem{
+				Ip:           it.IP,
+				Region:       it.Region,
+				Error:        it.Error,
+				Reserved:     it.Reserved,
+				ReservedKind: it.ReservedKind,
+			}
+			if it.IoCount != nil {
+				item.IoCount = proto.Int32(int32(*it.IoCount))
+			}
+			msg.Data.Results = append(msg.Data.Results, item)
+		}
+	}
+	return proto.Marshal(msg)
+}
@@ -0,0 +1,327 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var (
+	protoMessageRe = regexp.MustCompile(`^message\s+(\w+)\s*\{$`)
+	protoFieldRe   = regexp.MustCompile(`^(optional\s+|repeated\s+)?(\w+)\s+(\w+)\s*=\s*(\d+);$`)
+	protoScalars   = map[string]descriptorpb.FieldDescriptorProto_Type{
+		"string": descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		"bool":   descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+		"int32":  descriptorpb.FieldDescriptorProto_TYPE_INT32,
+		"int64":  descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	}
+)
+
+// loadSearchProto 解析searchpb/search.proto并构建文件描述符，不依赖生成的代码，
+// 修改search.proto后没有重新生成时测试会失败。只支持该文件用到的语法：
+// 不嵌套的message，标量或message类型的字段，optional和repeated修饰
+func loadSearchProto(t *testing.T) protoreflect.FileDescriptor {
+	t.Helper()
+
+	content, err := os.ReadFile("searchpb/search.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const pkg = "ip2region.web"
+	var file = &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("searchpb/search.proto"),
+		Package: proto.String(pkg),
+		Syntax:  proto.String("proto3"),
+	}
+	var msg *descriptorpb.DescriptorProto
+	for i, line := range strings.Split(string(content), "\n") {
+		if p := strings.Index(line, "//"); p >= 0 {
+			line = line[:p]
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "syntax ") || strings.HasPrefix(line, "package ") || strings.HasPrefix(line, "option "):
+		case protoMessageRe.MatchString(line):
+			msg = &descriptorpb.DescriptorProto{Name: proto.String(protoMessageRe.FindStringSubmatch(line)[1])}
+			file.MessageType = append(file.MessageType, msg)
+		case line == "}":
+			msg = nil
+		case msg != nil && protoFieldRe.MatchString(line):
+			m := protoFieldRe.FindStringSubmatch(line)
+			var field = &descriptorpb.FieldDescriptorProto{
+				Name:     proto.String(m[3]),
+				JsonName: proto.String(protoJSONName(m[3])),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			}
+			num, _ := strconv.Atoi(m[4])
+			field.Number = proto.Int32(int32(num))
+			if typ, ok := protoScalars[m[2]]; ok {
+				field.Type = typ.Enum()
+			} else {
+				field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+				field.TypeName = proto.String("." + pkg + "." + m[2])
+			}
+			switch strings.TrimSpace(m[1]) {
+			case "repeated":
+				field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+			case "optional":
+				// proto3 optional fields live in a synthetic oneof
+				field.Proto3Optional = proto.Bool(true)
+				field.OneofIndex = proto.Int32(int32(len(msg.OneofDecl)))
+				msg.OneofDecl = append(msg.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: proto.String("_" + m[3])})
+			}
+			msg.Field = append(msg.Field, field)
+		default:
+			t.Fatalf("search.proto line %d: unsupported syntax `%s`", i+1, line)
+		}
+	}
+
+	fd, err := protodesc.NewFile(file, nil)
+	if err != nil {
+		t.Fatalf("build descriptor from search.proto: %v", err)
+	}
+	return fd
+}
+
+// protoJSONName 与protoc相同，把下划线分隔的字段名转为小驼峰
+func protoJSONName(name string) string {
+	var sb strings.Builder
+	var upper bool
+	for _, c := range name {
+		if c == '_' {
+			upper = true
+			continue
+		}
+		if upper && c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		upper = false
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+// protoToMap 将解码后的消息按JSON名称转换为与encoding/json解码结果相同形式的map，只包含已设置的字段
+func protoToMap(m protoreflect.Message) map[string]any {
+	var out = map[string]any{}
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if fd.IsList() {
+			var list []any
+			for i := 0; i < v.List().Len(); i++ {
+				list = append(list, protoValue(fd, v.List().Get(i)))
+			}
+			out[fd.JSONName()] = list
+		} else {
+			out[fd.JSONName()] = protoValue(fd, v)
+		}
+		return true
+	})
+	return out
+}
+
+func protoValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) any {
+	switch fd.Kind() {
+	case protoreflect.MessageKind:
+		return protoToMap(v.Message())
+	case protoreflect.StringKind:
+		return v.String()
+	case protoreflect.BoolKind:
+		return v.Bool()
+	default:
+		return float64(v.Int())
+	}
+}
+
+// jsonToProtoMap 将resp按encoding/json编码再解码，只保留desc中有的字段，
+// 没有presence的字段为零值时去掉，与proto3编码时省略零值的规则一致
+func jsonToProtoMap(t *testing.T, resp any, desc protoreflect.MessageDescriptor) map[string]any {
+	t.Helper()
+
+	raw, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]any
+	if err = json.Unmarshal(raw, &m); err != nil {
+		t.Fatal(err)
+	}
+	return filterProtoMap(m, desc)
+}
+
+func filterProtoMap(m map[string]any, desc protoreflect.MessageDescriptor) map[string]any {
+	var out = map[string]any{}
+	for k, v := range m {
+		fd := desc.Fields().ByJSONName(k)
+		if fd == nil || v == nil {
+			continue
+		}
+		if fd.Kind() == protoreflect.MessageKind {
+			if fd.IsList() {
+				var list []any
+				for _, item := range v.([]any) {
+					list = append(list, filterProtoMap(item.(map[string]any), fd.Message()))
+				}
+				v = list
+			} else {
+				v = filterProtoMap(v.(map[string]any), fd.Message())
+			}
+		}
+		if !fd.HasPresence() && reflect.ValueOf(v).IsZero() {
+			continue
+		}
+		if list, ok := v.([]any); ok && len(list) == 0 {
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// decodeProto 使用search.proto中的name消息解码b
+func decodeProto(t *testing.T, fd protoreflect.FileDescriptor, name string, b []byte) map[string]any {
+	t.Helper()
+
+	desc := fd.Messages().ByName(protoreflect.Name(name))
+	if desc == nil {
+		t.Fatalf("message %s not found in search.proto", name)
+	}
+	msg := dynamicpb.NewMessage(desc)
+	if err := proto.Unmarshal(b, msg); err != nil {
+		t.Fatalf("decode %s: %v", name, err)
+	}
+	if len(msg.GetUnknown()) > 0 {
+		t.Fatalf("decode %s: fields not declared in search.proto: %x", name, msg.GetUnknown())
+	}
+	return protoToMap(msg)
+}
+
+func TestEncodeSearchResponse(t *testing.T) {
+	fd := loadSearchProto(t)
+	desc := fd.Messages().ByName("SearchResponse")
+
+	var empty = ""
+	var tests = []struct {
+		name string
+		resp Response
+	}{
+		{"all fields", Response{Code: 0, Msg: "查询成功", Data: &SearchResult{
+			Region:          "中国|0|广东省|广州市|电信",
+			IoCount:         3,
+			TookNanoseconds: 1234567,
+			SearchMode:      "file",
+			QueryTime:       "2022-01-02 15:04:05",
+			DbUsed:          "./data/ip2region.xdb",
+			Cached:          true,
+			Field:           "city",
+			FieldValue:      &empty,
+			IsDefault:       true,
+			SnapshotDate:    "2022-01-02",
+			Found:           true,
+			RequestedMode:   "memory",
+			ModeNote:        "数据库未加载到内存",
+			Reserved:        true,
+			ReservedKind:    "private",
+			RegionParts:     []string{"广州市", "", "中国"},
+		}}},
+		{"zero values", Response{Code: 0, Msg: "查询成功", Data: &SearchResult{}}},
+		{"negative code", Response{Code: -1, Msg: "错误"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := encodeSearchResponse(tt.resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := decodeProto(t, fd, "SearchResponse", b)
+			want := jsonToProtoMap(t, tt.resp, desc)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("decoded SearchResponse:\n got %v\nwant %v", got, want)
+			}
+		})
+	}
+}
+
+func TestEncodeSearchResponseInvalidUTF8(t *testing.T) {
+	// proto3的string必须是合法的UTF-8，损坏的地区数据返回错误而不是输出无法解码的消息
+	var resp = Response{Code: 0, Msg: "查询成功", Data: &SearchResult{Region: "中国|\xff\xfe|0|0|0"}}
+	if _, err := encodeSearchResponse(resp); err == nil {
+		t.Fatal("encodeSearchResponse with an invalid UTF-8 region: got no error")
+	}
+}
+
+func TestEncodeBatchSearchResponse(t *testing.T) {
+	fd := loadSearchProto(t)
+	desc := fd.Messages().ByName("BatchSearchResponse")
+
+	var region, ioCount = "中国|0|广东省|广州市|电信", 2
+	var empty, zero = "", 0
+	var resp = Response{Code: 0, Msg: "批量查询完成", Data: BatchSearchResult{
+		Results: []BatchSearchItem{
+			{IP: "1.0.0.1", Region: &region, IoCount: &ioCount},
+			{IP: "10.0.0.1", Region: &empty, IoCount: &zero, Reserved: true, ReservedKind: "private"},
+			{IP: "bad", Error: "无效的IP地址: bad"},
+		},
+		Total:           3,
+		ErrorCount:      1,
+		SearchMode:      "vector",
+		TookNanoseconds: 7654321,
+		RequestedMode:   "memory",
+		ModeNote:        "数据库未加载到内存",
+	}}
+
+	b, err := encodeBatchSearchResponse(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := decodeProto(t, fd, "BatchSearchResponse", b)
+	want := jsonToProtoMap(t, resp, desc)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("decoded BatchSearchResponse:\n got %v\nwant %v", got, want)
+	}
+}
+
+// TestSearchProtoFields 响应结构体新增JSON字段时search.proto和编码需要同步增加，
+// 只有明确不在protobuf格式中返回的字段可以例外
+func TestSearchProtoFields(t *testing.T) {
+	fd := loadSearchProto(t)
+
+	var tests = []struct {
+		message string
+		typ     reflect.Type
+		skip    []string
+	}{
+		{"SearchResult", reflect.TypeOf(SearchResult{}), []string{"explain"}},
+		{"BatchSearchItem", reflect.TypeOf(BatchSearchItem{}), nil},
+		{"BatchSearchResult", reflect.TypeOf(BatchSearchResult{}), nil},
+	}
+
+	for _, tt := range tests {
+		desc := fd.Messages().ByName(protoreflect.Name(tt.message))
+		for i := 0; i < tt.typ.NumField(); i++ {
+			name, _, _ := strings.Cut(tt.typ.Field(i).Tag.Get("json"), ",")
+			if name == "" || name == "-" || slices.Contains(tt.skip, name) {
+				continue
+			}
+			if desc.Fields().ByJSONName(name) == nil {
+				t.Errorf("%s.%s (json %q) has no field in search.proto message %s", tt.typ.Name(), tt.typ.Field(i).Name, name, tt.message)
+			}
+		}
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"ip2region-web/xdb"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 远程源文件的下载限制，maxBytes为0时不允许使用远程源文件，默认不允许
+var (
+	remoteSourceMaxBytes int64 = 0
+	remoteSourceTimeout        = 5 * time.Minute
+)
+
+// 允许访问的远程主机名，为空时不限制主机；设置了数据目录时必须配置，否则拒绝所有远程地址
+var remoteAllowHosts = map[string]bool{}
+
+// 访问远程地址时最多跟随的重定向次数，与 http.DefaultClient 相同
+const remoteMaxRedirects = 10
+
+// 访问远程地址的客户端，每次重定向都重新检查目标主机，避免通过重定向访问内网地址
+var remoteClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= remoteMaxRedirects {
+			return fmt.Errorf("重定向次数超过 %d 次", remoteMaxRedirects)
+		}
+		return checkRemoteHost(req.URL)
+	},
+}
+
+// 远程源文件允许的内容类型，响应没有Content-Type时同样允许
+var remoteSourceContentTypes = []string{"text/plain", "text/csv", "application/octet-stream"}
+
+// 检查格式时最多读取的行数，前面都是注释或空行时不再继续查找
+const remoteSourceSniffLines = 1000
+
+var errRemoteSourceDisabled = errors.New("未开启远程源文件，请使用本地文件路径")
+
+// SetRemoteSourceLimits 设置从http(s)地址下载源文件的大小上限(MB)和超时时间，maxMB为0时不允许使用远程源文件
+func SetRemoteSourceLimits(maxMB int, timeout time.Duration) error {
+	if maxMB < 0 {
+		return fmt.Errorf("远程源文件大小上限不能为负数: %d", maxMB)
+	}
+	if timeout <= 0 {
+		return fmt.Errorf("远程源文件下载超时必须大于0: %v", timeout)
+	}
+
+	remoteSourceMaxBytes = int64(maxMB) << 20
+	remoteSourceTimeout = timeout
+	return nil
+}
+
+// SetRemoteAllowHosts 设置允许访问的远程主机名，为空时不限制主机
+func SetRemoteAllowHosts(hosts []string) error {
+	allow := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h == "" || strings.ContainsAny(h, "/:") {
+			return fmt.Errorf("无效的主机名: %q，只需填写主机名，不含协议和端口", h)
+		}
+		allow[h] = true
+	}
+
+	remoteAllowHosts = allow
+	return nil
+}
+
+// checkRemoteHost 检查远程地址的主机是否允许访问，设置了数据目录但没有配置允许的主机时拒绝所有远程地址
+func checkRemoteHost(u *url.URL) error {
+	host := strings.ToLower(u.Hostname())
+	if len(remoteAllowHosts) == 0 {
+		if dataRoot != "" {
+			return errors.New("设置了数据目录时只能访问 -remote-allow-host 允许的主机")
+		}
+		return nil
+	}
+	if !remoteAllowHosts[host] {
+		return fmt.Errorf("主机 %s 不在允许访问的列表中", host)
+	}
+	return nil
+}
+
+// isRemoteSource 判断源文件路径是否为http或https地址
+func isRemoteSource(src string) bool {
+	lower := strings.ToLower(src)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// resolveSourcePath 远程源文件不经过数据目录解析，只检查是否允许使用；本地路径与 resolveRequestPaths 相同
+func resolveSourcePath(c *gin.Context, src *string) bool {
+	if !isRemoteSource(*src) {
+		return resolveRequestPaths(c, src)
+	}
+
+	if remoteSourceMaxBytes == 0 {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  errRemoteSourceDisabled.Error(),
+		})
+		return false
+	}
+	u, err := url.Parse(*src)
+	if err != nil || u.Host == "" {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "无效的源文件地址: " + *src,
+		})
+		return false
+	}
+	if err := checkRemoteHost(u); err != nil {
+		c.JSON(http.StatusForbidden, Response{
+			Code: 403,
+			Msg:  err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+// fetchRequestSource src为http(s)地址时下载到临时文件，返回临时文件路径和删除它的cleanup；
+// 本地路径原样返回，cleanup为空操作。下载失败时返回400并返回false
+func fetchRequestSource(c *gin.Context, src string) (string, func(), bool) {
+	if !isRemoteSource(src) {
+		return src, func() {}, true
+	}
+
+	local, cleanup, err := fetchRemoteSource(c.Request.Context(), src)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "下载源文件失败: " + err.Error(),
+		})
+		return "", nil, false
+	}
+	return local, cleanup, true
+}
+
+// fetchRemoteSource 将http(s)地址的源文件下载到临时文件，检查大小、内容类型和第一个数据行的格式，
+// 返回临时文件路径和删除它的cleanup；出错时不留下临时文件
+func fetchRemoteSource(ctx context.Context, rawURL string) (string, func(), error) {
+	if remoteSourceMaxBytes == 0 {
+		return "", nil, errRemoteSourceDisabled
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, remoteSourceTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := checkRemoteHost(req.URL); err != nil {
+		return "", nil, err
+	}
+	resp, err := remoteClient.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("服务器返回 %s", resp.Status)
+	}
+	if err := checkRemoteContentType(resp.Header.Get("Content-Type")); err != nil {
+		return "", nil, err
+	}
+	if resp.ContentLength > remoteSourceMaxBytes {
+		return "", nil, fmt.Errorf("文件大小 %d 字节超过上限 %d 字节", resp.ContentLength, remoteSourceMaxBytes)
+	}
+
+	// 保留地址中的扩展名，便于排查临时文件
+	tmp, err := os.CreateTemp("", "ip2region-src-*"+path.Ext(req.URL.Path))
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { _ = os.Remove(tmp.Name()) }
+
+	n, err := io.Copy(tmp, io.LimitReader(resp.Body, remoteSourceMaxBytes+1))
+	if err == nil && n > remoteSourceMaxBytes {
+		err = fmt.Errorf("文件大小超过上限 %d 字节", remoteSourceMaxBytes)
+	}
+	if cErr := tmp.Close(); err == nil {
+		err = cErr
+	}
+	if err == nil {
+		err = checkSourceFormat(tmp.Name())
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	return tmp.Name(), cleanup, nil
+}
+
+// checkRemoteContentType 拒绝明显不是源文件的响应，例如登录页面或JSON错误信息
+func checkRemoteContentType(contentType string) error {
+	if contentType == "" {
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("无效的Content-Type: %s", contentType)
+	}
+	for _, t := range remoteSourceContentTypes {
+		if mediaType == t {
+			return nil
+		}
+	}
+	return fmt.Errorf("不支持的Content-Type: %s，支持: %s", mediaType, strings.Join(remoteSourceContentTypes, ", "))
+}
+
+// checkSourceFormat 检查文件中的第一个数据行是否为 起始IP|结束IP|地区 格式，完整的检查在生成时进行
+func checkSourceFormat(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for i := 0; scanner.Scan(); i++ {
+		if i >= remoteSourceSniffLines {
+			return nil
+		}
+
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\uFEFF"))
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if _, err := xdb.SegmentFrom(line); err != nil {
+			return fmt.Errorf("第%d行不是有效的IP段，文件不是源文件格式: %w", i+1, err)
+		}
+		return nil
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("文件不是源文件格式: %w", err)
+	}
+	return errors.New("文件中没有IP段数据")
+}
@@ -0,0 +1,119 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	"ip2region-web/xdb"
+)
+
+// 查询保留地址时的处理方式
+const (
+	reservedIPOff    = "off"    // 不检查，保留地址与其它地址一样查询
+	reservedIPFlag   = "flag"   // 正常查询，结果中标记reserved和reservedKind
+	reservedIPReject = "reject" // 拒绝查询，返回400
+)
+
+// 保留地址的类别，与统计中的计数一一对应
+var reservedIPKinds = []string{"private", "loopback", "link-local", "multicast", "unspecified", "broadcast"}
+
+var (
+	reservedIPMode atomic.Value // string
+
+	// 按类别统计的保留地址查询次数，与reservedIPKinds下标对应
+	reservedIPCounts = make([]int64, len(reservedIPKinds))
+)
+
+// errReservedIP 配置为reject时查询了保留地址
+var errReservedIP = errors.New("保留地址")
+
+// SetReservedIPMode 设置查询私有、回环、链路本地等保留地址时的处理方式：off, flag, reject
+func SetReservedIPMode(mode string) error {
+	switch mode {
+	case "", reservedIPOff:
+		mode = reservedIPOff
+	case reservedIPFlag, reservedIPReject:
+	default:
+		return fmt.Errorf("无效的保留地址处理方式: %s，可选值: off, flag, reject", mode)
+	}
+
+	reservedIPMode.Store(mode)
+	return nil
+}
+
+func getReservedIPMode() string {
+	if mode, ok := reservedIPMode.Load().(string); ok {
+		return mode
+	}
+	return reservedIPOff
+}
+
+// reservedIPKind 返回IP所属的保留地址类别，公网地址返回空字符串
+func reservedIPKind(ip uint32) string {
+	addr := net.IPv4(byte(ip>>24), byte(ip>>16), byte(ip>>8), byte(ip))
+	switch {
+	case addr.IsPrivate():
+		return "private"
+	case addr.IsLoopback():
+		return "loopback"
+	case addr.IsLinkLocalUnicast():
+		return "link-local"
+	case addr.IsMulticast():
+		return "multicast"
+	case addr.IsUnspecified():
+		return "unspecified"
+	case ip == 0xFFFFFFFF:
+		return "broadcast"
+	default:
+		return ""
+	}
+}
+
+// checkReservedIP 按配置检查并统计保留地址，返回保留地址类别；配置为reject时返回errReservedIP
+func checkReservedIP(ip uint32) (string, error) {
+	mode := getReservedIPMode()
+	if mode == reservedIPOff {
+		return "", nil
+	}
+
+	kind := reservedIPKind(ip)
+	if kind == "" {
+		return "", nil
+	}
+	for i, k := range reservedIPKinds {
+		if k == kind {
+			atomic.AddInt64(&reservedIPCounts[i], 1)
+			break
+		}
+	}
+
+	if mode == reservedIPReject {
+		return kind, fmt.Errorf("%w %s (%s) 不提供地区查询", errReservedIP, xdb.Long2IP(ip), kind)
+	}
+	return kind, nil
+}
+
+// reservedIPStats 返回保留地址的查询总次数和按类别的次数，没有查询过的类别省略
+func reservedIPStats() (int64, map[string]int64) {
+	var total int64
+	byKind := make(map[string]int64)
+	for i, kind := range reservedIPKinds {
+		if n := atomic.LoadInt64(&reservedIPCounts[i]); n > 0 {
+			byKind[kind] = n
+			total += n
+		}
+	}
+	return total, byKind
+}
+
+func resetReservedIPStats() {
+	for i := range reservedIPCounts {
+		atomic.StoreInt64(&reservedIPCounts[i], 0)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"ip2region-web/xdb"
+)
+
+// 自检断言：IP以及期望查询到的地区
+type selfTestCase struct {
+	line   int
+	ip     string
+	region string
+}
+
+// loadSelfTestCases 读取期望文件，每行格式为 `IP|地区`，空行和#开头的行忽略
+func loadSelfTestCases(expectFile string) ([]selfTestCase, error) {
+	handle, err := os.Open(expectFile)
+	if err != nil {
+		return nil, fmt.Errorf("打开自检期望文件失败: %w", err)
+	}
+	defer handle.Close()
+
+	var cases []selfTestCase
+	scanner := bufio.NewScanner(handle)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		ps := strings.SplitN(line, "|", 2)
+		if len(ps) != 2 {
+			return nil, fmt.Errorf("自检期望文件第%d行格式错误，应为 `IP|地区`: %s", lineNumber, line)
+		}
+		cases = append(cases, selfTestCase{line: lineNumber, ip: strings.TrimSpace(ps[0]), region: ps[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取自检期望文件失败: %w", err)
+	}
+
+	if len(cases) == 0 {
+		return nil, fmt.Errorf("自检期望文件中没有断言: %s", expectFile)
+	}
+
+	return cases, nil
+}
+
+// RunSelfTest 使用期望文件中的断言检查数据库，任一断言失败时返回错误，
+// 每个失败的断言都会输出日志，用于在启动时发现部署了错误的数据库
+func RunSelfTest(dbPath string, expectFile string) error {
+	cases, err := loadSelfTestCases(expectFile)
+	if err != nil {
+		return err
+	}
+
+	s, err := xdb.NewWithFileOnly(dbPath)
+	if err != nil {
+		return fmt.Errorf("加载自检数据库失败: %w", err)
+	}
+	defer s.Close()
+
+	failed := 0
+	for _, tc := range cases {
+		ip, err := xdb.IP2Long(tc.ip)
+		if err != nil {
+			return fmt.Errorf("自检期望文件第%d行: %w", tc.line, err)
+		}
+
+		region, _, err := s.Search(ip)
+		if err != nil {
+			failed++
+			log.Printf("自检失败 (第%d行): 查询 %s 出错: %v", tc.line, tc.ip, err)
+			continue
+		}
+
+		if region != tc.region {
+			failed++
+			log.Printf("自检失败 (第%d行): %s 期望 `%s`，实际 `%s`", tc.line, tc.ip, tc.region, region)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d/%d 条自检断言失败，数据库: %s", failed, len(cases), dbPath)
+	}
+
+	log.Printf("自检通过: %d 条断言，数据库: %s", len(cases), dbPath)
+	return nil
+}
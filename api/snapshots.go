@@ -0,0 +1,154 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 快照日期的格式
+const snapshotDateLayout = "2006-01-02"
+
+// 注册历史数据库快照的请求
+type RegisterSnapshotRequest struct {
+	Date       string `json:"date" binding:"required"`   // 快照日期，格式为 2006-01-02
+	DbPath     string `json:"dbPath" binding:"required"` // 快照的XDB文件
+	SearchMode string `json:"searchMode,omitempty"`      // 查询快照时的模式，默认file，不替换已加载的数据库
+}
+
+// 已注册的历史数据库快照
+type DbSnapshot struct {
+	Date       string `json:"date"`
+	DbPath     string `json:"dbPath"`
+	SearchMode string `json:"searchMode"`
+}
+
+var (
+	// 按日期升序排列的快照列表，同一日期只保留最后一次注册的快照
+	dbSnapshots     []DbSnapshot
+	dbSnapshotsLock sync.RWMutex
+)
+
+// parseSnapshotDate 校验日期格式，返回规范化的日期字符串，规范化后可以直接按字符串比较先后
+func parseSnapshotDate(date string) (string, error) {
+	t, err := time.Parse(snapshotDateLayout, date)
+	if err != nil {
+		return "", fmt.Errorf("无效的日期: %s，格式应为 %s", date, snapshotDateLayout)
+	}
+	return t.Format(snapshotDateLayout), nil
+}
+
+// registerSnapshot 注册或替换指定日期的快照
+func registerSnapshot(snapshot DbSnapshot) {
+	dbSnapshotsLock.Lock()
+	defer dbSnapshotsLock.Unlock()
+
+	i := sort.Search(len(dbSnapshots), func(i int) bool {
+		return dbSnapshots[i].Date >= snapshot.Date
+	})
+	if i < len(dbSnapshots) && dbSnapshots[i].Date == snapshot.Date {
+		dbSnapshots[i] = snapshot
+		return
+	}
+
+	dbSnapshots = append(dbSnapshots, DbSnapshot{})
+	copy(dbSnapshots[i+1:], dbSnapshots[i:])
+	dbSnapshots[i] = snapshot
+}
+
+// resolveSnapshot 返回日期不晚于date的最近一个快照
+func resolveSnapshot(date string) (DbSnapshot, error) {
+	date, err := parseSnapshotDate(date)
+	if err != nil {
+		return DbSnapshot{}, err
+	}
+
+	dbSnapshotsLock.RLock()
+	defer dbSnapshotsLock.RUnlock()
+
+	i := sort.Search(len(dbSnapshots), func(i int) bool {
+		return dbSnapshots[i].Date > date
+	})
+	if i == 0 {
+		return DbSnapshot{}, fmt.Errorf("没有日期不晚于 %s 的数据库快照", date)
+	}
+	return dbSnapshots[i-1], nil
+}
+
+// listSnapshots 返回快照列表的副本
+func listSnapshots() []DbSnapshot {
+	dbSnapshotsLock.RLock()
+	defer dbSnapshotsLock.RUnlock()
+	return append([]DbSnapshot{}, dbSnapshots...)
+}
+
+// RegisterSnapshot 注册历史数据库快照，/api/search 指定date时查询日期不晚于date的最近一个快照
+func RegisterSnapshot(c *gin.Context) {
+	var req RegisterSnapshotRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	if !resolveRequestPaths(c, &req.DbPath) {
+		return
+	}
+
+	date, err := parseSnapshotDate(req.Date)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  err.Error(),
+		})
+		return
+	}
+
+	if req.SearchMode == "" {
+		req.SearchMode = "file"
+	}
+	if err := validateSearchTarget(req.DbPath, req.SearchMode); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  err.Error(),
+		})
+		return
+	}
+
+	if info, err := os.Stat(req.DbPath); err != nil || info.IsDir() {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "快照文件不存在: " + req.DbPath,
+		})
+		return
+	}
+
+	snapshot := DbSnapshot{Date: date, DbPath: req.DbPath, SearchMode: req.SearchMode}
+	registerSnapshot(snapshot)
+
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "快照注册成功",
+		Data: snapshot,
+	})
+}
+
+// ListSnapshots 列出已注册的历史数据库快照，按日期升序
+func ListSnapshots(c *gin.Context) {
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "获取快照列表成功",
+		Data: listSnapshots(),
+	})
+}
@@ -0,0 +1,110 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"ip2region-web/xdb"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 返回的子段数量上限，覆盖整个地址空间的段会拆分为65536个子段，超出部分只检查和计数
+const splitPreviewMaxParts = 4096
+
+// 预览段拆分请求
+type SplitPreviewRequest struct {
+	Segment string `json:"segment" binding:"required"` // 起始IP|结束IP|地区
+}
+
+// 拆分后的子段，即生成XDB时写入段索引的一条记录
+type SplitPart struct {
+	StartIP string `json:"startIp"`
+	EndIP   string `json:"endIp"`
+	Region  string `json:"region"`
+	IPCount uint64 `json:"ipCount"`
+	Cell    string `json:"cell"` // 子段所在的向量索引单元格，例如 1.0.0.0/16
+}
+
+// 预览段拆分结果
+type SplitPreviewResult struct {
+	Segment   string      `json:"segment"` // 规范化后的输入段
+	IPCount   uint64      `json:"ipCount"`
+	Count     int         `json:"count"` // 子段数量，即生成时写入的段索引条数
+	Parts     []SplitPart `json:"parts"`
+	Truncated bool        `json:"truncated,omitempty"` // 子段数量超过上限，parts只包含前面的部分
+
+	// 子段首尾相接、都不跨向量索引单元格且正好覆盖输入段时为true，否则problems说明原因
+	Valid    bool     `json:"valid"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+// PreviewSplit 按生成XDB时相同的规则拆分一个段，返回写入段索引的各个子段，不生成任何文件
+func PreviewSplit(c *gin.Context) {
+	var req SplitPreviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	seg, err := xdb.SegmentFrom(req.Segment)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "IP段格式错误: " + err.Error(),
+		})
+		return
+	}
+
+	result := SplitPreviewResult{
+		Segment: seg.String(),
+		IPCount: uint64(seg.EndIP) - uint64(seg.StartIP) + 1,
+	}
+
+	// 逐个检查子段，拆分逻辑有问题时指出第一个出错的位置
+	parts := seg.Split()
+	next := uint64(seg.StartIP)
+	for i, s := range parts {
+		if i < splitPreviewMaxParts {
+			result.Parts = append(result.Parts, SplitPart{
+				StartIP: xdb.Long2IP(s.StartIP),
+				EndIP:   xdb.Long2IP(s.EndIP),
+				Region:  s.Region,
+				IPCount: uint64(s.EndIP) - uint64(s.StartIP) + 1,
+				Cell:    fmt.Sprintf("%d.%d.0.0/16", s.StartIP>>24, (s.StartIP>>16)&0xFF),
+			})
+		}
+
+		switch {
+		case uint64(s.StartIP) != next:
+			result.Problems = append(result.Problems, fmt.Sprintf("第%d个子段应从 %s 开始，实际为 %s", i+1, xdb.Long2IP(uint32(next)), xdb.Long2IP(s.StartIP)))
+		case s.StartIP > s.EndIP:
+			result.Problems = append(result.Problems, fmt.Sprintf("第%d个子段起始IP大于结束IP", i+1))
+		case s.StartIP>>16 != s.EndIP>>16:
+			result.Problems = append(result.Problems, fmt.Sprintf("第%d个子段跨越了多个向量索引单元格", i+1))
+		case s.Region != seg.Region:
+			result.Problems = append(result.Problems, fmt.Sprintf("第%d个子段的地区与输入段不同", i+1))
+		}
+		next = uint64(s.EndIP) + 1
+	}
+	if next != uint64(seg.EndIP)+1 {
+		result.Problems = append(result.Problems, fmt.Sprintf("子段结束于 %s，输入段结束于 %s", xdb.Long2IP(uint32(next-1)), xdb.Long2IP(seg.EndIP)))
+	}
+
+	result.Count = len(parts)
+	result.Truncated = len(parts) > splitPreviewMaxParts
+	result.Valid = len(result.Problems) == 0
+
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "拆分完成",
+		Data: result,
+	})
+}
@@ -0,0 +1,140 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"math"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 服务启动时间，用于计算运行时长
+var serverStartTime = time.Now()
+
+// 上次重置统计计数器的时间，存储time.Time
+var statsResetAt atomic.Value
+
+// 已加载数据库的概要信息
+type StatsDatabase struct {
+	Loaded     bool   `json:"loaded"`
+	DbPath     string `json:"dbPath"`
+	SearchMode string `json:"searchMode"`
+	Aliases    int    `json:"aliases"` // 已加载的别名数量
+}
+
+// 按状态统计的任务数量
+type StatsTasks struct {
+	Export   map[string]int `json:"export"`
+	Generate map[string]int `json:"generate"`
+}
+
+// 全局统计快照
+type StatsResult struct {
+	Searches        int64         `json:"searches"`
+	Errors          int64         `json:"errors"`
+	IoOps           int64         `json:"ioOps"`
+	AvgIoPerSearch  float64       `json:"avgIoPerSearch"` // ioOps/searches，保留两位小数
+	IndexSearches   int64         `json:"indexSearches"`  // 查找过段索引的次数，不包括缓存命中和未映射的/16网段
+	AvgIterations   float64       `json:"avgIterations"`  // 每次查找段索引的平均二分查找迭代次数，保留两位小数
+	ClientCancelled int64         `json:"clientCancelled"`
+	Database        StatsDatabase `json:"database"`
+	Tasks           StatsTasks    `json:"tasks"`
+	UptimeSeconds   float64       `json:"uptimeSeconds"`
+	StartTime       time.Time     `json:"startTime"`
+	CountersResetAt *time.Time    `json:"countersResetAt,omitempty"` // 未重置过时省略
+	CountersSeconds float64       `json:"countersSeconds"`           // 计数器覆盖的时长
+
+	// 保留地址的查询次数，开启-reserved-ip后统计，reject时同时计入errors
+	ReservedSearches int64            `json:"reservedSearches"`
+	ReservedByKind   map[string]int64 `json:"reservedByKind,omitempty"`
+}
+
+func countTasksByStatus() StatsTasks {
+	tasks := StatsTasks{Export: map[string]int{}, Generate: map[string]int{}}
+
+	exportTasksLock.RLock()
+	for _, task := range exportTasks {
+		tasks.Export[task.Status]++
+	}
+	exportTasksLock.RUnlock()
+
+	generateTasksLock.RLock()
+	for _, task := range generateTasks {
+		tasks.Generate[task.Status]++
+	}
+	generateTasksLock.RUnlock()
+
+	return tasks
+}
+
+// GetStats 返回全局查询计数器、已加载的数据库、运行时长和任务数量
+func GetStats(c *gin.Context) {
+	searches, errors, ioOps := GetSearchStats()
+
+	result := StatsResult{
+		Searches:        searches,
+		Errors:          errors,
+		IoOps:           ioOps,
+		ClientCancelled: GetClientCancelledCount(),
+		Tasks:           countTasksByStatus(),
+		UptimeSeconds:   math.Round(time.Since(serverStartTime).Seconds()),
+		StartTime:       serverStartTime,
+	}
+
+	result.ReservedSearches, result.ReservedByKind = reservedIPStats()
+
+	if searches > 0 {
+		result.AvgIoPerSearch = math.Round(float64(ioOps)/float64(searches)*100) / 100
+	}
+
+	indexSearches, iterations := GetIterationStats()
+	result.IndexSearches = indexSearches
+	if indexSearches > 0 {
+		result.AvgIterations = math.Round(float64(iterations)/float64(indexSearches)*100) / 100
+	}
+
+	countersSince := serverStartTime
+	if val := statsResetAt.Load(); val != nil {
+		resetAt := val.(time.Time)
+		result.CountersResetAt = &resetAt
+		countersSince = resetAt
+	}
+	result.CountersSeconds = math.Round(time.Since(countersSince).Seconds())
+
+	searcherLock.RLock()
+	if searcher != nil && isCachedMode(searcherMode) {
+		result.Database.Loaded = true
+		result.Database.DbPath = searcherPath
+		result.Database.SearchMode = searcherMode
+	}
+	result.Database.Aliases = len(searcherAliases)
+	searcherLock.RUnlock()
+
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "获取统计信息成功",
+		Data: result,
+	})
+}
+
+// ResetStats 将全局查询计数器清零
+func ResetStats(c *gin.Context) {
+	atomic.StoreInt64(&globalStats.totalSearches, 0)
+	atomic.StoreInt64(&globalStats.totalErrors, 0)
+	atomic.StoreInt64(&globalStats.totalIoOperations, 0)
+	atomic.StoreInt64(&globalStats.indexSearches, 0)
+	atomic.StoreInt64(&globalStats.totalIterations, 0)
+	atomic.StoreInt64(&globalStats.clientCancelled, 0)
+	resetReservedIPStats()
+	statsResetAt.Store(time.Now())
+
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "统计计数器已重置",
+	})
+}
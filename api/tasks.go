@@ -0,0 +1,244 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 任务列表查询参数
+type ListTasksRequest struct {
+	Type   string `form:"type"`   // export 或 generate，为空时列出所有类型
+	Status string `form:"status"` // pending, queued, processing, completed, failed，为空时不过滤
+	Offset int    `form:"offset"`
+	Size   int    `form:"size"` // 每页数量，默认50，最大1000
+}
+
+// 任务列表中的一项
+type TaskSummary struct {
+	TaskID        string    `json:"taskId"`
+	Type          string    `json:"type"` // export 或 generate
+	Status        string    `json:"status"`
+	Progress      float64   `json:"progress"`
+	QueuePosition int       `json:"queuePosition,omitempty"` // 排队中的生成任务的位置
+	StartTime     time.Time `json:"startTime"`
+	EndTime       time.Time `json:"endTime"`
+	ErrorMessage  string    `json:"errorMessage,omitempty"`
+}
+
+const (
+	listTasksDefaultSize = 50
+	listTasksMaxSize     = 1000
+)
+
+var taskStatuses = []string{"pending", "queued", "processing", "completed", "failed"}
+
+// collectTaskSummaries 在持有各自任务锁时复制任务摘要，不返回任务状态的指针，
+// 之后的过滤和排序不会与任务协程的更新竞争
+func collectTaskSummaries(taskType string) []TaskSummary {
+	var tasks []TaskSummary
+
+	if taskType == "" || taskType == "export" {
+		exportTasksLock.RLock()
+		for _, task := range exportTasks {
+			tasks = append(tasks, TaskSummary{
+				TaskID:       task.TaskID,
+				Type:         "export",
+				Status:       task.Status,
+				Progress:     task.Progress,
+				StartTime:    task.StartTime,
+				EndTime:      task.EndTime,
+				ErrorMessage: task.ErrorMessage,
+			})
+		}
+		exportTasksLock.RUnlock()
+	}
+
+	if taskType == "" || taskType == "generate" {
+		generateTasksLock.RLock()
+		for _, task := range generateTasks {
+			tasks = append(tasks, TaskSummary{
+				TaskID:        task.TaskID,
+				Type:          "generate",
+				Status:        task.Status,
+				Progress:      task.Progress,
+				QueuePosition: task.QueuePosition,
+				StartTime:     task.StartTime,
+				EndTime:       task.EndTime,
+				ErrorMessage:  task.ErrorMessage,
+			})
+		}
+		generateTasksLock.RUnlock()
+	}
+
+	return tasks
+}
+
+// ListTasks 分页列出导出和生成任务的摘要，按开始时间从新到旧排列
+func ListTasks(c *gin.Context) {
+	var req ListTasksRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	if req.Type != "" && req.Type != "export" && req.Type != "generate" {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  fmt.Sprintf("不支持的任务类型: %s，支持的类型: export, generate", req.Type),
+		})
+		return
+	}
+
+	if req.Status != "" && !slices.Contains(taskStatuses, req.Status) {
+		c.JSON(http.StatusBadRequest, Response{
+			Code: 400,
+			Msg:  fmt.Sprintf("不支持的任务状态: %s，支持的状态: pending, queued, processing, completed, failed", req.Status),
+		})
+		return
+	}
+
+	if req.Size <= 0 {
+		req.Size = listTasksDefaultSize
+	}
+	req.Size = min(req.Size, listTasksMaxSize)
+
+	tasks := collectTaskSummaries(req.Type)
+	if req.Status != "" {
+		tasks = slices.DeleteFunc(tasks, func(t TaskSummary) bool {
+			return t.Status != req.Status
+		})
+	}
+
+	// 开始时间相同时按任务ID排序，保证翻页时顺序稳定
+	sort.Slice(tasks, func(i, j int) bool {
+		if !tasks[i].StartTime.Equal(tasks[j].StartTime) {
+			return tasks[i].StartTime.After(tasks[j].StartTime)
+		}
+		return tasks[i].TaskID < tasks[j].TaskID
+	})
+
+	// offset限制在[0, total)范围内，超出范围时返回空页
+	total := len(tasks)
+	req.Offset = max(min(req.Offset, total), 0)
+	end := min(req.Offset+req.Size, total)
+	page := append([]TaskSummary{}, tasks[req.Offset:end]...)
+
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "获取任务列表成功",
+		Data: gin.H{
+			"offset":     req.Offset,
+			"size":       req.Size,
+			"total":      total,
+			"tasks":      page,
+			"hasMore":    end < total,
+			"nextOffset": end,
+		},
+	})
+}
+
+// 取消所有任务的结果
+type CancelAllTasksResult struct {
+	Cancelled         int      `json:"cancelled"`         // 取消的任务总数
+	ExportCancelled   int      `json:"exportCancelled"`   // 取消的导出任务数量
+	GenerateCancelled int      `json:"generateCancelled"` // 取消的生成任务数量，包括排队中的任务
+	TaskIDs           []string `json:"taskIds"`
+}
+
+// signalExportCancel 关闭导出任务的取消通道并从表中移除，同一任务只会关闭一次，返回是否关闭了通道
+func signalExportCancel(taskID string) bool {
+	exportTasksLock.Lock()
+	defer exportTasksLock.Unlock()
+
+	ch, exists := cancelChans[taskID]
+	if exists {
+		delete(cancelChans, taskID)
+		close(ch)
+	}
+	return exists
+}
+
+// signalGenerateCancel 关闭生成任务的取消通道并从表中移除，同一任务只会关闭一次，返回是否关闭了通道
+func signalGenerateCancel(taskID string) bool {
+	generateTasksLock.Lock()
+	defer generateTasksLock.Unlock()
+
+	ch, exists := generateCancelChans[taskID]
+	if exists {
+		delete(generateCancelChans, taskID)
+		close(ch)
+	}
+	return exists
+}
+
+// CancelAllTasks 取消所有未结束的导出和生成任务，用于紧急情况下停止所有后台任务。
+// 先在持有各自任务锁时把状态改为failed，再在锁外关闭取消通道，重复调用不会重复取消
+func CancelAllTasks(c *gin.Context) {
+	const msg = "操作员取消了所有任务"
+	now := time.Now()
+
+	var exportIDs []string
+	exportTasksLock.Lock()
+	for id, task := range exportTasks {
+		if task.Status == "pending" || task.Status == "processing" {
+			task.Status = "failed"
+			task.ErrorMessage = msg
+			task.EndTime = now
+			exportIDs = append(exportIDs, id)
+		}
+	}
+	exportTasksLock.Unlock()
+
+	var generateIDs []string
+	generateTasksLock.Lock()
+	for id, task := range generateTasks {
+		if task.Status == "pending" || task.Status == "queued" || task.Status == "processing" {
+			task.Status = "failed"
+			task.ErrorMessage = msg
+			task.EndTime = now
+			generateIDs = append(generateIDs, id)
+		}
+	}
+	generateTasksLock.Unlock()
+
+	for _, id := range exportIDs {
+		signalExportCancel(id)
+	}
+	for _, id := range generateIDs {
+		signalGenerateCancel(id)
+	}
+
+	result := CancelAllTasksResult{
+		Cancelled:         len(exportIDs) + len(generateIDs),
+		ExportCancelled:   len(exportIDs),
+		GenerateCancelled: len(generateIDs),
+		TaskIDs:           append(exportIDs, generateIDs...),
+	}
+	sort.Strings(result.TaskIDs)
+	if result.TaskIDs == nil {
+		result.TaskIDs = []string{}
+	}
+
+	if result.Cancelled > 0 {
+		log.Printf("已取消所有任务: %d 个导出任务，%d 个生成任务", result.ExportCancelled, result.GenerateCancelled)
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Code: 0,
+		Msg:  "已取消所有未结束的任务",
+		Data: result,
+	})
+}
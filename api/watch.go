@@ -0,0 +1,163 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"ip2region-web/xdb"
+)
+
+// 替换后旧搜索器延迟关闭的时间，让替换前已取到旧搜索器的查询能够完成
+const reloadCloseDelay = 10 * time.Second
+
+// 文件监视状态：监视的是已加载数据库所在的目录，
+// 以便覆盖生成等先写临时文件再重命名的发布方式
+var (
+	fileWatcher   *fsnotify.Watcher
+	watchDebounce time.Duration
+	watchLock     sync.Mutex
+	watchDir      string      // 当前监视的目录，由watchLock保护
+	watchTimer    *time.Timer // 去抖定时器，由watchLock保护
+)
+
+// EnableWatch 开启文件监视，已加载的数据库文件被写入或替换后，
+// 等待debounce时间内没有新的变化再重新加载并替换全局搜索器
+func EnableWatch(debounce time.Duration) error {
+	if debounce <= 0 {
+		return fmt.Errorf("去抖时间必须大于0: %v", debounce)
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("创建文件监视失败: %w", err)
+	}
+
+	fileWatcher = w
+	watchDebounce = debounce
+	go runWatchLoop(w)
+	return nil
+}
+
+// retargetWatch 已加载的数据库变化时切换监视的目录，未开启文件监视时不做任何事
+func retargetWatch(dbPath string) {
+	if fileWatcher == nil {
+		return
+	}
+
+	dir := filepath.Dir(filepath.Clean(dbPath))
+
+	watchLock.Lock()
+	defer watchLock.Unlock()
+
+	if dir == watchDir {
+		return
+	}
+
+	if watchDir != "" {
+		fileWatcher.Remove(watchDir)
+	}
+	watchDir = ""
+
+	if err := fileWatcher.Add(dir); err != nil {
+		log.Printf("警告: 监视目录 %s 失败，数据库文件变化后不会自动重新加载: %v", dir, err)
+		return
+	}
+	watchDir = dir
+}
+
+func runWatchLoop(w *fsnotify.Watcher) {
+	for {
+		select {
+		case event, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) && !event.Has(fsnotify.Rename) {
+				continue
+			}
+
+			searcherLock.RLock()
+			loaded := searcher != nil && isCachedMode(searcherMode) && filepath.Clean(searcherPath) == filepath.Clean(event.Name)
+			searcherLock.RUnlock()
+			if loaded {
+				scheduleReload()
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("警告: 文件监视出错: %v", err)
+		}
+	}
+}
+
+// scheduleReload 连续的变化事件只触发一次重新加载
+func scheduleReload() {
+	watchLock.Lock()
+	defer watchLock.Unlock()
+
+	if watchTimer != nil {
+		watchTimer.Stop()
+	}
+	watchTimer = time.AfterFunc(watchDebounce, reloadLoadedSearcher)
+}
+
+// reloadLoadedSearcher 在锁外按原来的模式加载新的搜索器，成功后再替换全局搜索器，
+// 加载期间查询继续使用旧的搜索器；加载失败时保留旧的搜索器
+func reloadLoadedSearcher() {
+	searcherLock.RLock()
+	dbPath, mode := searcherPath, searcherMode
+	loaded := searcher != nil && isCachedMode(mode)
+	searcherLock.RUnlock()
+	if !loaded {
+		return
+	}
+
+	// 重命名发布时事件可能早于新文件出现，文件不存在时等待下一次事件
+	fileInfo, err := os.Stat(dbPath)
+	if err != nil {
+		log.Printf("警告: 数据库文件 %s 变化后无法访问，继续使用已加载的数据: %v", dbPath, err)
+		return
+	}
+
+	tStart := time.Now()
+	var s *xdb.Searcher
+	switch mode {
+	case "vector":
+		s, err = xdb.NewSearcherWithVectorIndex(dbPath)
+	case "hybrid":
+		s, err = xdb.NewSearcherWithHybridMode(dbPath)
+	case "memory":
+		s, err = xdb.NewSearcherWithMemoryMode(dbPath)
+	}
+	if err != nil {
+		log.Printf("警告: 重新加载数据库 %s 失败，继续使用已加载的数据: %v", dbPath, err)
+		return
+	}
+
+	searcherLock.Lock()
+	// 加载期间切换或卸载了数据库时放弃这次重新加载
+	if searcher == nil || searcherPath != dbPath || searcherMode != mode {
+		searcherLock.Unlock()
+		s.Close()
+		return
+	}
+	old := searcher
+	searcher = s
+	searcherFileInfo = fileInfo
+	invalidateSearchCache()
+	searcherLock.Unlock()
+
+	time.AfterFunc(reloadCloseDelay, func() { old.Close() })
+	log.Printf("数据库文件 %s 已变化，已按%s模式重新加载，耗时 %v", dbPath, mode, time.Since(tStart))
+}
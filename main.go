@@ -5,16 +5,21 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"net/http/pprof"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
 	"ip2region-web/api"
+	"ip2region-web/xdb"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -23,91 +28,337 @@ import (
 var (
 	port       = flag.Int("port", 8080, "Web服务监听端口")
 	staticPath = flag.String("static", "./frontend/dist", "前端静态文件目录")
+
+	searchCacheSize = flag.Int("search-cache-size", 0, "查询结果LRU缓存容量，0表示不缓存")
+	exportBufferKB  = flag.Int("export-buffer-kb", 4096, "导出写文件缓冲区大小(KB)，取值64-65536")
+	exportStep      = flag.Int("export-step", 256, "导出扫描步长(IP数)，取值为1-65536之间的2的幂")
+	exportRetries   = flag.Int("export-retries", 3, "导出扫描时单个IP查询失败的重试次数，0表示不重试")
+	callbackSecret  = flag.String("callback-secret", "", "任务完成回调的HMAC-SHA256签名密钥，为空时回调不签名")
+	defaultMode     = flag.String("default-search-mode", "file", "请求未指定searchMode且没有可复用的已加载数据库时使用的模式：file, file-vector, vector, hybrid, memory")
+	editMaxLines    = flag.Int("edit-file-max-lines", 5000000, "从文件批量编辑时补丁文件的最大行数，0表示不限制")
+	editTimeout     = flag.Duration("edit-file-timeout", 10*time.Minute, "从文件批量编辑的最长执行时间，超时后已应用的段会保留，0表示不限制")
+	defaultRegion   = flag.String("default-region", "", "查询未命中任何段时返回的地区信息，例如 UNKNOWN|||，为空时返回空地区")
+	genWorkers      = flag.Int("generate-workers", 2, "同时执行的生成任务数量，超出的生成请求排队等待，小于1时按1处理")
+	genThroughput   = flag.Float64("generate-throughput", 0, "预估生成耗时使用的初始生成速度(段/秒)，0表示使用内置默认值，完成的生成会继续校准")
+	editStreamIdle  = flag.Duration("edit-stream-idle-timeout", time.Minute, "流式编辑时等待请求体数据的最长时间，超时后结束任务，0表示不限制")
+	maxSegments     = flag.Int("max-segments", xdb.DefaultMaxSegments, "生成XDB和编辑时从源文件加载的最大IP段数量，超过时拒绝加载，0表示不限制")
+	strictVector    = flag.Bool("strict-vector-index", false, "向量索引预加载失败时拒绝加载数据库，默认记录警告并改为从文件读取向量索引")
+	selfTest        = flag.String("self-test", "", "启动自检的期望文件，每行为 `IP|地区`，任一断言失败时拒绝启动")
+	selfTestDb      = flag.String("self-test-db", "", "启动自检使用的XDB数据库文件")
+	watchDb         = flag.Bool("watch", false, "监视已加载的数据库文件，文件被写入或替换后自动重新加载")
+	watchDebounce   = flag.Duration("watch-debounce", 500*time.Millisecond, "文件监视的去抖时间，连续的变化在该时间内只触发一次重新加载")
+	regionFields    = flag.String("region-fields", "", "地区信息按 | 分隔的字段名称，逗号分隔，例如 国家,区域,省份,城市,ISP；配置后查询可以按字段名选择字段")
+	fileMode        = flag.String("file-mode", "0644", "新建的XDB、导出和补丁文件的八进制权限，实际权限还会去掉进程的umask；替换已有文件时保留原文件的权限，但不超过该权限")
+	reservedIP      = flag.String("reserved-ip", "off", "查询私有、回环、链路本地、组播等保留地址时的处理方式：off 不检查，flag 在结果中标记reserved，reject 返回400；开启后单独统计保留地址的查询次数")
+	pprofEnabled    = flag.Bool("pprof", false, "在 /debug/pprof 下提供性能分析接口，与管理接口使用同样的来源地址过滤，默认关闭")
+	dataRoot        = flag.String("data-root", "", "数据目录，设置后请求中的文件路径都相对于该目录解析，越界的路径返回403")
+	remoteMaxMB     = flag.Int("remote-source-max-mb", 0, "从http(s)地址下载源文件的大小上限(MB)，默认0表示不允许使用远程源文件")
+	remoteTimeout   = flag.Duration("remote-source-timeout", 5*time.Minute, "下载远程源文件的超时时间")
+	tlsCert         = flag.String("tls-cert", "", "HTTPS证书文件(PEM)，与-tls-key同时指定时使用HTTPS，文件被替换后自动重新加载")
+	tlsKey          = flag.String("tls-key", "", "HTTPS私钥文件(PEM)")
+
+	corsOrigins    stringSliceFlag
+	fallbackDbs    stringSliceFlag
+	adminAllow     stringSliceFlag
+	adminDeny      stringSliceFlag
+	trustedProxies stringSliceFlag
+	remoteHosts    stringSliceFlag
 )
 
-// 设置路由
-func setupRouter() *gin.Engine {
-	r := gin.Default()
+func init() {
+	flag.Var(&corsOrigins, "cors-origin", "允许跨域访问的来源，可重复指定，\"*\"表示允许所有来源；未指定时只允许localhost")
+	flag.Var(&fallbackDbs, "fallback-db", "后备XDB数据库路径，可重复指定，主数据库未命中时按顺序查询")
+	flag.Var(&adminAllow, "admin-allow", "允许访问管理接口的来源CIDR，可重复指定；未指定时允许所有来源")
+	flag.Var(&adminDeny, "admin-deny", "禁止访问管理接口的来源CIDR，可重复指定，优先于-admin-allow")
+	flag.Var(&remoteHosts, "remote-allow-host", "允许下载远程源文件和任务完成回调的主机名，可重复指定；未指定时不限制主机，但设置了-data-root时拒绝所有远程地址")
+	flag.Var(&trustedProxies, "trusted-proxy", "信任的反向代理地址或CIDR，可重复指定；只有来自这些代理的请求才使用X-Forwarded-For识别来源地址")
+}
 
-	// 跨域中间件
-	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
-	}))
+// 可重复指定的字符串命令行参数
+type stringSliceFlag []string
 
-	// 静态文件服务
-	if _, err := os.Stat(*staticPath); !os.IsNotExist(err) {
-		// 先注册API路由组
-		apiGroup := r.Group("/api")
-		{
-			// IP搜索
-			apiGroup.POST("/search", api.SearchIP)
+func (f *stringSliceFlag) String() string {
+	return strings.Join(*f, ",")
+}
 
-			// 加载XDB文件到内存 - 支持两种路径格式
-			apiGroup.POST("/load-xdb", api.LoadXdbToMemory)
+func (f *stringSliceFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
 
-			// 获取XDB文件加载状态
-			apiGroup.GET("/xdb-status", api.GetXdbStatus)
+// 判断来源是否为本机地址
+func isLocalOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
 
-			// 卸载内存中的XDB文件
-			apiGroup.POST("/unload-xdb", api.UnloadXdb)
+	host := u.Hostname()
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
 
-			// 导出XDB文件到文本文件
-			apiGroup.POST("/export-xdb", api.ExportXdb)
+// checkCorsOrigins 检查-cors-origin的取值，每个来源为 "*" 或 协议://主机[:端口]，
+// 没有协议的来源会让跨域中间件在启动时panic，在这里给出明确的错误
+func checkCorsOrigins(origins []string) error {
+	for _, origin := range origins {
+		if origin == "*" {
+			continue
+		}
 
-			// 获取导出任务状态
-			apiGroup.GET("/export-task/:taskId", api.GetExportTaskStatusHandler)
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("无效的来源 %q，应为 \"*\" 或 协议://主机[:端口]，例如 https://example.com", origin)
+		}
+		if u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+			return fmt.Errorf("无效的来源 %q，来源只包含协议、主机和端口，不能有路径 (包括末尾的 /) 或参数", origin)
+		}
+	}
+	return nil
+}
 
-			// 取消导出任务
-			apiGroup.POST("/export-task/:taskId/cancel", api.CancelExportTask)
+// 根据命令行参数生成跨域配置
+// 通配符来源不能与凭证同时使用，只有指定了具体来源列表时才允许携带凭证
+func corsConfig() cors.Config {
+	config := cors.Config{
+		AllowMethods:  []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowHeaders:  []string{"Origin", "Content-Type"},
+		ExposeHeaders: []string{"Content-Length"},
+		MaxAge:        12 * time.Hour,
+	}
 
-			// 异步生成数据库（带进度显示）
-			apiGroup.POST("/generate-with-progress", api.GenerateDbWithProgress)
+	switch {
+	case len(corsOrigins) == 0:
+		config.AllowOriginFunc = isLocalOrigin
+	case slices.Contains(corsOrigins, "*"):
+		config.AllowAllOrigins = true
+	default:
+		config.AllowOrigins = corsOrigins
+		config.AllowCredentials = true
+	}
 
-			// 获取生成任务状态
-			apiGroup.GET("/generate-task/:taskId", api.GetGenerateTaskStatusHandler)
+	return config
+}
 
-			// 取消生成任务
-			apiGroup.POST("/generate-task/:taskId/cancel", api.CancelGenerateTask)
+// 注册API路由，静态文件目录存在与否都使用同一套路由
+func registerAPIRoutes(r *gin.Engine) {
+	apiGroup := r.Group("/api")
 
-			// 数据库生成
-			apiGroup.POST("/generate", api.GenerateDb)
+	// 管理接口按来源地址过滤，查询类接口不受限制
+	adminGroup := apiGroup.Group("", api.AdminAccess())
 
-			// 查询任务状态（新增）
-			apiGroup.GET("/task/:taskId", api.GetTaskStatus)
+	// OpenAPI接口文档，根据已注册的路由生成
+	apiGroup.GET("/openapi.json", api.OpenAPISpec(r.Routes))
 
-			// 编辑IP段
-			apiGroup.POST("/edit/segment", api.EditSegment)
+	// IP搜索
+	apiGroup.POST("/search", api.SearchIP)
 
-			// PUT方法编辑IP段
-			apiGroup.PUT("/edit/segment", api.EditSegment)
+	// 批量IP搜索
+	apiGroup.POST("/search/batch", api.SearchIPBatch)
 
-			// 从文件编辑IP段
-			apiGroup.POST("/edit/file", api.EditFromFile)
+	// IP是否被数据库覆盖，不读取地区数据
+	apiGroup.POST("/contains", api.ContainsIP)
 
-			// 列出IP段
-			apiGroup.POST("/list/segments", api.ListSegments)
+	// 查询CIDR网段内的地区分布
+	apiGroup.POST("/search/cidrs", api.SearchCIDRs)
 
-			// 保存编辑
-			apiGroup.POST("/edit/save", api.SaveEdit)
+	// 统计IP范围内各地区覆盖的IP数量
+	apiGroup.POST("/search/histogram", api.SearchRegionHistogram)
 
-			// 保存编辑并生成xdb文件
-			apiGroup.POST("/edit/saveAndGenerate", api.SaveAndGenerateDb)
+	// 查询IP所在段及其前后相邻的段
+	apiGroup.POST("/search/neighbors", api.SearchNeighbors)
 
-			// 获取当前编辑的源文件信息
-			apiGroup.GET("/edit/current-file", api.GetCurrentEditFile)
+	// 查询性能基准测试
+	adminGroup.POST("/benchmark", api.Benchmark)
 
-			// 卸载当前编辑的源文件
-			apiGroup.POST("/edit/unload-file", api.UnloadEditFile)
+	// 加载XDB文件到内存 - 支持两种路径格式
+	adminGroup.POST("/load-xdb", api.LoadXdbToMemory)
 
-			// 新增调试接口
-			apiGroup.GET("/debug/status", api.GetDebugStatus)
-			apiGroup.POST("/force-load-memory", api.ForceLoadToMemory)
-		}
+	// 获取XDB文件加载状态
+	apiGroup.GET("/xdb-status", api.GetXdbStatus)
+
+	// 全局查询统计
+	apiGroup.GET("/stats", api.GetStats)
+
+	// 重置全局查询统计
+	adminGroup.POST("/stats/reset", api.ResetStats)
+
+	// 向量索引单元格占用统计
+	adminGroup.POST("/vector-occupancy", api.VectorOccupancy)
+
+	// 注册历史数据库快照
+	adminGroup.POST("/snapshots/register", api.RegisterSnapshot)
+
+	// 列出已注册的历史数据库快照
+	adminGroup.GET("/snapshots", api.ListSnapshots)
+
+	// 卸载内存中的XDB文件
+	adminGroup.POST("/unload-xdb", api.UnloadXdb)
+
+	// 清空缓冲区池和缓存并回收内存
+	adminGroup.POST("/gc", api.RunGC)
+
+	// 导出XDB文件到文本文件
+	adminGroup.POST("/export-xdb", api.ExportXdb)
+
+	// XDB文件同步转换为源文本（仅限小文件）
+	adminGroup.POST("/convert", api.ConvertXdb)
+
+	// 导出两个XDB之间变化的段
+	adminGroup.POST("/export-delta", api.ExportDelta)
+
+	// 生成两个XDB之间的二进制补丁
+	adminGroup.POST("/export-patch", api.ExportPatch)
+
+	// 将二进制补丁应用到基准XDB
+	adminGroup.POST("/apply-patch", api.ApplyPatch)
+
+	// 按段索引顺序重写XDB的地区数据
+	adminGroup.POST("/optimize", api.OptimizeXdb)
+
+	// 计算文件的SHA-256
+	adminGroup.GET("/checksum", api.Checksum)
+
+	// 校验XDB文件记录的源文件SHA-256
+	adminGroup.POST("/verify-source", api.VerifySource)
+
+	// 获取导出任务状态
+	adminGroup.GET("/export-task/:taskId", api.GetExportTaskStatusHandler)
+
+	// 分页列出导出和生成任务
+	adminGroup.GET("/tasks", api.ListTasks)
+
+	// 取消所有未结束的导出和生成任务
+	adminGroup.POST("/tasks/cancel-all", api.CancelAllTasks)
+
+	// 取消导出任务
+	adminGroup.POST("/export-task/:taskId/cancel", api.CancelExportTask)
+
+	// 异步生成数据库（带进度显示）
+	adminGroup.POST("/generate-with-progress", api.GenerateDbWithProgress)
+
+	// 获取生成任务状态
+	adminGroup.GET("/generate-task/:taskId", api.GetGenerateTaskStatusHandler)
+
+	// 取消生成任务
+	adminGroup.POST("/generate-task/:taskId/cancel", api.CancelGenerateTask)
+
+	// 数据库生成
+	adminGroup.POST("/generate", api.GenerateDb)
+
+	// 预估生成XDB的耗时
+	adminGroup.POST("/generate/estimate", api.EstimateGenerate)
+
+	// 查询任务状态
+	adminGroup.GET("/task/:taskId", api.GetTaskStatus)
+
+	// 编辑IP段
+	adminGroup.POST("/edit/segment", api.EditSegment)
+
+	// PUT方法编辑IP段
+	adminGroup.PUT("/edit/segment", api.EditSegment)
+
+	// 按IP修改所在段的地区
+	adminGroup.POST("/edit/segment-at", api.EditSegmentAt)
+
+	// 批量替换地区
+	adminGroup.POST("/edit/replace-region", api.ReplaceRegion)
+
+	// 从文件编辑IP段
+	adminGroup.POST("/edit/file", api.EditFromFile)
+
+	// 从NDJSON请求体流式编辑IP段
+	adminGroup.POST("/edit/stream", api.EditStream)
+
+	// 获取从文件批量编辑的任务状态
+	adminGroup.GET("/edit/file-task/:taskId", api.GetEditFileTaskStatusHandler)
+
+	// 列出IP段
+	adminGroup.POST("/list/segments", api.ListSegments)
+
+	// 查看未保存的编辑差异
+	adminGroup.GET("/edit/diff", api.EditDiff)
+
+	// 保存编辑
+	adminGroup.POST("/edit/save", api.SaveEdit)
+
+	// 整理源文件
+	adminGroup.POST("/edit/compact", api.CompactSource)
+
+	// 规范化乱序、重叠的源文件
+	adminGroup.POST("/edit/normalize", api.NormalizeSource)
+
+	// 校验IP段格式，不修改编辑器
+	adminGroup.POST("/validate/segment", api.ValidateSegment)
+
+	// 内联编辑，源文本随请求提交
+	adminGroup.POST("/edit/inline", api.EditInline)
+
+	// 保存编辑并生成xdb文件
+	adminGroup.POST("/edit/saveAndGenerate", api.SaveAndGenerateDb)
+
+	// 获取当前编辑的源文件信息
+	adminGroup.GET("/edit/current-file", api.GetCurrentEditFile)
+
+	// 卸载当前编辑的源文件
+	adminGroup.POST("/edit/unload-file", api.UnloadEditFile)
+
+	// 新增调试接口
+	adminGroup.GET("/debug/status", api.GetDebugStatus)
+	adminGroup.POST("/force-load-memory", api.ForceLoadToMemory)
+
+	// 预览生成XDB时一个段被拆分成的子段
+	adminGroup.POST("/debug/split", api.PreviewSplit)
+}
+
+// 注册性能分析接口，与管理接口使用同样的来源地址过滤。
+// 导入net/http/pprof会把处理函数注册到http.DefaultServeMux，服务只使用gin的路由，不会因此暴露
+func registerPprofRoutes(r *gin.Engine) {
+	debugGroup := r.Group("/debug/pprof", api.AdminAccess())
+
+	// 可用的profile列表
+	debugGroup.GET("/", gin.WrapF(pprof.Index))
+
+	// 命令行参数
+	debugGroup.GET("/cmdline", gin.WrapF(pprof.Cmdline))
+
+	// CPU profile，seconds参数指定采样秒数
+	debugGroup.GET("/profile", gin.WrapF(pprof.Profile))
+
+	// 按程序计数器查询函数名
+	debugGroup.GET("/symbol", gin.WrapF(pprof.Symbol))
+	debugGroup.POST("/symbol", gin.WrapF(pprof.Symbol))
+
+	// 执行追踪，seconds参数指定追踪秒数
+	debugGroup.GET("/trace", gin.WrapF(pprof.Trace))
+
+	// heap、goroutine等运行时profile
+	for _, name := range []string{"allocs", "block", "goroutine", "heap", "mutex", "threadcreate"} {
+		debugGroup.GET("/"+name, gin.WrapH(pprof.Handler(name)))
+	}
+}
+
+// 设置路由
+func setupRouter() *gin.Engine {
+	r := gin.Default()
+
+	// 默认不信任任何代理，来源地址不能通过X-Forwarded-For伪造
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatalf("信任的代理地址错误: %v", err)
+	}
+
+	// 跨域中间件
+	r.Use(cors.New(corsConfig()))
+
+	// 性能分析接口只在指定-pprof时注册
+	if *pprofEnabled {
+		registerPprofRoutes(r)
+		log.Printf("已启用性能分析接口 /debug/pprof/")
+	}
+
+	// 静态文件服务
+	if _, err := os.Stat(*staticPath); !os.IsNotExist(err) {
+		// 先注册API路由组
+		registerAPIRoutes(r)
 
 		// 然后再设置静态文件服务和NoRoute处理
 		// 使用前缀路由而非根路由
@@ -137,72 +388,7 @@ func setupRouter() *gin.Engine {
 		})
 	} else {
 		// API路由组 - 当静态文件不存在时仍需要注册API路由
-		apiGroup := r.Group("/api")
-		{
-			// IP搜索
-			apiGroup.POST("/search", api.SearchIP)
-
-			// 加载XDB文件到内存 - 支持两种路径格式
-			apiGroup.POST("/load-xdb", api.LoadXdbToMemory)
-
-			// 获取XDB文件加载状态
-			apiGroup.GET("/xdb-status", api.GetXdbStatus)
-
-			// 卸载内存中的XDB文件
-			apiGroup.POST("/unload-xdb", api.UnloadXdb)
-
-			// 导出XDB文件到文本文件
-			apiGroup.POST("/export-xdb", api.ExportXdb)
-
-			// 获取导出任务状态
-			apiGroup.GET("/export-task/:taskId", api.GetExportTaskStatusHandler)
-
-			// 取消导出任务
-			apiGroup.POST("/export-task/:taskId/cancel", api.CancelExportTask)
-
-			// 异步生成数据库
-			apiGroup.POST("/generate-with-progress", api.GenerateDbWithProgress)
-
-			// 获取生成任务状态
-			apiGroup.GET("/generate-task/:taskId", api.GetGenerateTaskStatusHandler)
-
-			// 取消生成任务
-			apiGroup.POST("/generate-task/:taskId/cancel", api.CancelGenerateTask)
-
-			// 数据库生成
-			apiGroup.POST("/generate", api.GenerateDb)
-
-			// 查询任务状态
-			apiGroup.GET("/task/:taskId", api.GetTaskStatus)
-
-			// 编辑IP段
-			apiGroup.POST("/edit/segment", api.EditSegment)
-
-			// PUT方法编辑IP段
-			apiGroup.PUT("/edit/segment", api.EditSegment)
-
-			// 从文件编辑IP段
-			apiGroup.POST("/edit/file", api.EditFromFile)
-
-			// 列出IP段
-			apiGroup.POST("/list/segments", api.ListSegments)
-
-			// 保存编辑
-			apiGroup.POST("/edit/save", api.SaveEdit)
-
-			// 保存编辑并生成xdb文件
-			apiGroup.POST("/edit/saveAndGenerate", api.SaveAndGenerateDb)
-
-			// 获取当前编辑的源文件信息
-			apiGroup.GET("/edit/current-file", api.GetCurrentEditFile)
-
-			// 卸载当前编辑的源文件
-			apiGroup.POST("/edit/unload-file", api.UnloadEditFile)
-
-			// 新增调试接口
-			apiGroup.GET("/debug/status", api.GetDebugStatus)
-			apiGroup.POST("/force-load-memory", api.ForceLoadToMemory)
-		}
+		registerAPIRoutes(r)
 	}
 
 	return r
@@ -215,6 +401,81 @@ func main() {
 	// 设置日志格式
 	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
 
+	// 配置查询未命中时的后备数据库
+	api.SetFallbackDbPaths(fallbackDbs)
+	api.SetSearchCacheSize(*searchCacheSize)
+	api.SetExportSearchRetries(*exportRetries)
+	api.SetCallbackSecret(*callbackSecret)
+	xdb.SetStrictVectorIndex(*strictVector)
+	xdb.SetMaxSegments(*maxSegments)
+	if mode, err := xdb.ParseFileMode(*fileMode); err != nil {
+		log.Fatalf("文件权限配置错误: %v", err)
+	} else if err := xdb.SetDefaultFileMode(mode); err != nil {
+		log.Fatalf("文件权限配置错误: %v", err)
+	}
+	api.SetEditFileLimits(*editMaxLines, *editTimeout)
+	api.SetEditStreamIdleTimeout(*editStreamIdle)
+	api.SetGenerateThroughput(*genThroughput)
+	api.SetGenerateWorkers(*genWorkers)
+	if *regionFields != "" {
+		if err := api.SetRegionFields(strings.Split(*regionFields, ",")); err != nil {
+			log.Fatalf("字段布局配置错误: %v", err)
+		}
+	}
+	if err := api.SetNotFoundRegion(*defaultRegion); err != nil {
+		log.Fatalf("默认地区配置错误: %v", err)
+	}
+	if err := api.SetReservedIPMode(*reservedIP); err != nil {
+		log.Fatalf("保留地址配置错误: %v", err)
+	}
+	if err := api.SetDefaultSearchMode(*defaultMode); err != nil {
+		log.Fatalf("默认搜索模式错误: %v", err)
+	}
+	if err := api.SetExportTuning(*exportBufferKB, *exportStep); err != nil {
+		log.Fatalf("导出参数错误: %v", err)
+	}
+	if err := api.SetRemoteSourceLimits(*remoteMaxMB, *remoteTimeout); err != nil {
+		log.Fatalf("远程源文件配置错误: %v", err)
+	}
+	if err := api.SetRemoteAllowHosts(remoteHosts); err != nil {
+		log.Fatalf("远程主机配置错误: %v", err)
+	}
+	if err := checkCorsOrigins(corsOrigins); err != nil {
+		log.Fatalf("跨域来源配置错误: %v", err)
+	}
+
+	// 管理接口的来源地址过滤
+	if err := api.SetAdminAccess(adminAllow, adminDeny); err != nil {
+		log.Fatalf("管理接口访问控制配置错误: %v", err)
+	}
+
+	// 限制请求可访问的文件范围
+	if err := api.SetDataRoot(*dataRoot); err != nil {
+		log.Fatalf("设置数据目录失败: %v", err)
+	}
+
+	// 数据库文件变化后自动重新加载
+	if *watchDb {
+		if err := api.EnableWatch(*watchDebounce); err != nil {
+			log.Fatalf("开启文件监视失败: %v", err)
+		}
+	}
+
+	// 证书和私钥需要同时指定
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatalf("-tls-cert 和 -tls-key 需要同时指定")
+	}
+
+	// 启动自检，尽早发现部署了错误的数据库
+	if *selfTest != "" {
+		if *selfTestDb == "" {
+			log.Fatalf("-self-test 需要同时通过 -self-test-db 指定数据库")
+		}
+		if err := api.RunSelfTest(*selfTestDb, *selfTest); err != nil {
+			log.Fatalf("启动自检失败: %v", err)
+		}
+	}
+
 	// 设置Gin为release模式，关闭debug输出
 	gin.SetMode(gin.ReleaseMode)
 
@@ -224,9 +485,36 @@ func main() {
 	// 启动Web服务器
 	log.Printf("Starting web server on port %d...\n", *port)
 	log.Printf("Static files directory: %s\n", *staticPath)
+	if root := api.GetDataRoot(); root != "" {
+		log.Printf("Data root directory: %s\n", root)
+	}
 
-	err := r.Run(fmt.Sprintf(":%d", *port))
+	var err error
+	if *tlsCert != "" {
+		err = runTLS(r.Handler(), fmt.Sprintf(":%d", *port), *tlsCert, *tlsKey)
+	} else {
+		err = r.Run(fmt.Sprintf(":%d", *port))
+	}
 	if err != nil {
 		log.Fatalf("启动Web服务器失败: %v", err)
 	}
 }
+
+// runTLS 使用HTTPS提供服务，与 gin 的 RunTLS 相同，但证书文件被替换后会自动重新加载
+func runTLS(handler http.Handler, addr string, certFile string, keyFile string) error {
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Serving HTTPS with certificate %s\n", certFile)
+	server := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+		TLSConfig: &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.GetCertificate,
+		},
+	}
+	return server.ListenAndServeTLS("", "")
+}
@@ -0,0 +1,71 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package xdb
+
+import (
+	"context"
+	"strings"
+)
+
+// Result 一次查询的结果，Found为false时只有IoCount有意义
+type Result struct {
+	Region  string   // 地区信息
+	Parts   []string // 按 | 分隔的地区字段
+	StartIP uint32   // 命中的段索引条目的起始IP，生成时按/16拆分，可能只是源数据中一个段的一部分
+	EndIP   uint32   // 命中的段索引条目的结束IP
+	IoCount int      // 本次查询的IO次数，内存模式为0
+	Found   bool     // 是否命中了段
+}
+
+// LookupFunc 查询函数的签名，Client.Lookup 可以直接作为该类型的值传给中间件等调用方
+type LookupFunc func(ctx context.Context, ip string) (Result, error)
+
+// Client 封装 Searcher 供其他Go程序嵌入使用，只依赖本包，不依赖HTTP框架和服务端的全局状态。
+// Searcher 支持并发查询，Client 同样可以在多个goroutine中共享
+type Client struct {
+	searcher *Searcher
+}
+
+// NewClient 使用已创建的搜索器创建 Client，搜索器的查询模式由调用方决定，
+// 例如 NewWithBuffer 为内存模式、NewWithFileAndVector 为文件加向量索引模式
+func NewClient(s *Searcher) *Client {
+	return &Client{searcher: s}
+}
+
+// Close 关闭底层的搜索器
+func (c *Client) Close() {
+	c.searcher.Close()
+}
+
+// Lookup 查询点分十进制的IPv4地址，ctx已取消或超时时不执行查询并返回ctx的错误
+func (c *Client) Lookup(ctx context.Context, ip string) (Result, error) {
+	ipUint32, err := IP2Long(strings.TrimSpace(ip))
+	if err != nil {
+		return Result{}, err
+	}
+	return c.LookupUint32(ctx, ipUint32)
+}
+
+// LookupUint32 与 Lookup 相同，IP为uint32形式
+func (c *Client) LookupUint32(ctx context.Context, ip uint32) (Result, error) {
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+
+	var info SearchInfo
+	region, ioCount, err := c.searcher.search(ip, &info)
+	if err != nil {
+		return Result{IoCount: ioCount}, err
+	}
+
+	var result = Result{IoCount: ioCount}
+	if info.DataLen > 0 {
+		result.Region = region
+		result.Parts = strings.Split(region, "|")
+		result.StartIP, result.EndIP = info.StartIP, info.EndIP
+		result.Found = true
+	}
+	return result, nil
+}
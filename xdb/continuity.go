@@ -0,0 +1,87 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package xdb
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// MaxContinuityProblems 连续性检查最多记录的问题数量，超出部分只计数
+const MaxContinuityProblems = 100
+
+// ContinuityProblem 源数据中的一处空缺或重叠，StartIP和EndIP为问题所在的范围
+type ContinuityProblem struct {
+	Kind    string `json:"kind"` // gap: 没有被任何段覆盖, overlap: 被多个段覆盖
+	StartIP string `json:"startIp"`
+	EndIP   string `json:"endIp"`
+}
+
+func (p ContinuityProblem) String() string {
+	switch p.Kind {
+	case "gap":
+		return fmt.Sprintf("空缺 %s-%s", p.StartIP, p.EndIP)
+	default:
+		return fmt.Sprintf("重叠 %s-%s", p.StartIP, p.EndIP)
+	}
+}
+
+// ContinuityError 严格模式下源数据没有连续覆盖整个IPv4地址空间
+type ContinuityError struct {
+	Problems []ContinuityProblem // 最多MaxContinuityProblems个
+	Total    int                 // 问题总数
+}
+
+func (e *ContinuityError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "源数据不连续，共 %d 处问题", e.Total)
+	for i, p := range e.Problems {
+		if i >= 5 {
+			fmt.Fprintf(&sb, " ...")
+			break
+		}
+		sb.WriteString("; ")
+		sb.WriteString(p.String())
+	}
+	return sb.String()
+}
+
+// CheckContinuity 检查按StartIP排序的段是否从0.0.0.0到255.255.255.255无空缺、无重叠地覆盖，
+// 有问题时返回*ContinuityError
+func CheckContinuity(segments []*Segment) error {
+	var cErr = &ContinuityError{}
+	var add = func(kind string, sip, eip uint32) {
+		cErr.Total++
+		if len(cErr.Problems) < MaxContinuityProblems {
+			cErr.Problems = append(cErr.Problems, ContinuityProblem{
+				Kind:    kind,
+				StartIP: Long2IP(sip),
+				EndIP:   Long2IP(eip),
+			})
+		}
+	}
+
+	// next 为下一个段应当开始的位置，覆盖到255.255.255.255之后超出uint32
+	var next uint64 = 0
+	for _, seg := range segments {
+		var sip, eip = uint64(seg.StartIP), uint64(seg.EndIP)
+		if sip > next {
+			add("gap", uint32(next), uint32(sip-1))
+		} else if sip < next {
+			add("overlap", uint32(sip), uint32(min(next-1, eip)))
+		}
+		next = max(next, eip+1)
+	}
+
+	if next <= math.MaxUint32 {
+		add("gap", uint32(next), math.MaxUint32)
+	}
+
+	if cErr.Total > 0 {
+		return cErr
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package xdb
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// parseTestSegments 解析 起始IP|结束IP|地区 格式的段，不合并、不排序
+func parseTestSegments(t *testing.T, lines ...string) []*Segment {
+	t.Helper()
+
+	var segments []*Segment
+	for _, l := range lines {
+		seg, err := SegmentFrom(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		segments = append(segments, seg)
+	}
+	return segments
+}
+
+var continuityTests = []struct {
+	name     string
+	lines    []string
+	problems []ContinuityProblem
+	segErr   string // CheckSegments的错误，为空表示通过
+}{
+	{
+		name:  "full coverage",
+		lines: []string{"0.0.0.0|1.0.0.255|A", "1.0.1.0|255.255.255.255|B"},
+	},
+	{
+		name:  "single segment",
+		lines: []string{"0.0.0.0|255.255.255.255|A"},
+	},
+	{
+		name:     "gap in the middle",
+		lines:    []string{"0.0.0.0|1.0.0.255|A", "1.0.2.0|255.255.255.255|B"},
+		problems: []ContinuityProblem{{Kind: "gap", StartIP: "1.0.1.0", EndIP: "1.0.1.255"}},
+		segErr:   "gap between",
+	},
+	{
+		name:     "gap at the head",
+		lines:    []string{"1.0.0.0|255.255.255.255|A"},
+		problems: []ContinuityProblem{{Kind: "gap", StartIP: "0.0.0.0", EndIP: "0.255.255.255"}},
+	},
+	{
+		name:     "gap at the tail",
+		lines:    []string{"0.0.0.0|1.0.0.255|A"},
+		problems: []ContinuityProblem{{Kind: "gap", StartIP: "1.0.1.0", EndIP: "255.255.255.255"}},
+	},
+	{
+		name:     "overlap",
+		lines:    []string{"0.0.0.0|1.0.1.127|A", "1.0.1.0|255.255.255.255|B"},
+		problems: []ContinuityProblem{{Kind: "overlap", StartIP: "1.0.1.0", EndIP: "1.0.1.127"}},
+		segErr:   "overlap: segment",
+	},
+	{
+		name:     "segment inside another",
+		lines:    []string{"0.0.0.0|255.255.255.255|A", "1.0.0.0|1.0.0.255|B"},
+		problems: []ContinuityProblem{{Kind: "overlap", StartIP: "1.0.0.0", EndIP: "1.0.0.255"}},
+		segErr:   "overlap: segment",
+	},
+	{
+		name:  "gap and overlap",
+		lines: []string{"0.0.0.0|0.255.255.255|A", "1.0.1.0|1.0.2.255|B", "1.0.2.0|255.255.255.255|C"},
+		problems: []ContinuityProblem{
+			{Kind: "gap", StartIP: "1.0.0.0", EndIP: "1.0.0.255"},
+			{Kind: "overlap", StartIP: "1.0.2.0", EndIP: "1.0.2.255"},
+		},
+		segErr: "gap between",
+	},
+}
+
+func TestCheckContinuity(t *testing.T) {
+	for _, tt := range continuityTests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckContinuity(parseTestSegments(t, tt.lines...))
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Fatalf("CheckContinuity: %v", err)
+				}
+				return
+			}
+
+			var cErr *ContinuityError
+			if !errors.As(err, &cErr) {
+				t.Fatalf("CheckContinuity: got %v, want a *ContinuityError", err)
+			}
+			if cErr.Total != len(tt.problems) || !slices.Equal(cErr.Problems, tt.problems) {
+				t.Fatalf("CheckContinuity:\n got %d %v\nwant %d %v", cErr.Total, cErr.Problems, len(tt.problems), tt.problems)
+			}
+		})
+	}
+}
+
+func TestCheckSegments(t *testing.T) {
+	for _, tt := range continuityTests {
+		t.Run(tt.name, func(t *testing.T) {
+			// CheckSegments only checks the segments against each other, not the head and tail
+			err := CheckSegments(parseTestSegments(t, tt.lines...))
+			if tt.segErr == "" {
+				if err != nil {
+					t.Fatalf("CheckSegments: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.segErr) {
+				t.Fatalf("CheckSegments: got %v, want an error containing %q", err, tt.segErr)
+			}
+		})
+	}
+}
+
+func TestCheckContinuityLimit(t *testing.T) {
+	// one gap after each of the segments, only the first MaxContinuityProblems are kept
+	var segments []*Segment
+	for i := uint32(0); i < MaxContinuityProblems+10; i++ {
+		segments = append(segments, &Segment{StartIP: i * 2, EndIP: i * 2, Region: "A"})
+	}
+
+	var cErr *ContinuityError
+	if err := CheckContinuity(segments); !errors.As(err, &cErr) {
+		t.Fatalf("CheckContinuity: got %v, want a *ContinuityError", err)
+	}
+	if cErr.Total != MaxContinuityProblems+10 || len(cErr.Problems) != MaxContinuityProblems {
+		t.Fatalf("CheckContinuity: got %d problems with %d kept, want %d with %d kept",
+			cErr.Total, len(cErr.Problems), MaxContinuityProblems+10, MaxContinuityProblems)
+	}
+}
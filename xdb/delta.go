@@ -0,0 +1,50 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package xdb
+
+// DiffSegments returns the ranges of cur whose region differs from base,
+// including those base does not cover at all. Both lists must be sorted by
+// ip without overlaps, as produced by IterateIndex. Applying the result to
+// base with Editor.PutFile yields cur for every range cur covers.
+func DiffSegments(base []*Segment, cur []*Segment) []*Segment {
+	var out []*Segment
+	var emit = func(sip, eip uint32, region string) {
+		if n := len(out); n > 0 && out[n-1].Region == region && out[n-1].EndIP+1 == sip {
+			out[n-1].EndIP = eip
+			return
+		}
+		out = append(out, &Segment{StartIP: sip, EndIP: eip, Region: region})
+	}
+
+	var j = 0
+	for _, seg := range cur {
+		var ip = uint64(seg.StartIP)
+		for ip <= uint64(seg.EndIP) {
+			// skip the base segments that end before the current ip
+			for j < len(base) && uint64(base[j].EndIP) < ip {
+				j++
+			}
+
+			// not covered by base until the next base segment starts
+			if j >= len(base) || uint64(base[j].StartIP) > ip {
+				end := uint64(seg.EndIP)
+				if j < len(base) && uint64(base[j].StartIP)-1 < end {
+					end = uint64(base[j].StartIP) - 1
+				}
+				emit(uint32(ip), uint32(end), seg.Region)
+				ip = end + 1
+				continue
+			}
+
+			end := min(uint64(seg.EndIP), uint64(base[j].EndIP))
+			if base[j].Region != seg.Region {
+				emit(uint32(ip), uint32(end), seg.Region)
+			}
+			ip = end + 1
+		}
+	}
+
+	return out
+}
@@ -7,10 +7,17 @@
 package xdb
 
 import (
-	"container/list"
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
 )
 
 type Editor struct {
@@ -19,11 +26,28 @@ type Editor struct {
 	srcHandle *os.File
 	toSave    bool
 
-	// segments list
-	segments *list.List
+	// source file encoding, regions are always kept as utf-8 in memory
+	encoding string
+
+	// the source file does not exist yet and will be created by Save
+	newFile bool
+
+	// segments sorted by ip and kept continuous, backed by a slice
+	// so that Slice is O(size) and PutSegment can binary search
+	segments []*Segment
 }
 
 func NewEditor(srcFile string) (*Editor, error) {
+	return NewEditorWithEncoding(srcFile, EncodingUTF8)
+}
+
+// NewEditorWithEncoding 使用指定编码读写源文件，空字符串表示UTF-8
+func NewEditorWithEncoding(srcFile string, encoding string) (*Editor, error) {
+	encoding, err := NormalizeEncoding(encoding)
+	if err != nil {
+		return nil, err
+	}
+
 	// check the src and dst file
 	srcPath, err := filepath.Abs(srcFile)
 	if err != nil {
@@ -39,7 +63,7 @@ func NewEditor(srcFile string) (*Editor, error) {
 		srcPath:   srcPath,
 		srcHandle: srcHandle,
 		toSave:    false,
-		segments:  list.New(),
+		encoding:  encoding,
 	}
 
 	// load the segments
@@ -47,14 +71,87 @@ func NewEditor(srcFile string) (*Editor, error) {
 		return nil, fmt.Errorf("failed to load segments: %s", err)
 	}
 
+	// an empty source starts from the whole default ip space
+	if len(e.segments) == 0 {
+		e.segments = []*Segment{fullSpaceSegment()}
+		e.toSave = true
+	}
+
 	return e, nil
 }
 
+// NewEmptyEditor creates an editor for a source file that does not exist yet.
+// It starts with a single default segment covering the whole ip space so that
+// the following Put calls carve it up, and Save creates the file.
+func NewEmptyEditor(srcFile string, encoding string) (*Editor, error) {
+	encoding, err := NormalizeEncoding(encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	srcPath, err := filepath.Abs(srcFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = os.Stat(srcPath); err == nil {
+		return nil, fmt.Errorf("source file `%s` already exists", srcFile)
+	}
+
+	// the directory must exist, otherwise Save would fail after all the edits
+	if info, err := os.Stat(filepath.Dir(srcPath)); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("directory of `%s` does not exist", srcFile)
+	}
+
+	return &Editor{
+		srcPath:  srcPath,
+		toSave:   true,
+		encoding: encoding,
+		newFile:  true,
+		segments: []*Segment{fullSpaceSegment()},
+	}, nil
+}
+
+// NewEditorFromBytes creates an editor over a source held in memory. It never
+// touches the filesystem: Save is not available and the edited source is
+// written out with WriteTo instead.
+func NewEditorFromBytes(data []byte, encoding string) (*Editor, error) {
+	encoding, err := NormalizeEncoding(encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Editor{encoding: encoding}
+	if err = e.loadSegmentsFrom(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("failed to load segments: %s", err)
+	}
+
+	if len(e.segments) == 0 {
+		e.segments = []*Segment{fullSpaceSegment()}
+		e.toSave = true
+	}
+
+	return e, nil
+}
+
+func fullSpaceSegment() *Segment {
+	return &Segment{StartIP: 0, EndIP: 0xFFFFFFFF, Region: DefaultRegion}
+}
+
 // Load all the segments from the source file
 func (e *Editor) loadSegments() error {
+	return e.loadSegmentsFrom(e.srcHandle)
+}
+
+func (e *Editor) loadSegmentsFrom(r io.Reader) error {
 	var last *Segment = nil
 
-	var iErr = IterateSegments(e.srcHandle, func(l string) {
+	reader, err := NewSourceReader(r, e.encoding)
+	if err != nil {
+		return err
+	}
+
+	var iErr = IterateSegments(reader, func(l string) {
 		// do nothing here
 	}, func(seg *Segment) error {
 		// check the continuity of the data segment
@@ -62,7 +159,11 @@ func (e *Editor) loadSegments() error {
 			return err
 		}
 
-		e.segments.PushBack(seg)
+		if err := checkSegmentCount(len(e.segments)); err != nil {
+			return err
+		}
+
+		e.segments = append(e.segments, seg)
 		last = seg
 		return nil
 	})
@@ -73,38 +174,36 @@ func (e *Editor) loadSegments() error {
 	return nil
 }
 
+// Encoding 返回源文件的编码
+func (e *Editor) Encoding() string {
+	return e.encoding
+}
+
 func (e *Editor) NeedSave() bool {
 	return e.toSave
 }
 
 func (e *Editor) SegLen() int {
-	return e.segments.Len()
+	return len(e.segments)
 }
 
 func (e *Editor) Slice(offset int, size int) []*Segment {
-	var index = -1
-	var out []*Segment
-	var next *list.Element
-	for ele := e.segments.Front(); ele != nil; ele = next {
-		next = ele.Next()
-		s, ok := ele.Value.(*Segment)
-		if !ok {
-			continue
-		}
+	var out = []*Segment{}
+	if offset < 0 {
+		offset = 0
+	}
 
-		// offset match
-		index++
-		if index < offset {
-			continue
-		}
+	// out of range offset returns an empty page
+	if offset >= len(e.segments) || size <= 0 {
+		return out
+	}
 
-		out = append(out, s)
-		if len(out) >= size {
-			break
-		}
+	var end = offset + size
+	if end > len(e.segments) {
+		end = len(e.segments)
 	}
 
-	return out
+	return append(out, e.segments[offset:end]...)
 }
 
 func (e *Editor) Put(ip string) (int, int, error) {
@@ -128,47 +227,52 @@ func (e *Editor) Put(ip string) (int, int, error) {
 //
 //	|---------------------seg.EndIP
 func (e *Editor) PutSegment(seg *Segment) (int, int, error) {
-	var next *list.Element
-	var eList []*list.Element
-	var found = false
-	for ele := e.segments.Front(); ele != nil; ele = next {
-		next = ele.Next()
-		s, ok := ele.Value.(*Segment)
-		if !ok {
-			// could this even be a case ?
-			continue
-		}
-
-		// found the related segment
-		if seg.StartIP <= s.EndIP && seg.StartIP >= s.StartIP {
-			found = true
-		}
-
-		if !found {
-			continue
-		}
-
-		eList = append(eList, ele)
-		if seg.EndIP <= s.EndIP {
-			break
-		}
+	if err := CheckRegionLength(seg.Region); err != nil {
+		return 0, 0, fmt.Errorf("segment %s|%s: %w", Long2IP(seg.StartIP), Long2IP(seg.EndIP), err)
 	}
 
-	if len(eList) == 0 {
+	// binary search the segment that contains seg.StartIP
+	var first = sort.Search(len(e.segments), func(i int) bool {
+		return e.segments[i].EndIP >= seg.StartIP
+	})
+	if first >= len(e.segments) || e.segments[first].StartIP > seg.StartIP {
 		// could this even be a case ?
 		// if the loaded segments contains all the segments we have
 		// from 0 to 0xffffffff
 		return 0, 0, fmt.Errorf("failed to find the related segment")
 	}
 
+	// the in-range segments are e.segments[first:last]
+	var last = first
+	for last < len(e.segments) {
+		last++
+		if seg.EndIP <= e.segments[last-1].EndIP {
+			break
+		}
+	}
+	var eList = e.segments[first:last]
+
+	// the new segment must be fully covered by the continuous located segments,
+	// otherwise the tail split below would produce an invalid segment
+	if tail := eList[len(eList)-1]; seg.EndIP > tail.EndIP {
+		return 0, 0, fmt.Errorf("segment %s|%s exceeds the loaded segments ending at %s",
+			Long2IP(seg.StartIP), Long2IP(seg.EndIP), Long2IP(tail.EndIP))
+	}
+	for i := 1; i < len(eList); i++ {
+		if eList[i-1].EndIP+1 != eList[i].StartIP {
+			return 0, 0, fmt.Errorf("segment %s|%s spans a gap in the loaded segments between %s and %s",
+				Long2IP(seg.StartIP), Long2IP(seg.EndIP), Long2IP(eList[i-1].EndIP), Long2IP(eList[i].StartIP))
+		}
+	}
+
 	// print for debug
 	// for i, s := range eList {
-	// 	fmt.Printf("ele %d: %s\n", i, s.Value.(*Segment))
+	// 	fmt.Printf("ele %d: %s\n", i, s)
 	// }
 
 	// segment split
 	var sList []*Segment
-	var head = eList[0].Value.(*Segment)
+	var head = eList[0]
 	if seg.StartIP > head.StartIP {
 		sList = append(sList, &Segment{
 			StartIP: head.StartIP,
@@ -183,7 +287,7 @@ func (e *Editor) PutSegment(seg *Segment) (int, int, error) {
 	// check and do the tailing segment append
 	if len(sList) > 0 {
 		// check and append the tailing
-		var tail = eList[len(eList)-1].Value.(*Segment)
+		var tail = eList[len(eList)-1]
 		if seg.EndIP < tail.EndIP {
 			sList = append(sList, &Segment{
 				StartIP: seg.EndIP + 1,
@@ -198,66 +302,434 @@ func (e *Editor) PutSegment(seg *Segment) (int, int, error) {
 	// 	fmt.Printf("%d: %s\n", i, s)
 	// }
 
-	// delete all the in-range segments and
-	var base *list.Element
+	// replace all the in-range segments with the new segments
 	var oldRows, newRows = len(eList), len(sList)
-	for _, ele := range eList {
-		base = ele.Next()
-		e.segments.Remove(ele)
+	e.segments = slices.Replace(e.segments, first, last, sList...)
+
+	// open the to save flag
+	e.toSave = true
+
+	return oldRows, newRows, nil
+}
+
+// ErrSegmentNotFound is returned when no loaded segment contains the ip
+var ErrSegmentNotFound = errors.New("segment not found")
+
+// SetRegionAt replace the region of the segment that contains the specified ip,
+// the segment bounds are kept unchanged. returns the segment before the change,
+// the editor is left untouched if the region is the same.
+func (e *Editor) SetRegionAt(ip uint32, region string) (*Segment, error) {
+	if err := CheckRegionLength(region); err != nil {
+		return nil, err
+	}
+
+	var i = sort.Search(len(e.segments), func(i int) bool {
+		return e.segments[i].EndIP >= ip
+	})
+	if i >= len(e.segments) || e.segments[i].StartIP > ip {
+		return nil, fmt.Errorf("%w: no segment contains ip %s", ErrSegmentNotFound, Long2IP(ip))
 	}
 
-	// add all the new segments
-	if base == nil {
-		for _, s := range sList {
-			e.segments.PushBack(s)
+	// segments are shared with Slice results, replace instead of modify
+	var old = e.segments[i]
+	if old.Region == region {
+		return old, nil
+	}
+	e.segments[i] = &Segment{
+		StartIP: old.StartIP,
+		EndIP:   old.EndIP,
+		Region:  region,
+	}
+	e.toSave = true
+
+	return old, nil
+}
+
+// PutMode defines how a put segment is merged with the existing segments
+type PutMode int
+
+const (
+	// PutOverwrite replaces whatever the new segment overlaps
+	PutOverwrite PutMode = iota
+
+	// PutFillOnly only fills the ranges with an empty or default region and
+	// never overwrites an existing non-default region
+	PutFillOnly
+)
+
+// PutResult reports the rows changed by a put, Applied and Skipped count the
+// overlapped existing segments that were written or left untouched
+type PutResult struct {
+	OldRows int
+	NewRows int
+	Applied int
+	Skipped int
+}
+
+func (r *PutResult) add(o PutResult) {
+	r.OldRows += o.OldRows
+	r.NewRows += o.NewRows
+	r.Applied += o.Applied
+	r.Skipped += o.Skipped
+}
+
+// PutSegmentMode put the specified segment with the specified merge mode
+func (e *Editor) PutSegmentMode(seg *Segment, mode PutMode) (PutResult, error) {
+	if mode != PutFillOnly {
+		o, n, err := e.PutSegment(seg)
+		if err != nil {
+			return PutResult{}, err
 		}
-	} else {
-		for _, s := range sList {
-			e.segments.InsertBefore(s, base)
+		return PutResult{OldRows: o, NewRows: n, Applied: 1}, nil
+	}
+
+	if err := CheckRegionLength(seg.Region); err != nil {
+		return PutResult{}, fmt.Errorf("segment %s|%s: %w", Long2IP(seg.StartIP), Long2IP(seg.EndIP), err)
+	}
+
+	// collect the default ranges first as PutSegment changes the list
+	var result PutResult
+	var fills []*Segment
+	var first = sort.Search(len(e.segments), func(i int) bool {
+		return e.segments[i].EndIP >= seg.StartIP
+	})
+	for i := first; i < len(e.segments) && e.segments[i].StartIP <= seg.EndIP; i++ {
+		s := e.segments[i]
+		if !IsDefaultRegion(s.Region) {
+			result.Skipped++
+			continue
 		}
+
+		fill := &Segment{StartIP: max(s.StartIP, seg.StartIP), EndIP: min(s.EndIP, seg.EndIP), Region: seg.Region}
+		fills = append(fills, fill)
 	}
 
-	// open the to save flag
-	e.toSave = true
+	for _, fill := range fills {
+		o, n, err := e.PutSegment(fill)
+		if err != nil {
+			return result, err
+		}
 
-	return oldRows, newRows, nil
+		result.OldRows += o
+		result.NewRows += n
+		result.Applied++
+	}
+
+	return result, nil
 }
 
 func (e *Editor) PutFile(src string) (int, int, error) {
+	r, err := e.PutFileMode(src, PutOverwrite)
+	return r.OldRows, r.NewRows, err
+}
+
+// PutFileMode put all the segments from the specified source file with the specified merge mode
+func (e *Editor) PutFileMode(src string, mode PutMode) (PutResult, error) {
+	return e.PutFileWithLimits(src, mode, PutLimits{})
+}
+
+// PutReaderMode put all the segments read from r with the specified merge mode
+func (e *Editor) PutReaderMode(r io.Reader, mode PutMode) (PutResult, error) {
+	return e.PutReaderWithLimits(r, mode, PutLimits{})
+}
+
+// ErrPutLimitExceeded is returned when a batch put hits one of its PutLimits
+var ErrPutLimitExceeded = errors.New("put limit exceeded")
+
+// PutLimits bounds a batch put, the zero value means no limit
+type PutLimits struct {
+	// MaxLines is the max number of lines, comments included, read from the patch
+	MaxLines int
+
+	// Timeout is the max time spent applying the segments
+	Timeout time.Duration
+
+	// Progress is called every putProgressEvery segments with the number
+	// of segments applied so far and the total to apply
+	Progress func(done int, total int)
+}
+
+const putProgressEvery = 1024
+
+// limitLineReader fails the read once more than max lines were seen, so an
+// oversized patch is rejected before it is loaded into memory
+type limitLineReader struct {
+	r     io.Reader
+	max   int
+	lines int
+}
+
+func (l *limitLineReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.lines += bytes.Count(p[:n], []byte{'\n'})
+	if l.lines > l.max {
+		return 0, fmt.Errorf("%w: more than %d lines", ErrPutLimitExceeded, l.max)
+	}
+
+	return n, err
+}
+
+// PutFileWithLimits is PutReaderWithLimits for the specified source file
+func (e *Editor) PutFileWithLimits(src string, mode PutMode, limits PutLimits) (PutResult, error) {
 	handle, err := os.OpenFile(src, os.O_RDONLY, 0600)
 	if err != nil {
-		return 0, 0, err
+		return PutResult{}, err
 	}
+	defer handle.Close()
 
-	var oldRows, newRows = 0, 0
-	iErr := IterateSegments(handle, func(l string) {
-		// do nothing here
-	}, func(seg *Segment) error {
-		o, n, err := e.PutSegment(seg)
-		if err == nil {
-			oldRows += o
-			newRows += n
-		}
+	return e.PutReaderWithLimits(handle, mode, limits)
+}
 
-		return err
+// PutReaderWithLimits put all the segments read from r with the specified
+// merge mode. The patch is parsed completely first, so an invalid or oversized
+// patch changes nothing. When the timeout is hit the segments applied so far
+// are kept and the partial result is returned with ErrPutLimitExceeded.
+func (e *Editor) PutReaderWithLimits(r io.Reader, mode PutMode, limits PutLimits) (PutResult, error) {
+	var result PutResult
+	if limits.MaxLines > 0 {
+		r = &limitLineReader{r: r, max: limits.MaxLines}
+	}
+
+	// the patch is expected to use the same encoding as the source
+	reader, err := NewSourceReader(r, e.encoding)
+	if err != nil {
+		return result, err
+	}
+
+	var segments []*Segment
+	iErr := IterateSegments(reader, nil, func(seg *Segment) error {
+		segments = append(segments, seg)
+		return nil
 	})
 	if iErr != nil {
-		return oldRows, newRows, iErr
+		return result, iErr
 	}
 
-	_ = handle.Close()
-	return oldRows, newRows, nil
+	var deadline time.Time
+	if limits.Timeout > 0 {
+		deadline = time.Now().Add(limits.Timeout)
+	}
+
+	for i, seg := range segments {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return result, fmt.Errorf("%w: timed out after %s, applied %d of %d segments",
+				ErrPutLimitExceeded, limits.Timeout, i, len(segments))
+		}
+
+		r, err := e.PutSegmentMode(seg, mode)
+		result.add(r)
+		if err != nil {
+			return result, err
+		}
+
+		if limits.Progress != nil && (i+1)%putProgressEvery == 0 {
+			limits.Progress(i+1, len(segments))
+		}
+	}
+
+	if limits.Progress != nil {
+		limits.Progress(len(segments), len(segments))
+	}
+
+	return result, nil
+}
+
+// ReplaceRegion replace oldRegion with newRegion in the region of every segment,
+// the whole region has to be equal to oldRegion unless substring is set.
+// Segments that become identical to a neighbour after the replace are merged.
+// returns the number of segments changed and the number of merges done.
+func (e *Editor) ReplaceRegion(oldRegion string, newRegion string, substring bool) (int, int, error) {
+	if oldRegion == "" {
+		return 0, 0, fmt.Errorf("empty region to replace")
+	}
+
+	var replace = func(region string) string {
+		if substring {
+			return strings.ReplaceAll(region, oldRegion, newRegion)
+		}
+		if region == oldRegion {
+			return newRegion
+		}
+		return region
+	}
+
+	// check all the new regions first so that a failure leaves the list untouched
+	for _, seg := range e.segments {
+		if err := CheckRegionLength(replace(seg.Region)); err != nil {
+			return 0, 0, fmt.Errorf("segment %s|%s: %w", Long2IP(seg.StartIP), Long2IP(seg.EndIP), err)
+		}
+	}
+
+	var changed, merged = 0, 0
+	var out = make([]*Segment, 0, len(e.segments))
+	var lastChanged = false
+	for _, seg := range e.segments {
+		region := replace(seg.Region)
+		isChanged := region != seg.Region
+		if isChanged {
+			// segments are shared with Slice results, replace instead of modify
+			seg = &Segment{StartIP: seg.StartIP, EndIP: seg.EndIP, Region: region}
+			changed++
+		}
+
+		// only merge around the changed segments
+		if n := len(out); n > 0 && (isChanged || lastChanged) {
+			last := out[n-1]
+			if last.Region == seg.Region && last.EndIP+1 == seg.StartIP {
+				out[n-1] = &Segment{StartIP: last.StartIP, EndIP: seg.EndIP, Region: last.Region}
+				merged++
+				lastChanged = true
+				continue
+			}
+		}
+
+		out = append(out, seg)
+		lastChanged = isChanged
+	}
+
+	if changed > 0 {
+		e.segments = out
+		e.toSave = true
+	}
+
+	return changed, merged, nil
+}
+
+// Coalesce merges the consecutive segments with the same region, which
+// PutSegment may leave behind, and returns the number of merges done.
+func (e *Editor) Coalesce() int {
+	if len(e.segments) < 2 {
+		return 0
+	}
+
+	var merged = 0
+	var out = e.segments[:1]
+	for _, seg := range e.segments[1:] {
+		last := out[len(out)-1]
+		if last.Region == seg.Region && last.EndIP+1 == seg.StartIP {
+			// segments are shared with Slice results, replace instead of modify
+			out[len(out)-1] = &Segment{StartIP: last.StartIP, EndIP: seg.EndIP, Region: last.Region}
+			merged++
+			continue
+		}
+
+		out = append(out, seg)
+	}
+
+	if merged > 0 {
+		clear(e.segments[len(out):])
+		e.segments = out
+		e.toSave = true
+	}
+
+	return merged
+}
+
+// Compact rewrites the source file even without pending edits. Save merges
+// the adjacent same-region segments first, then the segments are written
+// back one per line without comments, blank lines or extra whitespace.
+func (e *Editor) Compact() error {
+	e.toSave = true
+	return e.Save()
+}
+
+// SegmentChange 范围相同但区域信息不同的段
+type SegmentChange struct {
+	Old *Segment
+	New *Segment
+}
+
+// SegmentDiff 编辑器中未保存的段与源文件的差异
+type SegmentDiff struct {
+	Added    []*Segment // 只存在于编辑器中的段
+	Removed  []*Segment // 只存在于源文件中的段
+	Modified []SegmentChange
+}
+
+// Diff compares the in-memory segments against a fresh read of the source
+// file. Both lists are sorted by ip, segments with the same range are
+// matched against each other and any other segment counts as added or removed.
+func (e *Editor) Diff() (*SegmentDiff, error) {
+	var diff = &SegmentDiff{}
+	if e.newFile {
+		// nothing on disk yet, everything is added
+		diff.Added = append(diff.Added, e.segments...)
+		return diff, nil
+	}
+
+	handle, err := os.Open(e.srcPath)
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+
+	reader, err := NewSourceReader(handle, e.encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	var disk []*Segment
+	err = IterateSegments(reader, nil, func(seg *Segment) error {
+		disk = append(disk, seg)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load segments: %s", err)
+	}
+
+	var i, j = 0, 0
+	for i < len(e.segments) && j < len(disk) {
+		cur, old := e.segments[i], disk[j]
+		switch {
+		case cur.StartIP == old.StartIP && cur.EndIP == old.EndIP:
+			if cur.Region != old.Region {
+				diff.Modified = append(diff.Modified, SegmentChange{Old: old, New: cur})
+			}
+			i++
+			j++
+		case cur.StartIP < old.StartIP || (cur.StartIP == old.StartIP && cur.EndIP < old.EndIP):
+			diff.Added = append(diff.Added, cur)
+			i++
+		default:
+			diff.Removed = append(diff.Removed, old)
+			j++
+		}
+	}
+
+	diff.Added = append(diff.Added, e.segments[i:]...)
+	diff.Removed = append(diff.Removed, disk[j:]...)
+	return diff, nil
 }
 
 // SaveToXdbFile 将编辑器中的数据保存为XDB文件
 func (e *Editor) SaveToXdbFile(dstFile string) error {
+	return e.SaveToXdbFileWithPolicy(dstFile, VectorIndexPolicy)
+}
+
+// SaveToXdbFileWithPolicy 使用指定的索引策略将编辑器中的数据保存为XDB文件
+func (e *Editor) SaveToXdbFileWithPolicy(dstFile string, policy IndexPolicy) error {
+	return e.SaveToXdbFileWithMode(dstFile, policy, 0)
+}
+
+// SaveToXdbFileWithMode 与SaveToXdbFileWithPolicy相同，同时指定生成文件的权限，0表示使用默认权限
+func (e *Editor) SaveToXdbFileWithMode(dstFile string, policy IndexPolicy, mode os.FileMode) error {
 	// 创建一个Maker来生成XDB文件
-	maker, err := NewMaker(VectorIndexPolicy, e.srcPath, dstFile)
+	maker, err := NewMaker(policy, e.srcPath, dstFile)
 	if err != nil {
 		return fmt.Errorf("创建Maker失败: %w", err)
 	}
 	defer maker.Close()
 
+	if mode != 0 {
+		if err := maker.SetFileMode(mode); err != nil {
+			return err
+		}
+	}
+
+	if err := maker.SetSourceEncoding(e.encoding); err != nil {
+		return err
+	}
+
 	// 初始化Maker
 	if err := maker.Init(); err != nil {
 		return fmt.Errorf("初始化Maker失败: %w", err)
@@ -279,7 +751,8 @@ func (e *Editor) SaveToXdbFile(dstFile string) error {
 // IsHandleValid 检查文件句柄是否有效
 func (e *Editor) IsHandleValid() bool {
 	if e.srcHandle == nil {
-		return false
+		// 新文件在保存之前没有文件句柄
+		return e.newFile
 	}
 
 	// 尝试获取文件状态来验证句柄是否有效
@@ -287,33 +760,54 @@ func (e *Editor) IsHandleValid() bool {
 	return err == nil
 }
 
+// WriteTo writes the segments in the source format and encoding to w,
+// one segment per line, without marking the editor as saved.
+func (e *Editor) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for _, s := range e.segments {
+		line, err := encodeSourceLine(s.String()+"\n", e.encoding)
+		if err != nil {
+			return total, err
+		}
+
+		n, err := io.WriteString(w, line)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
 func (e *Editor) Save() error {
+	e.Coalesce()
 	if !e.toSave {
 		return nil
 	}
 
-	if err := e.srcHandle.Close(); err != nil {
-		return err
+	if e.srcPath == "" {
+		return fmt.Errorf("in-memory editor has no source file to save to")
+	}
+
+	if e.srcHandle != nil {
+		if err := e.srcHandle.Close(); err != nil {
+			return err
+		}
 	}
 
-	handle, err := os.OpenFile(e.srcPath, os.O_WRONLY|os.O_TRUNC, 0644)
+	handle, err := os.OpenFile(e.srcPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, DefaultFileMode())
 	if err != nil {
 		return err
 	}
 
-	var next *list.Element
-	for ele := e.segments.Front(); ele != nil; ele = next {
-		next = ele.Next()
-		s, ok := ele.Value.(*Segment)
-		if !ok {
-			continue
-		}
-
-		_, err = handle.WriteString(s.String() + "\n")
-		if err != nil {
-			_ = handle.Close()
-			return err
-		}
+	bufWriter := bufio.NewWriter(handle)
+	if _, err = e.WriteTo(bufWriter); err == nil {
+		err = bufWriter.Flush()
+	}
+	if err != nil {
+		_ = handle.Close()
+		return err
 	}
 
 	_ = handle.Close()
@@ -326,8 +820,9 @@ func (e *Editor) Save() error {
 		return err
 	}
 
-	e.segments = list.New()
+	e.segments = nil
 	e.srcHandle = srcHandle
+	e.newFile = false
 	if err = e.loadSegments(); err != nil {
 		return err
 	}
@@ -0,0 +1,137 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package xdb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPutSegmentNotCovered(t *testing.T) {
+	// the source ends at 1.0.3.255, the rest of the address space is not loaded
+	editor, err := NewEditorFromBytes([]byte("0.0.0.0|0.255.255.255|保留|0|0|0|0\n"+
+		"1.0.0.0|1.0.0.255|中国|0|广东省|广州市|电信\n"+
+		"1.0.1.0|1.0.3.255|中国|0|福建省|福州市|电信\n"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the loader rejects gaps, build one directly: 1.0.1.0-1.0.1.255 is missing
+	gapped := &Editor{segments: []*Segment{
+		{StartIP: 0, EndIP: 16777215, Region: "保留|0|0|0|0"},
+		{StartIP: 16777216, EndIP: 16777471, Region: "中国|0|广东省|广州市|电信"},
+		{StartIP: 16777728, EndIP: 4294967295, Region: "0|0|0|0|0"},
+	}}
+
+	var tests = []struct {
+		name   string
+		editor *Editor
+		seg    string
+		err    string
+	}{
+		{"past the tail", editor, "1.0.3.0|1.0.4.255|中国|0|福建省|厦门市|电信", "exceeds the loaded segments ending at 1.0.3.255"},
+		{"after the tail", editor, "2.0.0.0|2.0.0.255|中国|0|福建省|厦门市|电信", "failed to find the related segment"},
+		{"across a gap", gapped, "1.0.0.128|1.0.2.0|中国|0|广东省|深圳市|联通", "spans a gap in the loaded segments between 1.0.0.255 and 1.0.2.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seg, err := SegmentFrom(tt.seg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var before = slices.Clone(tt.editor.segments)
+			if _, _, err = tt.editor.PutSegment(seg); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("PutSegment(%s): got %v, want an error containing %q", tt.seg, err, tt.err)
+			}
+			if !slices.Equal(tt.editor.segments, before) {
+				t.Fatalf("PutSegment(%s) modified the segments on error", tt.seg)
+			}
+			if tt.editor.NeedSave() {
+				t.Fatalf("PutSegment(%s) set the save flag on error", tt.seg)
+			}
+		})
+	}
+}
+
+func TestSetRegionAt(t *testing.T) {
+	editor, err := NewEditorFromBytes([]byte("0.0.0.0|0.255.255.255|保留|0|0|0|0\n"+
+		"1.0.0.0|1.0.0.255|中国|0|广东省|广州市|电信\n"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same region is not a change
+	old, err := editor.SetRegionAt(16777217, "中国|0|广东省|广州市|电信")
+	if err != nil || old.Region != "中国|0|广东省|广州市|电信" {
+		t.Fatalf("SetRegionAt with the same region: %v, %v", old, err)
+	}
+	if editor.NeedSave() {
+		t.Fatal("SetRegionAt with the same region set the save flag")
+	}
+
+	// past the loaded segments
+	if _, err = editor.SetRegionAt(16777472, "中国|0|广东省|深圳市|电信"); !errors.Is(err, ErrSegmentNotFound) {
+		t.Fatalf("SetRegionAt(1.0.1.0): got %v, want ErrSegmentNotFound", err)
+	}
+
+	old, err = editor.SetRegionAt(16777217, "中国|0|广东省|深圳市|电信")
+	if err != nil || old.StartIP != 16777216 || old.EndIP != 16777471 {
+		t.Fatalf("SetRegionAt(1.0.0.1): %v, %v", old, err)
+	}
+	if got := editor.Slice(1, 1)[0]; got.String() != "1.0.0.0|1.0.0.255|中国|0|广东省|深圳市|电信" || !editor.NeedSave() {
+		t.Fatalf("SetRegionAt(1.0.0.1): segment %s, need save %v", got, editor.NeedSave())
+	}
+}
+
+func TestCoalesceKeepsSlices(t *testing.T) {
+	srcFile := filepath.Join(t.TempDir(), "ip.merge.txt")
+	if err := os.WriteFile(srcFile, []byte("0.0.0.0|0.255.255.255|保留|0|0|0|0\n"+
+		"1.0.0.0|1.0.0.255|中国|0|广东省|广州市|电信\n"+
+		"1.0.1.0|255.255.255.255|0|0|0|0|0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	editor, err := NewEditor(srcFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer editor.Close()
+
+	// the put leaves 1.0.0.0-1.0.0.255 and 1.0.1.0-1.0.1.255 with the same region, Save merges them
+	seg, err := SegmentFrom("1.0.1.0|1.0.1.255|中国|0|广东省|广州市|电信")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = editor.PutSegment(seg); err != nil {
+		t.Fatal(err)
+	}
+
+	var page = editor.Slice(0, editor.SegLen())
+	var before []string
+	for _, s := range page {
+		before = append(before, s.String())
+	}
+
+	if err = editor.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if editor.SegLen() != len(page)-1 {
+		t.Fatalf("Save: %d segments, want %d", editor.SegLen(), len(page)-1)
+	}
+	for i, s := range page {
+		if s.String() != before[i] {
+			t.Fatalf("Save modified segment %d of an earlier Slice: %s, want %s", i, s, before[i])
+		}
+	}
+	if seg.String() != "1.0.1.0|1.0.1.255|中国|0|广东省|广州市|电信" {
+		t.Fatalf("Save modified the segment passed to PutSegment: %s", seg)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package xdb
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/transform"
+)
+
+// 源文件支持的编码，内存中的区域信息始终为UTF-8
+const (
+	EncodingUTF8 = "utf-8"
+	EncodingGBK  = "gbk"
+)
+
+// NormalizeEncoding 校验并规范化编码名称，空字符串表示UTF-8
+func NormalizeEncoding(name string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "utf-8", "utf8":
+		return EncodingUTF8, nil
+	case "gbk":
+		return EncodingGBK, nil
+	default:
+		return "", fmt.Errorf("unsupported encoding '%s'", name)
+	}
+}
+
+// textEncoding 返回需要转换的编码，UTF-8返回nil
+func textEncoding(name string) (encoding.Encoding, error) {
+	name, err := NormalizeEncoding(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if name == EncodingGBK {
+		return simplifiedchinese.GBK, nil
+	}
+
+	return nil, nil
+}
+
+// NewSourceReader 返回将指定编码的源文件内容解码为UTF-8的Reader
+func NewSourceReader(r io.Reader, name string) (io.Reader, error) {
+	enc, err := textEncoding(name)
+	if err != nil || enc == nil {
+		return r, err
+	}
+
+	return transform.NewReader(r, enc.NewDecoder()), nil
+}
+
+// encodeSourceLine 将UTF-8的一行内容编码为源文件的编码，
+// 包含该编码无法表示的字符时返回错误
+func encodeSourceLine(line string, name string) (string, error) {
+	enc, err := textEncoding(name)
+	if err != nil || enc == nil {
+		return line, err
+	}
+
+	out, err := enc.NewEncoder().String(line)
+	if err != nil {
+		return "", fmt.Errorf("encode `%s` as %s: %w", line, name, err)
+	}
+
+	return out, nil
+}
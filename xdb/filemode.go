@@ -0,0 +1,94 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package xdb
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// 新建的XDB和导出文件默认使用的权限，实际权限还会去掉进程umask中的位
+var defaultFileMode os.FileMode = 0644
+
+// 进程的umask，启动时在其它goroutine创建文件之前读取一次
+var umask = processUmask()
+
+// SetDefaultFileMode 设置新建输出文件的默认权限，只能包含rwx权限位
+func SetDefaultFileMode(mode os.FileMode) error {
+	if mode == 0 || mode&^os.ModePerm != 0 {
+		return fmt.Errorf("无效的文件权限: %#o", uint32(mode))
+	}
+
+	defaultFileMode = mode
+	return nil
+}
+
+// DefaultFileMode 返回新建输出文件的默认权限，未去掉umask
+func DefaultFileMode() os.FileMode {
+	return defaultFileMode
+}
+
+// ParseFileMode 解析八进制的文件权限，例如 0640 或 640，空串返回0表示未指定
+func ParseFileMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || v == 0 || v&^uint64(os.ModePerm) != 0 {
+		return 0, fmt.Errorf("无效的文件权限: %s，应为八进制的rwx权限位，例如 0644", s)
+	}
+
+	return os.FileMode(v), nil
+}
+
+// OutputFileMode 返回新建输出文件的权限：mode为0时使用默认权限，再与os.OpenFile一样去掉umask中的位。
+// 输出先写入临时文件再重命名，临时文件需要显式Chmod，而Chmod不受umask影响
+func OutputFileMode(mode os.FileMode) os.FileMode {
+	if mode == 0 {
+		mode = defaultFileMode
+	}
+	return mode &^ umask
+}
+
+// TargetFileMode 返回替换dstFile时使用的权限：未指定mode且dstFile已存在时保留原文件的权限，
+// 但不超过默认权限，例如0666的旧文件按默认的0644替换后不再是所有人可写；否则按OutputFileMode计算
+func TargetFileMode(dstFile string, mode os.FileMode) os.FileMode {
+	if mode == 0 {
+		if info, err := os.Stat(dstFile); err == nil {
+			return info.Mode().Perm() & OutputFileMode(0)
+		}
+	}
+
+	return OutputFileMode(mode)
+}
+
+// writeFileAtomic 将write的输出写入path所在目录的临时文件，同步到磁盘后重命名为path，
+// 写入失败时删除临时文件、不影响已有的path，path可以是正在读取的文件；mode为写入后的权限
+func writeFileAtomic(path string, mode os.FileMode, write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = tmp.Chmod(mode); err == nil {
+		err = write(tmp)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cErr := tmp.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,46 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package xdb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTargetFileMode(t *testing.T) {
+	dir := t.TempDir()
+	var tests = []struct {
+		name     string
+		existing os.FileMode // 0 means the target does not exist
+		mode     os.FileMode
+		want     os.FileMode
+	}{
+		{"new file", 0, 0, OutputFileMode(0)},
+		{"new file with mode", 0, 0600, OutputFileMode(0600)},
+		{"keep a narrower mode", 0600, 0, 0600 & OutputFileMode(0)},
+		{"clamp a world writable file", 0666, 0, defaultFileMode &^ umask &^ 0002},
+		{"explicit mode replaces the existing one", 0666, 0640, OutputFileMode(0640)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := filepath.Join(dir, tt.name+".xdb")
+			if tt.existing != 0 {
+				if err := os.WriteFile(dst, nil, 0600); err != nil {
+					t.Fatal(err)
+				}
+				// chmod is not affected by the umask
+				if err := os.Chmod(dst, tt.existing); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got := TargetFileMode(dst, tt.mode); got != tt.want {
+				t.Fatalf("TargetFileMode: got %#o, want %#o", got, tt.want)
+			}
+		})
+	}
+}
@@ -26,3 +26,14 @@ func IndexPolicyFromString(str string) (IndexPolicy, error) {
 		return VectorIndexPolicy, fmt.Errorf("invalid policy '%s'", str)
 	}
 }
+
+func (p IndexPolicy) String() string {
+	switch p {
+	case VectorIndexPolicy:
+		return "vector"
+	case BTreeIndexPolicy:
+		return "btree"
+	default:
+		return "unknown"
+	}
+}
@@ -17,6 +17,7 @@
 // -- 4bytes: generate unix timestamp (version)
 // -- 4bytes: index block start ptr
 // -- 4bytes: index block end ptr
+// -- 32bytes: sha256 of the source file, all zero for databases without it
 //
 //
 // 2. data block : region or whatever data info.
@@ -36,10 +37,11 @@
 // +-----------------------+----------------------+
 //
 // data entry structure:
-// +--------------------+-----------------------+
-// | 2bytes (for desc)	| dynamic length		|
-// +--------------------+-----------------------+
-//  data length   whatever in bytes
+// +-----------------------+
+// | dynamic length		|
+// +-----------------------+
+//  whatever in bytes, each unique region is written only once.
+//  there is no length prefix, the data length is kept in the index entry.
 //
 // index entry structure
 // +------------+-----------+---------------+------------+
@@ -50,10 +52,13 @@
 package xdb
 
 import (
+	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"sort"
 	"time"
 )
@@ -66,14 +71,40 @@ const VectorIndexSize = 8
 const SegmentIndexSize = 14
 const VectorIndexLength = VectorIndexRows * VectorIndexCols * VectorIndexSize
 
+// 源文件SHA-256在头部中的位置
+const SourceChecksumOffset = 16
+const SourceChecksumLength = sha256.Size
+
 type Maker struct {
 	srcHandle *os.File
 	dstHandle *os.File
 
+	// the index is built in a temporary file next to dstFile and End renames
+	// it over dstFile, so readers never see a half-written database
+	dstFile string
+	tmpFile string
+
 	indexPolicy IndexPolicy
 	segments    []*Segment
 	regionPool  map[string]uint32
 	vectorIndex []byte
+
+	// 索引构建进度回调：已处理的段数和总段数
+	progress func(done, total int)
+
+	// 源文件编码，默认UTF-8
+	encoding string
+
+	// 严格模式：源数据存在空缺或重叠时 Init 失败
+	strict bool
+
+	// 地区信息的结构要求，为nil时不检查
+	regionSchema *RegionSchema
+
+	// XDB内容的写入目标，默认为临时文件，SetKeepBuffer 开启后同时写入mem
+	out   makerOutput
+	mem   *memBuffer
+	built bool // Start 已成功完成
 }
 
 func NewMaker(policy IndexPolicy, srcFile string, dstFile string) (*Maker, error) {
@@ -83,15 +114,27 @@ func NewMaker(policy IndexPolicy, srcFile string, dstFile string) (*Maker, error
 		return nil, fmt.Errorf("open source file `%s`: %w", srcFile, err)
 	}
 
-	// open the destination file with Read/Write mode
-	dstHandle, err := os.OpenFile(dstFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	// create the temporary file in the same directory so the rename is atomic
+	dstHandle, err := os.CreateTemp(filepath.Dir(dstFile), filepath.Base(dstFile)+".*.tmp")
 	if err != nil {
+		_ = srcHandle.Close()
 		return nil, fmt.Errorf("open target file `%s`: %w", dstFile, err)
 	}
 
+	// keep the permissions of the database being replaced, new files use the default mode minus umask
+	if err = dstHandle.Chmod(TargetFileMode(dstFile, 0)); err != nil {
+		_ = srcHandle.Close()
+		_ = dstHandle.Close()
+		_ = os.Remove(dstHandle.Name())
+		return nil, fmt.Errorf("chmod target file `%s`: %w", dstHandle.Name(), err)
+	}
+
 	return &Maker{
 		srcHandle: srcHandle,
 		dstHandle: dstHandle,
+		out:       dstHandle,
+		dstFile:   dstFile,
+		tmpFile:   dstHandle.Name(),
 
 		indexPolicy: policy,
 		segments:    []*Segment{},
@@ -100,7 +143,7 @@ func NewMaker(policy IndexPolicy, srcFile string, dstFile string) (*Maker, error
 	}, nil
 }
 
-// Close 关闭 Maker 资源
+// Close 关闭 Maker 资源，End 没有成功时删除临时文件，目标文件保持不变
 func (m *Maker) Close() {
 	if m.srcHandle != nil {
 		m.srcHandle.Close()
@@ -108,6 +151,53 @@ func (m *Maker) Close() {
 	if m.dstHandle != nil {
 		m.dstHandle.Close()
 	}
+	if m.tmpFile != "" {
+		_ = os.Remove(m.tmpFile)
+		m.tmpFile = ""
+	}
+}
+
+// SetProgressCallback 设置 Start 构建索引时的进度回调，按已处理的段数报告
+func (m *Maker) SetProgressCallback(cb func(done, total int)) {
+	m.progress = cb
+}
+
+// SetSourceEncoding 设置源文件编码，需要在 Init 之前调用
+func (m *Maker) SetSourceEncoding(encoding string) error {
+	encoding, err := NormalizeEncoding(encoding)
+	if err != nil {
+		return err
+	}
+
+	m.encoding = encoding
+	return nil
+}
+
+// SetFileMode 指定生成文件的权限，去掉umask后生效，替换已有文件时也使用该权限；0表示保留已有文件的权限或使用默认权限
+func (m *Maker) SetFileMode(mode os.FileMode) error {
+	if err := m.dstHandle.Chmod(TargetFileMode(m.dstFile, mode)); err != nil {
+		return fmt.Errorf("chmod target file `%s`: %w", m.tmpFile, err)
+	}
+	return nil
+}
+
+// SetStrict 开启后 Init 要求源数据无空缺、无重叠地覆盖整个IPv4地址空间，否则返回*ContinuityError；
+// 默认允许空缺，未覆盖的IP查询结果为空
+func (m *Maker) SetStrict(strict bool) {
+	m.strict = strict
+}
+
+// SetRegionSchema 设置后 Init 检查每一行的地区信息是否符合schema，不符合时返回列出这些行的*RegionSchemaError；
+// schema为nil时不检查
+func (m *Maker) SetRegionSchema(schema *RegionSchema) error {
+	if schema != nil {
+		if err := schema.Validate(); err != nil {
+			return err
+		}
+	}
+
+	m.regionSchema = schema
+	return nil
 }
 
 // GetSegmentsCount 获取段数量
@@ -169,7 +259,7 @@ func QueryTaskStatus(taskId string) (map[string]interface{}, error) {
 func (m *Maker) initDbHeader() error {
 	log.Printf("try to init the db header ... ")
 
-	_, err := m.dstHandle.Seek(0, 0)
+	_, err := m.out.Seek(0, 0)
 	if err != nil {
 		return err
 	}
@@ -192,7 +282,7 @@ func (m *Maker) initDbHeader() error {
 	// 5, index block end ptr
 	binary.LittleEndian.PutUint32(header[12:], uint32(0))
 
-	_, err = m.dstHandle.Write(header)
+	_, err = m.out.Write(header)
 	if err != nil {
 		return err
 	}
@@ -205,7 +295,14 @@ func (m *Maker) loadSegments() error {
 	// var last *Segment = nil
 	var tStart = time.Now()
 
-	var iErr = IterateSegments(m.srcHandle, func(l string) {
+	// 在读取源文件的同时计算校验值，不需要额外读一遍，校验值按转码前的原始内容计算
+	var hash = sha256.New()
+	reader, err := NewSourceReader(io.TeeReader(m.srcHandle, hash), m.encoding)
+	if err != nil {
+		return err
+	}
+
+	var iErr = IterateSegmentsWithSchema(reader, m.regionSchema, func(l string) {
 		// log.Printf("load segment: `%s`", l)
 	}, func(seg *Segment) error {
 		// check the continuity of the data segment
@@ -213,12 +310,21 @@ func (m *Maker) loadSegments() error {
 		// 	return err
 		// }
 
+		if err := checkSegmentCount(len(m.segments)); err != nil {
+			return err
+		}
+
 		m.segments = append(m.segments, seg)
 		// last = seg
 		return nil
 	})
 	if iErr != nil {
-		return fmt.Errorf("failed to load segments: %s", iErr)
+		return fmt.Errorf("failed to load segments: %w", iErr)
+	}
+
+	// 将源文件校验值写入头部
+	if _, err := m.out.WriteAt(hash.Sum(nil), SourceChecksumOffset); err != nil {
+		return fmt.Errorf("write source checksum: %w", err)
 	}
 
 	// 对加载的段按StartIP排序
@@ -226,6 +332,12 @@ func (m *Maker) loadSegments() error {
 		return m.segments[i].StartIP < m.segments[j].StartIP
 	})
 
+	if m.strict {
+		if err := CheckContinuity(m.segments); err != nil {
+			return err
+		}
+	}
+
 	log.Printf("All segments loaded, length: %d, elapsed: %s", len(m.segments), time.Since(tStart))
 	return nil
 }
@@ -268,7 +380,7 @@ func (m *Maker) Start() error {
 	}
 
 	// 1, 将数据块写入XDB文件的指定位置
-	_, err := m.dstHandle.Seek(int64(HeaderInfoLength+VectorIndexLength), 0)
+	_, err := m.out.Seek(int64(HeaderInfoLength+VectorIndexLength), 0)
 	if err != nil {
 		return fmt.Errorf("seek to data first ptr: %w", err)
 	}
@@ -283,17 +395,17 @@ func (m *Maker) Start() error {
 		}
 
 		var region = []byte(seg.Region)
-		if len(region) > 0xFFFF {
-			return fmt.Errorf("too long region info `%s`: should be less than %d bytes", seg.Region, 0xFFFF)
+		if len(region) > MaxRegionLength {
+			return fmt.Errorf("too long region info `%s`: should be less than %d bytes", seg.Region, MaxRegionLength)
 		}
 
 		// get the first ptr of the next region
-		pos, err := m.dstHandle.Seek(0, 1)
+		pos, err := m.out.Seek(0, 1)
 		if err != nil {
 			return fmt.Errorf("seek to current ptr: %w", err)
 		}
 
-		_, err = m.dstHandle.Write(region)
+		_, err = m.out.Write(region)
 		if err != nil {
 			return fmt.Errorf("write region '%s': %w", seg.Region, err)
 		}
@@ -306,7 +418,16 @@ func (m *Maker) Start() error {
 	log.Printf("try to write the segment index block ... ")
 	var indexBuff = make([]byte, SegmentIndexSize)
 	var counter, startIndexPtr, endIndexPtr = 0, int64(-1), int64(-1)
-	for _, seg := range m.segments {
+	var total = len(m.segments)
+	var reportStep = total / 200
+	if reportStep < 1 {
+		reportStep = 1
+	}
+	for i, seg := range m.segments {
+		if m.progress != nil && (i%reportStep == 0) {
+			m.progress(i, total)
+		}
+
 		dataPtr, has := m.regionPool[seg.Region]
 		if !has {
 			return fmt.Errorf("missing ptr cache for region `%s`", seg.Region)
@@ -323,7 +444,7 @@ func (m *Maker) Start() error {
 		var segList = seg.Split()
 		// log.Printf("try to index segment(%d splits) %s ...", len(segList), seg.String())
 		for _, s := range segList {
-			pos, err := m.dstHandle.Seek(0, 1)
+			pos, err := m.out.Seek(0, 1)
 			if err != nil {
 				return fmt.Errorf("seek to segment index block: %w", err)
 			}
@@ -333,7 +454,7 @@ func (m *Maker) Start() error {
 			binary.LittleEndian.PutUint32(indexBuff[4:], s.EndIP)
 			binary.LittleEndian.PutUint16(indexBuff[8:], uint16(dataLen))
 			binary.LittleEndian.PutUint32(indexBuff[10:], dataPtr)
-			_, err = m.dstHandle.Write(indexBuff)
+			_, err = m.out.Write(indexBuff)
 			if err != nil {
 				return fmt.Errorf("write segment index for '%s': %w", s.String(), err)
 			}
@@ -351,13 +472,17 @@ func (m *Maker) Start() error {
 		}
 	}
 
+	if m.progress != nil {
+		m.progress(total, total)
+	}
+
 	// synchronized the vector index block
 	log.Printf("try to write the vector index block ... ")
-	_, err = m.dstHandle.Seek(int64(HeaderInfoLength), 0)
+	_, err = m.out.Seek(int64(HeaderInfoLength), 0)
 	if err != nil {
 		return fmt.Errorf("seek vector index first ptr: %w", err)
 	}
-	_, err = m.dstHandle.Write(m.vectorIndex)
+	_, err = m.out.Write(m.vectorIndex)
 	if err != nil {
 		return fmt.Errorf("write vector index: %w", err)
 	}
@@ -365,26 +490,41 @@ func (m *Maker) Start() error {
 	// synchronized the segment index info
 	binary.LittleEndian.PutUint32(indexBuff, uint32(startIndexPtr))
 	binary.LittleEndian.PutUint32(indexBuff[4:], uint32(endIndexPtr))
-	_, err = m.dstHandle.Seek(8, 0)
+	_, err = m.out.Seek(8, 0)
 	if err != nil {
 		return fmt.Errorf("seek segment index ptr: %w", err)
 	}
 
-	_, err = m.dstHandle.Write(indexBuff[:8])
+	_, err = m.out.Write(indexBuff[:8])
 	if err != nil {
 		return fmt.Errorf("write segment index ptr: %w", err)
 	}
 
+	m.built = true
 	return nil
 }
 
+// End flushes the temporary file and renames it over the target file
 func (m *Maker) End() error {
-	err := m.dstHandle.Close()
+	err := m.dstHandle.Sync()
 	if err != nil {
 		return err
 	}
 
+	err = m.dstHandle.Close()
+	m.dstHandle = nil
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(m.tmpFile, m.dstFile)
+	if err != nil {
+		return fmt.Errorf("rename `%s` to `%s`: %w", m.tmpFile, m.dstFile, err)
+	}
+	m.tmpFile = ""
+
 	err = m.srcHandle.Close()
+	m.srcHandle = nil
 	if err != nil {
 		return err
 	}
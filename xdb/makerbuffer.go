@@ -0,0 +1,130 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package xdb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// makerOutput Maker写入XDB内容的目标
+type makerOutput interface {
+	io.Writer
+	io.Seeker
+	io.WriterAt
+}
+
+// memBuffer 可以随机写入的内存缓冲区，写入超出当前长度时自动扩展
+type memBuffer struct {
+	buf []byte
+	pos int64
+}
+
+func (b *memBuffer) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	if end := off + int64(len(p)); end > int64(len(b.buf)) {
+		if end > int64(cap(b.buf)) {
+			grown := make([]byte, end, max(end, 2*int64(cap(b.buf))))
+			copy(grown, b.buf)
+			b.buf = grown
+		} else {
+			b.buf = b.buf[:end]
+		}
+	}
+
+	return copy(b.buf[off:], p), nil
+}
+
+func (b *memBuffer) Write(p []byte) (int, error) {
+	n, err := b.WriteAt(p, b.pos)
+	b.pos += int64(n)
+	return n, err
+}
+
+func (b *memBuffer) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = b.pos + offset
+	case io.SeekEnd:
+		pos = int64(len(b.buf)) + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if pos < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	b.pos = pos
+	return pos, nil
+}
+
+// mirrorOutput 写入临时文件的同时在内存中保留一份相同的内容
+type mirrorOutput struct {
+	file *os.File
+	mem  *memBuffer
+}
+
+func (o *mirrorOutput) Write(p []byte) (int, error) {
+	n, err := o.file.Write(p)
+	_, _ = o.mem.Write(p[:n])
+	return n, err
+}
+
+func (o *mirrorOutput) WriteAt(p []byte, off int64) (int, error) {
+	n, err := o.file.WriteAt(p, off)
+	_, _ = o.mem.WriteAt(p[:n], off)
+	return n, err
+}
+
+func (o *mirrorOutput) Seek(offset int64, whence int) (int64, error) {
+	pos, err := o.file.Seek(offset, whence)
+	if err != nil {
+		return pos, err
+	}
+	return o.mem.Seek(pos, io.SeekStart)
+}
+
+// SetKeepBuffer 开启后生成的XDB内容同时保留在内存中，Start 完成后可以通过 Buffer 或
+// NewSearcher 直接使用，不需要再从磁盘读取一遍文件；需要在 Init 之前调用
+func (m *Maker) SetKeepBuffer(keep bool) {
+	if !keep {
+		m.mem, m.out = nil, m.dstHandle
+		return
+	}
+
+	m.mem = &memBuffer{buf: make([]byte, 0, HeaderInfoLength+VectorIndexLength)}
+	m.out = &mirrorOutput{file: m.dstHandle, mem: m.mem}
+}
+
+// Buffer 返回 Start 写入的完整XDB内容，与生成的文件逐字节相同；
+// 没有调用 SetKeepBuffer 或 Start 尚未成功时返回错误。返回的切片不能再被修改
+func (m *Maker) Buffer() ([]byte, error) {
+	if m.mem == nil {
+		return nil, errors.New("the maker does not keep the xdb content in memory, call SetKeepBuffer before Init")
+	}
+	if !m.built {
+		return nil, errors.New("the xdb content is not complete, call Start first")
+	}
+
+	return m.mem.buf, nil
+}
+
+// NewSearcher 使用内存中的XDB内容创建内存模式的搜索器，与读取生成的文件后调用 NewWithBuffer 的结果相同
+func (m *Maker) NewSearcher() (*Searcher, error) {
+	buffer, err := m.Buffer()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWithBuffer(buffer)
+}
@@ -0,0 +1,274 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package xdb
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Precedence 整理源数据时重叠范围的取舍方式
+type Precedence int
+
+const (
+	LastWins  Precedence = iota // 源文件中靠后的行覆盖靠前的行，与逐行编辑的结果一致
+	FirstWins                   // 保留源文件中靠前的行
+)
+
+func (p Precedence) String() string {
+	if p == FirstWins {
+		return "first-wins"
+	}
+	return "last-wins"
+}
+
+// ParsePrecedence 解析重叠范围的取舍方式，空字符串表示last-wins
+func ParsePrecedence(name string) (Precedence, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "last-wins":
+		return LastWins, nil
+	case "first-wins":
+		return FirstWins, nil
+	default:
+		return LastWins, fmt.Errorf("unsupported precedence '%s', should be first-wins or last-wins", name)
+	}
+}
+
+// NormalizeOptions 整理源数据的选项
+type NormalizeOptions struct {
+	Precedence Precedence
+
+	// 填充空缺使用的地区信息，为空时使用与数据字段数量一致的默认地区，例如 0|0|0|0|0
+	FillRegion string
+}
+
+// NormalizeStats 整理过程中做出的修改
+type NormalizeStats struct {
+	InputSegments  int    `json:"inputSegments"`  // 读取的段数量，相邻且地区相同的行已合并
+	OutOfOrder     int    `json:"outOfOrder"`     // 起始IP小于前一个段的段数量
+	Overlaps       int    `json:"overlaps"`       // 被多个段覆盖的范围数量
+	OverlapIPs     uint64 `json:"overlapIps"`     // 被多个段覆盖的IP数量
+	Dropped        int    `json:"dropped"`        // 被其它段完全覆盖、没有保留的段数量
+	Merged         int    `json:"merged"`         // 排序或去除重叠后合并的相邻同地区段数量
+	Gaps           int    `json:"gaps"`           // 填充的空缺数量
+	GapIPs         uint64 `json:"gapIps"`         // 填充的IP数量
+	OutputSegments int    `json:"outputSegments"` // 写出的段数量
+	FillRegion     string `json:"fillRegion"`
+
+	// 处理过的空缺和重叠范围，最多MaxContinuityProblems个
+	Changes []ContinuityProblem `json:"changes,omitempty"`
+}
+
+func (st *NormalizeStats) addChange(kind string, sip, eip uint32) {
+	if len(st.Changes) < MaxContinuityProblems {
+		st.Changes = append(st.Changes, ContinuityProblem{Kind: kind, StartIP: Long2IP(sip), EndIP: Long2IP(eip)})
+	}
+}
+
+// normalizeItem 参与整理的段，rank越大优先级越高
+type normalizeItem struct {
+	seg  *Segment
+	rank int
+	won  bool
+}
+
+// normalizeHeap 按rank排列的覆盖当前位置的段，已结束的段在访问堆顶时才移除
+type normalizeHeap []*normalizeItem
+
+func (h normalizeHeap) Len() int           { return len(h) }
+func (h normalizeHeap) Less(i, j int) bool { return h[i].rank > h[j].rank }
+func (h normalizeHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *normalizeHeap) Push(x any)        { *h = append(*h, x.(*normalizeItem)) }
+func (h *normalizeHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// NormalizeSegments 将按源文件顺序排列的段整理为从0.0.0.0到255.255.255.255连续、无重叠的段：
+// 按起始IP排序，重叠的范围按opts.Precedence取舍，空缺用填充地区补齐，相邻的同地区段合并
+func NormalizeSegments(segments []*Segment, opts NormalizeOptions) ([]*Segment, NormalizeStats) {
+	var st = NormalizeStats{InputSegments: len(segments), FillRegion: opts.FillRegion}
+	if st.FillRegion == "" {
+		st.FillRegion = DefaultRegionWithFields(commonRegionFields(segments))
+	}
+
+	var items = make([]*normalizeItem, len(segments))
+	for i, seg := range segments {
+		rank := i
+		if opts.Precedence == FirstWins {
+			rank = len(segments) - i
+		}
+		items[i] = &normalizeItem{seg: seg, rank: rank}
+		if i > 0 && seg.StartIP < segments[i-1].StartIP {
+			st.OutOfOrder++
+		}
+	}
+
+	// 段的起点和终点+1把地址空间切分为若干区间，每个区间内覆盖它的段不变
+	var points = make([]uint64, 0, 2*len(segments)+2)
+	points = append(points, 0, math.MaxUint32+1)
+	for _, seg := range segments {
+		points = append(points, uint64(seg.StartIP), uint64(seg.EndIP)+1)
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i] < points[j] })
+
+	var starts = append([]*normalizeItem{}, items...)
+	sort.SliceStable(starts, func(i, j int) bool { return starts[i].seg.StartIP < starts[j].seg.StartIP })
+	var ends = make([]uint64, len(segments))
+	for i, seg := range segments {
+		ends[i] = uint64(seg.EndIP)
+	}
+	sort.Slice(ends, func(i, j int) bool { return ends[i] < ends[j] })
+
+	var out []*Segment
+	var lastWinner *normalizeItem
+	var active normalizeHeap
+	var activeCount, si, ei int
+	var inOverlap bool
+	var overlapStart uint64
+	for k := 0; k+1 < len(points); k++ {
+		var p, next = points[k], points[k+1]
+		if p == next {
+			continue
+		}
+
+		for si < len(starts) && uint64(starts[si].seg.StartIP) == p {
+			heap.Push(&active, starts[si])
+			activeCount++
+			si++
+		}
+		for ei < len(ends) && ends[ei] < p {
+			activeCount--
+			ei++
+		}
+		for active.Len() > 0 && uint64(active[0].seg.EndIP) < p {
+			heap.Pop(&active)
+		}
+
+		// 连续的重叠区间算作一处重叠
+		if activeCount > 1 {
+			st.OverlapIPs += next - p
+			if !inOverlap {
+				inOverlap, overlapStart = true, p
+			}
+		} else if inOverlap {
+			st.Overlaps++
+			st.addChange("overlap", uint32(overlapStart), uint32(p-1))
+			inOverlap = false
+		}
+
+		var region string
+		var winner *normalizeItem
+		if active.Len() > 0 {
+			winner = active[0]
+			winner.won = true
+			region = winner.seg.Region
+		} else {
+			st.Gaps++
+			st.GapIPs += next - p
+			st.addChange("gap", uint32(p), uint32(next-1))
+			region = st.FillRegion
+		}
+
+		if n := len(out); n > 0 && out[n-1].Region == region {
+			out[n-1].EndIP = uint32(next - 1)
+			if winner != lastWinner {
+				st.Merged++
+			}
+		} else {
+			out = append(out, &Segment{StartIP: uint32(p), EndIP: uint32(next - 1), Region: region})
+		}
+		lastWinner = winner
+	}
+	if inOverlap {
+		st.Overlaps++
+		st.addChange("overlap", uint32(overlapStart), math.MaxUint32)
+	}
+
+	for _, item := range items {
+		if !item.won {
+			st.Dropped++
+		}
+	}
+
+	st.OutputSegments = len(out)
+	return out, st
+}
+
+// commonRegionFields 返回非默认地区中最常见的字段数量，没有时取DefaultRegion的字段数量
+func commonRegionFields(segments []*Segment) int {
+	var counts = map[int]int{}
+	for _, seg := range segments {
+		if !IsDefaultRegion(seg.Region) {
+			counts[strings.Count(seg.Region, "|")+1]++
+		}
+	}
+
+	fields, best := strings.Count(DefaultRegion, "|")+1, 0
+	for n, c := range counts {
+		if c > best || (c == best && n < fields) {
+			fields, best = n, c
+		}
+	}
+	return fields
+}
+
+// NormalizeFile 读取可以乱序、重叠的源文件srcFile，整理后写入dstFile，
+// 先写临时文件再重命名，dstFile中每行一个段，使用与源文件相同的编码
+func NormalizeFile(srcFile string, dstFile string, encoding string, opts NormalizeOptions) (NormalizeStats, error) {
+	handle, err := os.Open(srcFile)
+	if err != nil {
+		return NormalizeStats{}, err
+	}
+	defer handle.Close()
+
+	reader, err := NewSourceReader(handle, encoding)
+	if err != nil {
+		return NormalizeStats{}, err
+	}
+
+	var segments []*Segment
+	err = IterateSegments(reader, func(l string) {}, func(seg *Segment) error {
+		if err := checkSegmentCount(len(segments)); err != nil {
+			return err
+		}
+		segments = append(segments, seg)
+		return nil
+	})
+	if err != nil {
+		return NormalizeStats{}, fmt.Errorf("failed to load segments: %w", err)
+	}
+
+	out, st := NormalizeSegments(segments, opts)
+	if err := writeSourceFile(dstFile, out, encoding); err != nil {
+		return st, err
+	}
+	return st, nil
+}
+
+// writeSourceFile 把段按源文件格式写入临时文件并重命名为dstFile
+func writeSourceFile(dstFile string, segments []*Segment, encoding string) error {
+	return writeFileAtomic(dstFile, TargetFileMode(dstFile, 0), func(dst io.Writer) error {
+		w := bufio.NewWriter(dst)
+		for _, seg := range segments {
+			line, err := encodeSourceLine(seg.String()+"\n", encoding)
+			if err != nil {
+				return err
+			}
+			if _, err = io.WriteString(w, line); err != nil {
+				return err
+			}
+		}
+		return w.Flush()
+	})
+}
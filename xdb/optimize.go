@@ -0,0 +1,176 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+// ----
+// rewrite an xdb with its region data laid out in segment index order
+//
+// patches and other in-place rewrites may leave the data block with unused
+// bytes and regions far away from the index entries that refer to them. the
+// optimized database keeps the header, the index entries and the trailer,
+// regions are deduped and written in the order they are first referenced,
+// the same layout the Maker produces.
+
+package xdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DefaultOptimizeSamples 校验优化结果时默认比较的查询次数
+const DefaultOptimizeSamples = 10000
+
+// OptimizeStats 描述优化前后的数据库
+type OptimizeStats struct {
+	OldSize     int `json:"oldSize"`
+	NewSize     int `json:"newSize"`
+	OldDataSize int `json:"oldDataSize"` // 优化前数据块的字节数
+	NewDataSize int `json:"newDataSize"` // 优化后数据块的字节数
+	Regions     int `json:"regions"`     // 去重后的地区数量
+	Entries     int `json:"entries"`     // 段索引条数，优化前后相同
+	Verified    int `json:"verified"`    // 校验时比较的查询次数
+}
+
+// Optimize 按段索引的顺序重新排列buf的数据块并去掉重复的地区，返回新的数据库内容，
+// 段索引条目只有数据位置变化，查询结果与buf完全相同
+func Optimize(buf []byte) ([]byte, *OptimizeStats, error) {
+	layout, err := parseLayout(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var index = buf[layout.indexStart:layout.indexEnd]
+	var stats = &OptimizeStats{
+		OldSize:     len(buf),
+		OldDataSize: layout.indexStart - layout.dataStart,
+		Entries:     len(index) / SegmentIndexSize,
+	}
+
+	// 1, data block in the order of the index entries
+	var out = make([]byte, layout.dataStart, len(buf))
+	copy(out, buf[:HeaderInfoLength])
+	var pool = map[string]uint32{}
+	var ptrs = make([]uint32, stats.Entries)
+	for i := range ptrs {
+		entry := index[i*SegmentIndexSize:]
+		dataLen := int(binary.LittleEndian.Uint16(entry[8:]))
+		dataPtr := int(binary.LittleEndian.Uint32(entry[10:]))
+		if dataLen == 0 {
+			continue
+		}
+		if dataPtr < layout.dataStart || dataPtr+dataLen > len(buf) {
+			return nil, nil, fmt.Errorf("index entry %d: region data [%d, %d) out of range", i, dataPtr, dataPtr+dataLen)
+		}
+
+		region := buf[dataPtr : dataPtr+dataLen]
+		ptr, has := pool[string(region)]
+		if !has {
+			ptr = uint32(len(out))
+			pool[string(region)] = ptr
+			out = append(out, region...)
+		}
+		ptrs[i] = ptr
+	}
+	stats.Regions = len(pool)
+	stats.NewDataSize = len(out) - layout.dataStart
+
+	// 2, index block with the data ptr moved, entries without data are kept as-is
+	var indexStart = len(out)
+	for i, ptr := range ptrs {
+		entry := index[i*SegmentIndexSize : (i+1)*SegmentIndexSize]
+		out = append(out, entry...)
+		if binary.LittleEndian.Uint16(entry[8:]) > 0 {
+			binary.LittleEndian.PutUint32(out[len(out)-4:], ptr)
+		}
+	}
+
+	binary.LittleEndian.PutUint32(out[8:], uint32(indexStart))
+	binary.LittleEndian.PutUint32(out[12:], uint32(len(out)-SegmentIndexSize))
+
+	// 3, vector index and trailer
+	copy(out[HeaderInfoLength:], deriveVectorIndex(out[indexStart:], indexStart))
+	out = append(out, buf[layout.indexEnd:]...)
+
+	stats.NewSize = len(out)
+	return out, stats, nil
+}
+
+// VerifyOptimized 比较两个数据库对同一组IP的查询结果，包括每条段索引的起止IP
+// 以及在整个IP空间中均匀分布的IP，samples为比较的IP数量上限，返回比较的次数
+func VerifyOptimized(oldBuf []byte, newBuf []byte, samples int) (int, error) {
+	oldSearcher, err := NewWithBuffer(oldBuf)
+	if err != nil {
+		return 0, fmt.Errorf("old xdb: %w", err)
+	}
+	defer oldSearcher.Close()
+	newSearcher, err := NewWithBuffer(newBuf)
+	if err != nil {
+		return 0, fmt.Errorf("new xdb: %w", err)
+	}
+	defer newSearcher.Close()
+
+	layout, err := parseLayout(oldBuf)
+	if err != nil {
+		return 0, err
+	}
+	var index = oldBuf[layout.indexStart:layout.indexEnd]
+	var entries = len(index) / SegmentIndexSize
+
+	// half of the samples are bounds of evenly picked entries, the rest evenly spread ips
+	var ips []uint32
+	var step = max(1, entries*2/max(1, samples))
+	for i := 0; i < entries && len(ips) < samples/2; i += step {
+		entry := index[i*SegmentIndexSize:]
+		ips = append(ips, binary.LittleEndian.Uint32(entry), binary.LittleEndian.Uint32(entry[4:]))
+	}
+	for n := samples - len(ips); n > 0; n-- {
+		ips = append(ips, uint32(uint64(n)*0xFFFFFFFF/uint64(samples)))
+	}
+
+	for _, ip := range ips {
+		oldRegion, _, err := oldSearcher.Search(ip)
+		if err != nil {
+			return 0, fmt.Errorf("search %s in old xdb: %w", Long2IP(ip), err)
+		}
+		newRegion, _, err := newSearcher.Search(ip)
+		if err != nil {
+			return 0, fmt.Errorf("search %s in new xdb: %w", Long2IP(ip), err)
+		}
+		if oldRegion != newRegion {
+			return 0, fmt.Errorf("ip %s: region `%s` in the optimized xdb, `%s` in the original", Long2IP(ip), newRegion, oldRegion)
+		}
+	}
+
+	return len(ips), nil
+}
+
+// OptimizeFile 优化srcXdb并写入dstXdb，写入前用samples次查询校验结果，
+// 与Maker一样先写临时文件再重命名，dstXdb可以是srcXdb本身或正在使用的数据库；
+// mode为dstXdb的权限，0表示保留已有文件的权限或使用默认权限
+func OptimizeFile(srcXdb string, dstXdb string, samples int, mode os.FileMode) (*OptimizeStats, error) {
+	buf, err := os.ReadFile(srcXdb)
+	if err != nil {
+		return nil, err
+	}
+
+	out, stats, err := Optimize(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	if stats.Verified, err = VerifyOptimized(buf, out, samples); err != nil {
+		return nil, fmt.Errorf("verify optimized xdb: %w", err)
+	}
+
+	err = writeFileAtomic(dstXdb, TargetFileMode(dstXdb, mode), func(w io.Writer) error {
+		_, err := w.Write(out)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
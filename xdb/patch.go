@@ -0,0 +1,558 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+// ----
+// binary patch between two xdb versions
+//
+// the patch is gzip compressed and rebuilds the new database section by
+// section from the old one, the output is verified byte for byte against
+// the sha256 of the new database recorded in the patch.
+//
+// +-------+---------+----------+---------+--------+------------+---------+
+// | magic | version | checksum | header  | data   | index      | vector  | trailer
+// |       |         | old+new  | 256B    | ops    | ops        | cells   |
+// +-------+---------+----------+---------+--------+------------+---------+
+//
+// 1. data ops : copy a byte range of the old data block, or literal bytes.
+// 2. index ops: copy a run of old index entries with their data ptr moved
+//    to where the copied data landed, or literal raw entries.
+// 3. vector  : derived from the new index entries the same way the Maker
+//    builds it, followed by the cells that differ from the derived one.
+// 4. trailer : whatever follows the segment index block, normally empty.
+
+package xdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+const patchMagic = "XDBPATCH"
+const patchVersion = 1
+
+const (
+	patchOpCopy    = 0
+	patchOpLiteral = 1
+)
+
+// ErrPatchBaseMismatch is returned when a patch is applied to a database
+// other than the one it was made from
+var ErrPatchBaseMismatch = errors.New("patch base mismatch")
+
+// PatchStats 描述补丁的组成
+type PatchStats struct {
+	OldSize         int `json:"oldSize"`
+	NewSize         int `json:"newSize"`
+	PatchSize       int `json:"patchSize"`
+	DataCopyOps     int `json:"dataCopyOps"`     // 复用旧数据块的区间数
+	DataLiteralSize int `json:"dataLiteralSize"` // 新增的地区数据字节数
+	CopiedEntries   int `json:"copiedEntries"`   // 复用的段索引条数
+	LiteralEntries  int `json:"literalEntries"`  // 新增或变化的段索引条数
+	VectorOverrides int `json:"vectorOverrides"` // 与推导结果不同的向量索引单元格数
+}
+
+// xdbLayout 数据库各部分的边界
+type xdbLayout struct {
+	dataStart  int
+	indexStart int
+	indexEnd   int // exclusive
+}
+
+func parseLayout(buf []byte) (xdbLayout, error) {
+	var l = xdbLayout{dataStart: HeaderInfoLength + VectorIndexLength}
+	if len(buf) < l.dataStart {
+		return l, fmt.Errorf("too small for an xdb file: %d bytes", len(buf))
+	}
+
+	sPtr := int(binary.LittleEndian.Uint32(buf[8:]))
+	ePtr := int(binary.LittleEndian.Uint32(buf[12:]))
+	if sPtr < l.dataStart || ePtr < sPtr || (ePtr-sPtr)%SegmentIndexSize != 0 || ePtr+SegmentIndexSize > len(buf) {
+		return l, fmt.Errorf("invalid segment index block range [%d, %d]", sPtr, ePtr)
+	}
+
+	l.indexStart = sPtr
+	l.indexEnd = ePtr + SegmentIndexSize
+	return l, nil
+}
+
+type patchCopy struct {
+	oldPtr uint32
+	newPtr uint32
+	length uint32
+}
+
+// ptrTranslator 按数据块的复制操作把旧的数据位置换算为新的数据位置，
+// 生成和应用补丁时使用同一份规则
+type ptrTranslator []patchCopy
+
+func newPtrTranslator(copies []patchCopy) ptrTranslator {
+	var t = append(ptrTranslator(nil), copies...)
+	sort.SliceStable(t, func(i, j int) bool { return t[i].oldPtr < t[j].oldPtr })
+	return t
+}
+
+func (t ptrTranslator) translate(ptr uint32, length uint32) (uint32, bool) {
+	// the last copy starting at or before ptr
+	i := sort.Search(len(t), func(i int) bool { return t[i].oldPtr > ptr }) - 1
+	if i < 0 || uint64(ptr)+uint64(length) > uint64(t[i].oldPtr)+uint64(t[i].length) {
+		return 0, false
+	}
+
+	return t[i].newPtr + (ptr - t[i].oldPtr), true
+}
+
+// translateEntry 将旧的段索引条目换算为新数据块中的条目
+func (t ptrTranslator) translateEntry(dst []byte, entry []byte) bool {
+	ptr, ok := t.translate(binary.LittleEndian.Uint32(entry[10:]), uint32(binary.LittleEndian.Uint16(entry[8:])))
+	if !ok {
+		return false
+	}
+
+	copy(dst, entry[:10])
+	binary.LittleEndian.PutUint32(dst[10:], ptr)
+	return true
+}
+
+// deriveVectorIndex 按Maker的规则由段索引块推导向量索引
+func deriveVectorIndex(index []byte, indexStart int) []byte {
+	var vector = make([]byte, VectorIndexLength)
+	for off := 0; off+SegmentIndexSize <= len(index); off += SegmentIndexSize {
+		ip := binary.LittleEndian.Uint32(index[off:])
+		ptr := uint32(indexStart + off)
+		idx := ((ip>>24)&0xFF)*VectorIndexCols*VectorIndexSize + ((ip>>16)&0xFF)*VectorIndexSize
+		if binary.LittleEndian.Uint32(vector[idx:]) == 0 {
+			binary.LittleEndian.PutUint32(vector[idx:], ptr)
+		}
+		binary.LittleEndian.PutUint32(vector[idx+4:], ptr+SegmentIndexSize)
+	}
+
+	return vector
+}
+
+// MakePatch 生成把oldBuf变为newBuf的二进制补丁
+func MakePatch(oldBuf []byte, newBuf []byte) ([]byte, *PatchStats, error) {
+	oldLayout, err := parseLayout(oldBuf)
+	if err != nil {
+		return nil, nil, fmt.Errorf("old xdb: %w", err)
+	}
+	newLayout, err := parseLayout(newBuf)
+	if err != nil {
+		return nil, nil, fmt.Errorf("new xdb: %w", err)
+	}
+
+	var stats = &PatchStats{OldSize: len(oldBuf), NewSize: len(newBuf)}
+	var body bytes.Buffer
+	var u32 = func(v int) {
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], uint32(v))
+		body.Write(b[:])
+	}
+
+	oldSum, newSum := sha256.Sum256(oldBuf), sha256.Sum256(newBuf)
+	body.WriteString(patchMagic)
+	body.Write([]byte{patchVersion, 0})
+	u32(len(oldBuf))
+	body.Write(oldSum[:])
+	u32(len(newBuf))
+	body.Write(newSum[:])
+	body.Write(newBuf[:HeaderInfoLength])
+
+	// 1, data block: locate every region of the old database by its bytes
+	var oldIndex = oldBuf[oldLayout.indexStart:oldLayout.indexEnd]
+	var oldRegions = map[string]uint32{}
+	for off := 0; off < len(oldIndex); off += SegmentIndexSize {
+		dataLen := int(binary.LittleEndian.Uint16(oldIndex[off+8:]))
+		dataPtr := int(binary.LittleEndian.Uint32(oldIndex[off+10:]))
+		if dataPtr+dataLen > len(oldBuf) {
+			return nil, nil, fmt.Errorf("old xdb: data ptr %d out of range", dataPtr)
+		}
+		region := string(oldBuf[dataPtr : dataPtr+dataLen])
+		if _, ok := oldRegions[region]; !ok {
+			oldRegions[region] = uint32(dataPtr)
+		}
+	}
+
+	// regions of the new data block in file order
+	var newIndex = newBuf[newLayout.indexStart:newLayout.indexEnd]
+	type span struct{ ptr, length int }
+	var spans []span
+	var seen = map[int]bool{}
+	for off := 0; off < len(newIndex); off += SegmentIndexSize {
+		dataLen := int(binary.LittleEndian.Uint16(newIndex[off+8:]))
+		dataPtr := int(binary.LittleEndian.Uint32(newIndex[off+10:]))
+		if dataPtr < newLayout.dataStart || dataPtr+dataLen > newLayout.indexStart {
+			return nil, nil, fmt.Errorf("new xdb: data ptr %d out of the data block", dataPtr)
+		}
+		if !seen[dataPtr] {
+			seen[dataPtr] = true
+			spans = append(spans, span{dataPtr, dataLen})
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].ptr < spans[j].ptr })
+
+	// consecutive ops cover consecutive bytes of the new data block,
+	// so a literal op is the range of newBuf starting at newStart
+	type dataOp struct {
+		copy     bool
+		oldPtr   int
+		newStart int
+		length   int
+	}
+	var ops []dataOp
+	var emit = func(op dataOp) {
+		if n := len(ops); n > 0 {
+			last := &ops[n-1]
+			if last.copy && op.copy && last.oldPtr+last.length == op.oldPtr {
+				last.length += op.length
+				return
+			}
+			if !last.copy && !op.copy {
+				last.length += op.length
+				return
+			}
+		}
+		ops = append(ops, op)
+	}
+
+	var cursor = newLayout.dataStart
+	for _, sp := range spans {
+		if sp.ptr < cursor {
+			// overlaps the previous region, already covered
+			if sp.ptr+sp.length > cursor {
+				emit(dataOp{newStart: cursor, length: sp.ptr + sp.length - cursor})
+				cursor = sp.ptr + sp.length
+			}
+			continue
+		}
+		if sp.ptr > cursor {
+			emit(dataOp{newStart: cursor, length: sp.ptr - cursor})
+		}
+
+		region := newBuf[sp.ptr : sp.ptr+sp.length]
+		if oldPtr, ok := oldRegions[string(region)]; ok && sp.length > 0 {
+			emit(dataOp{copy: true, oldPtr: int(oldPtr), newStart: sp.ptr, length: sp.length})
+		} else {
+			emit(dataOp{newStart: sp.ptr, length: sp.length})
+		}
+		cursor = sp.ptr + sp.length
+	}
+	if cursor < newLayout.indexStart {
+		emit(dataOp{newStart: cursor, length: newLayout.indexStart - cursor})
+	}
+
+	var copies []patchCopy
+	var newPtr = newLayout.dataStart
+	u32(len(ops))
+	for _, op := range ops {
+		if op.copy {
+			body.WriteByte(patchOpCopy)
+			u32(op.oldPtr)
+			u32(op.length)
+			copies = append(copies, patchCopy{oldPtr: uint32(op.oldPtr), newPtr: uint32(newPtr), length: uint32(op.length)})
+			stats.DataCopyOps++
+		} else {
+			body.WriteByte(patchOpLiteral)
+			u32(op.length)
+			body.Write(newBuf[op.newStart : op.newStart+op.length])
+			stats.DataLiteralSize += op.length
+		}
+		newPtr += op.length
+	}
+
+	// 2, index block: runs of old entries that only moved with their data
+	var translator = newPtrTranslator(copies)
+	var oldBySip = make(map[uint32]int, len(oldIndex)/SegmentIndexSize)
+	for off := 0; off < len(oldIndex); off += SegmentIndexSize {
+		oldBySip[binary.LittleEndian.Uint32(oldIndex[off:])] = off
+	}
+
+	var entry = make([]byte, SegmentIndexSize)
+	var matches = func(oldOff, newOff int) bool {
+		if oldOff >= len(oldIndex) || newOff >= len(newIndex) {
+			return false
+		}
+		return translator.translateEntry(entry, oldIndex[oldOff:oldOff+SegmentIndexSize]) &&
+			bytes.Equal(entry, newIndex[newOff:newOff+SegmentIndexSize])
+	}
+
+	var indexOps bytes.Buffer
+	var indexOpCount = 0
+	var literalStart = -1
+	var flushLiteral = func(end int) {
+		if literalStart < 0 {
+			return
+		}
+		var b [9]byte
+		b[0] = patchOpLiteral
+		binary.LittleEndian.PutUint32(b[1:], uint32((end-literalStart)/SegmentIndexSize))
+		indexOps.Write(b[:5])
+		indexOps.Write(newIndex[literalStart:end])
+		stats.LiteralEntries += (end - literalStart) / SegmentIndexSize
+		indexOpCount++
+		literalStart = -1
+	}
+
+	for off := 0; off < len(newIndex); {
+		oldOff, ok := oldBySip[binary.LittleEndian.Uint32(newIndex[off:])]
+		if !ok || !matches(oldOff, off) {
+			if literalStart < 0 {
+				literalStart = off
+			}
+			off += SegmentIndexSize
+			continue
+		}
+
+		flushLiteral(off)
+		var n = 1
+		for matches(oldOff+n*SegmentIndexSize, off+n*SegmentIndexSize) {
+			n++
+		}
+
+		var b [9]byte
+		b[0] = patchOpCopy
+		binary.LittleEndian.PutUint32(b[1:], uint32(oldOff/SegmentIndexSize))
+		binary.LittleEndian.PutUint32(b[5:], uint32(n))
+		indexOps.Write(b[:])
+		stats.CopiedEntries += n
+		indexOpCount++
+		off += n * SegmentIndexSize
+	}
+	flushLiteral(len(newIndex))
+
+	u32(indexOpCount)
+	body.Write(indexOps.Bytes())
+
+	// 3, vector index: only the cells the Maker rule does not reproduce
+	var derived = deriveVectorIndex(newIndex, newLayout.indexStart)
+	var vector = newBuf[HeaderInfoLength : HeaderInfoLength+VectorIndexLength]
+	var cells bytes.Buffer
+	for idx := 0; idx < VectorIndexLength; idx += VectorIndexSize {
+		if !bytes.Equal(derived[idx:idx+VectorIndexSize], vector[idx:idx+VectorIndexSize]) {
+			var b [4]byte
+			binary.LittleEndian.PutUint32(b[:], uint32(idx/VectorIndexSize))
+			cells.Write(b[:])
+			cells.Write(vector[idx : idx+VectorIndexSize])
+			stats.VectorOverrides++
+		}
+	}
+	u32(stats.VectorOverrides)
+	body.Write(cells.Bytes())
+
+	// 4, trailer
+	u32(len(newBuf) - newLayout.indexEnd)
+	body.Write(newBuf[newLayout.indexEnd:])
+
+	var out bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&out, gzip.BestCompression)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err = zw.Write(body.Bytes()); err != nil {
+		return nil, nil, err
+	}
+	if err = zw.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	stats.PatchSize = out.Len()
+	return out.Bytes(), stats, nil
+}
+
+// patchReader 顺序读取补丁内容，出错后后续读取都返回零值
+type patchReader struct {
+	buf []byte
+	err error
+}
+
+func (r *patchReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.buf) {
+		r.err = fmt.Errorf("truncated patch")
+		return nil
+	}
+
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *patchReader) u32() int {
+	if b := r.next(4); b != nil {
+		return int(binary.LittleEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *patchReader) u8() byte {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+// ApplyPatch 将MakePatch生成的补丁应用到oldBuf上，返回新的数据库内容，
+// 补丁与oldBuf不匹配时返回ErrPatchBaseMismatch
+func ApplyPatch(oldBuf []byte, patch []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(patch))
+	if err != nil {
+		return nil, fmt.Errorf("invalid patch: %w", err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid patch: %w", err)
+	}
+
+	var r = &patchReader{buf: body}
+	if string(r.next(len(patchMagic))) != patchMagic {
+		return nil, fmt.Errorf("invalid patch: bad magic")
+	}
+	if v := r.next(2); v == nil || v[0] != patchVersion {
+		return nil, fmt.Errorf("invalid patch: unsupported version")
+	}
+
+	oldSize := r.u32()
+	oldSum := r.next(sha256.Size)
+	newSize := r.u32()
+	newSum := r.next(sha256.Size)
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid patch: %w", r.err)
+	}
+	if sum := sha256.Sum256(oldBuf); oldSize != len(oldBuf) || !bytes.Equal(sum[:], oldSum) {
+		return nil, ErrPatchBaseMismatch
+	}
+
+	oldLayout, err := parseLayout(oldBuf)
+	if err != nil {
+		return nil, fmt.Errorf("old xdb: %w", err)
+	}
+
+	// the recorded size is only a hint until the checksum is verified
+	var out = make([]byte, HeaderInfoLength+VectorIndexLength, max(HeaderInfoLength+VectorIndexLength, min(newSize, len(oldBuf)+len(body))))
+	copy(out, r.next(HeaderInfoLength))
+
+	// 1, data block
+	var copies []patchCopy
+	for n := r.u32(); n > 0 && r.err == nil; n-- {
+		switch r.u8() {
+		case patchOpCopy:
+			oldPtr, length := r.u32(), r.u32()
+			if oldPtr < oldLayout.dataStart || oldPtr+length > len(oldBuf) {
+				return nil, fmt.Errorf("invalid patch: data copy [%d, %d) out of range", oldPtr, oldPtr+length)
+			}
+			copies = append(copies, patchCopy{oldPtr: uint32(oldPtr), newPtr: uint32(len(out)), length: uint32(length)})
+			out = append(out, oldBuf[oldPtr:oldPtr+length]...)
+		case patchOpLiteral:
+			out = append(out, r.next(r.u32())...)
+		default:
+			return nil, fmt.Errorf("invalid patch: unknown data op")
+		}
+	}
+
+	// 2, index block
+	var translator = newPtrTranslator(copies)
+	var oldIndex = oldBuf[oldLayout.indexStart:oldLayout.indexEnd]
+	var indexStart = len(out)
+	var entry = make([]byte, SegmentIndexSize)
+	for n := r.u32(); n > 0 && r.err == nil; n-- {
+		switch r.u8() {
+		case patchOpCopy:
+			first, count := r.u32(), r.u32()
+			if (first+count)*SegmentIndexSize > len(oldIndex) {
+				return nil, fmt.Errorf("invalid patch: index copy out of range")
+			}
+			for i := first; i < first+count; i++ {
+				if !translator.translateEntry(entry, oldIndex[i*SegmentIndexSize:(i+1)*SegmentIndexSize]) {
+					return nil, fmt.Errorf("invalid patch: index entry %d refers to data that was not copied", i)
+				}
+				out = append(out, entry...)
+			}
+		case patchOpLiteral:
+			out = append(out, r.next(r.u32()*SegmentIndexSize)...)
+		default:
+			return nil, fmt.Errorf("invalid patch: unknown index op")
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid patch: %w", r.err)
+	}
+
+	// 3, vector index
+	var vector = out[HeaderInfoLength : HeaderInfoLength+VectorIndexLength]
+	copy(vector, deriveVectorIndex(out[indexStart:], indexStart))
+	for n := r.u32(); n > 0 && r.err == nil; n-- {
+		cell := r.u32()
+		value := r.next(VectorIndexSize)
+		if cell >= VectorIndexRows*VectorIndexCols {
+			return nil, fmt.Errorf("invalid patch: vector cell %d out of range", cell)
+		}
+		copy(vector[cell*VectorIndexSize:], value)
+	}
+
+	// 4, trailer
+	out = append(out, r.next(r.u32())...)
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid patch: %w", r.err)
+	}
+
+	if sum := sha256.Sum256(out); len(out) != newSize || !bytes.Equal(sum[:], newSum) {
+		return nil, fmt.Errorf("patched xdb does not match the checksum recorded in the patch")
+	}
+
+	return out, nil
+}
+
+// MakePatchFile 生成由oldXdb变为newXdb的补丁文件
+func MakePatchFile(oldXdb string, newXdb string, patchFile string) (*PatchStats, error) {
+	oldBuf, err := os.ReadFile(oldXdb)
+	if err != nil {
+		return nil, err
+	}
+	newBuf, err := os.ReadFile(newXdb)
+	if err != nil {
+		return nil, err
+	}
+
+	patch, stats, err := MakePatch(oldBuf, newBuf)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.WriteFile(patchFile, patch, DefaultFileMode()); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// ApplyPatchFile 将补丁应用到oldXdb并写入newXdb，
+// 与Maker一样先写临时文件再重命名，newXdb可以是正在使用的数据库
+func ApplyPatchFile(oldXdb string, patchFile string, newXdb string) error {
+	oldBuf, err := os.ReadFile(oldXdb)
+	if err != nil {
+		return err
+	}
+	patch, err := os.ReadFile(patchFile)
+	if err != nil {
+		return err
+	}
+
+	out, err := ApplyPatch(oldBuf, patch)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(newXdb, TargetFileMode(newXdb, 0), func(w io.Writer) error {
+		_, err := w.Write(out)
+		return err
+	})
+}
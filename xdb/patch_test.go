@@ -0,0 +1,99 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package xdb
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// makeTestXdb 使用Maker将src生成XDB并返回文件内容
+func makeTestXdb(t testing.TB, src string) []byte {
+	t.Helper()
+
+	dir := t.TempDir()
+	srcFile, dstFile := filepath.Join(dir, "src.txt"), filepath.Join(dir, "dst.xdb")
+	if err := os.WriteFile(srcFile, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	maker, err := NewMaker(VectorIndexPolicy, srcFile, dstFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer maker.Close()
+	if err = maker.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err = maker.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err = maker.End(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := os.ReadFile(dstFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+const (
+	patchTestOld = "0.0.0.0|0.255.255.255|保留|0|0|0|0\n" +
+		"1.0.0.0|1.0.0.255|中国|0|广东省|广州市|电信\n" +
+		"1.0.1.0|1.0.3.255|中国|0|福建省|福州市|电信\n" +
+		"1.0.4.0|255.255.255.255|0|0|0|0|0\n"
+	patchTestNew = "0.0.0.0|0.255.255.255|保留|0|0|0|0\n" +
+		"1.0.0.0|1.0.0.127|中国|0|广东省|广州市|电信\n" +
+		"1.0.0.128|1.0.0.255|中国|0|广东省|深圳市|联通\n" +
+		"1.0.1.0|1.0.3.255|中国|0|福建省|福州市|电信\n" +
+		"1.0.4.0|8.8.8.7|0|0|0|0|0\n" +
+		"8.8.8.8|8.8.8.8|美国|0|加利福尼亚|0|Google\n" +
+		"8.8.8.9|255.255.255.255|0|0|0|0|0\n"
+)
+
+func TestPatchRoundTrip(t *testing.T) {
+	oldBuf := makeTestXdb(t, patchTestOld)
+	newBuf := makeTestXdb(t, patchTestNew)
+
+	patch, _, err := MakePatch(oldBuf, newBuf)
+	if err != nil {
+		t.Fatalf("MakePatch: %v", err)
+	}
+
+	got, err := ApplyPatch(oldBuf, patch)
+	if err != nil {
+		t.Fatalf("ApplyPatch: %v", err)
+	}
+	if !bytes.Equal(got, newBuf) {
+		t.Fatalf("patched xdb differs from the new xdb: %d bytes, want %d", len(got), len(newBuf))
+	}
+}
+
+func TestPatchBaseMismatch(t *testing.T) {
+	oldBuf := makeTestXdb(t, patchTestOld)
+	newBuf := makeTestXdb(t, patchTestNew)
+
+	patch, _, err := MakePatch(oldBuf, newBuf)
+	if err != nil {
+		t.Fatalf("MakePatch: %v", err)
+	}
+
+	// the new xdb is a valid database but not the base the patch was made against
+	if _, err = ApplyPatch(newBuf, patch); !errors.Is(err, ErrPatchBaseMismatch) {
+		t.Fatalf("ApplyPatch on a wrong base: got %v, want ErrPatchBaseMismatch", err)
+	}
+
+	// a single flipped byte in the base is detected by the checksum
+	var corrupted = bytes.Clone(oldBuf)
+	corrupted[len(corrupted)-1] ^= 0xFF
+	if _, err = ApplyPatch(corrupted, patch); !errors.Is(err, ErrPatchBaseMismatch) {
+		t.Fatalf("ApplyPatch on a corrupted base: got %v, want ErrPatchBaseMismatch", err)
+	}
+}
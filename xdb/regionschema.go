@@ -0,0 +1,114 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package xdb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RegionSchema 地区信息的结构要求：按 | 分隔的字段数量和不能为空的字段。
+// 字段为空或为0都算作空；全部字段为空的默认地区 (例如 0|0|0|0|0) 只检查字段数量
+type RegionSchema struct {
+	Fields   int   `json:"fields"`             // 字段数量，0表示不限制
+	Required []int `json:"required,omitempty"` // 不能为空的字段下标，从0开始
+}
+
+// Validate 检查结构要求本身是否有效
+func (rs *RegionSchema) Validate() error {
+	if rs.Fields < 0 {
+		return fmt.Errorf("invalid field count %d", rs.Fields)
+	}
+
+	for _, i := range rs.Required {
+		if i < 0 || (rs.Fields > 0 && i >= rs.Fields) {
+			return fmt.Errorf("required field index %d out of range", i)
+		}
+	}
+
+	return nil
+}
+
+// Check 检查地区信息是否符合结构要求，符合时返回空字符串，否则返回原因
+func (rs *RegionSchema) Check(region string) string {
+	fields := strings.Split(region, "|")
+	if rs.Fields > 0 && len(fields) != rs.Fields {
+		return fmt.Sprintf("地区信息有%d个字段，要求%d个", len(fields), rs.Fields)
+	}
+
+	if IsDefaultRegion(region) {
+		return ""
+	}
+
+	for _, i := range rs.Required {
+		if i >= len(fields) {
+			return fmt.Sprintf("地区信息只有%d个字段，缺少必填的第%d个字段", len(fields), i+1)
+		}
+		if v := strings.TrimSpace(fields[i]); v == "" || v == "0" {
+			return fmt.Sprintf("必填的第%d个字段为空", i+1)
+		}
+	}
+
+	return ""
+}
+
+// RegionViolation 一个不符合结构要求的段，Line为源文件中的行号，检查内存中的段时为0
+type RegionViolation struct {
+	Line    int    `json:"line,omitempty"`
+	Content string `json:"content"`
+	Reason  string `json:"reason"`
+}
+
+func (v RegionViolation) String() string {
+	if v.Line > 0 {
+		return fmt.Sprintf("第%d行%s: `%s`", v.Line, v.Reason, v.Content)
+	}
+	return fmt.Sprintf("%s: `%s`", v.Reason, v.Content)
+}
+
+// RegionSchemaError 源数据中有地区信息不符合结构要求
+type RegionSchemaError struct {
+	Violations []RegionViolation // 最多MaxContinuityProblems个
+	Total      int               // 不符合要求的段总数
+
+	context string // 第一个问题前后的行
+}
+
+func (e *RegionSchemaError) add(line int, content string, reason string) {
+	e.Total++
+	if len(e.Violations) < MaxContinuityProblems {
+		e.Violations = append(e.Violations, RegionViolation{Line: line, Content: content, Reason: reason})
+	}
+}
+
+func (e *RegionSchemaError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "地区信息不符合结构要求，共 %d 处问题", e.Total)
+	for i, v := range e.Violations {
+		if i >= 5 {
+			sb.WriteString("\n...")
+			break
+		}
+		sb.WriteString("\n")
+		sb.WriteString(v.String())
+	}
+	sb.WriteString(e.context)
+	return sb.String()
+}
+
+// CheckRegionSchema 检查内存中的段是否都符合结构要求，有问题时返回*RegionSchemaError
+func CheckRegionSchema(segments []*Segment, schema *RegionSchema) error {
+	var sErr = &RegionSchemaError{}
+	for _, seg := range segments {
+		if reason := schema.Check(seg.Region); reason != "" {
+			sErr.add(0, seg.String(), reason)
+		}
+	}
+
+	if sErr.Total > 0 {
+		return sErr
+	}
+	return nil
+}
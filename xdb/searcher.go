@@ -6,20 +6,30 @@
 // ip2region database v2.0 searcher.
 // this is part of the maker for testing and validate.
 // please use the searcher in binding/golang for production use.
-// And this is a Not thread safe implementation.
+// All the reads go through ReadAt, so concurrent Search calls are fine,
+// but loading or clearing the vector index is Not thread safe.
 
 package xdb
 
 import (
 	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"math"
 	"os"
+	"sync"
+	"sync/atomic"
 )
 
 type Searcher struct {
 	handle *os.File
 
+	// 非内存模式下的数据源，文件模式下即为handle
+	reader io.ReaderAt
+
 	// header info
 	header []byte
 
@@ -36,13 +46,34 @@ type Searcher struct {
 
 	// 完全内存模式：整个XDB文件内容缓冲区
 	contentBuffer []byte
+
+	// 混合模式：常驻内存的段索引块，地区数据仍从文件读取
+	segmentIndex    []byte
+	segmentIndexPtr int64
+
+	// 保证Close只执行一次
+	closeOnce sync.Once
+
+	// 不重复地区数量，首次调用RegionCount时计算并缓存
+	regionCountOnce sync.Once
+	regionCount     int
+	regionCountErr  error
 }
 
 func NewSearcher(dbFile string) (*Searcher, error) {
 	return NewWithFileOnly(dbFile)
 }
 
-// NewSearcherWithVectorIndex 创建一个带有向量索引的搜索器
+// 向量索引预加载失败时是否直接返回错误，默认降级为每次查询从文件读取向量索引
+var strictVectorIndex atomic.Bool
+
+// SetStrictVectorIndex 设置向量索引预加载失败时是否返回错误
+func SetStrictVectorIndex(strict bool) {
+	strictVectorIndex.Store(strict)
+}
+
+// NewSearcherWithVectorIndex 创建一个带有向量索引的搜索器，
+// 向量索引加载失败时记录警告并返回从文件读取向量索引的搜索器，严格模式下返回错误
 func NewSearcherWithVectorIndex(dbFile string) (*Searcher, error) {
 	s, err := NewSearcher(dbFile)
 	if err != nil {
@@ -52,8 +83,12 @@ func NewSearcherWithVectorIndex(dbFile string) (*Searcher, error) {
 	// 加载向量索引
 	err = s.LoadVectorIndex()
 	if err != nil {
-		s.Close()
-		return nil, err
+		if strictVectorIndex.Load() {
+			s.Close()
+			return nil, err
+		}
+
+		log.Printf("警告: %s 预加载向量索引失败，查询时改为从文件读取向量索引: %v", dbFile, err)
 	}
 
 	return s, nil
@@ -98,6 +133,10 @@ func NewWithBuffer(contentBuffer []byte) (*Searcher, error) {
 		contentBuffer:     contentBuffer,
 	}
 
+	if err := s.validate(int64(len(contentBuffer))); err != nil {
+		return nil, err
+	}
+
 	// 从内存缓冲区加载向量索引
 	err := s.loadVectorIndexFromBuffer()
 	if err != nil {
@@ -107,6 +146,63 @@ func NewWithBuffer(contentBuffer []byte) (*Searcher, error) {
 	return s, nil
 }
 
+// NewSearcherWithHybridMode 创建混合模式搜索器：头部、向量索引和段索引块常驻内存，
+// 体积较大的地区数据块仍通过ReadAt从文件读取，每次查询只有一次IO
+func NewSearcherWithHybridMode(dbFile string) (*Searcher, error) {
+	s, err := NewSearcherWithVectorIndex(dbFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.LoadSegmentIndex(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// LoadSegmentIndex 把整个段索引块读入内存
+func (s *Searcher) LoadSegmentIndex() error {
+	sPtr, ePtr, err := s.IndexBlockRange()
+	if err != nil {
+		return err
+	}
+
+	if sPtr == 0 || ePtr < sPtr {
+		return fmt.Errorf("invalid index block range: %d - %d", sPtr, ePtr)
+	}
+
+	buff, err := s.read(int64(sPtr), int(ePtr-sPtr)+SegmentIndexSize)
+	if err != nil {
+		return fmt.Errorf("read segment index block: %w", err)
+	}
+
+	s.segmentIndex = buff
+	s.segmentIndexPtr = int64(sPtr)
+	return nil
+}
+
+// IsSegmentIndexLoaded 段索引块是否已常驻内存
+func (s *Searcher) IsSegmentIndexLoaded() bool {
+	return s.segmentIndex != nil
+}
+
+// GetSegmentIndexSize 获取常驻内存的段索引块大小
+func (s *Searcher) GetSegmentIndexSize() int {
+	return len(s.segmentIndex)
+}
+
+// inMemory 判断指定范围的读取是否直接命中内存，不产生IO
+func (s *Searcher) inMemory(offset int64, length int) bool {
+	if s.memoryMode {
+		return true
+	}
+
+	return s.segmentIndex != nil && offset >= s.segmentIndexPtr &&
+		offset+int64(length) <= s.segmentIndexPtr+int64(len(s.segmentIndex))
+}
+
 // NewSearcherWithMemoryMode 创建一个内存模式的搜索器（兼容旧接口，但推荐使用NewWithBuffer）
 func NewSearcherWithMemoryMode(dbFile string) (*Searcher, error) {
 	// 加载整个文件内容到内存
@@ -132,6 +228,34 @@ func (s *Searcher) loadVectorIndexFromBuffer() error {
 	return nil
 }
 
+// warmupSink 防止预热时的读操作被编译器优化掉，多个搜索器可能同时预热，使用原子写入
+var warmupSink atomic.Uint32
+
+// Warmup 预热搜索器，让首批查询不再触发缺页或冷IO。
+// 内存模式下逐页访问整个内容缓冲区；其它模式按向量索引的每个 /16 网段执行一次查询。
+func (s *Searcher) Warmup() error {
+	if s.memoryMode {
+		if s.contentBuffer == nil {
+			return fmt.Errorf("内容缓冲区为空")
+		}
+
+		var sum byte
+		for i := 0; i < len(s.contentBuffer); i += os.Getpagesize() {
+			sum += s.contentBuffer[i]
+		}
+		warmupSink.Store(uint32(sum))
+		return nil
+	}
+
+	for i := uint32(0); i < VectorIndexRows*VectorIndexCols; i++ {
+		if _, _, err := s.Search(i << 16); err != nil {
+			return fmt.Errorf("warmup search %s: %w", Long2IP(i<<16), err)
+		}
+	}
+
+	return nil
+}
+
 // IsMemoryMode 检查是否为内存模式
 func (s *Searcher) IsMemoryMode() bool {
 	return s.memoryMode
@@ -143,14 +267,14 @@ func (s *Searcher) GetContentBufferSize() int64 {
 		return int64(len(s.contentBuffer))
 	}
 
-	if s.handle == nil {
-		return 0
-	}
-
 	if s.contentBufferSize > 0 {
 		return s.contentBufferSize
 	}
 
+	if s.handle == nil {
+		return 0
+	}
+
 	// 如果未设置，获取文件大小
 	fileInfo, err := s.handle.Stat()
 	if err != nil {
@@ -165,6 +289,11 @@ func (s *Searcher) IsVectorIndexLoaded() bool {
 	return s.vectorIndex != nil
 }
 
+// VectorIndex 返回已加载的向量索引，没有加载时返回nil；返回的切片不能修改
+func (s *Searcher) VectorIndex() []byte {
+	return s.vectorIndex
+}
+
 // GetVectorIndexSize 获取向量索引大小
 func (s *Searcher) GetVectorIndexSize() int {
 	if s.vectorIndex == nil {
@@ -173,17 +302,18 @@ func (s *Searcher) GetVectorIndexSize() int {
 	return len(s.vectorIndex)
 }
 
+// Close 关闭文件句柄并释放缓冲区，可以重复调用，只有第一次调用生效；
+// 关闭文件出错时缓冲区同样会被释放
 func (s *Searcher) Close() {
-	if s.handle != nil {
-		err := s.handle.Close()
-		if err != nil {
-			return
+	s.closeOnce.Do(func() {
+		if s.handle != nil {
+			_ = s.handle.Close()
+			s.handle = nil
 		}
-	}
-	// 内存模式下清理内存
-	if s.memoryMode {
+
 		s.contentBuffer = nil
-	}
+		s.segmentIndex = nil
+	})
 }
 
 // LoadVectorIndex load and cache the vector index for search speedup.
@@ -201,19 +331,9 @@ func (s *Searcher) LoadVectorIndex() error {
 
 	// 文件模式下从文件加载
 	// load all the vector index block
-	_, err := s.handle.Seek(HeaderInfoLength, 0)
-	if err != nil {
-		return fmt.Errorf("seek to vector index: %w", err)
-	}
-
-	var buff = make([]byte, VectorIndexLength)
-	rLen, err := s.handle.Read(buff)
+	buff, err := s.read(HeaderInfoLength, VectorIndexLength)
 	if err != nil {
-		return err
-	}
-
-	if rLen != len(buff) {
-		return fmt.Errorf("incomplete read: readed bytes should be %d", len(buff))
+		return fmt.Errorf("read vector index: %w", err)
 	}
 
 	s.vectorIndex = buff
@@ -225,27 +345,619 @@ func (s *Searcher) ClearVectorIndex() {
 	s.vectorIndex = nil
 }
 
-// readFromBuffer 从内存缓冲区读取数据
+// readFromBuffer 从内存缓冲区读取数据，返回的是副本，调用方可以长期持有
 func (s *Searcher) readFromBuffer(offset int64, length int) ([]byte, error) {
-	if s.contentBuffer == nil {
+	view, err := s.viewFromBuffer(offset, length)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, length)
+	copy(data, view)
+	return data, nil
+}
+
+// viewFromBuffer 返回内存缓冲区中指定范围的子切片，不复制数据
+func (s *Searcher) viewFromBuffer(offset int64, length int) ([]byte, error) {
+	buffer := s.contentBuffer
+	if buffer == nil {
 		return nil, fmt.Errorf("内容缓冲区为空")
 	}
 
-	if offset < 0 || offset >= int64(len(s.contentBuffer)) {
+	if offset < 0 || offset >= int64(len(buffer)) {
 		return nil, fmt.Errorf("偏移量超出缓冲区范围: %d", offset)
 	}
 
-	if int64(length) > int64(len(s.contentBuffer))-offset {
+	if int64(length) > int64(len(buffer))-offset {
 		return nil, fmt.Errorf("读取长度超出缓冲区范围")
 	}
 
-	data := make([]byte, length)
-	copy(data, s.contentBuffer[offset:offset+int64(length)])
-	return data, nil
+	return buffer[offset : offset+int64(length)], nil
+}
+
+// view 与read相同，但内存模式下直接返回缓冲区的子切片而不复制，
+// 只用于查询过程中立即解码、不会被持有的读取
+func (s *Searcher) view(offset int64, length int) ([]byte, error) {
+	if s.memoryMode {
+		return s.viewFromBuffer(offset, length)
+	}
+
+	return s.read(offset, length)
+}
+
+// read 从内存缓冲区或文件的指定偏移读取数据
+func (s *Searcher) read(offset int64, length int) ([]byte, error) {
+	if s.memoryMode {
+		return s.readFromBuffer(offset, length)
+	}
+
+	if s.inMemory(offset, length) {
+		start := offset - s.segmentIndexPtr
+		return s.segmentIndex[start : start+int64(length)], nil
+	}
+
+	return s.readFile(make([]byte, length), offset)
+}
+
+// viewInto 与view相同，但需要从文件读取时读入调用方提供的buf，buf长度不足时才新分配；
+// 返回的切片可能与buf共享内存，只在buf下一次被使用之前有效
+func (s *Searcher) viewInto(buf []byte, offset int64, length int) ([]byte, error) {
+	if s.memoryMode {
+		return s.viewFromBuffer(offset, length)
+	}
+
+	if s.inMemory(offset, length) {
+		start := offset - s.segmentIndexPtr
+		return s.segmentIndex[start : start+int64(length)], nil
+	}
+
+	if len(buf) < length {
+		buf = make([]byte, length)
+	}
+	return s.readFile(buf[:length], offset)
+}
+
+// readFile 从文件的指定偏移读满buf
+func (s *Searcher) readFile(buf []byte, offset int64) ([]byte, error) {
+	if s.reader == nil {
+		return nil, fmt.Errorf("数据源为空")
+	}
+
+	rLen, err := s.reader.ReadAt(buf, offset)
+	if rLen != len(buf) {
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("incomplete read: readed bytes should be %d", len(buf))
+	}
+
+	return buf, nil
+}
+
+// searchScratch 需要从文件读取时一次查询使用的临时缓冲区，通过searchScratchPool复用，
+// 每次查询独占一个，并发查询之间不共享
+type searchScratch struct {
+	vector [VectorIndexSize]byte
+	index  [SegmentIndexSize]byte
+	region []byte
+}
+
+var searchScratchPool = sync.Pool{
+	New: func() any { return new(searchScratch) },
+}
+
+// regionBuf 返回长度为n的地区数据缓冲区，容量不足时扩大，sc为nil时返回nil
+func (sc *searchScratch) regionBuf(n int) []byte {
+	if sc == nil {
+		return nil
+	}
+	if cap(sc.region) < n {
+		sc.region = make([]byte, n)
+	}
+	return sc.region[:n]
+}
+
+// loadHeader 读取并缓存头部信息
+func (s *Searcher) loadHeader() ([]byte, error) {
+	if s.header != nil {
+		return s.header, nil
+	}
+
+	header, err := s.read(0, HeaderInfoLength)
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+
+	s.header = header
+	return header, nil
+}
+
+// IndexBlockRange 返回头部记录的段索引块起始指针和最后一条索引的指针
+func (s *Searcher) IndexBlockRange() (uint32, uint32, error) {
+	header, err := s.loadHeader()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return binary.LittleEndian.Uint32(header[8:]), binary.LittleEndian.Uint32(header[12:]), nil
+}
+
+// SourceChecksum 返回生成时记录在头部的源文件SHA-256十六进制串，旧版本生成的数据库没有记录时返回空串
+func (s *Searcher) SourceChecksum() (string, error) {
+	header, err := s.loadHeader()
+	if err != nil {
+		return "", err
+	}
+
+	sum := header[SourceChecksumOffset : SourceChecksumOffset+SourceChecksumLength]
+	for _, b := range sum {
+		if b != 0 {
+			return hex.EncodeToString(sum), nil
+		}
+	}
+
+	return "", nil
+}
+
+// validate 校验头部记录的索引块指针都在数据范围内，在加载时尽早发现下载中断等导致的截断文件
+func (s *Searcher) validate(size int64) error {
+	if size < HeaderInfoLength+VectorIndexLength {
+		return fmt.Errorf("corrupt or truncated XDB: file size %d is smaller than header and vector index (%d bytes)", size, HeaderInfoLength+VectorIndexLength)
+	}
+
+	sPtr, ePtr, err := s.IndexBlockRange()
+	if err != nil {
+		return fmt.Errorf("corrupt or truncated XDB: %w", err)
+	}
+
+	if int64(sPtr)+SegmentIndexSize > size {
+		return fmt.Errorf("corrupt or truncated XDB: index pointer %d exceeds file size %d", sPtr, size)
+	}
+
+	if int64(ePtr)+SegmentIndexSize > size {
+		return fmt.Errorf("corrupt or truncated XDB: index pointer %d exceeds file size %d", ePtr, size)
+	}
+
+	if sPtr > ePtr {
+		return fmt.Errorf("corrupt or truncated XDB: index start pointer %d is after end pointer %d", sPtr, ePtr)
+	}
+
+	return nil
+}
+
+// RegionCount 返回数据库中不重复地区的数量。
+// 数据块中的地区数据没有长度前缀，无法直接逐条遍历；但Maker生成时每个地区只写入一次，
+// 所以统计段索引中不同的数据指针即可得到地区数量。结果在首次调用后缓存。
+func (s *Searcher) RegionCount() (int, error) {
+	s.regionCountOnce.Do(func() {
+		s.regionCount, s.regionCountErr = s.countRegions()
+	})
+	return s.regionCount, s.regionCountErr
+}
+
+func (s *Searcher) countRegions() (int, error) {
+	sPtr, ePtr, err := s.IndexBlockRange()
+	if err != nil {
+		return 0, err
+	}
+
+	if sPtr == 0 || ePtr < sPtr {
+		return 0, fmt.Errorf("invalid index block range: %d - %d", sPtr, ePtr)
+	}
+
+	const batchEntries = 4096
+	var total = int64(ePtr-sPtr)/SegmentIndexSize + 1
+	var ptrs = map[uint32]struct{}{}
+
+	for i := int64(0); i < total; i += batchEntries {
+		n := total - i
+		if n > batchEntries {
+			n = batchEntries
+		}
+
+		buff, err := s.read(int64(sPtr)+i*SegmentIndexSize, int(n*SegmentIndexSize))
+		if err != nil {
+			return 0, fmt.Errorf("read segment index at %d: %w", int64(sPtr)+i*SegmentIndexSize, err)
+		}
+
+		for j := int64(0); j < n; j++ {
+			entry := buff[j*SegmentIndexSize:]
+			if binary.LittleEndian.Uint16(entry[8:]) == 0 {
+				continue
+			}
+			ptrs[binary.LittleEndian.Uint32(entry[10:])] = struct{}{}
+		}
+	}
+
+	return len(ptrs), nil
+}
+
+// IterateIndex 直接顺序遍历段索引块并回调每个IP段，不需要逐IP查询。
+// Maker 建索引时按前两个字节拆分了IP段，这里会把拆分出的相邻且指向同一地区数据的索引项重新合并。
+func (s *Searcher) IterateIndex(cb func(seg *Segment) error) error {
+	sPtr, ePtr, err := s.IndexBlockRange()
+	if err != nil {
+		return err
+	}
+
+	if sPtr == 0 || ePtr < sPtr {
+		return fmt.Errorf("invalid index block range: %d - %d", sPtr, ePtr)
+	}
+
+	return s.iterateEntries(sPtr, ePtr, math.MaxUint32, cb)
+}
+
+// IterateRange 按顺序回调与 [sip, eip] 相交的IP段，首尾两段裁剪到范围之内。
+// 先二分查找范围内的第一条索引项，之后只顺序读取范围内的索引项，不需要逐IP查询
+func (s *Searcher) IterateRange(sip uint32, eip uint32, cb func(seg *Segment) error) error {
+	if sip > eip {
+		return fmt.Errorf("start ip %s is greater than end ip %s", Long2IP(sip), Long2IP(eip))
+	}
+
+	sPtr, ePtr, err := s.IndexBlockRange()
+	if err != nil {
+		return err
+	}
+
+	if sPtr == 0 || ePtr < sPtr {
+		return fmt.Errorf("invalid index block range: %d - %d", sPtr, ePtr)
+	}
+
+	// 第一条结束IP不小于sip的索引项
+	var l, h = 0, int((ePtr-sPtr)/SegmentIndexSize) + 1
+	for l < h {
+		m := int(uint(l+h) >> 1)
+		entry, err := s.readIndexEntry(sPtr + uint32(m)*SegmentIndexSize)
+		if err != nil {
+			return err
+		}
+		if entry.eip < sip {
+			l = m + 1
+		} else {
+			h = m
+		}
+	}
+
+	var first = sPtr + uint32(l)*SegmentIndexSize
+	if first > ePtr {
+		return nil
+	}
+
+	return s.iterateEntries(first, ePtr, eip, func(seg *Segment) error {
+		seg.StartIP = max(seg.StartIP, sip)
+		seg.EndIP = min(seg.EndIP, eip)
+		return cb(seg)
+	})
+}
+
+// iterateEntries 从sPtr开始顺序遍历到ePtr的索引项，合并拆分出的相邻索引项后回调，
+// 遇到起始IP大于stopIP的索引项时停止
+func (s *Searcher) iterateEntries(sPtr uint32, ePtr uint32, stopIP uint32, cb func(seg *Segment) error) error {
+	// 每次读取一批索引项以减少IO次数
+	const batchEntries = 4096
+	var total = int64(ePtr-sPtr)/SegmentIndexSize + 1
+	var regions = map[uint32]string{}
+	var last *Segment
+	var lastPtr uint32
+
+	for i := int64(0); i < total; i += batchEntries {
+		n := total - i
+		if n > batchEntries {
+			n = batchEntries
+		}
+
+		buff, err := s.read(int64(sPtr)+i*SegmentIndexSize, int(n*SegmentIndexSize))
+		if err != nil {
+			return fmt.Errorf("read segment index at %d: %w", int64(sPtr)+i*SegmentIndexSize, err)
+		}
+
+		for j := int64(0); j < n; j++ {
+			entry := buff[j*SegmentIndexSize:]
+			sip := binary.LittleEndian.Uint32(entry)
+			eip := binary.LittleEndian.Uint32(entry[4:])
+			dataLen := int(binary.LittleEndian.Uint16(entry[8:]))
+			dataPtr := binary.LittleEndian.Uint32(entry[10:])
+			if sip > stopIP {
+				if last != nil {
+					return cb(last)
+				}
+				return nil
+			}
+
+			// 合并拆分出的相邻索引项
+			if last != nil && lastPtr == dataPtr && last.EndIP+1 == sip {
+				last.EndIP = eip
+				continue
+			}
+
+			region, has := regions[dataPtr]
+			if !has && dataLen > 0 {
+				regionBuff, err := s.read(int64(dataPtr), dataLen)
+				if err != nil {
+					return fmt.Errorf("read region data at %d: %w", dataPtr, err)
+				}
+				region = string(regionBuff)
+				regions[dataPtr] = region
+			}
+
+			if last != nil {
+				if err = cb(last); err != nil {
+					return err
+				}
+			}
+
+			last = &Segment{StartIP: sip, EndIP: eip, Region: region}
+			lastPtr = dataPtr
+		}
+	}
+
+	if last != nil {
+		return cb(last)
+	}
+
+	return nil
+}
+
+// VectorOccupancy 返回每个向量索引单元格 [第一字节][第二字节] 下的段索引条数，
+// 由单元格的sPtr/ePtr计算 (ePtr为最后一条之后的位置)，向量索引已常驻内存时不产生IO
+func (s *Searcher) VectorOccupancy() ([][]int, error) {
+	var vector = s.vectorIndex
+	if vector == nil {
+		buff, err := s.read(HeaderInfoLength, VectorIndexLength)
+		if err != nil {
+			return nil, fmt.Errorf("read vector index: %w", err)
+		}
+		vector = buff
+	}
+
+	var cells = make([][]int, VectorIndexRows)
+	for i := range cells {
+		cells[i] = make([]int, VectorIndexCols)
+		for j := range cells[i] {
+			idx := (i*VectorIndexCols + j) * VectorIndexSize
+			sPtr := binary.LittleEndian.Uint32(vector[idx:])
+			ePtr := binary.LittleEndian.Uint32(vector[idx+4:])
+			if sPtr == 0 || ePtr <= sPtr {
+				continue
+			}
+			cells[i][j] = int((ePtr - sPtr) / SegmentIndexSize)
+		}
+	}
+
+	return cells, nil
+}
+
+// indexEntry 段索引块中的一条索引项
+type indexEntry struct {
+	sip     uint32
+	eip     uint32
+	dataLen int
+	dataPtr uint32
+}
+
+func (s *Searcher) readIndexEntry(p uint32) (indexEntry, error) {
+	buff, err := s.read(int64(p), SegmentIndexSize)
+	if err != nil {
+		return indexEntry{}, fmt.Errorf("read segment index at %d: %w", p, err)
+	}
+
+	return indexEntry{
+		sip:     binary.LittleEndian.Uint32(buff),
+		eip:     binary.LittleEndian.Uint32(buff[4:]),
+		dataLen: int(binary.LittleEndian.Uint16(buff[8:])),
+		dataPtr: binary.LittleEndian.Uint32(buff[10:]),
+	}, nil
+}
+
+// segmentAt 返回位置p的索引项所在的IP段，与IterateIndex一样向前后合并拆分出的索引项，
+// 同时返回合并后第一条和最后一条索引项的位置
+func (s *Searcher) segmentAt(p, sPtr, ePtr uint32) (*Segment, uint32, uint32, error) {
+	entry, err := s.readIndexEntry(p)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	var seg = &Segment{StartIP: entry.sip, EndIP: entry.eip}
+	var first, last = p, p
+	for first > sPtr {
+		prev, err := s.readIndexEntry(first - SegmentIndexSize)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if prev.dataPtr != entry.dataPtr || prev.eip+1 != seg.StartIP {
+			break
+		}
+		seg.StartIP = prev.sip
+		first -= SegmentIndexSize
+	}
+
+	for last < ePtr {
+		next, err := s.readIndexEntry(last + SegmentIndexSize)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if next.dataPtr != entry.dataPtr || seg.EndIP+1 != next.sip {
+			break
+		}
+		seg.EndIP = next.eip
+		last += SegmentIndexSize
+	}
+
+	if entry.dataLen > 0 {
+		regionBuff, err := s.read(int64(entry.dataPtr), entry.dataLen)
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("read region data at %d: %w", entry.dataPtr, err)
+		}
+		seg.Region = string(regionBuff)
+	}
+
+	return seg, first, last, nil
+}
+
+// Neighbors 返回ip所在的IP段以及段索引中紧挨着它的前一个和后一个IP段，
+// 拆分出的索引项按IterateIndex的方式合并。ip未命中任何段时cur为nil，
+// 位于第一个或最后一个段时对应的prev或next为nil
+func (s *Searcher) Neighbors(ip uint32) (prev, cur, next *Segment, err error) {
+	var info = &SearchInfo{}
+	if _, _, err = s.search(ip, info); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if info.DataLen == 0 {
+		return nil, nil, nil, nil
+	}
+
+	sPtr, ePtr, err := s.IndexBlockRange()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	cur, first, last, err := s.segmentAt(info.IndexPtr, sPtr, ePtr)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if first > sPtr {
+		if prev, _, _, err = s.segmentAt(first-SegmentIndexSize, sPtr, ePtr); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	if last < ePtr {
+		if next, _, _, err = s.segmentAt(last+SegmentIndexSize, sPtr, ePtr); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	return prev, cur, next, nil
+}
+
+// IndexMatch 覆盖某个IP的一条段索引项
+type IndexMatch struct {
+	IndexPtr uint32 // 段索引项在文件中的偏移
+	StartIP  uint32
+	EndIP    uint32
+	DataPtr  uint32
+	DataLen  int
+	Region   string
+}
+
+// SearchAll 返回ip所在向量索引单元格中所有覆盖ip的段索引项，按索引顺序排列。
+// 正常生成的数据库最多只有一项；Split或导入出错时段索引项可能互相重叠，
+// 二分查找只会返回碰到的第一项，这里顺序扫描整个单元格，用于发现并报告重叠
+func (s *Searcher) SearchAll(ip uint32) ([]IndexMatch, error) {
+	var idx = (ip>>24)*VectorIndexCols*VectorIndexSize + ((ip>>16)&0xFF)*VectorIndexSize
+	var vector = s.vectorIndex
+	if vector == nil {
+		buff, err := s.read(int64(HeaderInfoLength+idx), VectorIndexSize)
+		if err != nil {
+			return nil, fmt.Errorf("read vector index at %d: %w", HeaderInfoLength+idx, err)
+		}
+		vector, idx = buff, 0
+	}
+
+	sPtr := binary.LittleEndian.Uint32(vector[idx:])
+	ePtr := binary.LittleEndian.Uint32(vector[idx+4:])
+	if sPtr == 0 || ePtr <= sPtr {
+		return nil, nil
+	}
+
+	buff, err := s.view(int64(sPtr), int(ePtr-sPtr))
+	if err != nil {
+		return nil, fmt.Errorf("read segment index at %d: %w", sPtr, err)
+	}
+
+	var matches []IndexMatch
+	for off := 0; off+SegmentIndexSize <= len(buff); off += SegmentIndexSize {
+		entry := buff[off:]
+		sip := binary.LittleEndian.Uint32(entry)
+		eip := binary.LittleEndian.Uint32(entry[4:])
+		if ip < sip || ip > eip {
+			continue
+		}
+
+		matches = append(matches, IndexMatch{
+			IndexPtr: sPtr + uint32(off),
+			StartIP:  sip,
+			EndIP:    eip,
+			DataLen:  int(binary.LittleEndian.Uint16(entry[8:])),
+			DataPtr:  binary.LittleEndian.Uint32(entry[10:]),
+		})
+	}
+
+	for i := range matches {
+		if matches[i].DataLen == 0 {
+			continue
+		}
+		regionBuff, err := s.read(int64(matches[i].DataPtr), matches[i].DataLen)
+		if err != nil {
+			return nil, fmt.Errorf("read region data at %d: %w", matches[i].DataPtr, err)
+		}
+		matches[i].Region = string(regionBuff)
+	}
+
+	return matches, nil
+}
+
+// SearchInfo 记录一次查询经过的索引路径，用于诊断IO次数偏高的/16网段
+type SearchInfo struct {
+	VectorIndex int    // 向量索引单元格序号 il0*256+il1
+	SPtr        uint32 // 单元格内第一条段索引的位置
+	EPtr        uint32 // 单元格内最后一条段索引之后的位置，[SPtr, EPtr) 为单元格内的段索引
+	CellEntries int    // 单元格内的段索引条数
+	Iterations  int    // 二分查找的迭代次数
+	DataPtr     uint32 // 命中段的地区数据位置，未命中为0
+	DataLen     int    // 命中段的地区数据长度
+	StartIP     uint32 // 命中段的起始IP
+	EndIP       uint32 // 命中段的结束IP
+	VectorPtr   uint32 // 向量索引单元格在文件中的偏移
+	IndexPtr    uint32 // 命中的段索引条目在文件中的偏移，未命中为0
 }
 
 // Search find the region for the specified ip address
 func (s *Searcher) Search(ip uint32) (string, int, error) {
+	return s.search(ip, nil)
+}
+
+// SearchWithInfo 与Search相同，同时返回本次查询的索引路径信息
+func (s *Searcher) SearchWithInfo(ip uint32) (string, int, *SearchInfo, error) {
+	var info = &SearchInfo{}
+	region, ioCount, err := s.search(ip, info)
+	return region, ioCount, info, err
+}
+
+// SearchWithRange 与Search相同，同时返回命中段的起止IP以及是否命中了段，未命中任何段时起止IP均为ip
+func (s *Searcher) SearchWithRange(ip uint32) (string, uint32, uint32, bool, error) {
+	var info = &SearchInfo{}
+	region, _, err := s.search(ip, info)
+	if err != nil || info.DataLen == 0 {
+		return region, ip, ip, false, err
+	}
+
+	return region, info.StartIP, info.EndIP, true, nil
+}
+
+// SearchWithIterations 与Search相同，同时返回二分查找的迭代次数，用于统计段索引的查找深度
+func (s *Searcher) SearchWithIterations(ip uint32) (string, int, int, error) {
+	var info SearchInfo
+	region, ioCount, err := s.search(ip, &info)
+	return region, ioCount, info.Iterations, err
+}
+
+func (s *Searcher) search(ip uint32, info *SearchInfo) (string, int, error) {
+	region, _, ioCount, err := s.lookup(ip, info, true)
+	return region, ioCount, err
+}
+
+// Contains 判断IP是否被数据库中的某个段覆盖，只查找段索引、不读取地区数据，
+// 文件模式下比 Search 少一次IO；覆盖IP的段地区为默认地区时同样返回true
+func (s *Searcher) Contains(ip uint32) (bool, int, error) {
+	_, covered, ioCount, err := s.lookup(ip, nil, false)
+	return covered, ioCount, err
+}
+
+// lookup 查找覆盖ip的段，readRegion为false时命中后不读取地区数据，返回的地区为空
+func (s *Searcher) lookup(ip uint32, info *SearchInfo, readRegion bool) (string, bool, int, error) {
 	// locate the segment index block based on the vector index
 	var ioCount = 0
 	var il0 = (ip >> 24) & 0xFF
@@ -253,35 +965,26 @@ func (s *Searcher) Search(ip uint32) (string, int, error) {
 	var idx = il0*VectorIndexCols*VectorIndexSize + il1*VectorIndexSize
 	var sPtr, ePtr = uint32(0), uint32(0)
 
+	// 内存模式直接读取缓冲区的子切片，不需要临时缓冲区
+	var scratch *searchScratch
+	var vectorBuf, indexBuf []byte
+	if !s.memoryMode {
+		scratch = searchScratchPool.Get().(*searchScratch)
+		defer searchScratchPool.Put(scratch)
+		vectorBuf, indexBuf = scratch.vector[:], scratch.index[:]
+	}
+
 	if s.vectorIndex != nil {
 		sPtr = binary.LittleEndian.Uint32(s.vectorIndex[idx:])
 		ePtr = binary.LittleEndian.Uint32(s.vectorIndex[idx+4:])
 	} else {
 		// 如果向量索引未加载，需要从存储中读取
-		var buffVec []byte
-		var err error
-
-		if s.memoryMode {
-			// 从内存缓冲区读取
-			buffVec, err = s.readFromBuffer(int64(HeaderInfoLength+idx), VectorIndexSize)
-			if err != nil {
-				return "", ioCount, fmt.Errorf("read vector index from buffer at %d: %w", HeaderInfoLength+idx, err)
-			}
-		} else {
-			// 从文件读取
-			pos, err := s.handle.Seek(int64(HeaderInfoLength+idx), 0)
-			if err != nil {
-				return "", ioCount, fmt.Errorf("seek to vector index %d: %w", HeaderInfoLength+idx, err)
-			}
+		if !s.memoryMode {
 			ioCount++
-			buffVec = make([]byte, VectorIndexSize)
-			rLenVec, err := s.handle.Read(buffVec)
-			if err != nil {
-				return "", ioCount, fmt.Errorf("read vector index at %d: %w", pos, err)
-			}
-			if rLenVec != len(buffVec) {
-				return "", ioCount, fmt.Errorf("incomplete read for vector index: readed bytes should be %d", len(buffVec))
-			}
+		}
+		buffVec, err := s.viewInto(vectorBuf, int64(HeaderInfoLength+idx), VectorIndexSize)
+		if err != nil {
+			return "", false, ioCount, fmt.Errorf("read vector index at %d: %w", HeaderInfoLength+idx, err)
 		}
 
 		sPtr = binary.LittleEndian.Uint32(buffVec)
@@ -290,95 +993,80 @@ func (s *Searcher) Search(ip uint32) (string, int, error) {
 
 	// binary search the segment index to get the region
 	var dataLen, dataPtr = 0, uint32(0)
-	var buff = make([]byte, SegmentIndexSize)
-	var l, h = 0, int((ePtr - sPtr) / SegmentIndexSize)
+	var l, h = 0, int((ePtr-sPtr)/SegmentIndexSize) - 1
+
+	if info != nil {
+		info.VectorIndex = int(il0*VectorIndexCols + il1)
+		info.VectorPtr = uint32(HeaderInfoLength + idx)
+		info.SPtr, info.EPtr = sPtr, ePtr
+		if ePtr > sPtr {
+			info.CellEntries = h + 1
+		}
+	}
 
-	if sPtr == 0 || ePtr == 0 || sPtr >= ePtr { // sPtr can be 0 if a /16 prefix has no IPs
-		// No need to search if the range is invalid or empty
-		// return "", ioCount, nil // This would indicate not found
+	// sPtr is 0 if the /16 prefix has no segments, ePtr points just past the
+	// last entry of the cell, unmapped ips are not found without any index read
+	if sPtr == 0 || ePtr <= sPtr {
+		return "", false, ioCount, nil
 	}
 
 	for l <= h {
 		m := (l + h) >> 1
 		p := sPtr + uint32(m*SegmentIndexSize)
+		if info != nil {
+			info.Iterations++
+		}
 
-		var err error
-		if s.memoryMode {
-			// 从内存缓冲区读取
-			buff, err = s.readFromBuffer(int64(p), SegmentIndexSize)
-			if err != nil {
-				return "", ioCount, fmt.Errorf("read segment index from buffer at %d: %w", p, err)
-			}
-		} else {
-			// 从文件读取
-			_, err := s.handle.Seek(int64(p), 0)
-			if err != nil {
-				return "", ioCount, fmt.Errorf("seek to segment block at %d: %w", p, err)
-			}
-
+		if !s.inMemory(int64(p), SegmentIndexSize) {
 			ioCount++
-			rLen, err := s.handle.Read(buff)
-			if err != nil {
-				return "", ioCount, fmt.Errorf("read segment index at %d: %w", p, err)
-			}
-
-			if rLen != len(buff) {
-				return "", ioCount, fmt.Errorf("incomplete read: readed bytes should be %d", len(buff))
-			}
+		}
+		buff, err := s.viewInto(indexBuf, int64(p), SegmentIndexSize)
+		if err != nil {
+			return "", false, ioCount, fmt.Errorf("read segment index at %d: %w", p, err)
 		}
 
-		sip := binary.LittleEndian.Uint32(buff)
-		eipRead := binary.LittleEndian.Uint32(buff[4:]) // Renamed to avoid conflict with outer ip
 		// decode the data step by step to reduce the unnecessary calculations
-		// sip := binary.LittleEndian.Uint32(buff)
+		sip := binary.LittleEndian.Uint32(buff)
 		if ip < sip {
 			h = m - 1
 		} else {
-			// eip := binary.LittleEndian.Uint32(buff[4:])
-			if ip > eipRead {
+			eip := binary.LittleEndian.Uint32(buff[4:])
+			if ip > eip {
 				l = m + 1
 			} else {
 				dataLen = int(binary.LittleEndian.Uint16(buff[8:]))
 				dataPtr = binary.LittleEndian.Uint32(buff[10:])
+				if info != nil {
+					info.StartIP, info.EndIP = sip, eip
+					info.IndexPtr = p
+				}
 				break
 			}
 		}
 	}
 
+	if info != nil {
+		info.DataPtr, info.DataLen = dataPtr, dataLen
+	}
+
 	if dataLen == 0 {
-		return "", ioCount, nil
+		return "", false, ioCount, nil
+	}
+	if !readRegion {
+		return "", true, ioCount, nil
 	}
 
 	// load and return the region data
-	var regionBuff []byte
-	var err error
-
-	if s.memoryMode {
-		// 从内存缓冲区读取地区数据
-		regionBuff, err = s.readFromBuffer(int64(dataPtr), dataLen)
-		if err != nil {
-			return "", ioCount, fmt.Errorf("read region data from buffer at %d: %w", dataPtr, err)
-		}
-	} else {
-		// 从文件读取地区数据
-		_, err := s.handle.Seek(int64(dataPtr), 0)
-		if err != nil {
-			return "", ioCount, fmt.Errorf("seek to data block at %d: %w", dataPtr, err)
-		}
-
+	if !s.memoryMode {
 		ioCount++
-		regionBuff = make([]byte, dataLen)
-		rLen, err := s.handle.Read(regionBuff)
-		if err != nil {
-			return "", ioCount, fmt.Errorf("read region data at %d: %w", dataPtr, err)
-		}
-
-		if rLen != dataLen {
-			return "", ioCount, fmt.Errorf("incomplete read: readed bytes should be %d", dataLen)
-		}
+	}
+	regionBuff, err := s.viewInto(scratch.regionBuf(dataLen), int64(dataPtr), dataLen)
+	if err != nil {
+		return "", false, ioCount, fmt.Errorf("read region data at %d: %w", dataPtr, err)
 	}
 
-	return string(regionBuff), ioCount, nil
+	// string会复制数据，返回的地区信息不与内存缓冲区共享
+	return string(regionBuff), true, ioCount, nil
 }
 
 // NewWithFileOnly 创建一个完全基于文件的搜索器（每次查询都进行IO操作）
@@ -388,12 +1076,96 @@ func NewWithFileOnly(dbFile string) (*Searcher, error) {
 		return nil, err
 	}
 
-	return &Searcher{
+	fileInfo, err := handle.Stat()
+	if err != nil {
+		_ = handle.Close()
+		return nil, err
+	}
+
+	s := &Searcher{
 		handle:            handle,
+		reader:            handle,
 		header:            nil,
 		vectorIndex:       nil, // 不预加载向量索引
 		memoryMode:        false,
-		contentBufferSize: 0,
+		contentBufferSize: fileInfo.Size(),
 		contentBuffer:     nil,
-	}, nil
+	}
+
+	if err = s.validate(fileInfo.Size()); err != nil {
+		_ = handle.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// NewWithFileAndVector 创建预加载向量索引的文件搜索器，只常驻512KiB的向量索引，
+// 段索引和地区数据仍然每次查询从文件读取，每次查询比 NewWithFileOnly 少一次IO；
+// 与 NewSearcherWithVectorIndex 不同，向量索引加载失败时总是返回错误
+func NewWithFileAndVector(dbFile string) (*Searcher, error) {
+	s, err := NewWithFileOnly(dbFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.LoadVectorIndex(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// SetVectorIndex 为文件搜索器设置之前从同一文件读取的向量索引，例如另一个搜索器的 VectorIndex，
+// 多个搜索器共享一份向量索引且不再读取文件；搜索器不会修改vectorIndex
+func (s *Searcher) SetVectorIndex(vectorIndex []byte) error {
+	if len(vectorIndex) != VectorIndexLength {
+		return fmt.Errorf("invalid vector index length %d, expected %d", len(vectorIndex), VectorIndexLength)
+	}
+
+	s.vectorIndex = vectorIndex
+	return nil
+}
+
+// Stat 返回文件搜索器已打开的文件的信息，路径之后被替换时仍为打开时的文件；
+// 基于缓冲区或 io.ReaderAt 的搜索器没有文件，返回错误
+func (s *Searcher) Stat() (os.FileInfo, error) {
+	if s.handle == nil {
+		return nil, fmt.Errorf("the searcher is not backed by a file")
+	}
+
+	return s.handle.Stat()
+}
+
+// NewWithReaderAt 基于任意 io.ReaderAt 创建搜索器，例如通过范围请求读取对象存储中的XDB文件。
+// 构造时通过一次范围读取预加载向量索引，之后每次查询只按需读取段索引和地区数据。
+func NewWithReaderAt(r io.ReaderAt, size int64) (*Searcher, error) {
+	if r == nil {
+		return nil, fmt.Errorf("数据源不能为空")
+	}
+
+	if size < HeaderInfoLength+VectorIndexLength {
+		return nil, fmt.Errorf("XDB数据大小 %d 字节太小，至少需要 %d 字节", size, HeaderInfoLength+VectorIndexLength)
+	}
+
+	s := &Searcher{
+		handle:            nil,
+		reader:            r,
+		header:            nil,
+		vectorIndex:       nil,
+		memoryMode:        false,
+		contentBufferSize: size,
+		contentBuffer:     nil,
+	}
+
+	if err := s.validate(size); err != nil {
+		return nil, err
+	}
+
+	if err := s.LoadVectorIndex(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
 }
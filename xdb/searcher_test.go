@@ -0,0 +1,178 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package xdb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const searcherTestSrc = "0.0.0.0|0.255.255.255|保留|0|0|0|0\n" +
+	"1.0.0.0|1.0.0.255|中国|0|广东省|广州市|电信\n" +
+	"1.0.1.0|1.0.3.255|中国|0|福建省|福州市|电信\n" +
+	"1.0.4.0|8.8.8.7|0|0|0|0|0\n" +
+	"8.8.8.8|8.8.8.8|美国|0|加利福尼亚|0|Google\n" +
+	"8.8.8.9|255.255.255.255|0|0|0|0|0\n"
+
+// writeTestXdb 生成XDB并写入临时目录，返回文件路径和内容
+func writeTestXdb(t testing.TB, src string) (string, []byte) {
+	t.Helper()
+
+	buf := makeTestXdb(t, src)
+	dbFile := filepath.Join(t.TempDir(), "test.xdb")
+	if err := os.WriteFile(dbFile, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dbFile, buf
+}
+
+func TestSearcherCloseTwice(t *testing.T) {
+	dbFile, buf := writeTestXdb(t, searcherTestSrc)
+
+	var tests = []struct {
+		name string
+		open func() (*Searcher, error)
+	}{
+		{"file", func() (*Searcher, error) { return NewWithFileOnly(dbFile) }},
+		{"vector", func() (*Searcher, error) { return NewWithFileAndVector(dbFile) }},
+		{"hybrid", func() (*Searcher, error) { return NewSearcherWithHybridMode(dbFile) }},
+		{"buffer", func() (*Searcher, error) { return NewWithBuffer(buf) }},
+		{"memory", func() (*Searcher, error) { return NewSearcherWithMemoryMode(dbFile) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := tt.open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if region, _, err := s.Search(0x08080808); err != nil || region != "美国|0|加利福尼亚|0|Google" {
+				t.Fatalf("Search(8.8.8.8) = %q, %v", region, err)
+			}
+
+			s.Close()
+			s.Close()
+		})
+	}
+}
+
+// BenchmarkSearch 各查询模式的单次查询开销，文件模式的分配次数反映 searchScratchPool 和 viewInto 的复用情况
+func BenchmarkSearch(b *testing.B) {
+	dbFile, buf := writeTestXdb(b, searcherTestSrc)
+
+	var modes = []struct {
+		name string
+		open func() (*Searcher, error)
+	}{
+		{"file", func() (*Searcher, error) { return NewWithFileOnly(dbFile) }},
+		{"vector", func() (*Searcher, error) { return NewWithFileAndVector(dbFile) }},
+		{"hybrid", func() (*Searcher, error) { return NewSearcherWithHybridMode(dbFile) }},
+		{"memory", func() (*Searcher, error) { return NewWithBuffer(buf) }},
+	}
+
+	for _, mode := range modes {
+		b.Run(mode.name, func(b *testing.B) {
+			s, err := mode.open()
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer s.Close()
+
+			// 1.0.0.1, 1.0.2.1, 8.8.8.8 and 200.0.0.1, one ip in each of the data segments
+			var ips = []uint32{0x01000001, 0x01000201, 0x08080808, 0xC8000001}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := s.Search(ips[i%len(ips)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// the vector index stores the position just past the last entry of each cell, the binary
+// search covers [sPtr, ePtr) and must not read the entry after the cell
+func TestSearchCellBounds(t *testing.T) {
+	dbFile, buf := writeTestXdb(t, "1.0.0.0|1.0.255.255|单条|0|0|0|0\n"+
+		"2.0.0.0|2.0.0.255|第一条|0|0|0|0\n"+
+		"2.0.1.0|2.0.1.255|第二条|0|0|0|0\n"+
+		"2.0.2.0|2.0.2.255|最后一条|0|0|0|0\n"+
+		"3.0.0.0|3.0.0.255|末尾|0|0|0|0\n")
+
+	var tests = []struct {
+		name       string
+		ip         string
+		region     string
+		entries    int
+		iterations int
+	}{
+		{"empty cell", "1.1.0.1", "", 0, 0},
+		{"one entry cell", "1.0.128.1", "单条|0|0|0|0", 1, 1},
+		{"first entry", "2.0.0.1", "第一条|0|0|0|0", 3, 2},
+		{"last entry", "2.0.2.255", "最后一条|0|0|0|0", 3, 2},
+		{"past the last entry", "2.0.3.0", "", 3, 2},
+		{"past the last cell", "3.0.1.0", "", 1, 1},
+	}
+
+	var modes = []struct {
+		name string
+		open func() (*Searcher, error)
+	}{
+		{"file", func() (*Searcher, error) { return NewWithFileOnly(dbFile) }},
+		{"memory", func() (*Searcher, error) { return NewWithBuffer(buf) }},
+	}
+
+	for _, mode := range modes {
+		s, err := mode.open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+
+		for _, tt := range tests {
+			t.Run(mode.name+"/"+tt.name, func(t *testing.T) {
+				ip, err := IP2Long(tt.ip)
+				if err != nil {
+					t.Fatal(err)
+				}
+				region, _, info, err := s.SearchWithInfo(ip)
+				if err != nil {
+					t.Fatalf("SearchWithInfo(%s): %v", tt.ip, err)
+				}
+				if region != tt.region {
+					t.Fatalf("SearchWithInfo(%s) = %q, want %q", tt.ip, region, tt.region)
+				}
+				if info.CellEntries != tt.entries || info.Iterations != tt.iterations {
+					t.Fatalf("SearchWithInfo(%s): %d entries and %d iterations, want %d and %d",
+						tt.ip, info.CellEntries, info.Iterations, tt.entries, tt.iterations)
+				}
+			})
+		}
+	}
+}
+
+func TestWarmupConcurrent(t *testing.T) {
+	_, buf := writeTestXdb(t, searcherTestSrc)
+
+	// run with -race, searchers warmed up in parallel share the package level sink
+	var done = make(chan error)
+	for i := 0; i < 4; i++ {
+		go func() {
+			s, err := NewWithBuffer(buf)
+			if err == nil {
+				err = s.Warmup()
+				s.Close()
+			}
+			done <- err
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -5,8 +5,10 @@
 package xdb
 
 import (
+	"errors"
 	"fmt"
 	"strings"
+	"sync/atomic"
 )
 
 type Segment struct {
@@ -15,6 +17,42 @@ type Segment struct {
 	Region  string
 }
 
+// MaxRegionLength 索引项中地区数据长度字段为2字节，地区信息最多65535字节
+const MaxRegionLength = 0xFFFF
+
+// CheckRegionLength 检查地区信息长度，尽早拒绝超长的地区而不是等到生成XDB时才失败
+func CheckRegionLength(region string) error {
+	if len(region) > MaxRegionLength {
+		return fmt.Errorf("too long region info: %d bytes, should be at most %d bytes", len(region), MaxRegionLength)
+	}
+
+	return nil
+}
+
+// DefaultMaxSegments 从源文件加载IP段时默认允许的最大段数量
+const DefaultMaxSegments = 50000000
+
+// ErrTooManySegments 源文件的IP段数量超过了SetMaxSegments设置的上限
+var ErrTooManySegments = errors.New("too many segments")
+
+// 加载源文件时允许的最大段数量，0表示不限制
+var maxSegments int64 = DefaultMaxSegments
+
+// SetMaxSegments 设置Maker和Editor从源文件加载IP段时允许的最大段数量，0表示不限制，
+// 用于避免异常的源文件耗尽内存
+func SetMaxSegments(n int) {
+	atomic.StoreInt64(&maxSegments, int64(n))
+}
+
+// checkSegmentCount 加载第n个段之前检查是否超过上限
+func checkSegmentCount(n int) error {
+	if limit := atomic.LoadInt64(&maxSegments); limit > 0 && int64(n) >= limit {
+		return fmt.Errorf("%w: the source has more than %d segments", ErrTooManySegments, limit)
+	}
+
+	return nil
+}
+
 func SegmentFrom(seg string) (*Segment, error) {
 	var ps = strings.SplitN(strings.TrimSpace(seg), "|", 3)
 	if len(ps) != 3 {
@@ -35,6 +73,10 @@ func SegmentFrom(seg string) (*Segment, error) {
 		return nil, fmt.Errorf("start ip(%s) should not be greater than end ip(%s)", ps[0], ps[1])
 	}
 
+	if err = CheckRegionLength(ps[2]); err != nil {
+		return nil, err
+	}
+
 	return &Segment{
 		StartIP: sip,
 		EndIP:   eip,
@@ -151,6 +193,31 @@ func (s *Segment) Split() []*Segment {
 	return segList
 }
 
+// DefaultRegion 空白IP段使用的默认地区信息
+const DefaultRegion = "0|0|0|0|0"
+
+// DefaultRegionWithFields 返回指定字段数量的默认地区信息，
+// 用于填充未覆盖的范围，使其与实际数据的字段数量一致
+func DefaultRegionWithFields(fields int) string {
+	if fields <= 1 {
+		return "0"
+	}
+
+	return strings.Repeat("0|", fields-1) + "0"
+}
+
+// IsDefaultRegion 判断地区信息是否为空或全部字段都是默认值0，例如 `0|0|0|0|0`
+func IsDefaultRegion(region string) bool {
+	for _, part := range strings.Split(region, "|") {
+		part = strings.TrimSpace(part)
+		if part != "" && part != "0" {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (s *Segment) String() string {
 	return fmt.Sprintf("%s|%s|%s", Long2IP(s.StartIP), Long2IP(s.EndIP), s.Region)
 }
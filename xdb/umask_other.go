@@ -0,0 +1,14 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+//go:build !unix
+
+package xdb
+
+import "os"
+
+// processUmask 非unix系统没有umask
+func processUmask() os.FileMode {
+	return 0
+}
@@ -0,0 +1,20 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+//go:build unix
+
+package xdb
+
+import (
+	"os"
+	"syscall"
+)
+
+// processUmask 读取进程的umask。syscall.Umask只能在设置的同时返回旧值，
+// 因此立即恢复原值；只在包初始化时调用一次
+func processUmask() os.FileMode {
+	mask := syscall.Umask(0)
+	syscall.Umask(mask)
+	return os.FileMode(mask) & os.ModePerm
+}
@@ -7,8 +7,8 @@ package xdb
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"net"
-	"os"
 	"strings"
 	"unsafe"
 )
@@ -92,12 +92,45 @@ func Long2IPPool(ip uint32) string {
 	return result
 }
 
+// DrainIPBufPool 清空Long2IPPool的缓冲区池，返回丢弃的缓冲区数量，之后的调用会重新分配
+func DrainIPBufPool() int {
+	var n = 0
+	for {
+		select {
+		case <-ipBufPool:
+			n++
+		default:
+			return n
+		}
+	}
+}
+
 func MidIP(sip uint32, eip uint32) uint32 {
 	return uint32((uint64(sip) + uint64(eip)) >> 1)
 }
 
-func IterateSegments(handle *os.File, before func(l string), cb func(seg *Segment) error) error {
+// utf8BOM Windows记事本等编辑器保存UTF-8文件时可能在文件开头添加的BOM
+const utf8BOM = "\uFEFF"
+
+// normalizeSourceLine 去除行首BOM (仅第一行) 和CRLF换行残留的\r
+func normalizeSourceLine(line string, first bool) string {
+	if first {
+		line = strings.TrimPrefix(line, utf8BOM)
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+// IterateSegments 解析源文件内容并按顺序回调合并后的IP段，源内容只读取一遍，
+// 因此可以传入io.TeeReader等在读取时顺带计算校验值
+func IterateSegments(handle io.Reader, before func(l string), cb func(seg *Segment) error) error {
+	return IterateSegmentsWithSchema(handle, nil, before, cb)
+}
+
+// IterateSegmentsWithSchema 与 IterateSegments 相同，同时检查每行的地区信息是否符合schema，
+// 不符合的行不回调，全部读完后返回列出这些行的*RegionSchemaError；schema为nil时不检查
+func IterateSegmentsWithSchema(handle io.Reader, schema *RegionSchema, before func(l string), cb func(seg *Segment) error) error {
 	var last *Segment = nil
+	var sErr = &RegionSchemaError{}
 	var scanner = bufio.NewScanner(handle)
 	scanner.Split(bufio.ScanLines)
 
@@ -107,20 +140,27 @@ func IterateSegments(handle *os.File, before func(l string), cb func(seg *Segmen
 	var currentLine string
 	var nextLines []string = make([]string, 0, 3) // 预读后3行
 
-	// 预读所有行以便提供上下文
+	// 预读所有行以便提供上下文；每个数据行至少对应一个段，
+	// 数据行超过段数量上限时直接停止读取，避免异常的源文件耗尽内存
 	var allLines []string
+	var dataLines int
 	for scanner.Scan() {
-		allLines = append(allLines, scanner.Text())
+		line := normalizeSourceLine(scanner.Text(), len(allLines) == 0)
+		if t := strings.TrimSpace(line); t != "" && t[0] != '#' {
+			if err := checkSegmentCount(dataLines); err != nil {
+				return fmt.Errorf("源文件第%d行: %w", len(allLines)+1, err)
+			}
+			dataLines++
+		}
+		allLines = append(allLines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("读取源文件第%d行失败: %w", len(allLines)+1, err)
 	}
 
-	// 重新设置文件指针到开头
-	handle.Seek(0, 0)
-	scanner = bufio.NewScanner(handle)
-	scanner.Split(bufio.ScanLines)
-
-	for scanner.Scan() {
+	for _, line := range allLines {
 		lineNumber++
-		currentLine = strings.TrimSpace(strings.TrimSuffix(scanner.Text(), "\n"))
+		currentLine = strings.TrimSpace(line)
 
 		// 更新前后文信息
 		if lineNumber > 1 {
@@ -272,6 +312,33 @@ func IterateSegments(handle *os.File, before func(l string), cb func(seg *Segmen
 			return fmt.Errorf("%s", errorMsg.String())
 		}
 
+		if schema != nil {
+			if reason := schema.Check(ps[2]); reason != "" {
+				if sErr.Total == 0 {
+					var errorMsg strings.Builder
+					errorMsg.WriteString("\n")
+					if len(previousLines) > 0 {
+						errorMsg.WriteString("\n前面的行:\n")
+						for _, line := range previousLines {
+							errorMsg.WriteString(fmt.Sprintf("  %s\n", line))
+						}
+					}
+
+					errorMsg.WriteString(fmt.Sprintf("\n>>> 错误行: 第%d行: %s <<<\n", lineNumber, currentLine))
+
+					if len(nextLines) > 0 {
+						errorMsg.WriteString("\n后面的行:\n")
+						for _, line := range nextLines {
+							errorMsg.WriteString(fmt.Sprintf("  %s\n", line))
+						}
+					}
+					sErr.context = errorMsg.String()
+				}
+				sErr.add(lineNumber, currentLine, reason)
+				continue
+			}
+		}
+
 		var seg = &Segment{
 			StartIP: sip,
 			EndIP:   eip,
@@ -292,17 +359,21 @@ func IterateSegments(handle *os.File, before func(l string), cb func(seg *Segmen
 		}
 
 		if err = cb(last); err != nil {
-			return fmt.Errorf("第%d行处理段时出错: %s\n段内容: %s", lineNumber, err, last.String())
+			return fmt.Errorf("第%d行处理段时出错: %w\n段内容: %s", lineNumber, err, last.String())
 		}
 
 		// reset the last
 		last = seg
 	}
 
+	if sErr.Total > 0 {
+		return sErr
+	}
+
 	// process the last segment
 	if last != nil {
 		if err := cb(last); err != nil {
-			return fmt.Errorf("处理最后一个段时出错: %s\n段内容: %s", err, last.String())
+			return fmt.Errorf("处理最后一个段时出错: %w\n段内容: %s", err, last.String())
 		}
 	}
 
@@ -317,10 +388,15 @@ func CheckSegments(segList []*Segment) error {
 			return fmt.Errorf("segment `%s`: start ip should not be greater than end ip", seg.String())
 		}
 
-		// check the continuity of the data segment
+		// check the continuity of the data segment, an overlap means the
+		// source should be trimmed while a gap means it should be filled.
 		if last != nil {
+			if seg.StartIP <= last.EndIP {
+				return fmt.Errorf("overlap: segment `%s` starts inside previous segment `%s`", seg.String(), last.String())
+			}
+
 			if last.EndIP+1 != seg.StartIP {
-				return fmt.Errorf("discontinuous segment `%s`: last.eip+1 != cur.sip", seg.String())
+				return fmt.Errorf("gap between `%s` and `%s`", last.String(), seg.String())
 			}
 		}
 
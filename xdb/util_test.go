@@ -0,0 +1,65 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package xdb
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestIterateSegments(t *testing.T) {
+	var want = []string{
+		"0.0.0.0|0.255.255.255|保留|0|0|0|0",
+		"1.0.0.0|1.0.3.255|中国|0|广东省|广州市|电信",
+		"1.0.4.0|255.255.255.255|0|0|0|0|0",
+	}
+
+	// the two 1.0.x.x lines have the same region and are merged into one segment
+	var lines = []string{
+		"0.0.0.0|0.255.255.255|保留|0|0|0|0",
+		"1.0.0.0|1.0.0.255|中国|0|广东省|广州市|电信",
+		"1.0.1.0|1.0.3.255|中国|0|广东省|广州市|电信",
+		"1.0.4.0|255.255.255.255|0|0|0|0|0",
+	}
+
+	var tests = []struct {
+		name string
+		src  string
+	}{
+		{"lf", strings.Join(lines, "\n") + "\n"},
+		{"crlf", strings.Join(lines, "\r\n") + "\r\n"},
+		{"no trailing newline", strings.Join(lines, "\r\n")},
+		{"bom", utf8BOM + strings.Join(lines, "\n")},
+		{"bom crlf", utf8BOM + strings.Join(lines, "\r\n") + "\r\n"},
+		{"bom before a comment", utf8BOM + "# ip2region source\r\n\r\n" + strings.Join(lines, "\r\n")},
+		{"lone cr", strings.Join(lines, "\r\r\n")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := IterateSegments(strings.NewReader(tt.src), nil, func(seg *Segment) error {
+				got = append(got, seg.String())
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("IterateSegments: %v", err)
+			}
+			if !slices.Equal(got, want) {
+				t.Fatalf("IterateSegments:\n got %q\nwant %q", got, want)
+			}
+		})
+	}
+}
+
+func TestIterateSegmentsBOMNotFirst(t *testing.T) {
+	// only a BOM at the start of the content is stripped, one in the middle is part of the line
+	var src = "0.0.0.0|0.255.255.255|保留|0|0|0|0\n" + utf8BOM + "1.0.0.0|255.255.255.255|0|0|0|0|0\n"
+	err := IterateSegments(strings.NewReader(src), nil, func(seg *Segment) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "第2行起始IP格式错误") {
+		t.Fatalf("IterateSegments: got %v, want an error on line 2", err)
+	}
+}